@@ -1,25 +1,46 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
 	"syscall"
 	"os"
-	"os/user"
-	"io/ioutil"
 	"github.com/daludaluking/ons-sawtooth-sdk/logging"
 	"github.com/daludaluking/ons-sawtooth-sdk/processor"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_config"
 	ons "github.com/daludaluking/ons-sawtooth/src/ons/ons_handler"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_health"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_logging"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_metrics"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_simulate"
 	flags "github.com/jessevdk/go-flags"
 )
 
 var opts struct {
+	Config string `short:"c" long:"config" description:"TOML or YAML (.yaml, .yml) config file path (values are overridden by ONS_* env and command line options)"`
 	Verbose []bool `short:"v" long:"verbose" description:"Increase verbosity"`
-	Connect string `short:"C" long:"connect" description:"The validator component endpoint to" default:"tcp://localhost:4004"`
+	Connect string `short:"C" long:"connect" description:"The validator component endpoint to (default: tcp://localhost:4004)"`
 	PublicKey string `short:"p" long:"publickey" description:"ONS super user address"`
+	PublicKeyFile string `long:"publickey-file" description:"File to read ONS super user address from (default: $HOME/.sawtooth/keys/$USER.pub)"`
+	Queue uint `long:"max-queue-size" description:"Set the maximum queue size before rejecting process requests (default: 100)"`
+	Threads uint `long:"worker-thread-count" description:"Set the number of worker threads to use for processing requests in parallel"`
+	LogLevel string `long:"log-level" description:"Log level: debug, info, warn, error or critical (default: warn)"`
+	LogFormat string `long:"log-format" description:"Log format: text or json (default: text)"`
+	Metrics string `short:"m" long:"metrics" description:"Address to serve Prometheus metrics on (e.g. :9100), disabled if empty"`
+	Health string `long:"health" description:"Address to serve /healthz and /readyz on (e.g. :9101), disabled if empty"`
+}
+
+var simulateOpts struct {
+	Snapshot string `short:"s" long:"snapshot" required:"true" description:"State snapshot: REST API /state response (or a list of its pages) or {address: base64 data} JSON dump"`
+	Transactions string `short:"t" long:"transactions" required:"true" description:"JSON list of {signer, payload | transaction, inputs, outputs} to apply in order"`
 }
 
 func main() {
 	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	parser.AddCommand("simulate",
+		"Dry-run ONS transactions against a state snapshot",
+		"Loads a state snapshot into memory, applies the transactions with the ONS handler and prints the result of each transaction and the resulting state diff as JSON. The validator is not contacted.",
+		&simulateOpts)
 
 	logger := logging.Get()
 
@@ -33,65 +54,166 @@ func main() {
 		}
 	}
 
+	cfg, err := loadConfig(parser)
+	if err != nil {
+		logger.Error("Invalid configuration: ", err)
+		os.Exit(2)
+	}
 
-	var loggingLevel int
-	switch len(opts.Verbose) {
-	case 0:
-		loggingLevel = logging.WARN
-	case 1:
-		loggingLevel = logging.INFO
-	default:
-		loggingLevel = logging.DEBUG
+	logger.SetLevel(cfg.LogLevel())
+	err = ons_logging.SetFormat(cfg.Log.Format)
+	if err != nil {
+		logger.Error("Failed to set log format: ", err)
+		os.Exit(2)
 	}
-	logger.SetLevel(loggingLevel)
+	defer ons_logging.Close()
 
 	logger.Debugf("command line arguments: %v", os.Args)
-	logger.Debugf("verbose = %v\n", len(opts.Verbose))
-	logger.Debugf("endpoint = %v\n", opts.Connect)
-
-	var local_public_key string
-	if len(opts.PublicKey) == 0 {
-		user, err := user.Current()
-		path := user.HomeDir+"/.sawtooth/keys/"+user.Username+".pub"
-		logger.Debugf("%s is used as public key\n", path)
-		_public_key_bytes, err := ioutil.ReadFile(path)
-		if err != nil {
-			fmt.Println("Fail to read private key.")
-			os.Exit(2)
-		}
-		if _public_key_bytes[len(_public_key_bytes)-1] == 10 {
-			_public_key_bytes = _public_key_bytes[:len(_public_key_bytes)-1]
-		}
-		local_public_key = string(_public_key_bytes)
-		logger.Debugf("public key is %s\n", local_public_key)
-	}else{
-		local_public_key = opts.PublicKey
-	}
+	logger.Debugf("configuration: %+v", *cfg)
 
-	//for debugging ... ...
-	fmt.Printf("command line arguments: %v\n", os.Args)
-	fmt.Printf("verbose = %v\n", len(opts.Verbose))
-	fmt.Printf("endpoint = %v\n", opts.Connect)
+	local_public_key, err := cfg.AdminPublicKey()
+	if err != nil {
+		logger.Error("Failed to load ONS super user address: ", err)
+		exit(2)
+	}
+	logger.Debugf("public key is %s\n", local_public_key)
 
 	handler := &ons.ONSHandler{}
 
 	//just for test yet.
 	if handler.SetSudoAddress(local_public_key) == false {
-		logger.Debugf("Failed to set sudo address")
-		os.Exit(2)
+		logger.Error("Failed to set sudo address")
+		exit(2)
 	}
 
-	processor := processor.NewTransactionProcessor(opts.Connect)
-	/*
-		processor.SetMaxQueueSize(opts.Queue)
-		if opts.Threads > 0 {
-			processor.SetThreadCount(opts.Threads)
-		}
-	*/
+	if parser.Active != nil && parser.Active.Name == "simulate" {
+		exit(runSimulate(handler))
+	}
+
+	if len(cfg.Metrics.Listen) > 0 {
+		ons_metrics.StartListener(cfg.Metrics.Listen)
+	}
+
+	ons_health.SetEndpoint(cfg.Endpoint)
+	if len(cfg.Health.Listen) > 0 {
+		ons_health.StartListener(cfg.Health.Listen)
+	}
+
+	processor := processor.NewTransactionProcessor(cfg.Endpoint)
+	processor.SetMaxQueueSize(cfg.QueueSize)
+	if cfg.Threads > 0 {
+		processor.SetThreadCount(cfg.Threads)
+	}
 	processor.AddHandler(handler)
 	processor.ShutdownOnSignal(syscall.SIGINT, syscall.SIGTERM)
+	ons_health.SetStarting()
 	err = processor.Start()
+	ons_health.SetStopped(err)
 	if err != nil {
 		logger.Error("Processor stopped: ", err)
 	}
 }
+
+//default < config file < ONS_* env < command line option 순서로 설정을 덮어쓴다.
+func loadConfig(parser *flags.Parser) (*ons_config.Config, error) {
+	cfg := ons_config.Default()
+	//config file, env, --log-level 중 하나로 log level을 지정했는지 알 수 있도록 비워 둔다.
+	default_log_level := cfg.Log.Level
+	cfg.Log.Level = ""
+
+	if len(opts.Config) > 0 {
+		err := cfg.LoadFile(opts.Config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := cfg.ApplyEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	isSet := func(long_name string) bool {
+		option := parser.FindOptionByLongName(long_name)
+		return option != nil && option.IsSet()
+	}
+
+	if isSet("connect") {
+		cfg.Endpoint = opts.Connect
+	}
+	if isSet("publickey") {
+		cfg.Admin.PublicKey = opts.PublicKey
+	}
+	if isSet("publickey-file") {
+		cfg.Admin.PublicKeyFile = opts.PublicKeyFile
+	}
+	if isSet("max-queue-size") {
+		cfg.QueueSize = opts.Queue
+	}
+	if isSet("worker-thread-count") {
+		cfg.Threads = opts.Threads
+	}
+	if isSet("log-level") {
+		cfg.Log.Level = opts.LogLevel
+	}
+	if isSet("log-format") {
+		cfg.Log.Format = opts.LogFormat
+	}
+	if isSet("metrics") {
+		cfg.Metrics.Listen = opts.Metrics
+	}
+	if isSet("health") {
+		cfg.Health.Listen = opts.Health
+	}
+
+	//-v는 log level을 지정하지 않았을 때만 사용한다.
+	if len(cfg.Log.Level) == 0 {
+		switch len(opts.Verbose) {
+		case 0:
+			cfg.Log.Level = default_log_level
+		case 1:
+			cfg.Log.Level = "info"
+		default:
+			cfg.Log.Level = "debug"
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+//json log format이면 남은 log를 모두 쓴 후에 종료한다.
+func exit(code int) {
+	ons_logging.Close()
+	os.Exit(code)
+}
+
+func runSimulate(handler *ons.ONSHandler) int {
+	logger := logging.Get()
+
+	snapshot, err := ons_simulate.LoadSnapshot(simulateOpts.Snapshot)
+	if err != nil {
+		logger.Error("Failed to load snapshot: ", err)
+		return 2
+	}
+
+	transactions, err := ons_simulate.LoadTransactions(simulateOpts.Transactions)
+	if err != nil {
+		logger.Error("Failed to load transactions: ", err)
+		return 2
+	}
+
+	report := ons_simulate.Run(handler, snapshot, transactions)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(report)
+	if err != nil {
+		logger.Error("Failed to write report: ", err)
+		return 2
+	}
+
+	if report.Rejected > 0 {
+		return 1
+	}
+	return 0
+}
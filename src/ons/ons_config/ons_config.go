@@ -0,0 +1,207 @@
+package ons_config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/daludaluking/ons-sawtooth-sdk/logging"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_logging"
+	"gopkg.in/yaml.v2"
+)
+
+type AdminConfig struct {
+	//admin(sudo) public key를 hex string으로 직접 지정한다.
+	PublicKey string `toml:"public_key" yaml:"public_key"`
+	//public key를 읽어 들일 file path. public_key가 지정되면 무시된다.
+	PublicKeyFile string `toml:"public_key_file" yaml:"public_key_file"`
+}
+
+//format은 text 또는 json이다. sawtooth sdk logger의 출력을 ons_logging이 json으로 바꾼다.
+type LogConfig struct {
+	Level  string `toml:"level" yaml:"level"`
+	Format string `toml:"format" yaml:"format"`
+}
+
+type ListenerConfig struct {
+	//host:port, 비어 있으면 listener를 실행하지 않는다.
+	Listen string `toml:"listen" yaml:"listen"`
+}
+
+type Config struct {
+	Endpoint  string         `toml:"endpoint" yaml:"endpoint"`
+	Threads   uint           `toml:"threads" yaml:"threads"`
+	QueueSize uint           `toml:"queue_size" yaml:"queue_size"`
+	Admin     AdminConfig    `toml:"admin" yaml:"admin"`
+	Log       LogConfig      `toml:"log" yaml:"log"`
+	Metrics   ListenerConfig `toml:"metrics" yaml:"metrics"`
+	Health    ListenerConfig `toml:"health" yaml:"health"`
+}
+
+var logLevels = map[string]int{
+	"debug":    logging.DEBUG,
+	"info":     logging.INFO,
+	"warn":     logging.WARN,
+	"error":    logging.ERROR,
+	"critical": logging.CRITICAL,
+}
+
+func Default() *Config {
+	return &Config{
+		Endpoint:  "tcp://localhost:4004",
+		Threads:   0,
+		QueueSize: 100,
+		Log: LogConfig{
+			Level:  "warn",
+			Format: ons_logging.FORMAT_TEXT,
+		},
+	}
+}
+
+//path의 TOML 또는 YAML(.yaml, .yml) file로 cfg를 덮어쓴다. file에 없는 항목은 기존 값을 유지한다.
+func (cfg *Config) LoadFile(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return cfg.loadYAML(path)
+	}
+
+	md, err := toml.DecodeFile(path, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return fmt.Errorf("unknown keys in config file %s: %s", path, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+//모르는 key가 있으면 error를 반환한다.
+func (cfg *Config) loadYAML(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	err = yaml.UnmarshalStrict(data, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	return nil
+}
+
+//ONS_ prefix를 가진 environment variable로 cfg를 덮어쓴다.
+func (cfg *Config) ApplyEnv() error {
+	if v, ok := os.LookupEnv("ONS_ENDPOINT"); ok {
+		cfg.Endpoint = v
+	}
+	if v, ok := os.LookupEnv("ONS_THREADS"); ok {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("ONS_THREADS must be a non-negative integer, got %q", v)
+		}
+		cfg.Threads = uint(n)
+	}
+	if v, ok := os.LookupEnv("ONS_QUEUE_SIZE"); ok {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("ONS_QUEUE_SIZE must be a positive integer, got %q", v)
+		}
+		cfg.QueueSize = uint(n)
+	}
+	if v, ok := os.LookupEnv("ONS_ADMIN_PUBLIC_KEY"); ok {
+		cfg.Admin.PublicKey = v
+	}
+	if v, ok := os.LookupEnv("ONS_ADMIN_PUBLIC_KEY_FILE"); ok {
+		cfg.Admin.PublicKeyFile = v
+	}
+	if v, ok := os.LookupEnv("ONS_LOG_LEVEL"); ok {
+		cfg.Log.Level = v
+	}
+	if v, ok := os.LookupEnv("ONS_LOG_FORMAT"); ok {
+		cfg.Log.Format = v
+	}
+	if v, ok := os.LookupEnv("ONS_METRICS_LISTEN"); ok {
+		cfg.Metrics.Listen = v
+	}
+	if v, ok := os.LookupEnv("ONS_HEALTH_LISTEN"); ok {
+		cfg.Health.Listen = v
+	}
+	return nil
+}
+
+func (cfg *Config) Validate() error {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Scheme != "tcp" || len(u.Host) == 0 {
+		return fmt.Errorf("endpoint must look like tcp://host:port, got %q", cfg.Endpoint)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return fmt.Errorf("endpoint %q has no port: %v", cfg.Endpoint, err)
+	}
+
+	if cfg.QueueSize == 0 {
+		return fmt.Errorf("queue_size must be greater than 0")
+	}
+
+	if _, ok := logLevels[strings.ToLower(cfg.Log.Level)]; ok == false {
+		return fmt.Errorf("log level must be one of debug, info, warn, error, critical, got %q", cfg.Log.Level)
+	}
+
+	if ons_logging.IsFormat(cfg.Log.Format) == false {
+		return fmt.Errorf("log format must be one of %s, got %q", strings.Join(ons_logging.Formats, ", "), cfg.Log.Format)
+	}
+
+	if len(cfg.Metrics.Listen) > 0 {
+		if _, _, err := net.SplitHostPort(cfg.Metrics.Listen); err != nil {
+			return fmt.Errorf("metrics listen address must look like host:port, got %q", cfg.Metrics.Listen)
+		}
+	}
+
+	if len(cfg.Health.Listen) > 0 {
+		if _, _, err := net.SplitHostPort(cfg.Health.Listen); err != nil {
+			return fmt.Errorf("health listen address must look like host:port, got %q", cfg.Health.Listen)
+		}
+		if cfg.Health.Listen == cfg.Metrics.Listen {
+			return fmt.Errorf("health and metrics listeners can't share the address %q", cfg.Health.Listen)
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) LogLevel() int {
+	return logLevels[strings.ToLower(cfg.Log.Level)]
+}
+
+//admin public key를 결정한다.
+//public_key > public_key_file > $HOME/.sawtooth/keys/$USER.pub 순서로 사용한다.
+func (cfg *Config) AdminPublicKey() (string, error) {
+	if len(cfg.Admin.PublicKey) > 0 {
+		return cfg.Admin.PublicKey, nil
+	}
+
+	path := cfg.Admin.PublicKeyFile
+	if len(path) == 0 {
+		user, err := user.Current()
+		if err != nil {
+			return "", fmt.Errorf("failed to find current user for the default admin key: %v", err)
+		}
+		path = user.HomeDir + "/.sawtooth/keys/" + user.Username + ".pub"
+	}
+
+	public_key_bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read admin public key file: %v", err)
+	}
+
+	return strings.TrimSpace(string(public_key_bytes)), nil
+}
@@ -6,7 +6,9 @@ import (
 	"github.com/daludaluking/ons-sawtooth-sdk/ons_pb2"
 	"github.com/daludaluking/ons-sawtooth/src/ons/ons_state"
 	"github.com/daludaluking/ons-sawtooth/src/ons/ons_service"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_health"
 	"github.com/daludaluking/ons-sawtooth/src/ons/ons_manager"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_metrics"
 	"github.com/daludaluking/ons-sawtooth-sdk/logging"
 	"github.com/daludaluking/ons-sawtooth-sdk/processor"
 	"github.com/daludaluking/ons-sawtooth-sdk/protobuf/processor_pb2"
@@ -34,7 +36,34 @@ func (self *ONSHandler) SetSudoAddress(address string) bool {
 	return ons_manager.SetSudoAddress(address)
 }
 
+//validator가 보낸 transaction. 결과를 metrics, health에 기록한다.
 func (self *ONSHandler) Apply(request *processor_pb2.TpProcessRequest, context *processor.Context) error {
+	tx_type, err := self.applyRequest(request, context)
+	if tx_type == "UNKNOWN" {
+		ons_metrics.ObserveRejected(tx_type, err)
+		return err
+	}
+
+	ons_metrics.ObservePayloadSize(tx_type, len(request.GetPayload()))
+	ons_health.ObserveTransaction(request.GetSignature(), tx_type, err)
+	if err != nil {
+		ons_metrics.ObserveRejected(tx_type, err)
+		return err
+	}
+
+	ons_metrics.ObserveApplied(tx_type)
+	return nil
+}
+
+//Apply와 같지만 processor.Context 대신 StateContext를 받고 metrics, health에 기록하지 않는다.
+//simulate mode에서 memory에 load한 state로 transaction을 실행할 때 사용한다.
+func (self *ONSHandler) ApplyRequest(request *processor_pb2.TpProcessRequest, context ons_state.StateContext) error {
+	_, err := self.applyRequest(request, context)
+	return err
+}
+
+//transaction type을 함께 반환한다. payload를 unpack 할 수 없으면 UNKNOWN이다.
+func (self *ONSHandler) applyRequest(request *processor_pb2.TpProcessRequest, context ons_state.StateContext) (string, error) {
 
 	requestor_pk := request.GetHeader().GetSignerPublicKey()
 	payload, err := UnpackPayload(request.GetPayload())
@@ -42,11 +71,16 @@ func (self *ONSHandler) Apply(request *processor_pb2.TpProcessRequest, context *
 	logger.Debugf("call apply from ", requestor_pk)
 
 	if err != nil {
-		return err
+		return "UNKNOWN", err
 	}
 
 	logger.Debugf("ONS txn %v: type %v", request.Signature, payload.TransactionType)
 
+	err = applyPayload(payload, context, requestor_pk)
+	return payload.TransactionType.String(), err
+}
+
+func applyPayload(payload *ons_pb2.SendONSTransactionPayload, context ons_state.StateContext, requestor_pk string) error {
 	switch payload.TransactionType {
 	case ons_pb2.SendONSTransactionPayload_OP_MANAGER:
 		return applyOPManager(payload.OpManager, context, requestor_pk)
@@ -82,7 +116,7 @@ func (self *ONSHandler) Apply(request *processor_pb2.TpProcessRequest, context *
 
 func applyRegiserGS1Code(
 	registerGS1CodeData *ons_pb2.SendONSTransactionPayload_RegisterGS1CodeTransactionData,
-	context *processor.Context,	requestor string) error {
+	context ons_state.StateContext,	requestor string) error {
 	//permission check...
 	if GetPermissionLevel("", requestor, ons_manager.PERMISSION_SU_MANAGER, context) == false {
 		return &processor.InvalidTransactionError{Msg: "applyRegiserGS1Code : Authentication failed"}
@@ -108,7 +142,7 @@ func applyRegiserGS1Code(
 
 func applyDeregiserGS1Code(
 	deregisterGS1CodeData *ons_pb2.SendONSTransactionPayload_DeregisterGS1CodeTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 	//permission check...
 	if GetPermissionLevel("", requestor, ons_manager.PERMISSION_SU_MANAGER, context) == false {
@@ -133,7 +167,7 @@ func applyDeregiserGS1Code(
 
 func applyAddRecord(
 	addRecordData *ons_pb2.SendONSTransactionPayload_AddRecordTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 	//permission check...
 	if GetPermissionLevel(addRecordData.GetGs1Code(), requestor, ons_manager.PERMISSION_MANAGER, context) == false {
@@ -173,7 +207,7 @@ func applyAddRecord(
 
 func applyRemoveRecord(
 	removeRecordData *ons_pb2.SendONSTransactionPayload_RemoveRecordTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 	//permission check...
 	if GetPermissionLevel(removeRecordData.GetGs1Code(), requestor, ons_manager.PERMISSION_MANAGER, context) == false {
@@ -208,7 +242,7 @@ func applyRemoveRecord(
 
 func applyRegiserServiceType(
 	registerServiceType *ons_pb2.SendONSTransactionPayload_RegisterServiceTypeTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 	//permission check...
 	if GetPermissionLevel("", requestor, ons_manager.PERMISSION_SU_MANAGER, context) == false {
@@ -234,7 +268,7 @@ func applyRegiserServiceType(
 
 func applyDeregiserServiceType(
 	deregisterServiceType *ons_pb2.SendONSTransactionPayload_DeregisterServiceTypeTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 	//permission check...
 	if GetPermissionLevel("", requestor, ons_manager.PERMISSION_SU_MANAGER, context) == false {
@@ -261,7 +295,7 @@ func applyDeregiserServiceType(
 
 func applyChangeGS1CodeState(
 	changeGS1CodeState *ons_pb2.SendONSTransactionPayload_ChangeGS1CodeStateTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 	//permission check...
 	if GetPermissionLevel("", requestor, ons_manager.PERMISSION_SU_MANAGER, context) == false {
@@ -284,7 +318,7 @@ func applyChangeGS1CodeState(
 
 func applyChangeRecordState(
 	changeRecordState *ons_pb2.SendONSTransactionPayload_ChangeRecordStateTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 	//permission check...
 	if GetPermissionLevel("", requestor, ons_manager.PERMISSION_SU_MANAGER, context) == false {
@@ -316,7 +350,7 @@ func applyChangeRecordState(
 
 func applyAddManager(
 	addManagerData *ons_pb2.SendONSTransactionPayload_AddManagerTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 
 	//permission check...
@@ -337,7 +371,7 @@ func applyAddManager(
 
 func applyRemoveManager(
 	removeManagerData *ons_pb2.SendONSTransactionPayload_RemoveManagerTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 	//permission check...
 	if GetPermissionLevel("", requestor, ons_manager.PERMISSION_SU_MANAGER, context) == false {
@@ -351,7 +385,7 @@ func applyRemoveManager(
 
 func applyAddSuManager(
 	addSuManagerData *ons_pb2.SendONSTransactionPayload_AddSUManagerTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 	//permission check...
 	if GetPermissionLevel("", requestor, ons_manager.PERMISSION_SU_ADDRESS, context) == false {
@@ -363,7 +397,7 @@ func applyAddSuManager(
 
 func applyRemoveSuManager(
 	removeSuManagerData *ons_pb2.SendONSTransactionPayload_RemoveSUManagerTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 	//permission check...
 	if GetPermissionLevel("", requestor, ons_manager.PERMISSION_SU_ADDRESS, context) == false {
@@ -377,7 +411,7 @@ func applyRemoveSuManager(
 
 func applyOPManager(
 	opManagerData *ons_pb2.SendONSTransactionPayload_OPManagerTransactionData,
-	context *processor.Context,
+	context ons_state.StateContext,
 	requestor string) error {
 	//GS1Code Manager의 경우에는 권한이 SU Address거나 SU Manager의 경우에는
 	//등록, 삭제, 수정이 가능하다.
@@ -394,7 +428,7 @@ func UnpackPayload(payloadData []byte) (*ons_pb2.SendONSTransactionPayload, erro
 	return payload, nil
 }
 
-func GetPermissionLevel(gs1_code string, requestor string, require_perm ons_manager.Permission, context *processor.Context) bool{
+func GetPermissionLevel(gs1_code string, requestor string, require_perm ons_manager.Permission, context ons_state.StateContext) bool{
 	permission, err:= ons_manager.CheckPermission(gs1_code, requestor, context)
 	if err != nil {
 		logger.Debugf("Failed to check permission")
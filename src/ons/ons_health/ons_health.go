@@ -0,0 +1,234 @@
+package ons_health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/daludaluking/ons-sawtooth-sdk/logging"
+)
+
+const (
+	//processor.Start()를 호출했지만 validator에서 아직 transaction을 받지 않았다.
+	STATE_STARTING = "starting"
+	//validator에서 transaction을 받았다. validator는 등록된 processor에만 transaction을 보낸다.
+	STATE_PROCESSING = "processing"
+	//processor.Start()가 반환되었다.
+	STATE_STOPPED = "stopped"
+
+	//validator endpoint에 연결할 수 있는지 확인하는 간격과 timeout
+	PROBE_INTERVAL = 5 * time.Second
+	PROBE_TIMEOUT  = 2 * time.Second
+)
+
+var logger *logging.Logger = logging.Get()
+
+//sawtooth sdk의 TransactionProcessor는 연결 상태와 등록 결과를 외부에 알려주지 않는다.
+//그래서 추측하지 않고 확인할 수 있는 것만 보고한다.
+//Start()를 호출한 시점, validator가 보낸 transaction, Start()가 반환된 시점과 error,
+//그리고 validator endpoint에 직접 연결해 본 결과.
+type status struct {
+	mutex                 sync.Mutex
+	endpoint              string
+	state                 string
+	startedTime           time.Time
+	stopError             string
+	connected             bool
+	connectError          string
+	lastProbeTime         time.Time
+	lastTransactionTime   time.Time
+	lastTransactionSig    string
+	lastTransactionType   string
+	lastTransactionResult string
+}
+
+var g_status = &status{state: STATE_STARTING}
+
+type Report struct {
+	Status    string `json:"status"`
+	Endpoint  string `json:"endpoint"`
+	State     string `json:"state"`
+	Started   string `json:"started,omitempty"`
+	StopError string `json:"stop_error,omitempty"`
+	//validator endpoint에 연결할 수 있는지
+	Connected    bool   `json:"connected"`
+	ConnectError string `json:"connect_error,omitempty"`
+	LastProbe    string `json:"last_probe,omitempty"`
+	//transaction processor는 block 정보를 받지 않기 때문에 마지막으로 처리한 transaction을 보고한다.
+	LastTransaction *TransactionReport `json:"last_transaction,omitempty"`
+}
+
+type TransactionReport struct {
+	Signature string `json:"signature"`
+	Type      string `json:"type"`
+	Result    string `json:"result"`
+	Time      string `json:"time"`
+}
+
+func SetEndpoint(endpoint string) {
+	g_status.mutex.Lock()
+	defer g_status.mutex.Unlock()
+	g_status.endpoint = endpoint
+}
+
+//processor.Start()를 호출하기 직전에 호출한다.
+func SetStarting() {
+	g_status.mutex.Lock()
+	defer g_status.mutex.Unlock()
+	g_status.state = STATE_STARTING
+	g_status.startedTime = time.Now()
+	g_status.stopError = ""
+}
+
+//processor.Start()가 반환되면 호출한다. 연결이나 등록에 실패하면 err가 있다.
+func SetStopped(err error) {
+	g_status.mutex.Lock()
+	defer g_status.mutex.Unlock()
+	g_status.state = STATE_STOPPED
+	if err != nil {
+		g_status.stopError = err.Error()
+	}
+}
+
+//validator endpoint에 연결해 본 결과를 기록한다.
+func SetConnected(connected bool, err error) {
+	g_status.mutex.Lock()
+	defer g_status.mutex.Unlock()
+	if connected != g_status.connected {
+		logger.Infof("Validator endpoint %v is reachable: %v", g_status.endpoint, connected)
+	}
+	g_status.connected = connected
+	g_status.lastProbeTime = time.Now()
+	g_status.connectError = ""
+	if err != nil {
+		g_status.connectError = err.Error()
+	}
+}
+
+//processor가 처리한 transaction을 기록한다. 첫 transaction을 받으면 processing 상태가 된다.
+func ObserveTransaction(signature string, tx_type string, err error) {
+	g_status.mutex.Lock()
+	defer g_status.mutex.Unlock()
+	if g_status.state == STATE_STARTING {
+		logger.Infof("Received the first transaction from the validator")
+		g_status.state = STATE_PROCESSING
+	}
+	g_status.lastTransactionTime = time.Now()
+	g_status.lastTransactionSig = signature
+	g_status.lastTransactionType = tx_type
+	if err != nil {
+		g_status.lastTransactionResult = "rejected: " + err.Error()
+	} else {
+		g_status.lastTransactionResult = "applied"
+	}
+}
+
+func GetReport() *Report {
+	g_status.mutex.Lock()
+	defer g_status.mutex.Unlock()
+
+	report := &Report{
+		Endpoint:     g_status.endpoint,
+		State:        g_status.state,
+		StopError:    g_status.stopError,
+		Connected:    g_status.connected,
+		ConnectError: g_status.connectError,
+	}
+
+	if g_status.lastProbeTime.IsZero() == false {
+		report.LastProbe = g_status.lastProbeTime.Format(time.RFC3339)
+	}
+
+	if g_status.startedTime.IsZero() == false {
+		report.Started = g_status.startedTime.Format(time.RFC3339)
+	}
+
+	if g_status.lastTransactionTime.IsZero() == false {
+		report.LastTransaction = &TransactionReport{
+			Signature: g_status.lastTransactionSig,
+			Type:      g_status.lastTransactionType,
+			Result:    g_status.lastTransactionResult,
+			Time:      g_status.lastTransactionTime.Format(time.RFC3339),
+		}
+	}
+
+	if report.Ready() {
+		report.Status = "ok"
+	} else {
+		report.Status = "unavailable"
+	}
+	return report
+}
+
+//processor가 멈추지 않았고 validator endpoint에 연결할 수 있으면 ready이다.
+//transaction을 받지 않는 idle chain에서도 ready가 될 수 있도록 transaction은 기다리지 않는다.
+func (report *Report) Ready() bool {
+	return report.State != STATE_STOPPED && report.Connected
+}
+
+//endpoint(tcp://host:port)에 TCP로 연결해 본다. tcp가 아닌 endpoint는 확인할 수 없다.
+func probeEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "tcp" {
+		return fmt.Errorf("cannot probe %s endpoint", u.Scheme)
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, PROBE_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+//processor가 멈출 때까지 PROBE_INTERVAL마다 validator endpoint에 연결해 본다.
+func runProbe() {
+	for {
+		report := GetReport()
+		if report.State == STATE_STOPPED {
+			return
+		}
+		err := probeEndpoint(report.Endpoint)
+		SetConnected(err == nil, err)
+		time.Sleep(PROBE_INTERVAL)
+	}
+}
+
+func writeReport(w http.ResponseWriter, report *Report, healthy bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+//addr로 /healthz, /readyz를 제공하는 http listener를 background로 실행한다.
+//healthz는 processor가 멈추지 않았는지, readyz는 validator endpoint에 연결할 수 있는지를 보고한다.
+//sdk가 연결 상태를 알려주지 않기 때문에 background에서 validator endpoint에 직접 연결해 본다.
+func StartListener(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		report := GetReport()
+		writeReport(w, report, report.State != STATE_STOPPED)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		report := GetReport()
+		writeReport(w, report, report.Ready())
+	})
+	go runProbe()
+
+	go func() {
+		logger.Infof("Serving health checks on %v", addr)
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			logger.Errorf("Health listener stopped: %v", err)
+		}
+	}()
+}
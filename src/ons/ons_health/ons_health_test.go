@@ -0,0 +1,42 @@
+package ons_health
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestProbeEndpoint(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	if err := probeEndpoint("tcp://" + addr); err != nil {
+		t.Errorf("probe of a listening endpoint : %v", err)
+	}
+	listener.Close()
+	if err := probeEndpoint("tcp://" + addr); err == nil {
+		t.Errorf("probe of a closed endpoint succeeded")
+	}
+	if err := probeEndpoint("ipc:///tmp/validator"); err == nil {
+		t.Errorf("probe of an ipc endpoint succeeded")
+	}
+}
+
+//transaction을 받지 않아도 validator endpoint에 연결할 수 있으면 ready이다.
+func TestReadyWithoutTransactions(t *testing.T) {
+	SetStarting()
+	SetConnected(false, errors.New("connection refused"))
+	if report := GetReport(); report.Ready() == true || report.Status != "unavailable" {
+		t.Errorf("ready without a validator : %+v", report)
+	}
+	SetConnected(true, nil)
+	if report := GetReport(); report.Ready() == false || report.Status != "ok" || report.State != STATE_STARTING {
+		t.Errorf("not ready with a validator : %+v", report)
+	}
+	SetStopped(errors.New("stopped"))
+	if report := GetReport(); report.Ready() == true {
+		t.Errorf("ready after the processor is stopped : %+v", report)
+	}
+}
@@ -0,0 +1,132 @@
+package ons_logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+
+	//sawtooth sdk logger가 쓰는 시간 형식 (log.LstdFlags)
+	SDK_TIME_LAYOUT = "2006/01/02 15:04:05"
+	MAX_LINE_SIZE   = 1024 * 1024
+)
+
+var Formats = []string{FORMAT_TEXT, FORMAT_JSON}
+
+var sdkCallerRegexp = regexp.MustCompile(`^(\S+\.go:\d+): `)
+var sdkLevelRegexp = regexp.MustCompile(`^\[?(DEBUG|INFO|WARN|WARNING|ERROR|CRITICAL)\]?:?\s+`)
+
+//json format의 한 줄
+type Entry struct {
+	Time    string `json:"time,omitempty"`
+	Level   string `json:"level,omitempty"`
+	Caller  string `json:"caller,omitempty"`
+	Message string `json:"msg"`
+}
+
+//format을 바꾼 stdout pipe. Close에서 닫고 남은 log를 모두 쓸 때까지 기다린다.
+var g_pipe *os.File
+var g_done chan struct{}
+
+func IsFormat(format string) bool {
+	for _, f := range Formats {
+		if strings.ToLower(format) == f {
+			return true
+		}
+	}
+	return false
+}
+
+//sawtooth sdk logger는 출력 형식을 바꿀 수 없고 stdout에 text로 쓴다.
+//json이면 stdout(fd 1)을 pipe로 바꾸고 한 줄씩 JSON object로 바꿔서 원래 stdout에 쓴다.
+//os.Stdout은 원래 stdout으로 바꾸므로 simulate 결과처럼 직접 쓰는 출력은 바뀌지 않는다.
+func SetFormat(format string) error {
+	if strings.ToLower(format) != FORMAT_JSON || g_pipe != nil {
+		return nil
+	}
+
+	stdout, err := dupStdout()
+	if err != nil {
+		return err
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		stdout.Close()
+		return err
+	}
+	err = redirectStdout(writer)
+	if err != nil {
+		reader.Close()
+		writer.Close()
+		stdout.Close()
+		return err
+	}
+
+	os.Stdout = stdout
+	g_pipe = writer
+	g_done = make(chan struct{})
+	go func() {
+		defer close(g_done)
+		FormatLines(reader, stdout)
+	}()
+	return nil
+}
+
+//pipe를 닫고 남은 log를 모두 쓴다. 종료하기 전에 호출한다.
+func Close() {
+	if g_pipe == nil {
+		return
+	}
+	g_pipe.Close()
+	closeStdout()
+	<-g_done
+	g_pipe = nil
+}
+
+//r의 sdk log를 한 줄씩 JSON object로 바꿔서 w에 쓴다.
+func FormatLines(r io.Reader, w io.Writer) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MAX_LINE_SIZE)
+	encoder := json.NewEncoder(w)
+	for scanner.Scan() {
+		encoder.Encode(ParseLine(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		encoder.Encode(&Entry{Level: "error", Message: fmt.Sprintf("failed to read log: %v", err)})
+	}
+}
+
+//"2006/01/02 15:04:05 file.go:10: [DEBUG] message" 형식의 sdk log를 나눈다.
+//형식이 다르면 나머지를 그대로 message로 사용한다.
+func ParseLine(line string) *Entry {
+	entry := &Entry{}
+	if len(line) > len(SDK_TIME_LAYOUT) {
+		t, err := time.ParseInLocation(SDK_TIME_LAYOUT, line[:len(SDK_TIME_LAYOUT)], time.Local)
+		if err == nil {
+			entry.Time = t.Format(time.RFC3339)
+			line = strings.TrimPrefix(line[len(SDK_TIME_LAYOUT):], " ")
+		}
+	}
+	if m := sdkCallerRegexp.FindStringSubmatch(line); m != nil {
+		entry.Caller = m[1]
+		line = line[len(m[0]):]
+	}
+	if m := sdkLevelRegexp.FindStringSubmatch(line); m != nil {
+		entry.Level = strings.ToLower(m[1])
+		if entry.Level == "warning" {
+			entry.Level = "warn"
+		}
+		line = line[len(m[0]):]
+	}
+	entry.Message = line
+	return entry
+}
@@ -0,0 +1,61 @@
+package ons_logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	local_time := time.Date(2026, 10, 19, 11, 0, 0, 0, time.Local).Format(time.RFC3339)
+	tests := []struct {
+		line  string
+		entry Entry
+	}{
+		{"2026/10/19 11:00:00 ons_handler.go:62: [DEBUG] call apply from 02abc",
+			Entry{Time: local_time, Level: "debug", Caller: "ons_handler.go:62", Message: "call apply from 02abc"}},
+		{"2026/10/19 11:00:00 main.go:10: WARNING Processor stopped",
+			Entry{Time: local_time, Level: "warn", Caller: "main.go:10", Message: "Processor stopped"}},
+		{"2026/10/19 11:00:00 ERROR: failed",
+			Entry{Time: local_time, Level: "error", Message: "failed"}},
+		//sdk 형식이 아닌 줄은 그대로 message가 된다.
+		{"panic: runtime error", Entry{Message: "panic: runtime error"}},
+		{"2026/13/19 11:00:00 x", Entry{Message: "2026/13/19 11:00:00 x"}},
+		{"", Entry{}},
+	}
+	for _, test := range tests {
+		entry := ParseLine(test.line)
+		if *entry != test.entry {
+			t.Errorf("%q is parsed as %+v, want %+v", test.line, *entry, test.entry)
+		}
+	}
+}
+
+func TestFormatLines(t *testing.T) {
+	input := "2026/10/19 11:00:00 main.go:1: [INFO] first\nsecond \"quoted\"\n" + strings.Repeat("x", 100*1024)
+	output := &bytes.Buffer{}
+	FormatLines(strings.NewReader(input), output)
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("%d lines, want 3 : %s", len(lines), output.String())
+	}
+	messages := []string{"first", "second \"quoted\"", strings.Repeat("x", 100*1024)}
+	for idx, line := range lines {
+		entry := &Entry{}
+		err := json.Unmarshal([]byte(line), entry)
+		if err != nil || entry.Message != messages[idx] {
+			t.Errorf("line %d is %.80s, %v", idx, line, err)
+		}
+	}
+}
+
+func TestIsFormat(t *testing.T) {
+	for format, expected := range map[string]bool{"text": true, "JSON": true, "logfmt": false, "": false} {
+		if IsFormat(format) != expected {
+			t.Errorf("IsFormat(%q) is %v", format, !expected)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux
+// +build linux
+
+package ons_logging
+
+import (
+	"os"
+	"syscall"
+)
+
+func dupStdout() (*os.File, error) {
+	fd, err := syscall.Dup(1)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "/dev/stdout"), nil
+}
+
+func redirectStdout(writer *os.File) error {
+	return syscall.Dup3(int(writer.Fd()), 1, 0)
+}
+
+func closeStdout() {
+	syscall.Close(1)
+}
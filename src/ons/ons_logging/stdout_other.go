@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package ons_logging
+
+import (
+	"errors"
+	"os"
+)
+
+var errNotSupported = errors.New("json log format is supported only on linux")
+
+func dupStdout() (*os.File, error) {
+	return nil, errNotSupported
+}
+
+func redirectStdout(writer *os.File) error {
+	return errNotSupported
+}
+
+func closeStdout() {
+}
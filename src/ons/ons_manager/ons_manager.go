@@ -2,10 +2,12 @@ package ons_manager
 
 import (
 	"fmt"
+	"time"
 	"github.com/golang/protobuf/proto"
 	"github.com/daludaluking/ons-sawtooth-sdk/ons_pb2"
 	"github.com/daludaluking/ons-sawtooth-sdk/processor"
 	"github.com/daludaluking/ons-sawtooth-sdk/logging"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_metrics"
 	"github.com/daludaluking/ons-sawtooth/src/ons/ons_state"
 )
 
@@ -54,10 +56,12 @@ func UnpackONSManager(ons_manager_byte_data []byte) (*ons_pb2.ONSManager, error)
 	return ons_manager, nil
 }
 
-func LoadONSManager(context *processor.Context) (*ons_pb2.ONSManager, error) {
+func LoadONSManager(context ons_state.StateContext) (*ons_pb2.ONSManager, error) {
 	//address로 state를 읽어 들인다 -> saveGS1Code에서 저장된 data이다.
 	address := getONSManagerAddress()
+	start := time.Now()
 	results, err := context.GetState([]string{address})
+	ons_metrics.ObserveStateLatency(ons_metrics.STATE_OP_GET, start)
 	if err != nil {
 		logger.Debugf("LoadONSManager: address %v, error : %v", address, err)
 		return nil, err
@@ -75,7 +79,7 @@ func LoadONSManager(context *processor.Context) (*ons_pb2.ONSManager, error) {
 	return nil, nil
 }
 
-func SaveONSManager(requestor string, ons_manager_data *ons_pb2.ONSManager, context *processor.Context) error {
+func SaveONSManager(requestor string, ons_manager_data *ons_pb2.ONSManager, context ons_state.StateContext) error {
 	address := getONSManagerAddress()
 	data, err := proto.Marshal(ons_manager_data)
 	if err != nil {
@@ -83,9 +87,11 @@ func SaveONSManager(requestor string, ons_manager_data *ons_pb2.ONSManager, cont
 	}
 	logger.Debugf("SaveONSManager data length : %v", len(data))
 
+	start := time.Now()
 	addresses, err := context.SetState(map[string][]byte{
 		address: data,
 	})
+	ons_metrics.ObserveStateLatency(ons_metrics.STATE_OP_SET, start)
 	if err != nil {
 		return err
 	}
@@ -98,7 +104,7 @@ func SaveONSManager(requestor string, ons_manager_data *ons_pb2.ONSManager, cont
 	return nil
 }
 
-func loadCachedONSManager(context *processor.Context) error {
+func loadCachedONSManager(context ons_state.StateContext) error {
 	//context에서 manager address를 읽어와야 한다.
 	//매번 읽을 수 없으니 caching으로..
 	if g_state_cached == false {
@@ -117,6 +123,7 @@ func loadCachedONSManager(context *processor.Context) error {
 		}
 
 		g_ons_manager = _manager
+		ons_metrics.IncManagerCacheReload()
 
 		//caching에서 찾기..
 		for _, manager := range g_ons_manager.ManagerAddresses {
@@ -136,18 +143,30 @@ func clearCachedONSManager() {
 	//context에서 manager address를 읽어와야 한다.
 	//매번 읽을 수 없으니 caching으로..
 	if g_state_cached == true {
-		g_ons_manager = nil
-		g_cached_ons_managers = nil
-		g_cached_ons_sumanagers = nil
+		ResetCache()
+	}
+}
+
+//cache를 지운다. 다음 transaction에서 state로부터 다시 읽는다.
+//simulate mode에서 다른 snapshot을 읽거나 transaction이 reject 되었을 때 사용한다.
+func ResetCache() {
+	g_ons_manager = &ons_pb2.ONSManager{}
+	g_cached_ons_managers = make(map[string]string)
+	g_cached_ons_sumanagers = make(map[string]bool)
+	g_state_cached = false
+}
 
-		g_ons_manager = &ons_pb2.ONSManager{}
-		g_cached_ons_managers = make(map[string]string)
-		g_cached_ons_managers = make(map[string]string)
-		g_state_cached = false
+//cache에서 바꾼 ONS manager를 state에 저장한다.
+//저장하지 못하면 transaction이 reject 되어 state는 바뀌지 않으므로 바꾼 cache를 버린다.
+func saveCachedONSManager(requestor string, context ons_state.StateContext) error {
+	err := SaveONSManager(requestor, g_ons_manager, context)
+	if err != nil {
+		ResetCache()
 	}
+	return err
 }
 
-func CheckPermission(gs1_code string, address string, context *processor.Context) (Permission, error) {
+func CheckPermission(gs1_code string, address string, context ons_state.StateContext) (Permission, error) {
 	logger.Debugf("CheckPermission : %s, %s", address, g_sudo_address)
 	if address == g_sudo_address {
 		logger.Debugf("You have su address auth")
@@ -183,7 +202,7 @@ func CheckPermission(gs1_code string, address string, context *processor.Context
 	return PERMISSION_NONE, nil
 }
 
-func GetGS1CodeManagerAddress(gs1_code string, context *processor.Context) (string, bool, error) {
+func GetGS1CodeManagerAddress(gs1_code string, context ons_state.StateContext) (string, bool, error) {
 	//context에서 manager address를 읽어와야 한다.
 	//매번 읽을 수 없으니 caching으로..
 	if g_state_cached == false {
@@ -196,7 +215,7 @@ func GetGS1CodeManagerAddress(gs1_code string, context *processor.Context) (stri
 	return v, ok, nil
 }
 
-func AddGS1CodeManager(gs1_code string, address string, requestor string, context *processor.Context) error {
+func AddGS1CodeManager(gs1_code string, address string, requestor string, context ons_state.StateContext) error {
 	//context에서 manager address를 읽어와야 한다.
 	//매번 읽을 수 없으니 caching으로..
 	if g_state_cached == false {
@@ -211,7 +230,7 @@ func AddGS1CodeManager(gs1_code string, address string, requestor string, contex
 		logger.Debugf("gs1 code manager already exist in the cache : %v", gs1_code)
 		if v == address {
 			logger.Debugf("gs1 code manager has the same address: %v", address)
-			return SaveONSManager(requestor, g_ons_manager, context)
+			return saveCachedONSManager(requestor, context)
 		}
 	}
 
@@ -229,16 +248,16 @@ func AddGS1CodeManager(gs1_code string, address string, requestor string, contex
 			if manager.Gs1Code == gs1_code {
 				logger.Debugf("update gs1 code %s manager to %v from %v", gs1_code, address, manager.Address)
 				manager.Address = address
-				return SaveONSManager(requestor, g_ons_manager, context)
+				return saveCachedONSManager(requestor, context)
 			}
 		}
 		g_ons_manager.ManagerAddresses = append(g_ons_manager.ManagerAddresses, new_manager)
 	}
 
-	return SaveONSManager(requestor, g_ons_manager, context)
+	return saveCachedONSManager(requestor, context)
 }
 
-func RemoveGS1CodeManager(gs1_code string, requestor string, context *processor.Context) error {
+func RemoveGS1CodeManager(gs1_code string, requestor string, context ons_state.StateContext) error {
 	//context에서 manager address를 읽어와야 한다.
 	//매번 읽을 수 없으니 caching으로..
 	if g_state_cached == false {
@@ -261,18 +280,20 @@ func RemoveGS1CodeManager(gs1_code string, requestor string, context *processor.
 		}
 	}
 
-	return SaveONSManager(requestor, g_ons_manager, context)
+	return saveCachedONSManager(requestor, context)
 }
 
 //just for test
-func DeleteAllManager(context *processor.Context) error {
+func DeleteAllManager(context ons_state.StateContext) error {
 	address := getONSManagerAddress()
 	clearCachedONSManager()
+	start := time.Now()
 	_, err := context.DeleteState([]string{address})
+	ons_metrics.ObserveStateLatency(ons_metrics.STATE_OP_DELETE, start)
 	return err
 }
 
-func AddSuManager(su_address string, requestor string, context *processor.Context) error {
+func AddSuManager(su_address string, requestor string, context ons_state.StateContext) error {
 	if requestor != g_sudo_address {
 		logger.Debugf("You don't have su address auth")
 		return &processor.InvalidTransactionError{Msg: "AddSuManager : Authentication failed"}
@@ -281,7 +302,7 @@ func AddSuManager(su_address string, requestor string, context *processor.Contex
 	//update or add address as gs1 code manager to cached ons managers
 	if _, ok := g_cached_ons_sumanagers[su_address]; ok {
 		logger.Debugf("su manager already exist in the cache : %v, so just call SaveONSManager with the same data", su_address)
-		return SaveONSManager(requestor, g_ons_manager, context)
+		return saveCachedONSManager(requestor, context)
 	}
 
 	g_cached_ons_sumanagers[su_address] = true
@@ -297,10 +318,10 @@ func AddSuManager(su_address string, requestor string, context *processor.Contex
 		g_ons_manager.SuAddresses = append(g_ons_manager.SuAddresses, new_manager)
 	}
 
-	return SaveONSManager(requestor, g_ons_manager, context)
+	return saveCachedONSManager(requestor, context)
 }
 
-func RemoveSuManager(su_address string, requestor string, context *processor.Context) error {
+func RemoveSuManager(su_address string, requestor string, context ons_state.StateContext) error {
 	if requestor != g_sudo_address {
 		logger.Debugf("You don't have su address auth")
 		return &processor.InvalidTransactionError{Msg: "AddSuManager : Authentication failed"}
@@ -319,10 +340,10 @@ func RemoveSuManager(su_address string, requestor string, context *processor.Con
 		}
 	}
 
-	return SaveONSManager(requestor, g_ons_manager, context)
+	return saveCachedONSManager(requestor, context)
 }
 
-func OperateManager(op uint32, requestor string, context *processor.Context) error {
+func OperateManager(op uint32, requestor string, context ons_state.StateContext) error {
 	//if op is 1, load cache...
 	if requestor != g_sudo_address {
 		return &processor.InvalidTransactionError{Msg: "LoadManager : Authentication failed"}
@@ -0,0 +1,108 @@
+package ons_manager
+
+import (
+	"errors"
+	"testing"
+)
+
+const (
+	TEST_SUDO_ADDRESS    = "02sudo"
+	TEST_MANAGER_ADDRESS = "02manager"
+)
+
+//memory state를 사용하는 StateContext. fail_set이면 SetState가 실패한다.
+type testContext struct {
+	state     map[string][]byte
+	fail_set  bool
+	get_count int
+}
+
+func (self *testContext) GetState(addresses []string) (map[string][]byte, error) {
+	self.get_count++
+	results := make(map[string][]byte)
+	for _, address := range addresses {
+		if data, ok := self.state[address]; ok {
+			results[address] = data
+		}
+	}
+	return results, nil
+}
+
+func (self *testContext) SetState(pairs map[string][]byte) ([]string, error) {
+	if self.fail_set == true {
+		return nil, errors.New("set state failed")
+	}
+	addresses := []string{}
+	for address, data := range pairs {
+		self.state[address] = data
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+func (self *testContext) DeleteState(addresses []string) ([]string, error) {
+	for _, address := range addresses {
+		delete(self.state, address)
+	}
+	return addresses, nil
+}
+
+func TestRejectedManagerIsNotCached(t *testing.T) {
+	SetSudoAddress(TEST_SUDO_ADDRESS)
+	ResetCache()
+	t.Cleanup(ResetCache)
+	context := &testContext{state: map[string][]byte{}}
+
+	err := AddGS1CodeManager("8801", TEST_MANAGER_ADDRESS, TEST_SUDO_ADDRESS, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//state에 저장하지 못한 manager는 cache에도 남지 않는다.
+	context.fail_set = true
+	if err := AddGS1CodeManager("8802", TEST_MANAGER_ADDRESS, TEST_SUDO_ADDRESS, context); err == nil {
+		t.Fatalf("AddGS1CodeManager succeeded without saving the state")
+	}
+	if err := AddSuManager(TEST_MANAGER_ADDRESS, TEST_SUDO_ADDRESS, context); err == nil {
+		t.Fatalf("AddSuManager succeeded without saving the state")
+	}
+	context.fail_set = false
+
+	for gs1_code, expected := range map[string]bool{"8801": true, "8802": false} {
+		_, ok, err := GetGS1CodeManagerAddress(gs1_code, context)
+		if err != nil || ok != expected {
+			t.Errorf("manager of %s exists : %v, %v, want %v", gs1_code, ok, err, expected)
+		}
+	}
+	permission, err := CheckPermission("8801", TEST_MANAGER_ADDRESS, context)
+	if err != nil || permission != PERMISSION_MANAGER {
+		t.Errorf("permission of %s is %v, %v, want PERMISSION_MANAGER", TEST_MANAGER_ADDRESS, permission, err)
+	}
+}
+
+//manager를 바꾸지 않는 transaction이 reject 되어도 cache를 다시 읽지 않는다.
+func TestPermissionCheckKeepsCache(t *testing.T) {
+	SetSudoAddress(TEST_SUDO_ADDRESS)
+	ResetCache()
+	t.Cleanup(ResetCache)
+	context := &testContext{state: map[string][]byte{}}
+
+	err := AddGS1CodeManager("8801", TEST_MANAGER_ADDRESS, TEST_SUDO_ADDRESS, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//state에 manager가 없을 때 AddGS1CodeManager가 읽은 값은 cache되지 않으므로 한 번 더 읽는다.
+	if _, err := CheckPermission("8801", "02other", context); err != nil {
+		t.Fatal(err)
+	}
+	get_count := context.get_count
+	for i := 0; i < 10; i++ {
+		permission, err := CheckPermission("8801", "02other", context)
+		if err != nil || permission != PERMISSION_NONE {
+			t.Fatalf("permission of 02other is %v, %v, want PERMISSION_NONE", permission, err)
+		}
+	}
+	if context.get_count != get_count {
+		t.Errorf("ONS manager is loaded %d times", context.get_count-get_count)
+	}
+}
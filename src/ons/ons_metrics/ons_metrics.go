@@ -0,0 +1,127 @@
+package ons_metrics
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/daludaluking/ons-sawtooth-sdk/logging"
+	"github.com/daludaluking/ons-sawtooth-sdk/processor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	STATE_OP_GET    = "get"
+	STATE_OP_SET    = "set"
+	STATE_OP_DELETE = "delete"
+)
+
+var logger *logging.Logger = logging.Get()
+
+var (
+	transactionsApplied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ons",
+		Name:      "transactions_applied_total",
+		Help:      "Number of ONS transactions applied successfully, by ONSTransactionType.",
+	}, []string{"type"})
+
+	transactionsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ons",
+		Name:      "transactions_rejected_total",
+		Help:      "Number of ONS transactions rejected, by ONSTransactionType and reason.",
+	}, []string{"type", "reason"})
+
+	stateLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ons",
+		Name:      "state_request_duration_seconds",
+		Help:      "Latency of global state requests to the validator.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"op"})
+
+	payloadSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ons",
+		Name:      "transaction_payload_bytes",
+		Help:      "Size of ONS transaction payloads, by ONSTransactionType.",
+		Buckets:   prometheus.ExponentialBuckets(32, 2, 12),
+	}, []string{"type"})
+
+	managerCacheReloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "ons",
+		Name:      "manager_cache_reloads_total",
+		Help:      "Number of times the ONS manager cache was loaded from global state.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(transactionsApplied, transactionsRejected, stateLatency, payloadSize, managerCacheReloads)
+}
+
+func ObserveApplied(tx_type string) {
+	transactionsApplied.WithLabelValues(tx_type).Inc()
+}
+
+func ObserveRejected(tx_type string, err error) {
+	transactionsRejected.WithLabelValues(tx_type, RejectionReason(err)).Inc()
+}
+
+func ObserveStateLatency(op string, start time.Time) {
+	stateLatency.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+func ObservePayloadSize(tx_type string, size int) {
+	payloadSize.WithLabelValues(tx_type).Observe(float64(size))
+}
+
+func IncManagerCacheReload() {
+	managerCacheReloads.Inc()
+}
+
+//error message에는 gs1 code나 index가 포함되므로 그대로 label로 쓰면 cardinality가 커진다.
+//handler에서 사용하는 message 문구를 기준으로 고정된 reason으로 분류한다.
+var rejectionReasons = []struct {
+	fragment string
+	reason   string
+}{
+	{"Authentication failed", "authentication_failed"},
+	{"already exists", "already_exists"},
+	{"doesn't exist", "not_found"},
+	{"Invalid index", "invalid_index"},
+	{"doesn't match", "not_owner"},
+	{"mismatch", "not_owner"},
+	{"Invalid TransactionType", "invalid_transaction_type"},
+	{"Faied to Unpack", "invalid_state_data"},
+}
+
+func RejectionReason(err error) string {
+	switch e := err.(type) {
+	case *processor.InvalidTransactionError:
+		for _, r := range rejectionReasons {
+			if strings.Contains(e.Msg, r.fragment) {
+				return r.reason
+			}
+		}
+		return "invalid_transaction"
+	case *processor.InternalError:
+		if strings.Contains(e.Msg, "unmarshal ONSTransaction") {
+			return "invalid_payload"
+		}
+		return "internal_error"
+	default:
+		return "error"
+	}
+}
+
+//addr로 /metrics를 제공하는 http listener를 background로 실행한다.
+func StartListener(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		logger.Infof("Serving metrics on %v/metrics", addr)
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			logger.Errorf("Metrics listener stopped: %v", err)
+		}
+	}()
+}
@@ -2,10 +2,13 @@ package ons_service
 
 import (
 	"fmt"
+	"time"
 	"github.com/golang/protobuf/proto"
 	"github.com/daludaluking/ons-sawtooth-sdk/ons_pb2"
 	"github.com/daludaluking/ons-sawtooth-sdk/processor"
 	"github.com/daludaluking/ons-sawtooth-sdk/logging"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_metrics"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_state"
 )
 
 var logger *logging.Logger = logging.Get()
@@ -22,11 +25,13 @@ func UnpackServiceType(service_type_byte_data []byte) (*ons_pb2.ServiceType, err
 	return service_type_data, nil
 }
 
-func LoadServiceType(address string, context *processor.Context) (*ons_pb2.ServiceType, error) {
+func LoadServiceType(address string, context ons_state.StateContext) (*ons_pb2.ServiceType, error) {
 	logger.Debugf("LoadServiceType address: " + address)
 
 	//address로 state를 읽어 들인다 -> saveGS1Code에서 저장된 data이다.
+	start := time.Now()
 	results, err := context.GetState([]string{address})
+	ons_metrics.ObserveStateLatency(ons_metrics.STATE_OP_GET, start)
 
 	if err != nil {
 		logger.Debugf("Failed to LoadServiceType(1): " + address)
@@ -45,11 +50,13 @@ func LoadServiceType(address string, context *processor.Context) (*ons_pb2.Servi
 	return nil, nil
 }
 
-func CheckAddress(address string, context *processor.Context) bool {
+func CheckAddress(address string, context ons_state.StateContext) bool {
 	logger.Debugf("CheckAddress address: " + address)
 
 	//address로 state를 읽어 들인다 -> saveGS1Code에서 저장된 data이다.
+	start := time.Now()
 	results, err := context.GetState([]string{address})
+	ons_metrics.ObserveStateLatency(ons_metrics.STATE_OP_GET, start)
 
 	if err != nil {
 		logger.Debugf("Failed to CheckAddress(1): " + address)
@@ -63,7 +70,7 @@ func CheckAddress(address string, context *processor.Context) bool {
 	return false
 }
 
-func SaveServiceType(address string, service_type_data *ons_pb2.ServiceType, context *processor.Context) error {
+func SaveServiceType(address string, service_type_data *ons_pb2.ServiceType, context ons_state.StateContext) error {
 	data, err := proto.Marshal(service_type_data)
 	if err != nil {
 		return &processor.InternalError{Msg: fmt.Sprint("Failed to serialize service type data:", err)}
@@ -71,9 +78,11 @@ func SaveServiceType(address string, service_type_data *ons_pb2.ServiceType, con
 
 	logger.Debugf("data length : %v", len(data))
 
+	start := time.Now()
 	addresses, err := context.SetState(map[string][]byte{
 		address: data,
 	})
+	ons_metrics.ObserveStateLatency(ons_metrics.STATE_OP_SET, start)
 	if err != nil {
 		return err
 	}
@@ -87,9 +96,11 @@ func SaveServiceType(address string, service_type_data *ons_pb2.ServiceType, con
 	return nil
 }
 
-func DeleteServiceType(address string, context *processor.Context) error {
+func DeleteServiceType(address string, context ons_state.StateContext) error {
 	//address로 state를 읽어 들인다 -> saveGS1Code에서 저장된 data이다.
+	start := time.Now()
 	results, err := context.DeleteState([]string{address})
+	ons_metrics.ObserveStateLatency(ons_metrics.STATE_OP_DELETE, start)
 
 	if err != nil {
 		return &processor.InternalError{Msg: fmt.Sprint("Failed to detele service type:", err)}
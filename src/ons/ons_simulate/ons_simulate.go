@@ -0,0 +1,396 @@
+package ons_simulate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/daludaluking/ons-sawtooth-sdk/logging"
+	"github.com/daludaluking/ons-sawtooth-sdk/ons_pb2"
+	"github.com/daludaluking/ons-sawtooth-sdk/protobuf/processor_pb2"
+	"github.com/daludaluking/ons-sawtooth-sdk/protobuf/transaction_pb2"
+	ons "github.com/daludaluking/ons-sawtooth/src/ons/ons_handler"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_manager"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_metrics"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_state"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+var logger *logging.Logger = logging.Get()
+
+//global state snapshot을 memory에 가지고 있는 StateContext.
+//transaction 하나가 실행되는 동안의 변경은 pending에 쌓아 두었다가
+//transaction이 성공하면 Commit, 실패하면 Rollback 한다. (validator가 invalid transaction의 변경을 버리는 것과 같다.)
+type MemoryContext struct {
+	state   map[string][]byte
+	pending map[string][]byte
+	inputs  []string
+	outputs []string
+}
+
+func NewMemoryContext(snapshot map[string][]byte) *MemoryContext {
+	state := make(map[string][]byte)
+	for address, data := range snapshot {
+		state[address] = data
+	}
+	return &MemoryContext{
+		state:   state,
+		pending: make(map[string][]byte),
+	}
+}
+
+//transaction header의 inputs/outputs와 같이 접근 가능한 address prefix를 제한한다.
+//nil이면 제한하지 않는다.
+func (self *MemoryContext) SetAuthorizedAddresses(inputs []string, outputs []string) {
+	self.inputs = inputs
+	self.outputs = outputs
+}
+
+func isAuthorized(address string, prefixes []string) bool {
+	if prefixes == nil {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(address, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *MemoryContext) get(address string) []byte {
+	if data, ok := self.pending[address]; ok {
+		return data
+	}
+	return self.state[address]
+}
+
+func (self *MemoryContext) GetState(addresses []string) (map[string][]byte, error) {
+	results := make(map[string][]byte)
+	for _, address := range addresses {
+		if isAuthorized(address, self.inputs) == false {
+			return nil, fmt.Errorf("Tried to get unauthorized address: %v", address)
+		}
+		if data := self.get(address); len(data) > 0 {
+			results[address] = data
+		}
+	}
+	return results, nil
+}
+
+func (self *MemoryContext) SetState(pairs map[string][]byte) ([]string, error) {
+	addresses := make([]string, 0, len(pairs))
+	for address := range pairs {
+		if isAuthorized(address, self.outputs) == false {
+			return nil, fmt.Errorf("Tried to set unauthorized address: %v", address)
+		}
+	}
+	for address, data := range pairs {
+		self.pending[address] = data
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+func (self *MemoryContext) DeleteState(addresses []string) ([]string, error) {
+	deleted := []string{}
+	for _, address := range addresses {
+		if isAuthorized(address, self.outputs) == false {
+			return nil, fmt.Errorf("Tried to delete unauthorized address: %v", address)
+		}
+		if len(self.get(address)) > 0 {
+			deleted = append(deleted, address)
+		}
+		//empty value는 삭제된 address를 의미한다.
+		self.pending[address] = []byte{}
+	}
+	return deleted, nil
+}
+
+func (self *MemoryContext) Commit() {
+	for address, data := range self.pending {
+		if len(data) == 0 {
+			delete(self.state, address)
+		} else {
+			self.state[address] = data
+		}
+	}
+	self.pending = make(map[string][]byte)
+}
+
+func (self *MemoryContext) Rollback() {
+	self.pending = make(map[string][]byte)
+}
+
+func (self *MemoryContext) State() map[string][]byte {
+	return self.state
+}
+
+//simulate할 transaction.
+//payload에는 base64로 encoding된 SendONSTransactionPayload를,
+//또는 transaction에는 SendONSTransactionPayload를 protobuf JSON 형식으로 지정한다.
+type Transaction struct {
+	Signer      string          `json:"signer"`
+	Payload     string          `json:"payload,omitempty"`
+	Transaction json.RawMessage `json:"transaction,omitempty"`
+	Inputs      []string        `json:"inputs,omitempty"`
+	Outputs     []string        `json:"outputs,omitempty"`
+}
+
+func (self *Transaction) PayloadBytes() ([]byte, error) {
+	if len(self.Payload) > 0 {
+		return base64.StdEncoding.DecodeString(self.Payload)
+	}
+	if len(self.Transaction) == 0 {
+		return nil, fmt.Errorf("neither payload nor transaction is given")
+	}
+	payload := &ons_pb2.SendONSTransactionPayload{}
+	err := jsonpb.Unmarshal(bytes.NewReader(self.Transaction), payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction JSON: %v", err)
+	}
+	return proto.Marshal(payload)
+}
+
+type TransactionResult struct {
+	Index    int    `json:"index"`
+	Type     string `json:"type"`
+	Signer   string `json:"signer"`
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type StateDiff struct {
+	Address string          `json:"address"`
+	Kind    string          `json:"kind"`
+	Change  string          `json:"change"`
+	Before  json.RawMessage `json:"before,omitempty"`
+	After   json.RawMessage `json:"after,omitempty"`
+}
+
+type Report struct {
+	Accepted     int                  `json:"accepted"`
+	Rejected     int                  `json:"rejected"`
+	Transactions []*TransactionResult `json:"transactions"`
+	Diff         []*StateDiff         `json:"diff"`
+}
+
+//REST API의 /state 응답(또는 그 page들의 array)이나
+//{"address": "base64 data"} 형식의 JSON dump를 읽어 들인다.
+func LoadSnapshot(path string) (map[string][]byte, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	type restEntry struct {
+		Address string `json:"address"`
+		Data    string `json:"data"`
+	}
+	type restPage struct {
+		Data []restEntry `json:"data"`
+	}
+
+	var pages []restPage
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &pages)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a list of REST /state pages: %v", path, err)
+		}
+	} else {
+		var object map[string]json.RawMessage
+		err = json.Unmarshal(trimmed, &object)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a JSON object: %v", path, err)
+		}
+		if data, ok := object["data"]; ok && len(data) > 0 && bytes.TrimSpace(data)[0] == '[' {
+			var page restPage
+			err = json.Unmarshal(trimmed, &page)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not a REST /state page: %v", path, err)
+			}
+			pages = []restPage{page}
+		} else {
+			page := restPage{}
+			for address, value := range object {
+				var encoded string
+				err = json.Unmarshal(value, &encoded)
+				if err != nil {
+					return nil, fmt.Errorf("%s: value of %s is not a base64 string", path, address)
+				}
+				page.Data = append(page.Data, restEntry{Address: address, Data: encoded})
+			}
+			pages = []restPage{page}
+		}
+	}
+
+	snapshot := make(map[string][]byte)
+	for _, page := range pages {
+		for _, entry := range page.Data {
+			data, err := base64.StdEncoding.DecodeString(entry.Data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: failed to decode data of %s: %v", path, entry.Address, err)
+			}
+			snapshot[entry.Address] = data
+		}
+	}
+	return snapshot, nil
+}
+
+func LoadTransactions(path string) ([]*Transaction, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var transactions []*Transaction
+	err = json.Unmarshal(raw, &transactions)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a list of transactions: %v", path, err)
+	}
+	return transactions, nil
+}
+
+//snapshot에 transactions를 순서대로 적용하고 결과와 state 변경 내역을 반환한다.
+//network에는 접근하지 않는다.
+//transaction이 reject 되면 state 변경과 함께 ons_manager의 cache도 버린다.
+func Run(handler *ons.ONSHandler, snapshot map[string][]byte, transactions []*Transaction) *Report {
+	//이전에 다른 state로 읽은 ONS manager cache를 사용하지 않는다.
+	ons_manager.ResetCache()
+	context := NewMemoryContext(snapshot)
+	report := &Report{Transactions: []*TransactionResult{}, Diff: []*StateDiff{}}
+
+	for idx, transaction := range transactions {
+		result := &TransactionResult{Index: idx, Signer: transaction.Signer, Type: "UNKNOWN"}
+		report.Transactions = append(report.Transactions, result)
+
+		payload_bytes, err := transaction.PayloadBytes()
+		if err != nil {
+			result.Reason = "invalid_payload"
+			result.Error = err.Error()
+			report.Rejected++
+			continue
+		}
+
+		if payload, err := ons.UnpackPayload(payload_bytes); err == nil {
+			result.Type = payload.TransactionType.String()
+		}
+
+		request := &processor_pb2.TpProcessRequest{
+			Header: &transaction_pb2.TransactionHeader{
+				FamilyName:      ons_state.GetFamilyName(),
+				FamilyVersion:   ons_state.GetFamilyVersion(),
+				SignerPublicKey: transaction.Signer,
+				Inputs:          transaction.Inputs,
+				Outputs:         transaction.Outputs,
+			},
+			Payload:   payload_bytes,
+			Signature: fmt.Sprintf("simulated-%d", idx),
+		}
+
+		context.SetAuthorizedAddresses(transaction.Inputs, transaction.Outputs)
+		err = handler.ApplyRequest(request, context)
+		if err != nil {
+			context.Rollback()
+			ons_manager.ResetCache()
+			result.Reason = ons_metrics.RejectionReason(err)
+			result.Error = err.Error()
+			report.Rejected++
+			logger.Debugf("simulate: transaction %d rejected: %v", idx, err)
+			continue
+		}
+
+		context.Commit()
+		result.Accepted = true
+		report.Accepted++
+	}
+
+	report.Diff = Diff(snapshot, context.State())
+	return report
+}
+
+func Diff(before map[string][]byte, after map[string][]byte) []*StateDiff {
+	addresses := []string{}
+	for address := range before {
+		addresses = append(addresses, address)
+	}
+	for address := range after {
+		if _, ok := before[address]; ok == false {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+
+	diff := []*StateDiff{}
+	for _, address := range addresses {
+		old_data, had := before[address]
+		new_data, has := after[address]
+
+		var change string
+		switch {
+		case had && has == false:
+			change = "deleted"
+		case had == false && has:
+			change = "added"
+		case bytes.Equal(old_data, new_data) == false:
+			change = "modified"
+		default:
+			continue
+		}
+
+		entry := &StateDiff{Address: address, Kind: addressKind(address), Change: change}
+		if had {
+			entry.Before = decodeState(address, old_data)
+		}
+		if has {
+			entry.After = decodeState(address, new_data)
+		}
+		diff = append(diff, entry)
+	}
+	return diff
+}
+
+func addressKind(address string) string {
+	namespace := ons_state.GetNameSapce()
+	switch {
+	case strings.HasPrefix(address, namespace+ons_state.Hexdigest("gs1")[:8]):
+		return "gs1_code"
+	case strings.HasPrefix(address, namespace+ons_state.Hexdigest("service-type")[:8]):
+		return "service_type"
+	case address == namespace+ons_state.Hexdigest("ons_manager")[:64]:
+		return "ons_manager"
+	default:
+		return "unknown"
+	}
+}
+
+//address 종류에 맞는 protobuf message로 decoding해서 JSON으로 반환한다.
+//decoding할 수 없으면 base64 string을 반환한다.
+func decodeState(address string, data []byte) json.RawMessage {
+	var message proto.Message
+	switch addressKind(address) {
+	case "gs1_code":
+		message = &ons_pb2.GS1CodeData{}
+	case "service_type":
+		message = &ons_pb2.ServiceType{}
+	case "ons_manager":
+		message = &ons_pb2.ONSManager{}
+	}
+
+	if message != nil && proto.Unmarshal(data, message) == nil {
+		m := &jsonpb.Marshaler{}
+		json_string, err := m.MarshalToString(message)
+		if err == nil {
+			return json.RawMessage(json_string)
+		}
+	}
+
+	encoded, _ := json.Marshal(base64.StdEncoding.EncodeToString(data))
+	return json.RawMessage(encoded)
+}
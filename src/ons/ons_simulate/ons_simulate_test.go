@@ -0,0 +1,109 @@
+package ons_simulate
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/daludaluking/ons-sawtooth-sdk/ons_pb2"
+	ons "github.com/daludaluking/ons-sawtooth/src/ons/ons_handler"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_state"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	TEST_SUDO_ADDRESS    = "02sudo"
+	TEST_MANAGER_ADDRESS = "02manager"
+	TEST_GS1_CODE        = "8801234567890"
+)
+
+func newTestTransaction(t *testing.T, signer string, payload *ons_pb2.SendONSTransactionPayload) *Transaction {
+	data, err := proto.Marshal(payload)
+	if err != nil {
+		t.Fatalf("failed to marshal payload: %v", err)
+	}
+	return &Transaction{Signer: signer, Payload: base64.StdEncoding.EncodeToString(data)}
+}
+
+func newAddManager(t *testing.T, gs1_code string, address string) *Transaction {
+	return newTestTransaction(t, TEST_SUDO_ADDRESS, &ons_pb2.SendONSTransactionPayload{
+		TransactionType: ons_pb2.SendONSTransactionPayload_ADD_MANAGER,
+		AddManager: &ons_pb2.SendONSTransactionPayload_AddManagerTransactionData{
+			Gs1Code: gs1_code,
+			Address: address,
+		},
+	})
+}
+
+func newAddRecord(t *testing.T, signer string, gs1_code string) *Transaction {
+	return newTestTransaction(t, signer, &ons_pb2.SendONSTransactionPayload{
+		TransactionType: ons_pb2.SendONSTransactionPayload_ADD_RECORD,
+		AddRecord: &ons_pb2.SendONSTransactionPayload_AddRecordTransactionData{
+			Gs1Code: gs1_code,
+			Record: &ons_pb2.SendONSTransactionPayload_RecordTranactionData{
+				Flags:   1,
+				Service: "http://example.com/service.xml",
+				Regexp:  "!^.*$!http://example.com/!",
+			},
+		},
+	})
+}
+
+func newTestSnapshot(t *testing.T) map[string][]byte {
+	data, err := proto.Marshal(&ons_pb2.GS1CodeData{
+		Gs1Code: TEST_GS1_CODE,
+		OwnerId: TEST_SUDO_ADDRESS,
+		State:   ons_pb2.GS1CodeData_GS1CODE_ACTIVE,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal gs1 code: %v", err)
+	}
+	return map[string][]byte{ons_state.MakeAddress(TEST_GS1_CODE): data}
+}
+
+//reject 된 ADD_MANAGER의 manager가 뒤 transaction에서 권한을 가지면 안 된다.
+func TestRejectedManagerIsNotCached(t *testing.T) {
+	handler := &ons.ONSHandler{}
+	handler.SetSudoAddress(TEST_SUDO_ADDRESS)
+
+	//ons manager address에 쓸 수 없으므로 SaveONSManager에서 실패한다.
+	rejected := newAddManager(t, TEST_GS1_CODE, TEST_MANAGER_ADDRESS)
+	rejected.Outputs = []string{ons_state.MakeAddress(TEST_GS1_CODE)}
+
+	report := Run(handler, newTestSnapshot(t), []*Transaction{
+		rejected,
+		newAddRecord(t, TEST_MANAGER_ADDRESS, TEST_GS1_CODE),
+		newAddManager(t, TEST_GS1_CODE, TEST_MANAGER_ADDRESS),
+		newAddRecord(t, TEST_MANAGER_ADDRESS, TEST_GS1_CODE),
+	})
+
+	expected := []bool{false, false, true, true}
+	for idx, accepted := range expected {
+		result := report.Transactions[idx]
+		if result.Accepted != accepted {
+			t.Errorf("transaction %d (%s) accepted = %v, want %v (%s)", idx, result.Type, result.Accepted, accepted, result.Error)
+		}
+	}
+	if report.Accepted != 2 || report.Rejected != 2 {
+		t.Errorf("accepted %d, rejected %d, want 2, 2", report.Accepted, report.Rejected)
+	}
+}
+
+//Run은 이전 Run에서 cache한 ONS manager를 사용하지 않는다.
+func TestRunDoesNotReuseCache(t *testing.T) {
+	handler := &ons.ONSHandler{}
+	handler.SetSudoAddress(TEST_SUDO_ADDRESS)
+
+	report := Run(handler, newTestSnapshot(t), []*Transaction{
+		newAddManager(t, TEST_GS1_CODE, TEST_MANAGER_ADDRESS),
+	})
+	if report.Accepted != 1 {
+		t.Fatalf("add manager is rejected: %s", report.Transactions[0].Error)
+	}
+
+	report = Run(handler, newTestSnapshot(t), []*Transaction{
+		newAddRecord(t, TEST_MANAGER_ADDRESS, TEST_GS1_CODE),
+	})
+	if report.Transactions[0].Accepted == true {
+		t.Errorf("add record is accepted with the manager of the previous snapshot")
+	}
+}
@@ -8,10 +8,20 @@ import (
 	"github.com/daludaluking/ons-sawtooth-sdk/ons_pb2"
 	"github.com/daludaluking/ons-sawtooth-sdk/processor"
 	"github.com/daludaluking/ons-sawtooth-sdk/logging"
+	"github.com/daludaluking/ons-sawtooth/src/ons/ons_metrics"
 	"strings"
+	"time"
 )
 
 var logger *logging.Logger = logging.Get()
+
+//processor.Context가 제공하는 global state 접근 method.
+//simulate mode에서는 memory에 load한 state를 사용하는 context로 대체된다.
+type StateContext interface {
+	GetState(addresses []string) (map[string][]byte, error)
+	SetState(pairs map[string][]byte) ([]string, error)
+	DeleteState(addresses []string) ([]string, error)
+}
 var familyname string = "ons"
 var namespace = Hexdigest(familyname)[:6]
 
@@ -27,13 +37,15 @@ func UnpackGS1Code(gs1_code_byte_data []byte) (*ons_pb2.GS1CodeData, error) {
 	return gs1_code_data, nil
 }
 
-func LoadGS1Code(gs1_code string, context *processor.Context) (*ons_pb2.GS1CodeData, error) {
+func LoadGS1Code(gs1_code string, context StateContext) (*ons_pb2.GS1CodeData, error) {
 	//namespac와 gs1 code로 address를 만든다.
 	address := MakeAddress(gs1_code)
 	logger.Debugf("loadGS1Code gs1code: " + gs1_code + ", address : " + address)
 
 	//address로 state를 읽어 들인다 -> saveGS1Code에서 저장된 data이다.
+	start := time.Now()
 	results, err := context.GetState([]string{address})
+	ons_metrics.ObserveStateLatency(ons_metrics.STATE_OP_GET, start)
 
 	if err != nil {
 		return nil, err
@@ -50,16 +62,18 @@ func LoadGS1Code(gs1_code string, context *processor.Context) (*ons_pb2.GS1CodeD
 	return nil, nil
 }
 
-func SaveGS1Code(gs1_code_data *ons_pb2.GS1CodeData, context *processor.Context) error {
+func SaveGS1Code(gs1_code_data *ons_pb2.GS1CodeData, context StateContext) error {
 	address := MakeAddress(gs1_code_data.GetGs1Code())
 	data, err := proto.Marshal(gs1_code_data)
 	if err != nil {
 		return &processor.InternalError{Msg: fmt.Sprint("Failed to serialize GS1 Code data:", err)}
 	}
 	logger.Debugf("data length : %v", len(data))
+	start := time.Now()
 	addresses, err := context.SetState(map[string][]byte{
 		address: data,
 	})
+	ons_metrics.ObserveStateLatency(ons_metrics.STATE_OP_SET, start)
 	if err != nil {
 		return err
 	}
@@ -73,12 +87,14 @@ func SaveGS1Code(gs1_code_data *ons_pb2.GS1CodeData, context *processor.Context)
 	return nil
 }
 
-func DeleteGS1Code(gs1_code string, context *processor.Context) error {
+func DeleteGS1Code(gs1_code string, context StateContext) error {
 	//namespac와 gs1 code로 address를 만든다.
 	address := MakeAddress(gs1_code)
 
 	//address로 state를 읽어 들인다 -> saveGS1Code에서 저장된 data이다.
+	start := time.Now()
 	results, err := context.DeleteState([]string{address})
+	ons_metrics.ObserveStateLatency(ons_metrics.STATE_OP_DELETE, start)
 
 	if err != nil {
 		return &processor.InternalError{Msg: fmt.Sprint("Failed to detele GS1 Code data:", err)}
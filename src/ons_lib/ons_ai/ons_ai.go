@@ -0,0 +1,347 @@
+package ons_ai
+
+import (
+	"fmt"
+	"net/url"
+	"ons_lib/ons_gs1"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//GS1-128, GS1 DataMatrix 등의 barcode에서 읽은 GS1 element string을 해석한다.
+//지원하는 형식
+//  HRI(괄호)     : (01)09506000134352(21)ABC123
+//  raw data     : ]d2010950600013435221ABC123<GS>10LOT1 (symbology identifier는 생략 가능)
+//raw data의 FNC1은 GS(0x1D) 문자 또는 "<GS>"로 나타낸다.
+
+const GS = "\x1d"
+
+//AI의 data format part. (예: "N13+X..17" -> N13, X..17)
+type part struct {
+	numeric bool
+	fixed   bool
+	length  int
+}
+
+type aiDefinition struct {
+	ai    string
+	title string
+	parts []part
+	check bool
+	date  bool
+}
+
+//AI의 앞 2자리가 이 목록에 있으면 FNC1 없이 AI를 포함한 길이가 정해져 있다. (GS1 General Specifications Figure 7.8.5-2)
+var predefinedLength = map[string]int{
+	"00": 20, "01": 16, "02": 16, "03": 16, "04": 18,
+	"11": 8, "12": 8, "13": 8, "14": 8, "15": 8, "16": 8, "17": 8, "18": 8, "19": 8, "20": 4,
+	"31": 10, "32": 10, "33": 10, "34": 10, "35": 10, "36": 10, "41": 16,
+}
+
+var definitions = map[string]*aiDefinition{}
+
+func define(ai string, title string, format string, check bool, date bool) {
+	parts := []part{}
+	for _, spec := range strings.Split(format, "+") {
+		p := part{numeric: spec[0] == 'N'}
+		if strings.HasPrefix(spec[1:], "..") {
+			p.length, _ = strconv.Atoi(spec[3:])
+		} else {
+			p.fixed = true
+			p.length, _ = strconv.Atoi(spec[1:])
+		}
+		parts = append(parts, p)
+	}
+	definitions[ai] = &aiDefinition{ai: ai, title: title, parts: parts, check: check, date: date}
+}
+
+func init() {
+	define("00", "SSCC", "N18", true, false)
+	define("01", "GTIN", "N14", true, false)
+	define("02", "CONTENT", "N14", true, false)
+	define("10", "BATCH/LOT", "X..20", false, false)
+	define("11", "PROD DATE", "N6", false, true)
+	define("12", "DUE DATE", "N6", false, true)
+	define("13", "PACK DATE", "N6", false, true)
+	define("15", "BEST BEFORE", "N6", false, true)
+	define("16", "SELL BY", "N6", false, true)
+	define("17", "USE BY", "N6", false, true)
+	define("20", "VARIANT", "N2", false, false)
+	define("21", "SERIAL", "X..20", false, false)
+	define("22", "CPV", "X..20", false, false)
+	define("235", "TPX", "X..28", false, false)
+	define("240", "ADDITIONAL ID", "X..30", false, false)
+	define("241", "CUST. PART No.", "X..30", false, false)
+	define("250", "SECONDARY SERIAL", "X..30", false, false)
+	define("251", "REF. TO SOURCE", "X..30", false, false)
+	define("253", "GDTI", "N13+X..17", true, false)
+	define("254", "GLN EXTENSION COMPONENT", "X..20", false, false)
+	define("30", "VAR. COUNT", "N..8", false, false)
+	define("37", "COUNT", "N..8", false, false)
+	define("400", "ORDER NUMBER", "X..30", false, false)
+	define("401", "GINC", "X..30", false, false)
+	define("402", "GSIN", "N17", true, false)
+	define("403", "ROUTE", "X..30", false, false)
+	define("410", "SHIP TO LOC", "N13", true, false)
+	define("411", "BILL TO", "N13", true, false)
+	define("412", "PURCHASE FROM", "N13", true, false)
+	define("413", "SHIP FOR LOC", "N13", true, false)
+	define("414", "LOC No.", "N13", true, false)
+	define("415", "PAY TO", "N13", true, false)
+	define("416", "PROD/SERV LOC", "N13", true, false)
+	define("417", "PARTY", "N13", true, false)
+	define("420", "SHIP TO POST", "X..20", false, false)
+	define("421", "SHIP TO POST", "N3+X..9", false, false)
+	define("422", "ORIGIN", "N3", false, false)
+	define("7003", "EXPIRY TIME", "N10", false, false)
+	define("8003", "GRAI", "N14+X..16", false, false)
+	define("8004", "GIAI", "X..30", false, false)
+	define("8006", "ITIP", "N14+N2+N2", true, false)
+	define("8017", "GSRN - PROVIDER", "N18", true, false)
+	define("8018", "GSRN - RECIPIENT", "N18", true, false)
+	define("90", "INTERNAL", "X..30", false, false)
+	for ai := 91; ai <= 99; ai++ {
+		define(strconv.Itoa(ai), "INTERNAL", "X..90", false, false)
+	}
+	//310n ~ 369n (n은 소수점 위치)
+	for prefix := 310; prefix <= 369; prefix++ {
+		if (prefix > 316 && prefix < 320) || (prefix > 337 && prefix < 340) || (prefix > 357 && prefix < 360) {
+			continue
+		}
+		for n := 0; n <= 9; n++ {
+			define(fmt.Sprintf("%d%d", prefix, n), "MEASURE", "N6", false, false)
+		}
+	}
+}
+
+//GS1 AI encodable character set 82.
+var cset82 = regexp.MustCompile(`^[!"%&'()*+,\-./0-9:;<=>?A-Z_a-z]*$`)
+
+type Element struct {
+	AI    string `json:"ai"`
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+type ElementString struct {
+	Elements []*Element `json:"elements"`
+}
+
+//s가 element string처럼 보이는지 확인한다. (괄호로 시작하거나 symbology identifier, FNC1을 포함)
+func IsElementString(s string) bool {
+	return strings.HasPrefix(s, "(") || strings.HasPrefix(s, "]") || strings.Contains(s, GS) || strings.Contains(s, "<GS>")
+}
+
+func lookup(data string) *aiDefinition {
+	for length := 2; length <= 4 && length <= len(data); length++ {
+		if definition, ok := definitions[data[:length]]; ok {
+			return definition
+		}
+	}
+	return nil
+}
+
+//괄호 형식의 HRI나 raw data를 해석하고 각 AI의 format을 검증한다.
+func Parse(input string) (*ElementString, error) {
+	data := strings.Replace(input, "<GS>", GS, -1)
+	//symbology identifier (]C1: GS1-128, ]d2: GS1 DataMatrix, ]Q3: GS1 QR Code, ]e0: GS1 DataBar)
+	if strings.HasPrefix(data, "]") {
+		if len(data) < 3 {
+			return nil, fmt.Errorf("invalid symbology identifier in %q", input)
+		}
+		data = data[3:]
+	}
+	data = strings.TrimPrefix(data, GS)
+
+	var elements []*Element
+	var err error
+	if strings.HasPrefix(data, "(") {
+		elements, err = parseBracketed(data)
+	} else {
+		elements, err = parseRaw(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) == 0 {
+		return nil, fmt.Errorf("no element in %q", input)
+	}
+
+	seen := make(map[string]string)
+	for _, element := range elements {
+		if err := validate(element); err != nil {
+			return nil, err
+		}
+		if value, ok := seen[element.AI]; ok && value != element.Value {
+			return nil, fmt.Errorf("AI (%s) appears twice with different values", element.AI)
+		}
+		seen[element.AI] = element.Value
+	}
+	return &ElementString{Elements: elements}, nil
+}
+
+var bracketAI = regexp.MustCompile(`\(([0-9]{2,4})\)`)
+
+func parseBracketed(data string) ([]*Element, error) {
+	//value 안에도 괄호가 올 수 있기 때문에 알려진 AI만 구분자로 사용한다.
+	type position struct {
+		ai         string
+		start, end int
+	}
+	positions := []position{}
+	for _, loc := range bracketAI.FindAllStringSubmatchIndex(data, -1) {
+		ai := data[loc[2]:loc[3]]
+		if _, ok := definitions[ai]; ok {
+			positions = append(positions, position{ai: ai, start: loc[0], end: loc[1]})
+		}
+	}
+	if len(positions) == 0 || positions[0].start != 0 {
+		return nil, fmt.Errorf("element string %q must start with a known (AI)", data)
+	}
+
+	elements := []*Element{}
+	for idx, pos := range positions {
+		value_end := len(data)
+		if idx+1 < len(positions) {
+			value_end = positions[idx+1].start
+		}
+		elements = append(elements, &Element{AI: pos.ai, Value: data[pos.end:value_end]})
+	}
+	return elements, nil
+}
+
+func parseRaw(data string) ([]*Element, error) {
+	elements := []*Element{}
+	for len(data) > 0 {
+		definition := lookup(data)
+		if definition == nil {
+			return nil, fmt.Errorf("unknown AI at %q", data)
+		}
+		data = data[len(definition.ai):]
+
+		var value string
+		if length, ok := predefinedLength[definition.ai[:2]]; ok {
+			length -= len(definition.ai)
+			if len(data) < length {
+				return nil, fmt.Errorf("AI (%s) needs %d characters, got %q", definition.ai, length, data)
+			}
+			value = data[:length]
+			data = data[length:]
+		} else {
+			end := strings.Index(data, GS)
+			if end < 0 {
+				end = len(data)
+			}
+			value = data[:end]
+			data = data[end:]
+		}
+		data = strings.TrimPrefix(data, GS)
+		elements = append(elements, &Element{AI: definition.ai, Value: value})
+	}
+	return elements, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func validate(element *Element) error {
+	definition := definitions[element.AI]
+	element.Title = definition.title
+
+	value := element.Value
+	for idx, p := range definition.parts {
+		var field string
+		last := idx == len(definition.parts)-1
+		switch {
+		case p.fixed:
+			if len(value) < p.length || (last && len(value) != p.length) {
+				return fmt.Errorf("AI (%s) must be %d characters, got %q", element.AI, p.length, element.Value)
+			}
+			field = value[:p.length]
+		case last == false:
+			return fmt.Errorf("AI (%s) has an unsupported format", element.AI)
+		default:
+			if len(value) == 0 || len(value) > p.length {
+				return fmt.Errorf("AI (%s) must be 1 ~ %d characters, got %q", element.AI, p.length, element.Value)
+			}
+			field = value
+		}
+		value = value[len(field):]
+
+		if p.numeric && isDigits(field) == false {
+			return fmt.Errorf("AI (%s) must be numeric, got %q", element.AI, element.Value)
+		}
+		if p.numeric == false && cset82.MatchString(field) == false {
+			return fmt.Errorf("AI (%s) has an invalid character in %q", element.AI, element.Value)
+		}
+		if idx == 0 && definition.check {
+			if err := ons_gs1.ValidateCheckDigit(field); err != nil {
+				return fmt.Errorf("AI (%s): %v", element.AI, err)
+			}
+		}
+	}
+
+	if definition.date {
+		month, _ := strconv.Atoi(element.Value[2:4])
+		day, _ := strconv.Atoi(element.Value[4:6])
+		if month < 1 || month > 12 || day > 31 {
+			return fmt.Errorf("AI (%s) is not a valid YYMMDD date: %q", element.AI, element.Value)
+		}
+	}
+	return nil
+}
+
+func (es *ElementString) Get(ai string) (string, bool) {
+	for _, element := range es.Elements {
+		if element.AI == ai {
+			return element.Value, true
+		}
+	}
+	return "", false
+}
+
+//ONS에서 사용하는 primary key AI와 key qualifier AI. (순서대로)
+var primaryKeys = []struct {
+	ai         string
+	keyType    string
+	qualifiers []string
+}{
+	{"01", "gtin", []string{"22", "10", "21"}},
+	{"00", "sscc", nil},
+	{"414", "gln", []string{"254"}},
+}
+
+//element string의 primary key type(gtin, sscc, gln)과 GS1 key를 반환한다.
+func (es *ElementString) PrimaryKey() (string, string, error) {
+	for _, primary := range primaryKeys {
+		if key, ok := es.Get(primary.ai); ok {
+			return primary.keyType, key, nil
+		}
+	}
+	return "", "", fmt.Errorf("no GTIN (01), SSCC (00) or GLN (414) in element string")
+}
+
+//primary key와 key qualifier로 만든 GS1 Digital Link path. (예: /01/09506000134352/21/ABC123)
+func (es *ElementString) DigitalLinkPath() (string, error) {
+	for _, primary := range primaryKeys {
+		key, ok := es.Get(primary.ai)
+		if ok == false {
+			continue
+		}
+		path := ons_gs1.DigitalLinkPath(primary.keyType, key)
+		for _, qualifier := range primary.qualifiers {
+			if value, ok := es.Get(qualifier); ok {
+				path += "/" + qualifier + "/" + url.PathEscape(value)
+			}
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("no GTIN (01), SSCC (00) or GLN (414) in element string")
+}
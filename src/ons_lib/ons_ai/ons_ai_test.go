@@ -0,0 +1,141 @@
+package ons_ai
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		elements []string
+	}{
+		//FNC1 없이 이어지는 고정 길이 AI
+		{"01095060001343521718123110LOT1", []string{"01", "09506000134352", "17", "181231", "10", "LOT1"}},
+		//가변 길이 AI 뒤의 FNC1은 GS 또는 <GS>로 나타낸다.
+		{"]d20109506000134352" + "21ABC123" + GS + "10LOT1", []string{"01", "09506000134352", "21", "ABC123", "10", "LOT1"}},
+		{"]C1" + GS + "0109506000134352" + "21ABC123<GS>3103000500", []string{"01", "09506000134352", "21", "ABC123", "3103", "000500"}},
+		//고정 길이 AI 뒤의 FNC1은 무시한다.
+		{"0109506000134352" + GS + "21ABC123" + GS, []string{"01", "09506000134352", "21", "ABC123"}},
+		{"00106141412345678908", []string{"00", "106141412345678908"}},
+		{"4140614141123452254400", []string{"414", "0614141123452", "254", "400"}},
+		{"(01)09506000134352(21)ABC123", []string{"01", "09506000134352", "21", "ABC123"}},
+		//알려진 AI가 아닌 괄호는 value에 포함된다.
+		{"(01)09506000134352(21)A(1)B(10)LOT", []string{"01", "09506000134352", "21", "A(1)B", "10", "LOT"}},
+		{"(421)840ABC(8006)095060001343520102", []string{"421", "840ABC", "8006", "095060001343520102"}},
+		//같은 AI가 같은 value로 반복되는 것은 허용한다.
+		{"(01)09506000134352(01)09506000134352", []string{"01", "09506000134352", "01", "09506000134352"}},
+	}
+	for _, test := range tests {
+		es, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("%q: %v", test.input, err)
+			continue
+		}
+		elements := []string{}
+		for _, element := range es.Elements {
+			if len(element.Title) == 0 {
+				t.Errorf("%q: AI (%s) has no title", test.input, element.AI)
+			}
+			elements = append(elements, element.AI, element.Value)
+		}
+		if reflect.DeepEqual(elements, test.elements) == false {
+			t.Errorf("%q is parsed as %v, want %v", test.input, elements, test.elements)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"]d",
+		//check digit
+		"(01)09506000134353",
+		"0109506000134353",
+		"(414)0614141123453",
+		"(8006)095060001343530102",
+		//잘린 입력
+		"01095060001343",
+		"(01)0950600013435",
+		"010950600013435217",
+		"0109506000134352171812",
+		"(421)84",
+		//고정 길이 AI의 길이와 형식
+		"(01)095060001343521",
+		"(01)0950600013435X",
+		"(17)181332",
+		"(20)1",
+		//가변 길이 AI의 길이와 형식
+		"(10)",
+		"(10)(21)ABC",
+		"(10)" + strings.Repeat("A", 21),
+		"(21)AB CD",
+		"(30)12A",
+		//알 수 없는 AI
+		"(5)123",
+		"(01)09506000134352ZZ",
+		"0109506000134352" + "ZZ123",
+		"(01)09506000134352(21)A(21)B",
+	} {
+		es, err := Parse(input)
+		if err == nil {
+			t.Errorf("%q is parsed as %+v", input, es.Elements)
+		}
+	}
+}
+
+func TestDigitalLinkPath(t *testing.T) {
+	tests := []struct {
+		input    string
+		key_type string
+		key      string
+		path     string
+	}{
+		{"(01)09506000134352(21)S/1(10)LOT(17)181231", "gtin", "09506000134352", "/01/09506000134352/10/LOT/21/S%2F1"},
+		{"(00)106141412345678908(01)09506000134352", "gtin", "09506000134352", "/01/09506000134352"},
+		{"(00)106141412345678908", "sscc", "106141412345678908", "/00/106141412345678908"},
+		{"(414)0614141123452(254)400", "gln", "0614141123452", "/414/0614141123452/254/400"},
+	}
+	for _, test := range tests {
+		es, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("%q: %v", test.input, err)
+			continue
+		}
+		key_type, key, err := es.PrimaryKey()
+		if err != nil || key_type != test.key_type || key != test.key {
+			t.Errorf("%q: primary key is %s %s, %v, want %s %s", test.input, key_type, key, err, test.key_type, test.key)
+		}
+		path, err := es.DigitalLinkPath()
+		if err != nil || path != test.path {
+			t.Errorf("%q: path is %s, %v, want %s", test.input, path, err, test.path)
+		}
+	}
+
+	es, err := Parse("(10)LOT(21)ABC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := es.PrimaryKey(); err == nil {
+		t.Errorf("element string without a primary key has a primary key")
+	}
+	if path, err := es.DigitalLinkPath(); err == nil {
+		t.Errorf("element string without a primary key has a path %s", path)
+	}
+}
+
+func TestIsElementString(t *testing.T) {
+	for input, expected := range map[string]bool{
+		"(01)09506000134352":                   true,
+		"]d20109506000134352":                  true,
+		"0109506000134352" + GS + "10LOT1":     true,
+		"0109506000134352<GS>10LOT1":           true,
+		"09506000134352":                       false,
+		"urn:epc:id:sgtin:0614141.812345.6789": false,
+	} {
+		if IsElementString(input) != expected {
+			t.Errorf("IsElementString(%q) is %v", input, !expected)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package ons_epc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/url"
+	"ons_lib/ons_gs1"
+	"strconv"
+	"strings"
+)
+
+//GS1 EPC Tag Data Standard의 SGTIN, SSCC, SGLN과 ONS에서 사용하는 GS1 key(GTIN-14, SSCC-18, GLN-13) 사이의 변환.
+//지원하는 형식
+//  pure identity URI : urn:epc:id:sgtin:0614141.812345.6789
+//  tag URI           : urn:epc:tag:sgtin-96:3.0614141.812345.6789
+//  binary(hex)       : 3074257BF7194E4000001A85 (SGTIN-96, SSCC-96, SGLN-96)
+
+const (
+	SCHEME_SGTIN = "sgtin"
+	SCHEME_SSCC  = "sscc"
+	SCHEME_SGLN  = "sgln"
+)
+
+const (
+	PURE_IDENTITY_PREFIX = "urn:epc:id:"
+	TAG_PREFIX           = "urn:epc:tag:"
+)
+
+//96 bit encoding의 header 값.
+var headers = map[string]byte{
+	SCHEME_SGTIN: 0x30,
+	SCHEME_SSCC:  0x31,
+	SCHEME_SGLN:  0x32,
+}
+
+//partition table의 한 행. company prefix와 reference의 bit 수, digit 수.
+type partition struct {
+	companyBits   int
+	companyDigits int
+	refBits       int
+	refDigits     int
+}
+
+//partition 값(0~6)의 순서대로.
+var partitions = map[string][]partition{
+	SCHEME_SGTIN: {
+		{40, 12, 4, 1}, {37, 11, 7, 2}, {34, 10, 10, 3}, {30, 9, 14, 4},
+		{27, 8, 17, 5}, {24, 7, 20, 6}, {20, 6, 24, 7},
+	},
+	SCHEME_SSCC: {
+		{40, 12, 18, 5}, {37, 11, 21, 6}, {34, 10, 24, 7}, {30, 9, 28, 8},
+		{27, 8, 31, 9}, {24, 7, 34, 10}, {20, 6, 38, 11},
+	},
+	SCHEME_SGLN: {
+		{40, 12, 1, 0}, {37, 11, 4, 1}, {34, 10, 7, 2}, {30, 9, 11, 3},
+		{27, 8, 14, 4}, {24, 7, 17, 5}, {20, 6, 21, 6},
+	},
+}
+
+//scheme별 serial(SGTIN serial, SGLN extension)의 bit 수. SSCC-96은 24 bit가 사용되지 않는다.
+var serialBits = map[string]int{
+	SCHEME_SGTIN: 38,
+	SCHEME_SSCC:  24,
+	SCHEME_SGLN:  41,
+}
+
+type EPC struct {
+	Scheme        string
+	CompanyPrefix string
+	//SGTIN은 indicator digit을 포함한 item reference, SSCC는 extension digit을 포함한 serial reference,
+	//SGLN은 location reference.
+	Reference string
+	//SGTIN의 serial number, SGLN의 extension. SSCC는 비어 있다.
+	Serial string
+	//tag URI와 binary encoding의 filter 값.
+	Filter int
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//EPC URI의 component에서 escape해야 하는 문자.
+const uriEscapeChars = "\"%&/<>?#"
+
+func escapeComponent(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(uriEscapeChars, s[i]) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", s[i])
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func unescapeComponent(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+//input이 EPC URI 또는 96 bit EPC hex인지 확인한다.
+func IsEPC(input string) bool {
+	lower := strings.ToLower(input)
+	if strings.HasPrefix(lower, PURE_IDENTITY_PREFIX) || strings.HasPrefix(lower, TAG_PREFIX) {
+		return true
+	}
+	lower = strings.TrimPrefix(lower, "0x")
+	if len(lower) != 24 {
+		return false
+	}
+	if _, err := hex.DecodeString(lower); err != nil {
+		return false
+	}
+	for _, header := range headers {
+		if lower[:2] == fmt.Sprintf("%02x", header) {
+			return true
+		}
+	}
+	return false
+}
+
+//pure identity URI, tag URI, 96 bit hex를 해석한다.
+func Parse(input string) (*EPC, error) {
+	lower := strings.ToLower(input)
+	switch {
+	case strings.HasPrefix(lower, PURE_IDENTITY_PREFIX):
+		return parseURI(input[len(PURE_IDENTITY_PREFIX):], false)
+	case strings.HasPrefix(lower, TAG_PREFIX):
+		return parseURI(input[len(TAG_PREFIX):], true)
+	default:
+		return DecodeHex(input)
+	}
+}
+
+func parseURI(body string, is_tag bool) (*EPC, error) {
+	colon := strings.Index(body, ":")
+	if colon < 0 {
+		return nil, fmt.Errorf("invalid EPC URI %q", body)
+	}
+	scheme := strings.ToLower(body[:colon])
+	components := strings.Split(body[colon+1:], ".")
+
+	epc := &EPC{}
+	if is_tag {
+		if strings.HasSuffix(scheme, "-96") == false {
+			return nil, fmt.Errorf("unsupported EPC tag scheme %q", scheme)
+		}
+		scheme = strings.TrimSuffix(scheme, "-96")
+		if len(components) < 1 {
+			return nil, fmt.Errorf("missing filter in EPC tag URI")
+		}
+		filter, err := strconv.Atoi(components[0])
+		if err != nil || filter < 0 || filter > 7 {
+			return nil, fmt.Errorf("invalid filter %q in EPC tag URI", components[0])
+		}
+		epc.Filter = filter
+		components = components[1:]
+	}
+	if _, ok := headers[scheme]; ok == false {
+		return nil, fmt.Errorf("unsupported EPC scheme %q", scheme)
+	}
+	epc.Scheme = scheme
+
+	expected := 3
+	if scheme == SCHEME_SSCC {
+		expected = 2
+	}
+	if len(components) != expected {
+		return nil, fmt.Errorf("%s needs %d components, got %d", scheme, expected, len(components))
+	}
+	epc.CompanyPrefix = components[0]
+	epc.Reference = components[1]
+	if expected == 3 {
+		serial, err := unescapeComponent(components[2])
+		if err != nil {
+			return nil, err
+		}
+		epc.Serial = serial
+	}
+
+	if err := epc.validate(); err != nil {
+		return nil, err
+	}
+	if is_tag {
+		//tag URI는 binary encoding의 값 범위를 만족해야 한다.
+		if _, err := epc.Hex(); err != nil {
+			return nil, err
+		}
+	}
+	return epc, nil
+}
+
+func (epc *EPC) validate() error {
+	if isDigits(epc.CompanyPrefix) == false || len(epc.CompanyPrefix) < 6 || len(epc.CompanyPrefix) > 12 {
+		return fmt.Errorf("invalid company prefix %q", epc.CompanyPrefix)
+	}
+	if isDigits(epc.Reference) == false {
+		return fmt.Errorf("invalid reference %q", epc.Reference)
+	}
+	//company prefix와 reference를 합친 길이는 scheme별로 고정되어 있다.
+	total := map[string]int{SCHEME_SGTIN: 13, SCHEME_SSCC: 17, SCHEME_SGLN: 12}[epc.Scheme]
+	if len(epc.CompanyPrefix)+len(epc.Reference) != total {
+		return fmt.Errorf("%s company prefix and reference must be %d digits, got %d", epc.Scheme, total, len(epc.CompanyPrefix)+len(epc.Reference))
+	}
+	if epc.Scheme == SCHEME_SGTIN && len(epc.Serial) == 0 {
+		return fmt.Errorf("missing serial in sgtin")
+	}
+	return nil
+}
+
+//EPC에 해당하는 GS1 key type(gtin, sscc, gln)과 check digit을 포함한 GS1 key를 반환한다.
+func (epc *EPC) GS1Key() (string, string, error) {
+	var key_type, digits string
+	switch epc.Scheme {
+	case SCHEME_SGTIN:
+		//indicator digit + company prefix + item reference
+		key_type = "gtin"
+		digits = epc.Reference[:1] + epc.CompanyPrefix + epc.Reference[1:]
+	case SCHEME_SSCC:
+		//extension digit + company prefix + serial reference
+		key_type = "sscc"
+		digits = epc.Reference[:1] + epc.CompanyPrefix + epc.Reference[1:]
+	case SCHEME_SGLN:
+		key_type = "gln"
+		digits = epc.CompanyPrefix + epc.Reference
+	default:
+		return "", "", fmt.Errorf("unsupported EPC scheme %q", epc.Scheme)
+	}
+	check, err := ons_gs1.CheckDigit(digits)
+	if err != nil {
+		return "", "", err
+	}
+	return key_type, digits + string(check), nil
+}
+
+func (epc *EPC) PureIdentityURI() string {
+	uri := PURE_IDENTITY_PREFIX + epc.Scheme + ":" + epc.CompanyPrefix + "." + epc.Reference
+	if epc.Scheme != SCHEME_SSCC {
+		uri += "." + escapeComponent(epc.Serial)
+	}
+	return uri
+}
+
+func (epc *EPC) TagURI() (string, error) {
+	if _, err := epc.Hex(); err != nil {
+		return "", err
+	}
+	uri := fmt.Sprintf("%s%s-96:%d.%s.%s", TAG_PREFIX, epc.Scheme, epc.Filter, epc.CompanyPrefix, epc.Reference)
+	if epc.Scheme != SCHEME_SSCC {
+		uri += "." + epc.Serial
+	}
+	return uri, nil
+}
+
+func parseNumber(s string, bits int, name string) (*big.Int, error) {
+	value, ok := new(big.Int).SetString(s, 10)
+	if ok == false || value.Sign() < 0 {
+		return nil, fmt.Errorf("%s %q is not a number", name, s)
+	}
+	if value.BitLen() > bits {
+		return nil, fmt.Errorf("%s %s does not fit in %d bits", name, s, bits)
+	}
+	return value, nil
+}
+
+//96 bit binary encoding을 대문자 hex로 반환한다.
+func (epc *EPC) Hex() (string, error) {
+	if epc.Filter < 0 || epc.Filter > 7 {
+		return "", fmt.Errorf("invalid filter %d", epc.Filter)
+	}
+	table := partitions[epc.Scheme]
+	p := -1
+	for idx, row := range table {
+		if row.companyDigits == len(epc.CompanyPrefix) {
+			p = idx
+			break
+		}
+	}
+	if p < 0 {
+		return "", fmt.Errorf("invalid company prefix length %d", len(epc.CompanyPrefix))
+	}
+
+	company, err := parseNumber(epc.CompanyPrefix, table[p].companyBits, "company prefix")
+	if err != nil {
+		return "", err
+	}
+	reference, err := parseNumber("0"+epc.Reference, table[p].refBits, "reference")
+	if err != nil {
+		return "", err
+	}
+
+	serial := big.NewInt(0)
+	switch epc.Scheme {
+	case SCHEME_SGTIN, SCHEME_SGLN:
+		//96 bit encoding에서 serial은 0으로 시작하지 않는 숫자만 사용할 수 있다. ("0"은 가능)
+		if isDigits(epc.Serial) == false || len(epc.Serial) == 0 || (len(epc.Serial) > 1 && epc.Serial[0] == '0') {
+			return "", fmt.Errorf("serial %q can not be encoded in %s-96", epc.Serial, epc.Scheme)
+		}
+		serial, err = parseNumber(epc.Serial, serialBits[epc.Scheme], "serial")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	value := big.NewInt(int64(headers[epc.Scheme]))
+	shift := func(v *big.Int, bits int) {
+		value.Lsh(value, uint(bits))
+		value.Or(value, v)
+	}
+	shift(big.NewInt(int64(epc.Filter)), 3)
+	shift(big.NewInt(int64(p)), 3)
+	shift(company, table[p].companyBits)
+	shift(reference, table[p].refBits)
+	shift(serial, serialBits[epc.Scheme])
+
+	return fmt.Sprintf("%024X", value), nil
+}
+
+//96 bit EPC hex(앞의 0x는 생략 가능)를 해석한다.
+func DecodeHex(input string) (*EPC, error) {
+	raw := strings.TrimPrefix(strings.ToLower(input), "0x")
+	if len(raw) != 24 {
+		return nil, fmt.Errorf("%q is not a 96 bit EPC", input)
+	}
+	value, ok := new(big.Int).SetString(raw, 16)
+	if ok == false {
+		return nil, fmt.Errorf("%q is not a hex string", input)
+	}
+
+	//상위 bit부터 읽는다.
+	offset := 96
+	take := func(bits int) *big.Int {
+		offset -= bits
+		v := new(big.Int).Rsh(value, uint(offset))
+		return v.And(v, new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bits)), big.NewInt(1)))
+	}
+
+	header := byte(take(8).Uint64())
+	scheme := ""
+	for name, h := range headers {
+		if h == header {
+			scheme = name
+		}
+	}
+	if len(scheme) == 0 {
+		return nil, fmt.Errorf("unsupported EPC header 0x%02X", header)
+	}
+
+	epc := &EPC{Scheme: scheme}
+	epc.Filter = int(take(3).Int64())
+	p := int(take(3).Int64())
+	if p > 6 {
+		return nil, fmt.Errorf("invalid partition %d", p)
+	}
+	row := partitions[scheme][p]
+
+	company := take(row.companyBits).String()
+	if len(company) > row.companyDigits {
+		return nil, fmt.Errorf("company prefix %s is too long for partition %d", company, p)
+	}
+	epc.CompanyPrefix = strings.Repeat("0", row.companyDigits-len(company)) + company
+
+	reference := take(row.refBits).String()
+	if row.refDigits == 0 {
+		if reference != "0" {
+			return nil, fmt.Errorf("location reference must be empty for partition %d", p)
+		}
+		reference = ""
+	} else if len(reference) > row.refDigits {
+		return nil, fmt.Errorf("reference %s is too long for partition %d", reference, p)
+	} else {
+		reference = strings.Repeat("0", row.refDigits-len(reference)) + reference
+	}
+	epc.Reference = reference
+
+	serial := take(serialBits[scheme])
+	if scheme != SCHEME_SSCC {
+		epc.Serial = serial.String()
+	}
+
+	if err := epc.validate(); err != nil {
+		return nil, err
+	}
+	return epc, nil
+}
+
+//GS1 key와 company prefix 길이로 EPC를 만든다.
+//serial은 SGTIN의 serial number, SGLN의 extension이다. (SGLN은 비어 있으면 "0"을 사용한다)
+func FromGS1Key(key_type string, key string, company_prefix_length int, serial string, filter int) (*EPC, error) {
+	length, ok := ons_gs1.KeyLengths[key_type]
+	if ok == false {
+		return nil, fmt.Errorf("unsupported GS1 key type %q", key_type)
+	}
+	if len(key) != length || isDigits(key) == false {
+		return nil, fmt.Errorf("%s must be %d digits, got %q", key_type, length, key)
+	}
+	if err := ons_gs1.ValidateCheckDigit(key); err != nil {
+		return nil, err
+	}
+	if company_prefix_length < 6 || company_prefix_length > 12 {
+		return nil, fmt.Errorf("company prefix length must be 6 ~ 12, got %d", company_prefix_length)
+	}
+
+	body := key[:len(key)-1]
+	epc := &EPC{Filter: filter, Serial: serial}
+	switch key_type {
+	case "gtin":
+		epc.Scheme = SCHEME_SGTIN
+		epc.CompanyPrefix = body[1 : 1+company_prefix_length]
+		epc.Reference = body[:1] + body[1+company_prefix_length:]
+	case "sscc":
+		epc.Scheme = SCHEME_SSCC
+		epc.CompanyPrefix = body[1 : 1+company_prefix_length]
+		epc.Reference = body[:1] + body[1+company_prefix_length:]
+		epc.Serial = ""
+	case "gln":
+		epc.Scheme = SCHEME_SGLN
+		epc.CompanyPrefix = body[:company_prefix_length]
+		epc.Reference = body[company_prefix_length:]
+		if len(epc.Serial) == 0 {
+			epc.Serial = "0"
+		}
+	}
+
+	if err := epc.validate(); err != nil {
+		return nil, err
+	}
+	return epc, nil
+}
+
+//EPC에 해당하는 GS1 Digital Link path.
+//SGTIN은 serial(AI 21), SGLN은 "0"이 아닌 extension(AI 254)을 key qualifier로 포함한다.
+func (epc *EPC) DigitalLinkPath() (string, error) {
+	key_type, key, err := epc.GS1Key()
+	if err != nil {
+		return "", err
+	}
+	path := ons_gs1.DigitalLinkPath(key_type, key)
+	switch epc.Scheme {
+	case SCHEME_SGTIN:
+		path += "/21/" + url.PathEscape(epc.Serial)
+	case SCHEME_SGLN:
+		if epc.Serial != "0" {
+			path += "/254/" + url.PathEscape(epc.Serial)
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,163 @@
+package ons_epc
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+//GS1 EPC Tag Data Standard의 예제와 같은 값.
+var tdsVectors = []struct {
+	hex      string
+	uri      string
+	tag_uri  string
+	key_type string
+	key      string
+	path     string
+}{
+	{"3074257BF7194E4000001A85", "urn:epc:id:sgtin:0614141.812345.6789", "urn:epc:tag:sgtin-96:3.0614141.812345.6789",
+		"gtin", "80614141123458", "/01/80614141123458/21/6789"},
+	{"3174257BF4499602D2000000", "urn:epc:id:sscc:0614141.1234567890", "urn:epc:tag:sscc-96:3.0614141.1234567890",
+		"sscc", "106141412345678908", "/00/106141412345678908"},
+	{"3274257BF460720000000190", "urn:epc:id:sgln:0614141.12345.400", "urn:epc:tag:sgln-96:3.0614141.12345.400",
+		"gln", "0614141123452", "/414/0614141123452/254/400"},
+}
+
+func TestTDSVectors(t *testing.T) {
+	for _, vector := range tdsVectors {
+		for _, input := range []string{vector.hex, "0x" + strings.ToLower(vector.hex), vector.uri, vector.tag_uri} {
+			if IsEPC(input) == false {
+				t.Errorf("%s is not an EPC", input)
+			}
+			epc, err := Parse(input)
+			if err != nil {
+				t.Errorf("%s: %v", input, err)
+				continue
+			}
+			if uri := epc.PureIdentityURI(); uri != vector.uri {
+				t.Errorf("%s: pure identity URI is %s, want %s", input, uri, vector.uri)
+			}
+			key_type, key, err := epc.GS1Key()
+			if err != nil || key_type != vector.key_type || key != vector.key {
+				t.Errorf("%s: GS1 key is %s %s, %v, want %s %s", input, key_type, key, err, vector.key_type, vector.key)
+			}
+			path, err := epc.DigitalLinkPath()
+			if err != nil || path != vector.path {
+				t.Errorf("%s: Digital Link path is %s, %v, want %s", input, path, err, vector.path)
+			}
+			//pure identity URI에는 filter가 없다.
+			if strings.HasPrefix(input, PURE_IDENTITY_PREFIX) {
+				epc.Filter = 3
+			}
+			hex, err := epc.Hex()
+			if err != nil || hex != vector.hex {
+				t.Errorf("%s: hex is %s, %v, want %s", input, hex, err, vector.hex)
+			}
+			tag_uri, err := epc.TagURI()
+			if err != nil || tag_uri != vector.tag_uri {
+				t.Errorf("%s: tag URI is %s, %v, want %s", input, tag_uri, err, vector.tag_uri)
+			}
+		}
+	}
+}
+
+func TestFromGS1Key(t *testing.T) {
+	for _, vector := range tdsVectors {
+		serial := ""
+		if idx := strings.LastIndex(vector.uri, "."); vector.key_type != "sscc" {
+			serial = vector.uri[idx+1:]
+		}
+		epc, err := FromGS1Key(vector.key_type, vector.key, 7, serial, 3)
+		if err != nil {
+			t.Errorf("%s %s: %v", vector.key_type, vector.key, err)
+			continue
+		}
+		if hex, err := epc.Hex(); err != nil || hex != vector.hex {
+			t.Errorf("%s %s: hex is %s, %v, want %s", vector.key_type, vector.key, hex, err, vector.hex)
+		}
+	}
+}
+
+//모든 partition 값으로 encoding하고 다시 decoding한다.
+func TestPartitions(t *testing.T) {
+	for _, scheme := range []string{SCHEME_SGTIN, SCHEME_SSCC, SCHEME_SGLN} {
+		for p, row := range partitions[scheme] {
+			epc := &EPC{
+				Scheme:        scheme,
+				CompanyPrefix: "9" + strings.Repeat("1", row.companyDigits-1),
+				Reference:     strings.Repeat("2", row.refDigits),
+				Serial:        "12345",
+				Filter:        p,
+			}
+			if scheme == SCHEME_SSCC {
+				epc.Serial = ""
+			}
+			hex, err := epc.Hex()
+			if err != nil {
+				t.Errorf("%s partition %d: %v", scheme, p, err)
+				continue
+			}
+			value, _ := new(big.Int).SetString(hex, 16)
+			if partition := new(big.Int).Rsh(value, 82).Int64() & 7; partition != int64(p) {
+				t.Errorf("%s partition %d is encoded as %d", scheme, p, partition)
+			}
+			decoded, err := DecodeHex(hex)
+			if err != nil {
+				t.Errorf("%s partition %d: %s: %v", scheme, p, hex, err)
+				continue
+			}
+			if *decoded != *epc {
+				t.Errorf("%s partition %d: %s is decoded as %+v, want %+v", scheme, p, hex, decoded, epc)
+			}
+
+			key_type, key, err := epc.GS1Key()
+			if err != nil {
+				t.Errorf("%s partition %d: %v", scheme, p, err)
+				continue
+			}
+			from_key, err := FromGS1Key(key_type, key, row.companyDigits, epc.Serial, p)
+			if err != nil || *from_key != *epc {
+				t.Errorf("%s partition %d: %s %s is %+v, %v, want %+v", scheme, p, key_type, key, from_key, err, epc)
+			}
+		}
+	}
+}
+
+func TestInvalidEPC(t *testing.T) {
+	for _, input := range []string{
+		//지원하지 않는 header (GID-96, SGTIN-198)
+		"3574257BF7194E4000001A85",
+		"3674257BF7194E4000001A85",
+		//partition 7
+		"307C257BF7194E4000001A85",
+		//96 bit가 아니다.
+		"3074257BF7194E4000001A",
+		"3074257BF7194E4000001AXY",
+		"urn:epc:tag:sgtin-96:8.0614141.812345.6789",
+		"urn:epc:tag:sgtin-96:-1.0614141.812345.6789",
+		"urn:epc:tag:sgtin-96:x.0614141.812345.6789",
+		"urn:epc:tag:sgtin-198:3.0614141.812345.6789",
+		"urn:epc:tag:gid-96:3.0614141.812345.6789",
+		"urn:epc:id:gid:0614141.812345.6789",
+		"urn:epc:id:sgtin:0614141.812345",
+		"urn:epc:id:sgtin:0614141.81234.6789",
+		"urn:epc:id:sgtin:0614141.812345.",
+		"urn:epc:id:sscc:0614141.1234567890.1",
+		//serial이 0으로 시작하면 96 bit로 encoding할 수 없다.
+		"urn:epc:tag:sgtin-96:3.0614141.812345.06789",
+		"urn:epc:tag:sgtin-96:3.0614141.812345.A",
+	} {
+		epc, err := Parse(input)
+		if err == nil {
+			t.Errorf("%s is parsed as %+v", input, epc)
+		}
+	}
+
+	epc := &EPC{Scheme: SCHEME_SGTIN, CompanyPrefix: "0614141", Reference: "812345", Serial: "6789"}
+	for _, filter := range []int{-1, 8} {
+		epc.Filter = filter
+		if hex, err := epc.Hex(); err == nil {
+			t.Errorf("filter %d is encoded as %s", filter, hex)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package ons_gs1
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//ONS 2.0 FQDN은 "<check digit을 제외한 key의 digit을 역순으로 '.'으로 연결>.<key type>.gs1.id.<ons root>" 형식이다.
+//예) GTIN 09506000134352 -> 5.3.4.3.1.0.0.0.6.0.5.9.0.gtin.gs1.id.onsepc.com
+const DEFAULT_ONS_ROOT = "onsepc.com"
+
+//ONS에서 사용하는 GS1 key type과 check digit을 포함한 길이.
+var KeyLengths = map[string]int{
+	"gtin": 14,
+	"gln":  13,
+	"sscc": 18,
+}
+
+func isDigits(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//GS1 mod 10 check digit을 계산한다. digits는 check digit을 제외한 값이다.
+func CheckDigit(digits string) (byte, error) {
+	if isDigits(digits) == false {
+		return 0, fmt.Errorf("%q is not a digit string", digits)
+	}
+	sum := 0
+	weight := 3
+	for i := len(digits) - 1; i >= 0; i-- {
+		sum += int(digits[i]-'0') * weight
+		weight = 4 - weight
+	}
+	return byte('0' + (10-sum%10)%10), nil
+}
+
+//key의 마지막 digit이 올바른 check digit인지 확인한다.
+func ValidateCheckDigit(key string) error {
+	if len(key) < 2 {
+		return fmt.Errorf("%q is too short for a GS1 key", key)
+	}
+	check, err := CheckDigit(key[:len(key)-1])
+	if err != nil {
+		return err
+	}
+	if key[len(key)-1] != check {
+		return fmt.Errorf("invalid check digit in %s, expected %c", key, check)
+	}
+	return nil
+}
+
+func onsSuffix(key_type string, ons_root string) string {
+	return "." + key_type + ".gs1.id." + strings.Trim(ons_root, ".")
+}
+
+//GS1 key를 ONS 2.0 FQDN(끝에 '.' 없음)으로 변환한다.
+func KeyToFQDN(key_type string, key string, ons_root string) (string, error) {
+	length, ok := KeyLengths[key_type]
+	if ok == false {
+		return "", fmt.Errorf("unsupported GS1 key type %q", key_type)
+	}
+	if len(key) != length || isDigits(key) == false {
+		return "", fmt.Errorf("%s must be %d digits, got %q", key_type, length, key)
+	}
+	if err := ValidateCheckDigit(key); err != nil {
+		return "", err
+	}
+
+	digits := key[:len(key)-1]
+	labels := make([]string, 0, len(digits))
+	for i := len(digits) - 1; i >= 0; i-- {
+		labels = append(labels, string(digits[i]))
+	}
+	return strings.Join(labels, ".") + onsSuffix(key_type, ons_root), nil
+}
+
+var ErrNotONSName = errors.New("not an ONS name")
+
+//ONS 2.0 FQDN을 key type과 check digit을 포함한 GS1 key로 변환한다.
+//check digit을 포함한 label이 주어진 경우에는 check digit을 검증한다.
+func FQDNToKey(fqdn string, ons_root string) (string, string, error) {
+	name := strings.ToLower(strings.TrimSuffix(fqdn, "."))
+
+	for key_type, length := range KeyLengths {
+		suffix := onsSuffix(key_type, ons_root)
+		if strings.HasSuffix(name, suffix) == false {
+			continue
+		}
+
+		labels := strings.Split(strings.TrimSuffix(name, suffix), ".")
+		digits := make([]byte, 0, len(labels))
+		for i := len(labels) - 1; i >= 0; i-- {
+			if len(labels[i]) != 1 || isDigits(labels[i]) == false {
+				return "", "", fmt.Errorf("%s: label %q is not a single digit", fqdn, labels[i])
+			}
+			digits = append(digits, labels[i][0])
+		}
+
+		switch len(digits) {
+		case length - 1:
+			check, _ := CheckDigit(string(digits))
+			return key_type, string(append(digits, check)), nil
+		case length:
+			if err := ValidateCheckDigit(string(digits)); err != nil {
+				return "", "", err
+			}
+			return key_type, string(digits), nil
+		default:
+			return "", "", fmt.Errorf("%s: %s needs %d digits, got %d", fqdn, key_type, length-1, len(digits))
+		}
+	}
+	return "", "", ErrNotONSName
+}
+
+//GS1 key type의 GS1 Digital Link primary key AI.
+var KeyAIs = map[string]string{
+	"gtin": "01",
+	"gln":  "414",
+	"sscc": "00",
+}
+
+//check digit을 포함한 key의 길이로 key type을 구한다.
+func KeyTypeOf(key string) (string, error) {
+	for key_type, length := range KeyLengths {
+		if len(key) == length && isDigits(key) {
+			return key_type, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a GTIN-14, GLN or SSCC", key)
+}
+
+//GS1 key의 Digital Link path. (예: /01/09506000134352)
+func DigitalLinkPath(key_type string, key string) string {
+	return "/" + KeyAIs[key_type] + "/" + key
+}
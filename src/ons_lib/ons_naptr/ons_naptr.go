@@ -0,0 +1,211 @@
+package ons_naptr
+
+import (
+	"fmt"
+	"ons_lib/ons_gs1"
+	"protobuf/ons_pb2"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//NAPTR regexp field를 해석한 결과. (RFC 3402, 3403)
+//"<delim><ere><delim><replacement><delim><flags>" 형식이며 flags에는 대소문자를 구분하지 않는 "i"만 올 수 있다.
+type Regexp struct {
+	Pattern *regexp.Regexp
+	//backreference(\1 ~ \9)를 regexp.Expand 형식(${1})으로 변환한 replacement.
+	Template string
+	Flags    string
+}
+
+//field를 delimiter로 나눈다. backslash로 escape된 delimiter는 나누지 않는다.
+func splitFields(field string, delim byte) []string {
+	fields := []string{}
+	current := []byte{}
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if c == '\\' && i+1 < len(field) {
+			current = append(current, c, field[i+1])
+			i++
+			continue
+		}
+		if c == delim {
+			fields = append(fields, string(current))
+			current = []byte{}
+			continue
+		}
+		current = append(current, c)
+	}
+	return append(fields, string(current))
+}
+
+//replacement의 backslash escape를 regexp.Expand template으로 변환한다.
+//\N은 N번째 group, \\는 backslash, \<delim>은 delimiter 문자가 된다.
+func translateReplacement(replacement string, groups int) (string, error) {
+	var template strings.Builder
+	for i := 0; i < len(replacement); i++ {
+		c := replacement[i]
+		switch {
+		case c == '$':
+			template.WriteString("$$")
+		case c == '\\':
+			if i+1 >= len(replacement) {
+				return "", fmt.Errorf("trailing backslash in replacement %q", replacement)
+			}
+			i++
+			next := replacement[i]
+			if next >= '1' && next <= '9' {
+				if int(next-'0') > groups {
+					return "", fmt.Errorf("backreference \\%c in replacement %q has no group", next, replacement)
+				}
+				template.WriteString("${" + string(next) + "}")
+			} else if next == '$' {
+				template.WriteString("$$")
+			} else {
+				template.WriteByte(next)
+			}
+		default:
+			template.WriteByte(c)
+		}
+	}
+	return template.String(), nil
+}
+
+func ParseRegexp(field string) (*Regexp, error) {
+	if len(field) < 3 {
+		return nil, fmt.Errorf("invalid NAPTR regexp %q", field)
+	}
+	delim := field[0]
+	if (delim >= '0' && delim <= '9') || delim == '\\' || delim == 'i' {
+		return nil, fmt.Errorf("invalid delimiter %q in NAPTR regexp %q", delim, field)
+	}
+
+	fields := splitFields(field[1:], delim)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("NAPTR regexp %q must be %cere%creplacement%cflags", field, delim, delim, delim)
+	}
+	if fields[2] != "" && fields[2] != "i" {
+		return nil, fmt.Errorf("invalid flags %q in NAPTR regexp %q", fields[2], field)
+	}
+
+	//ere 안의 escape된 delimiter는 delimiter 문자 그대로 matching한다.
+	pattern := strings.Replace(fields[0], "\\"+string(delim), regexp.QuoteMeta(string(delim)), -1)
+	if fields[2] == "i" {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ere in NAPTR regexp %q: %v", field, err)
+	}
+
+	template, err := translateReplacement(fields[1], re.NumSubexp())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Regexp{
+		Pattern:  re,
+		Template: template,
+		Flags:    fields[2],
+	}, nil
+}
+
+//aus(application unique string)에 regexp를 적용한다. matching되지 않으면 false를 반환한다.
+func (re *Regexp) Apply(aus string) (string, bool) {
+	match := re.Pattern.FindStringSubmatchIndex(aus)
+	if match == nil {
+		return "", false
+	}
+	return string(re.Pattern.ExpandString(nil, re.Template, aus, match)), true
+}
+
+//ONS record를 DNS NAPTR 형식으로 나타낸 것.
+type NAPTR struct {
+	Order       uint16 `json:"order"`
+	Preference  uint16 `json:"preference"`
+	Flags       string `json:"flags"`
+	Service     string `json:"service"`
+	Regexp      string `json:"regexp"`
+	Replacement string `json:"replacement"`
+}
+
+//record의 flags(문자 code)를 NAPTR flags로 바꾼다. 0이면 flag가 없다.
+//NAPTR flag는 A-Z, 0-9 중 한 문자이므로 (RFC 3403) 다른 문자이면 ok가 false이다.
+func RecordFlags(flags int32) (string, bool) {
+	if flags == 0 {
+		return "", true
+	}
+	if (flags >= 'A' && flags <= 'Z') || (flags >= 'a' && flags <= 'z') || (flags >= '0' && flags <= '9') {
+		return string(rune(flags)), true
+	}
+	return "", false
+}
+
+//GS1 code가 ACTIVE 상태일 때 ACTIVE인 record를 NAPTR로 변환한다.
+//record에는 order, preference가 없기 때문에 order는 0, preference는 등록된 순서(index)를 사용한다.
+//flags가 잘못된 record는 제외한다.
+func FromGS1CodeData(gs1_code_data *ons_pb2.GS1CodeData) []*NAPTR {
+	naptrs := []*NAPTR{}
+	if gs1_code_data.GetState() != ons_pb2.GS1CodeData_GS1CODE_ACTIVE {
+		return naptrs
+	}
+	for _, record := range gs1_code_data.GetRecords() {
+		if record.GetState() != ons_pb2.Record_RECORD_ACTIVE {
+			continue
+		}
+		flags, ok := RecordFlags(record.GetFlags())
+		if ok == false {
+			continue
+		}
+		naptrs = append(naptrs, &NAPTR{
+			Order:       0,
+			Preference:  uint16(len(naptrs)),
+			Flags:       flags,
+			Service:     record.GetService(),
+			Regexp:      record.GetRegexp(),
+			Replacement: ".",
+		})
+	}
+	return naptrs
+}
+
+//regexp를 적용해서 얻은 service URI.
+type Result struct {
+	NAPTR
+	URI string `json:"uri"`
+}
+
+//GS1 key의 application unique string. Digital Link path 형식을 사용한다. (예: /01/09506000134352)
+func ApplicationUniqueString(key_type string, key string) string {
+	return ons_gs1.DigitalLinkPath(key_type, key)
+}
+
+//naptrs를 order, preference 순서로 정렬하고 terminal("u" flag) NAPTR의 regexp를 aus에 적용한 URI를 반환한다.
+//regexp가 잘못되었거나 matching되지 않는 NAPTR은 제외한다.
+func Resolve(aus string, naptrs []*NAPTR) []*Result {
+	sorted := make([]*NAPTR, len(naptrs))
+	copy(sorted, naptrs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Order != sorted[j].Order {
+			return sorted[i].Order < sorted[j].Order
+		}
+		return sorted[i].Preference < sorted[j].Preference
+	})
+
+	results := []*Result{}
+	for _, naptr := range sorted {
+		if strings.ToLower(naptr.Flags) != "u" {
+			continue
+		}
+		re, err := ParseRegexp(naptr.Regexp)
+		if err != nil {
+			continue
+		}
+		uri, ok := re.Apply(aus)
+		if ok == false {
+			continue
+		}
+		results = append(results, &Result{NAPTR: *naptr, URI: uri})
+	}
+	return results
+}
@@ -0,0 +1,157 @@
+package ons_naptr
+
+import (
+	"protobuf/ons_pb2"
+	"testing"
+)
+
+func TestParseRegexp(t *testing.T) {
+	tests := []struct {
+		field string
+		aus   string
+		uri   string
+		match bool
+	}{
+		{"!^.*$!http://example.com/epcis!", "/01/09506000134352", "http://example.com/epcis", true},
+		//RFC 3402 backreference
+		{"!^/01/([0-9]+)/21/(.*)$!http://example.com/\\1?serial=\\2!", "/01/09506000134352/21/12345",
+			"http://example.com/09506000134352?serial=12345", true},
+		{"/^urn:cid:.+@([^\\.]+\\.)(.*)$/\\2/i", "URN:cid:199606121851.1@bar.example.com", "example.com", true},
+		//escape된 delimiter는 delimiter 문자가 된다.
+		{"!^/01/(.*)\\!$!http://example.com/\\1\\!!", "/01/123!", "http://example.com/123!", true},
+		{"#^/01/(.*)$#http://example.com/\\#\\1#", "/01/123", "http://example.com/#123", true},
+		//replacement의 $는 그대로 쓴다.
+		{"!^/01/(.*)$!http://example.com/$\\1\\$!", "/01/123", "http://example.com/$123$", true},
+		{"!^/01/ABC$!http://example.com/!i", "/01/abc", "http://example.com/", true},
+		{"!^/01/ABC$!http://example.com/!", "/01/abc", "", false},
+	}
+	for _, test := range tests {
+		re, err := ParseRegexp(test.field)
+		if err != nil {
+			t.Errorf("%s: %v", test.field, err)
+			continue
+		}
+		uri, ok := re.Apply(test.aus)
+		if ok != test.match || uri != test.uri {
+			t.Errorf("%s applied to %s is %q, %v, want %q, %v", test.field, test.aus, uri, ok, test.uri, test.match)
+		}
+	}
+}
+
+func TestParseRegexpMalformed(t *testing.T) {
+	for _, field := range []string{
+		"",
+		"!!",
+		//field가 3개가 아니다.
+		"!^.*$!http://example.com/",
+		"!^.*$!http://example.com/!i!",
+		"!^.*$\\!http://example.com/!",
+		//delimiter로 쓸 수 없는 문자
+		"1^.*$1http://example.com/1",
+		"\\^.*$\\http://example.com/\\",
+		"i^.*$ihttp://example.com/i",
+		"!^.*$!http://example.com/!x",
+		"!^(.*$!http://example.com/!",
+		"!^.*$!http://example.com/\\1!",
+		"!^(.*)$!http://example.com/\\2!",
+	} {
+		re, err := ParseRegexp(field)
+		if err == nil {
+			t.Errorf("%q is parsed as %s -> %s", field, re.Pattern, re.Template)
+		}
+	}
+}
+
+func TestTranslateReplacement(t *testing.T) {
+	tests := []struct {
+		replacement string
+		groups      int
+		template    string
+		valid       bool
+	}{
+		{"http://example.com/", 0, "http://example.com/", true},
+		{"\\1-\\2", 2, "${1}-${2}", true},
+		{"\\9", 9, "${9}", true},
+		{"a$b", 0, "a$$b", true},
+		{"\\$", 0, "$$", true},
+		{"\\\\", 0, "\\", true},
+		{"\\!\\0", 0, "!0", true},
+		{"\\3", 2, "", false},
+		{"abc\\", 0, "", false},
+	}
+	for _, test := range tests {
+		template, err := translateReplacement(test.replacement, test.groups)
+		if (err == nil) != test.valid || template != test.template {
+			t.Errorf("%q with %d groups is %q, %v, want %q", test.replacement, test.groups, template, err, test.template)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	naptrs := []*NAPTR{
+		{Order: 1, Preference: 0, Flags: "u", Service: "second", Regexp: "!^.*$!http://example.com/second!"},
+		{Order: 0, Preference: 1, Flags: "U", Service: "first-1", Regexp: "!^/01/(.*)$!http://example.com/\\1!"},
+		{Order: 0, Preference: 0, Flags: "u", Service: "first-0", Regexp: "!^.*$!http://example.com/first!"},
+		//terminal이 아닌 NAPTR, 잘못된 regexp, matching되지 않는 regexp는 제외한다.
+		{Order: 0, Preference: 2, Flags: "s", Service: "srv", Regexp: "!^.*$!http://example.com/srv!"},
+		{Order: 0, Preference: 3, Flags: "", Service: "empty", Regexp: "!^.*$!http://example.com/empty!"},
+		{Order: 0, Preference: 4, Flags: "u", Service: "malformed", Regexp: "!^(.*$!http://example.com/!"},
+		{Order: 0, Preference: 5, Flags: "u", Service: "sscc", Regexp: "!^/00/.*$!http://example.com/sscc!"},
+	}
+
+	results := Resolve(ApplicationUniqueString("gtin", "09506000134352"), naptrs)
+	expected := []string{"http://example.com/first", "http://example.com/09506000134352", "http://example.com/second"}
+	if len(results) != len(expected) {
+		t.Fatalf("%d results, want %d", len(results), len(expected))
+	}
+	for idx, uri := range expected {
+		if results[idx].URI != uri {
+			t.Errorf("result %d (%s) is %s, want %s", idx, results[idx].Service, results[idx].URI, uri)
+		}
+	}
+	//naptrs의 순서는 바꾸지 않는다.
+	if naptrs[0].Service != "second" {
+		t.Errorf("naptrs are sorted in place")
+	}
+}
+
+func TestFromGS1CodeData(t *testing.T) {
+	gs1_code_data := &ons_pb2.GS1CodeData{
+		Gs1Code: "09506000134352",
+		State:   ons_pb2.GS1CodeData_GS1CODE_ACTIVE,
+		Records: []*ons_pb2.Record{
+			{Flags: 'u', Service: "u", State: ons_pb2.Record_RECORD_ACTIVE},
+			//flag가 없는 record
+			{Flags: 0, Service: "empty", State: ons_pb2.Record_RECORD_ACTIVE},
+			{Flags: 'S', Service: "inactive", State: ons_pb2.Record_RECORD_INACTIVE},
+			//NAPTR flag로 쓸 수 없는 문자
+			{Flags: 1, Service: "control", State: ons_pb2.Record_RECORD_ACTIVE},
+			{Flags: '!', Service: "punct", State: ons_pb2.Record_RECORD_ACTIVE},
+			{Flags: 0x00e9, Service: "non-ascii", State: ons_pb2.Record_RECORD_ACTIVE},
+			{Flags: -1, Service: "negative", State: ons_pb2.Record_RECORD_ACTIVE},
+			{Flags: '7', Service: "digit", State: ons_pb2.Record_RECORD_ACTIVE},
+		},
+	}
+	naptrs := FromGS1CodeData(gs1_code_data)
+	expected := []struct {
+		service string
+		flags   string
+	}{
+		{"u", "u"},
+		{"empty", ""},
+		{"digit", "7"},
+	}
+	if len(naptrs) != len(expected) {
+		t.Fatalf("%d NAPTRs, want %d", len(naptrs), len(expected))
+	}
+	for idx, naptr := range naptrs {
+		if naptr.Service != expected[idx].service || naptr.Flags != expected[idx].flags || naptr.Preference != uint16(idx) {
+			t.Errorf("NAPTR %d is %+v, want %s with flags %q", idx, naptr, expected[idx].service, expected[idx].flags)
+		}
+	}
+
+	gs1_code_data.State = ons_pb2.GS1CodeData_GS1CODE_INACTIVE
+	if naptrs := FromGS1CodeData(gs1_code_data); len(naptrs) != 0 {
+		t.Errorf("inactive GS1 code has %d NAPTRs", len(naptrs))
+	}
+}
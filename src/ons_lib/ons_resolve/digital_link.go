@@ -0,0 +1,156 @@
+package ons_resolve
+
+import (
+	"fmt"
+	"net/url"
+	"ons_lib/ons_ai"
+	"ons_lib/ons_epc"
+	"ons_lib/ons_gs1"
+	"strings"
+)
+
+//GS1 Digital Link URI path에서 사용하는 primary key AI와 key type.
+var dlPrimaryKeys = map[string]string{
+	"01":  "gtin",
+	"00":  "sscc",
+	"414": "gln",
+}
+
+//primary key 뒤에 path로 올 수 있는 key qualifier AI. (순서대로)
+var dlKeyQualifiers = map[string][]string{
+	"01":  []string{"22", "10", "21"},
+	"414": []string{"254"},
+}
+
+//GS1 Digital Link URI를 해석한 결과.
+type DigitalLink struct {
+	//primary key AI와 check digit을 포함한 GS1 key. GTIN은 14자리로 변환한다.
+	KeyAI   string
+	KeyType string
+	Key     string
+	//path에 포함된 key qualifier. (AI, value) 순서대로.
+	Qualifiers [][2]string
+	//query의 linkType. 없으면 default link를 사용한다.
+	LinkType string
+}
+
+//GS1 key를 ONS에 등록된 형식(check digit 포함, GTIN은 14자리)으로 변환한다.
+func normalizeKey(key_type string, value string) (string, error) {
+	if key_type == "gtin" {
+		switch len(value) {
+		case 8, 12, 13:
+			value = strings.Repeat("0", 14-len(value)) + value
+		}
+	}
+	length := ons_gs1.KeyLengths[key_type]
+	if len(value) != length {
+		return "", fmt.Errorf("%s must be %d digits, got %q", key_type, length, value)
+	}
+	if err := ons_gs1.ValidateCheckDigit(value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+//Digital Link URI path와 query를 해석한다.
+//primary key 앞의 path는 resolver의 prefix로 보고 무시한다.
+//또한 /epc/<EPC>와 ?scan=<element string>은 각각에 해당하는 Digital Link로 해석한다.
+//예) /01/09506000134352/21/12345?linkType=gs1:pip
+func ParseDigitalLink(path string, query url.Values) (*DigitalLink, error) {
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if len(segment) == 0 {
+			continue
+		}
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path segment %q: %v", segment, err)
+		}
+		segments = append(segments, unescaped)
+	}
+
+	//barcode에서 읽은 element string은 ?scan=<element string>으로 조회할 수 있다.
+	if scanned := query.Get("scan"); len(scanned) > 0 {
+		element_string, err := ons_ai.Parse(scanned)
+		if err != nil {
+			return nil, err
+		}
+		scan_path, err := element_string.DigitalLinkPath()
+		if err != nil {
+			return nil, err
+		}
+		query.Del("scan")
+		return ParseDigitalLink(scan_path, query)
+	}
+
+	//RFID reader가 읽은 EPC는 /epc/<EPC URI 또는 96 bit hex>로 조회할 수 있다.
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i] == "epc" && ons_epc.IsEPC(segments[i+1]) {
+			epc, err := ons_epc.Parse(segments[i+1])
+			if err != nil {
+				return nil, err
+			}
+			epc_path, err := epc.DigitalLinkPath()
+			if err != nil {
+				return nil, err
+			}
+			return ParseDigitalLink(epc_path, query)
+		}
+	}
+
+	start := -1
+	for i := 0; i+1 < len(segments); i++ {
+		if _, ok := dlPrimaryKeys[segments[i]]; ok {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("no GS1 primary key in %q", path)
+	}
+
+	link := &DigitalLink{
+		KeyAI:   segments[start],
+		KeyType: dlPrimaryKeys[segments[start]],
+	}
+	key, err := normalizeKey(link.KeyType, segments[start+1])
+	if err != nil {
+		return nil, err
+	}
+	link.Key = key
+
+	//qualifier는 정해진 순서대로만 올 수 있다.
+	rest := segments[start+2:]
+	if len(rest)%2 != 0 {
+		return nil, fmt.Errorf("missing value of AI %s in %q", rest[len(rest)-1], path)
+	}
+	allowed := dlKeyQualifiers[link.KeyAI]
+	for i := 0; i < len(rest); i += 2 {
+		found := false
+		for len(allowed) > 0 {
+			ai := allowed[0]
+			allowed = allowed[1:]
+			if ai == rest[i] {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			return nil, fmt.Errorf("AI %s is not a valid key qualifier of AI %s here", rest[i], link.KeyAI)
+		}
+		link.Qualifiers = append(link.Qualifiers, [2]string{rest[i], rest[i+1]})
+	}
+
+	link.LinkType = query.Get("linkType")
+	return link, nil
+}
+
+//primary key와 qualifier만 포함하는 canonical path.
+//NAPTR regexp를 적용하는 application unique string으로 사용한다.
+func (link *DigitalLink) Path() string {
+	path := ons_gs1.DigitalLinkPath(link.KeyType, link.Key)
+	for _, qualifier := range link.Qualifiers {
+		path += "/" + qualifier[0] + "/" + url.PathEscape(qualifier[1])
+	}
+	return path
+}
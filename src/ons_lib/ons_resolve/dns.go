@@ -0,0 +1,105 @@
+package ons_resolve
+
+import (
+	"log"
+	"ons_lib/ons_gs1"
+	"ons_lib/ons_naptr"
+	"protobuf/ons_pb2"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+type ONSDNSHandler struct {
+	source  GS1CodeSource
+	onsRoot string
+	ttl     uint32
+	verbose bool
+}
+
+func NewONSDNSHandler(source GS1CodeSource, ons_root string, ttl uint32, verbose bool) *ONSDNSHandler {
+	return &ONSDNSHandler{
+		source:  source,
+		onsRoot: strings.Trim(ons_root, "."),
+		ttl:     ttl,
+		verbose: verbose,
+	}
+}
+
+func (h *ONSDNSHandler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	if len(req.Question) != 1 {
+		resp.SetRcode(req, dns.RcodeFormatError)
+		w.WriteMsg(resp)
+		return
+	}
+
+	question := req.Question[0]
+	rcode, answers := h.resolve(question)
+	resp.SetRcode(req, rcode)
+	resp.Answer = answers
+
+	if h.verbose == true {
+		log.Printf("%s %s -> %s, %d answers\n", dns.TypeToString[question.Qtype], question.Name, dns.RcodeToString[rcode], len(answers))
+	}
+
+	err := w.WriteMsg(resp)
+	if err != nil {
+		log.Printf("Failed to write DNS response: %v\n", err)
+	}
+}
+
+func (h *ONSDNSHandler) resolve(question dns.Question) (int, []dns.RR) {
+	if question.Qclass != dns.ClassINET {
+		return dns.RcodeRefused, nil
+	}
+
+	if dns.IsSubDomain(dns.Fqdn("gs1.id."+h.onsRoot), strings.ToLower(question.Name)) == false {
+		return dns.RcodeRefused, nil
+	}
+
+	_, gs1_code, err := ons_gs1.FQDNToKey(question.Name, h.onsRoot)
+	if err != nil {
+		if h.verbose == true {
+			log.Printf("Invalid ONS name %s: %v\n", question.Name, err)
+		}
+		return dns.RcodeNameError, nil
+	}
+
+	gs1_code_data, err := h.source.GetGS1Code(gs1_code)
+	if err != nil {
+		log.Printf("Failed to get GS1 code %s: %v\n", gs1_code, err)
+		return dns.RcodeServerFailure, nil
+	}
+
+	//등록되지 않았거나 ACTIVE가 아닌 GS1 code는 존재하지 않는 이름으로 응답한다.
+	if gs1_code_data == nil || gs1_code_data.GetState() != ons_pb2.GS1CodeData_GS1CODE_ACTIVE {
+		return dns.RcodeNameError, nil
+	}
+
+	if question.Qtype != dns.TypeNAPTR && question.Qtype != dns.TypeANY {
+		return dns.RcodeSuccess, nil
+	}
+
+	answers := []dns.RR{}
+	for _, naptr := range ons_naptr.FromGS1CodeData(gs1_code_data) {
+		answers = append(answers, &dns.NAPTR{
+			Hdr: dns.RR_Header{
+				Name:   question.Name,
+				Rrtype: dns.TypeNAPTR,
+				Class:  dns.ClassINET,
+				Ttl:    h.ttl,
+			},
+			Order:       naptr.Order,
+			Preference:  naptr.Preference,
+			Flags:       naptr.Flags,
+			Service:     naptr.Service,
+			Regexp:      naptr.Regexp,
+			Replacement: naptr.Replacement,
+		})
+	}
+	return dns.RcodeSuccess, answers
+}
@@ -0,0 +1,107 @@
+package ons_resolve
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const testGS1Codes = `[
+  {"gs1Code": "09506000134352", "state": "GS1CODE_ACTIVE", "records": [
+    {"flags": 117, "service": "http://www.gs1.org/ons/epcis", "regexp": "!^.*$!http://example.com/epcis!", "state": "RECORD_ACTIVE"},
+    {"flags": 117, "service": "http://www.gs1.org/ons/pip", "regexp": "!^.*$!http://example.com/pip!", "state": "RECORD_INACTIVE"}
+  ]},
+  {"gs1Code": "09506000134369", "state": "GS1CODE_INACTIVE"}
+]`
+
+const (
+	TEST_ACTIVE_NAME   = "5.3.4.3.1.0.0.0.6.0.5.9.0.gtin.gs1.id.onsepc.com."
+	TEST_INACTIVE_NAME = "6.3.4.3.1.0.0.0.6.0.5.9.0.gtin.gs1.id.onsepc.com."
+	TEST_UNKNOWN_NAME  = "7.3.4.3.1.0.0.0.6.0.5.9.0.gtin.gs1.id.onsepc.com."
+)
+
+func startTestServer(t *testing.T) *Server {
+	dir, err := ioutil.TempDir("", "ons_resolve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "gs1codes.json")
+	err = ioutil.WriteFile(path, []byte(testGS1Codes), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewFileSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := Start(source, &Options{DNS: "127.0.0.1:0", Root: "onsepc.com", TTL: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return server
+}
+
+func exchange(t *testing.T, net string, addr string, name string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	client := &dns.Client{Net: net}
+	resp, _, err := client.Exchange(req, addr)
+	if err != nil {
+		t.Fatalf("%s %s : %v", net, name, err)
+	}
+	return resp
+}
+
+func TestDNSNAPTR(t *testing.T) {
+	server := startTestServer(t)
+
+	for _, net := range []string{"udp", "tcp"} {
+		resp := exchange(t, net, server.DNSAddr(), TEST_ACTIVE_NAME, dns.TypeNAPTR)
+		if resp.Rcode != dns.RcodeSuccess || resp.Authoritative == false {
+			t.Fatalf("%s: rcode %s, authoritative %v", net, dns.RcodeToString[resp.Rcode], resp.Authoritative)
+		}
+		//INACTIVE record는 응답하지 않는다.
+		if len(resp.Answer) != 1 {
+			t.Fatalf("%s: %d answers, want 1", net, len(resp.Answer))
+		}
+		naptr, ok := resp.Answer[0].(*dns.NAPTR)
+		if ok == false {
+			t.Fatalf("%s: answer is %T", net, resp.Answer[0])
+		}
+		if naptr.Flags != "u" || naptr.Service != "http://www.gs1.org/ons/epcis" ||
+			naptr.Regexp != "!^.*$!http://example.com/epcis!" || naptr.Hdr.Ttl != 60 {
+			t.Errorf("%s: unexpected answer %s", net, naptr.String())
+		}
+	}
+}
+
+func TestDNSRcodes(t *testing.T) {
+	server := startTestServer(t)
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		rcode int
+	}{
+		{TEST_INACTIVE_NAME, dns.TypeNAPTR, dns.RcodeNameError},
+		{TEST_UNKNOWN_NAME, dns.TypeNAPTR, dns.RcodeNameError},
+		{"1.2.gtin.gs1.id.onsepc.com.", dns.TypeNAPTR, dns.RcodeNameError},
+		{"www.example.com.", dns.TypeNAPTR, dns.RcodeRefused},
+		//등록된 이름의 다른 type은 answer 없이 응답한다.
+		{TEST_ACTIVE_NAME, dns.TypeA, dns.RcodeSuccess},
+	}
+	for _, test := range tests {
+		resp := exchange(t, "udp", server.DNSAddr(), test.name, test.qtype)
+		if resp.Rcode != test.rcode || len(resp.Answer) != 0 {
+			t.Errorf("%s %s: rcode %s with %d answers, want %s", dns.TypeToString[test.qtype], test.name,
+				dns.RcodeToString[resp.Rcode], len(resp.Answer), dns.RcodeToString[test.rcode])
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package ons_resolve
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"ons_lib/ons_naptr"
+	"protobuf/ons_pb2"
+	"strings"
+)
+
+//linkType과 record의 service를 비교한다.
+//"gs1:pip"와 같은 compact 형식은 service URI의 마지막 path와 비교한다.
+func matchLinkType(link_type string, service string) bool {
+	if link_type == service {
+		return true
+	}
+	if strings.HasPrefix(link_type, "gs1:") {
+		term := strings.TrimPrefix(link_type, "gs1:")
+		return strings.HasSuffix(strings.TrimRight(service, "/"), "/"+term)
+	}
+	return false
+}
+
+type linkEntry struct {
+	Href string `json:"href"`
+}
+
+type DigitalLinkHandler struct {
+	source  GS1CodeSource
+	verbose bool
+}
+
+func NewDigitalLinkHandler(source GS1CodeSource, verbose bool) *DigitalLinkHandler {
+	return &DigitalLinkHandler{
+		source:  source,
+		verbose: verbose,
+	}
+}
+
+func (h *DigitalLinkHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	link, err := ParseDigitalLink(req.URL.Path, req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	gs1_code_data, err := h.source.GetGS1Code(link.Key)
+	if err != nil {
+		log.Printf("Failed to get GS1 code %s: %v\n", link.Key, err)
+		http.Error(w, "failed to get GS1 code", http.StatusInternalServerError)
+		return
+	}
+	if gs1_code_data == nil || gs1_code_data.GetState() != ons_pb2.GS1CodeData_GS1CODE_ACTIVE {
+		http.Error(w, fmt.Sprintf("%s %s is not registered", link.KeyType, link.Key), http.StatusNotFound)
+		return
+	}
+
+	//URI를 반환하는 'u' flag의 ACTIVE record만 order, preference 순서대로 사용한다.
+	aus := link.Path()
+	links := make(map[string][]linkEntry)
+	services := []string{}
+	for _, result := range ons_naptr.Resolve(aus, ons_naptr.FromGS1CodeData(gs1_code_data)) {
+		if _, ok := links[result.Service]; ok == false {
+			services = append(services, result.Service)
+		}
+		links[result.Service] = append(links[result.Service], linkEntry{Href: result.URI})
+	}
+
+	if link.LinkType == "all" {
+		h.writeLinkset(w, req, aus, links)
+		return
+	}
+
+	//linkType이 없으면 첫 번째 record를 default link로 사용한다.
+	for _, service := range services {
+		if len(link.LinkType) == 0 || matchLinkType(link.LinkType, service) {
+			if h.verbose == true {
+				log.Printf("%s -> %s\n", req.URL.String(), links[service][0].Href)
+			}
+			http.Redirect(w, req, links[service][0].Href, http.StatusTemporaryRedirect)
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("no link for %s %s", link.KeyType, link.Key), http.StatusNotFound)
+}
+
+//RFC 9264 형식의 linkset JSON을 반환한다. service를 link relation type으로 사용한다.
+func (h *DigitalLinkHandler) writeLinkset(w http.ResponseWriter, req *http.Request, aus string, links map[string][]linkEntry) {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	entry := map[string]interface{}{
+		"anchor": scheme + "://" + req.Host + aus,
+	}
+	for service, entries := range links {
+		entry[service] = entries
+	}
+
+	w.Header().Set("Content-Type", "application/linkset+json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(map[string]interface{}{
+		"linkset": []interface{}{entry},
+	})
+	if err != nil {
+		log.Printf("Failed to write linkset: %v\n", err)
+	}
+}
@@ -0,0 +1,119 @@
+package ons_resolve
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+//ONS DNS, GS1 Digital Link listener 설정. address가 비어 있으면 실행하지 않는다.
+type Options struct {
+	//udp, tcp host:port
+	DNS  string
+	HTTP string
+	//ONS root domain
+	Root string
+	//NAPTR answer의 TTL
+	TTL     uint32
+	Verbose bool
+}
+
+//실행 중인 DNS, Digital Link listener.
+type Server struct {
+	dnsServers []*dns.Server
+	httpServer *http.Server
+	dnsAddr    string
+	httpAddr   string
+}
+
+//listener를 모두 열고 나서 serve를 시작한다. 하나라도 열지 못하면 연 listener를 닫고 error를 반환한다.
+func Start(source GS1CodeSource, options *Options) (*Server, error) {
+	if len(options.DNS) == 0 && len(options.HTTP) == 0 {
+		return nil, fmt.Errorf("neither DNS nor HTTP address is given")
+	}
+
+	server := &Server{}
+	var packet_conn net.PacketConn
+	var dns_listener net.Listener
+	var http_listener net.Listener
+	closeAll := func() {
+		for _, closer := range []interface{ Close() error }{packet_conn, dns_listener, http_listener} {
+			if closer != nil {
+				closer.Close()
+			}
+		}
+	}
+
+	var err error
+	if len(options.DNS) > 0 {
+		packet_conn, err = net.ListenPacket("udp", options.DNS)
+		if err != nil {
+			return nil, err
+		}
+		//port가 0이면 udp와 같은 port를 사용한다.
+		dns_listener, err = net.Listen("tcp", packet_conn.LocalAddr().String())
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		server.dnsAddr = packet_conn.LocalAddr().String()
+
+		handler := NewONSDNSHandler(source, options.Root, options.TTL, options.Verbose)
+		server.dnsServers = []*dns.Server{
+			&dns.Server{PacketConn: packet_conn, Net: "udp", Handler: handler},
+			&dns.Server{Listener: dns_listener, Net: "tcp", Handler: handler},
+		}
+	}
+
+	if len(options.HTTP) > 0 {
+		http_listener, err = net.Listen("tcp", options.HTTP)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		server.httpAddr = http_listener.Addr().String()
+		server.httpServer = &http.Server{Handler: NewDigitalLinkHandler(source, options.Verbose)}
+	}
+
+	for _, dns_server := range server.dnsServers {
+		go func(dns_server *dns.Server) {
+			log.Printf("Serving ONS DNS on %s/%s\n", server.dnsAddr, dns_server.Net)
+			err := dns_server.ActivateAndServe()
+			if err != nil {
+				log.Printf("DNS server(%s) stopped : %v\n", dns_server.Net, err)
+			}
+		}(dns_server)
+	}
+	if server.httpServer != nil {
+		go func() {
+			log.Printf("Serving GS1 Digital Link resolver on %s\n", server.httpAddr)
+			err := server.httpServer.Serve(http_listener)
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("Digital Link server stopped : %v\n", err)
+			}
+		}()
+	}
+	return server, nil
+}
+
+//DNS listener의 udp, tcp address. DNS를 실행하지 않으면 비어 있다.
+func (s *Server) DNSAddr() string {
+	return s.dnsAddr
+}
+
+//Digital Link listener address. 실행하지 않으면 비어 있다.
+func (s *Server) HTTPAddr() string {
+	return s.httpAddr
+}
+
+func (s *Server) Stop() {
+	for _, dns_server := range s.dnsServers {
+		dns_server.Shutdown()
+	}
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+}
@@ -0,0 +1,54 @@
+package ons_resolve
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"protobuf/ons_pb2"
+
+	"github.com/golang/protobuf/jsonpb"
+)
+
+//resolver가 GS1CodeData를 읽어 오는 곳.
+//ons_sync는 동기화한 store를, ons_resolver는 test용 JSON file을 사용한다.
+type GS1CodeSource interface {
+	//GS1 code가 없으면 nil, nil을 반환한다.
+	GetGS1Code(gs1_code string) (*ons_pb2.GS1CodeData, error)
+}
+
+//GS1CodeData의 protobuf JSON list를 memory에 load해서 사용한다.
+//ons_test의 get action 출력을 모아서 만들 수 있으며 local test에 사용한다.
+type FileSource struct {
+	gs1_codes map[string]*ons_pb2.GS1CodeData
+}
+
+func NewFileSource(path string) (*FileSource, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []json.RawMessage
+	err = json.Unmarshal(raw, &items)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a JSON list of GS1 code data: %v", path, err)
+	}
+
+	source := &FileSource{gs1_codes: make(map[string]*ons_pb2.GS1CodeData)}
+	for idx, item := range items {
+		gs1_code_data := &ons_pb2.GS1CodeData{}
+		err = jsonpb.Unmarshal(bytes.NewReader(item), gs1_code_data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: item %d is not GS1 code data: %v", path, idx, err)
+		}
+		source.gs1_codes[gs1_code_data.GetGs1Code()] = gs1_code_data
+	}
+	log.Printf("Loaded %d GS1 codes from %s\n", len(source.gs1_codes), path)
+	return source, nil
+}
+
+func (s *FileSource) GetGS1Code(gs1_code string) (*ons_pb2.GS1CodeData, error) {
+	return s.gs1_codes[gs1_code], nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"ons_lib/ons_resolve"
+	"os"
+	"os/signal"
+)
+
+//ons_sync가 동기화한 database로 응답하려면 ons_sync의 resolver listener를 사용한다.
+//ons_resolver는 JSON file의 GS1 code data로 응답하는 local test용이다.
+func main() {
+	data_file := flag.String("data", "", "JSON file of GS1 code data to serve (use the ons_sync -resolver-dns, -resolver-http options to serve the synchronized database)")
+	dns_addr := flag.String("dns", ":5353", "Address to serve ONS DNS queries on (udp and tcp), empty to disable")
+	http_addr := flag.String("http", "", "Address to serve GS1 Digital Link resolver on, empty to disable")
+	ons_root := flag.String("root", "onsepc.com", "ONS root domain")
+	ttl := flag.Uint("ttl", 300, "TTL of NAPTR answers")
+	verbose := flag.Bool("v", false, "Verbose logging")
+	flag.Parse()
+	log.SetFlags(0)
+
+	if len(*data_file) == 0 {
+		log.Printf("-data is not given")
+		os.Exit(2)
+	}
+	source, err := ons_resolve.NewFileSource(*data_file)
+	if err != nil {
+		log.Printf("Failed to load %s : %v", *data_file, err)
+		os.Exit(2)
+	}
+
+	server, err := ons_resolve.Start(source, &ons_resolve.Options{
+		DNS:     *dns_addr,
+		HTTP:    *http_addr,
+		Root:    *ons_root,
+		TTL:     uint32(*ttl),
+		Verbose: *verbose,
+	})
+	if err != nil {
+		log.Printf("Failed to start resolver : %v", err)
+		os.Exit(2)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	sig := <-interrupt
+	log.Println(sig)
+
+	server.Stop()
+}
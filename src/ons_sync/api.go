@@ -0,0 +1,568 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"ons_lib/ons_naptr"
+	"protobuf/ons_pb2"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+//query API의 JSON 응답 형식. protobuf와 database의 field 이름 대신 snake_case를 사용한다.
+type APIRecord struct {
+	Index    int    `json:"index"`
+	Flags    string `json:"flags"`
+	Service  string `json:"service"`
+	Regexp   string `json:"regexp"`
+	State    string `json:"state"`
+	Provider string `json:"provider,omitempty"`
+}
+
+type APIGS1Code struct {
+	Gs1Code  string       `json:"gs1_code"`
+	OwnerId  string       `json:"owner_id"`
+	State    string       `json:"state"`
+	Records  []*APIRecord `json:"records"`
+	Address  string       `json:"address"`
+	BlockNum float64      `json:"block_num"`
+}
+
+type APIKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type APIServiceType struct {
+	Address  string         `json:"address"`
+	Provider string         `json:"provider"`
+	Fields   []*APIKeyValue `json:"fields"`
+	Types    []*APIKeyValue `json:"types"`
+	//types에서 json인 field를 decode 한 fields
+	Document map[string]interface{} `json:"document"`
+	BlockNum float64                `json:"block_num"`
+}
+
+//provider의 record. GS1 code와 그 code 안에서의 record index를 함께 반환한다.
+type APIProviderRecord struct {
+	Gs1Code      string `json:"gs1_code"`
+	Gs1CodeState string `json:"gs1_code_state"`
+	APIRecord
+	BlockNum float64 `json:"block_num"`
+}
+
+//manager가 관리하는 GS1 code. GS1 code가 등록되지 않았으면 registered가 false이다.
+type APIManagedGS1Code struct {
+	Gs1Code    string  `json:"gs1_code"`
+	Manager    string  `json:"manager"`
+	Registered bool    `json:"registered"`
+	State      string  `json:"state,omitempty"`
+	OwnerId    string  `json:"owner_id,omitempty"`
+	BlockNum   float64 `json:"block_num"`
+	//manager가 GS1 code의 권한을 받은 block
+	GrantedBlockNum float64 `json:"granted_block_num"`
+}
+
+//su manager 또는 GS1 code manager
+type APIManager struct {
+	Kind            string  `json:"kind"`
+	Address         string  `json:"address"`
+	Gs1Code         string  `json:"gs1_code,omitempty"`
+	GrantedBlockNum float64 `json:"granted_block_num"`
+	BlockNum        float64 `json:"block_num"`
+}
+
+type APIList struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Next   string      `json:"next,omitempty"`
+}
+
+type APIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+//NAPTR flag로 쓸 수 없는 flags는 비워 둔다.
+func newAPIRecord(idx int, record *ons_pb2.Record) *APIRecord {
+	flags, _ := ons_naptr.RecordFlags(record.Flags)
+	return &APIRecord{
+		Index:    idx,
+		Flags:    flags,
+		Service:  record.Service,
+		Regexp:   record.Regexp,
+		State:    record.State.String(),
+		Provider: record.Provider,
+	}
+}
+
+func NewAPIGS1Code(gs1_code_event *ONSGS1CodeEvent) *APIGS1Code {
+	gs1_code := &APIGS1Code{
+		Gs1Code:  gs1_code_event.Gs1Code,
+		OwnerId:  gs1_code_event.OwnerId,
+		State:    gs1_code_event.State.String(),
+		Records:  []*APIRecord{},
+		Address:  gs1_code_event.Address,
+		BlockNum: gs1_code_event.BlockNum,
+	}
+	for idx, record := range gs1_code_event.Records {
+		gs1_code.Records = append(gs1_code.Records, newAPIRecord(idx, record))
+	}
+	return gs1_code
+}
+
+func newAPIKeyValues(fields []*ons_pb2.ServiceType_ServiceTypeField) []*APIKeyValue {
+	key_values := []*APIKeyValue{}
+	for _, field := range fields {
+		key_values = append(key_values, &APIKeyValue{Key: field.Key, Value: field.Value})
+	}
+	return key_values
+}
+
+//document가 없으면 (state value에서 바로 만든 event) fields에서 만든다.
+func NewAPIServiceType(service_type_event *ONSServiceTypeEvent) *APIServiceType {
+	document := service_type_event.Document
+	if document == nil {
+		document, _ = NewServiceTypeDocument(&service_type_event.ServiceType)
+	}
+	return &APIServiceType{
+		Address:  service_type_event.Address,
+		Provider: service_type_event.Provider,
+		Fields:   newAPIKeyValues(service_type_event.Fields),
+		Types:    newAPIKeyValues(service_type_event.Types),
+		Document: document,
+		BlockNum: service_type_event.BlockNum,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(v)
+	if err != nil {
+		log.Printf("Failed to write API response : %v\n", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, &APIError{Code: status, Message: fmt.Sprintf(format, args...)})
+}
+
+//offset, limit query parameter를 읽는다.
+func parsePage(query url.Values) (Page, error) {
+	page := Page{Offset: 0, Limit: defaultPageLimit}
+	if v := query.Get("offset"); len(v) > 0 {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return page, fmt.Errorf("invalid offset %q", v)
+		}
+		page.Offset = offset
+	}
+	if v := query.Get("limit"); len(v) > 0 {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return page, fmt.Errorf("limit must be 1 ~ %d, got %q", maxPageLimit, v)
+		}
+		page.Limit = limit
+	}
+	return page, nil
+}
+
+//다음 page가 있으면 다음 page의 URL을 반환한다.
+func nextPageURL(req *http.Request, page Page, count int, total int) string {
+	if page.Offset+count >= total {
+		return ""
+	}
+	query := req.URL.Query()
+	query.Set("offset", strconv.Itoa(page.Offset+count))
+	query.Set("limit", strconv.Itoa(page.Limit))
+	return req.URL.Path + "?" + query.Encode()
+}
+
+//GS1 code state는 GS1CODE_ACTIVE, ACTIVE, active, 2 모두 사용할 수 있다.
+func parseGS1CodeState(v string) (int32, error) {
+	if state, err := strconv.Atoi(v); err == nil {
+		if _, ok := ons_pb2.GS1CodeData_GS1CodeState_name[int32(state)]; ok {
+			return int32(state), nil
+		}
+	}
+	name := strings.ToUpper(v)
+	if strings.HasPrefix(name, "GS1CODE_") == false {
+		name = "GS1CODE_" + name
+	}
+	if state, ok := ons_pb2.GS1CodeData_GS1CodeState_value[name]; ok {
+		return state, nil
+	}
+	return 0, fmt.Errorf("invalid GS1 code state %q", v)
+}
+
+//as_of_block은 block number, as_of_time은 RFC 3339 또는 unix time이다. 둘 다 없으면 nil을 반환한다.
+func parseAsOf(query url.Values) (*AsOf, error) {
+	as_of := &AsOf{}
+	if v := query.Get("as_of_block"); len(v) > 0 {
+		block_num, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid as_of_block %q", v)
+		}
+		as_of.BlockNum, as_of.HasBlockNum = float64(block_num), true
+	}
+	if v := query.Get("as_of_time"); len(v) > 0 {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			as_of.Time = t.Unix()
+		} else if unix_time, err := strconv.ParseInt(v, 10, 64); err == nil {
+			as_of.Time = unix_time
+		} else {
+			return nil, fmt.Errorf("invalid as_of_time %q (RFC 3339 or unix time)", v)
+		}
+		as_of.HasTime = true
+	}
+	if as_of.HasBlockNum == false && as_of.HasTime == false {
+		return nil, nil
+	}
+	return as_of, nil
+}
+
+func handleGS1Codes(w http.ResponseWriter, req *http.Request) {
+	code := strings.Trim(strings.TrimPrefix(req.URL.Path, "/gs1codes"), "/")
+	query := req.URL.Query()
+	as_of, err := parseAsOf(query)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	if len(code) > 0 {
+		var gs1_code_event *ONSGS1CodeEvent
+		if as_of != nil {
+			gs1_code_event, err = DBGetGS1CodeAsOf(code, as_of)
+		} else {
+			gs1_code_event, err = DBGetGS1Code(code)
+		}
+		if _, ok := err.(*unknownVersionTimeError); ok == true {
+			writeAPIError(w, http.StatusUnprocessableEntity, "%v", err)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get GS1 code %s : %v\n", code, err)
+			writeAPIError(w, http.StatusInternalServerError, "failed to get GS1 code")
+			return
+		}
+		if gs1_code_event == nil {
+			writeAPIError(w, http.StatusNotFound, "GS1 code %s is not found", code)
+			return
+		}
+		writeJSON(w, http.StatusOK, NewAPIGS1Code(gs1_code_event))
+		return
+	}
+	if as_of != nil {
+		writeAPIError(w, http.StatusBadRequest, "as_of_block and as_of_time are supported only for a single GS1 code")
+		return
+	}
+
+	page, err := parsePage(query)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	filter := &GS1CodeFilter{
+		OwnerId:     query.Get("owner"),
+		Provider:    query.Get("provider"),
+		ServiceType: query.Get("service_type"),
+	}
+	if v := query.Get("state"); len(v) > 0 {
+		filter.State, err = parseGS1CodeState(v)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		filter.HasState = true
+	}
+
+	gs1_code_events, total, err := DBListGS1Codes(filter, page)
+	if err != nil {
+		log.Printf("Failed to list GS1 codes : %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to list GS1 codes")
+		return
+	}
+	items := []*APIGS1Code{}
+	for _, gs1_code_event := range gs1_code_events {
+		items = append(items, NewAPIGS1Code(gs1_code_event))
+	}
+	writeAPIList(w, req, page, items, len(items), total)
+}
+
+func handleServiceTypes(w http.ResponseWriter, req *http.Request) {
+	address := strings.Trim(strings.TrimPrefix(req.URL.Path, "/servicetypes"), "/")
+	if len(address) > 0 {
+		service_type_event, err := DBGetServiceType(address)
+		if err != nil {
+			log.Printf("Failed to get service type %s : %v\n", address, err)
+			writeAPIError(w, http.StatusInternalServerError, "failed to get service type")
+			return
+		}
+		if service_type_event == nil {
+			writeAPIError(w, http.StatusNotFound, "service type %s is not found", address)
+			return
+		}
+		writeJSON(w, http.StatusOK, NewAPIServiceType(service_type_event))
+		return
+	}
+
+	query := req.URL.Query()
+	page, err := parsePage(query)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	filter := &ServiceTypeFilter{
+		Provider: query.Get("provider"),
+	}
+	fields, err := parseFieldFilters(query)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	filter.Fields = fields
+
+	service_type_events, total, err := DBListServiceTypes(filter, page)
+	if err != nil {
+		log.Printf("Failed to list service types : %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to list service types")
+		return
+	}
+	items := []*APIServiceType{}
+	for _, service_type_event := range service_type_events {
+		items = append(items, NewAPIServiceType(service_type_event))
+	}
+	writeAPIList(w, req, page, items, len(items), total)
+}
+
+//fields.protocol.version=2.0 같은 parameter. 같은 path를 여러 번 쓰면 모두 맞아야 한다.
+func parseFieldFilters(query url.Values) ([]*FieldFilter, error) {
+	names := []string{}
+	for name := range query {
+		if strings.HasPrefix(name, SERVICE_TYPE_FIELD_PARAM) == true {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	fields := []*FieldFilter{}
+	for _, name := range names {
+		path, err := ParseFieldPath(strings.TrimPrefix(name, SERVICE_TYPE_FIELD_PARAM))
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range query[name] {
+			fields = append(fields, &FieldFilter{Path: path, Value: value})
+		}
+	}
+	return fields, nil
+}
+
+//reverse lookup. /owners/{key}/gs1codes, /providers/{key}/records, /managers/{key}/gs1codes
+func parseReverseLookupPath(req *http.Request, prefix string, resource string) (string, bool) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, prefix), "/")
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 || len(parts[0]) == 0 || parts[1] != resource {
+		return "", false
+	}
+	return parts[0], true
+}
+
+func writeAPIList(w http.ResponseWriter, req *http.Request, page Page, items interface{}, count int, total int) {
+	writeJSON(w, http.StatusOK, &APIList{
+		Items:  items,
+		Total:  total,
+		Offset: page.Offset,
+		Limit:  page.Limit,
+		Next:   nextPageURL(req, page, count, total),
+	})
+}
+
+func handleOwners(w http.ResponseWriter, req *http.Request) {
+	owner, ok := parseReverseLookupPath(req, "/owners", "gs1codes")
+	if ok == false {
+		writeAPIError(w, http.StatusNotFound, "%s is not found", req.URL.Path)
+		return
+	}
+	page, err := parsePage(req.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	gs1_code_events, total, err := DBListGS1Codes(&GS1CodeFilter{OwnerId: owner}, page)
+	if err != nil {
+		log.Printf("Failed to list GS1 codes of owner %s : %v\n", owner, err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to list GS1 codes")
+		return
+	}
+	items := []*APIGS1Code{}
+	for _, gs1_code_event := range gs1_code_events {
+		items = append(items, NewAPIGS1Code(gs1_code_event))
+	}
+	writeAPIList(w, req, page, items, len(items), total)
+}
+
+func handleProviders(w http.ResponseWriter, req *http.Request) {
+	provider, ok := parseReverseLookupPath(req, "/providers", "records")
+	if ok == false {
+		writeAPIError(w, http.StatusNotFound, "%s is not found", req.URL.Path)
+		return
+	}
+	page, err := parsePage(req.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	records, total, err := DBListRecordsByProvider(provider, page)
+	if err != nil {
+		log.Printf("Failed to list records of provider %s : %v\n", provider, err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to list records")
+		return
+	}
+	items := []*APIProviderRecord{}
+	for _, record := range records {
+		items = append(items, &APIProviderRecord{
+			Gs1Code:      record.Gs1Code,
+			Gs1CodeState: record.Gs1State.String(),
+			APIRecord:    *newAPIRecord(record.RecordIndex, record.Record),
+			BlockNum:     record.BlockNum,
+		})
+	}
+	writeAPIList(w, req, page, items, len(items), total)
+}
+
+//GET /managers?kind=su|gs1&gs1_code=
+func handleManagerList(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	page, err := parsePage(query)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	filter := &ManagerFilter{
+		Kind:    query.Get("kind"),
+		Gs1Code: query.Get("gs1_code"),
+	}
+	if len(filter.Kind) > 0 && filter.Kind != MANAGER_KIND_SU && filter.Kind != MANAGER_KIND_GS1 {
+		writeAPIError(w, http.StatusBadRequest, "invalid kind %q (su or gs1)", filter.Kind)
+		return
+	}
+
+	managers, total, err := DBListManagers(filter, page)
+	if err != nil {
+		log.Printf("Failed to list managers : %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to list managers")
+		return
+	}
+	items := []*APIManager{}
+	for _, manager := range managers {
+		items = append(items, &APIManager{
+			Kind:            manager.Kind,
+			Address:         manager.Address,
+			Gs1Code:         manager.Gs1Code,
+			GrantedBlockNum: manager.GrantedBlockNum,
+			BlockNum:        manager.BlockNum,
+		})
+	}
+	writeAPIList(w, req, page, items, len(items), total)
+}
+
+func handleManagers(w http.ResponseWriter, req *http.Request) {
+	address, ok := parseReverseLookupPath(req, "/managers", "gs1codes")
+	if ok == false {
+		writeAPIError(w, http.StatusNotFound, "%s is not found", req.URL.Path)
+		return
+	}
+	page, err := parsePage(req.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	managed, total, err := DBListManagedGS1Codes(address, page)
+	if err != nil {
+		log.Printf("Failed to list GS1 codes of manager %s : %v\n", address, err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to list GS1 codes")
+		return
+	}
+	items := []*APIManagedGS1Code{}
+	for _, m := range managed {
+		item := &APIManagedGS1Code{
+			Gs1Code:         m.Manager.Gs1Code,
+			Manager:         m.Manager.Address,
+			BlockNum:        m.Manager.BlockNum,
+			GrantedBlockNum: m.Manager.GrantedBlockNum,
+		}
+		if m.Gs1CodeData != nil {
+			item.Registered = true
+			item.State = m.Gs1CodeData.State.String()
+			item.OwnerId = m.Gs1CodeData.OwnerId
+		}
+		items = append(items, item)
+	}
+	writeAPIList(w, req, page, items, len(items), total)
+}
+
+func handleOpenAPI(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write([]byte(openAPISpec))
+}
+
+//GET 이외의 method는 허용하지 않는다.
+func getOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method %s is not allowed", req.Method)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+func NewAPIServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gs1codes", getOnly(handleGS1Codes))
+	mux.HandleFunc("/gs1codes/", getOnly(handleGS1Codes))
+	mux.HandleFunc("/servicetypes", getOnly(handleServiceTypes))
+	mux.HandleFunc("/servicetypes/", getOnly(handleServiceTypes))
+	mux.HandleFunc("/owners/", getOnly(handleOwners))
+	mux.HandleFunc("/providers/", getOnly(handleProviders))
+	mux.HandleFunc("/managers", getOnly(handleManagerList))
+	mux.HandleFunc("/managers/", getOnly(handleManagers))
+	mux.HandleFunc("/changes", getOnly(handleChanges))
+	mux.HandleFunc("/openapi.yaml", getOnly(handleOpenAPI))
+	if len(g_webhook_token) > 0 {
+		mux.HandleFunc("/webhooks", requireWebhookToken(handleWebhooks))
+		mux.HandleFunc("/webhooks/", requireWebhookToken(handleWebhooks))
+	}
+	return mux
+}
+
+//synchronized database를 조회하는 JSON query API를 제공한다.
+func StartAPIListener(addr string) {
+	go func() {
+		log.Printf("Serving query API on %s\n", addr)
+		err := http.ListenAndServe(addr, NewAPIServeMux())
+		if err != nil {
+			log.Printf("Query API listener stopped : %v\n", err)
+		}
+	}()
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const statePageLimit = 1000
+
+//fork가 undo data보다 깊어서 block을 적용할 수 없다. snapshot으로 다시 동기화해야 한다.
+var errSnapshotRequired = errors.New("snapshot is required")
+
+//transaction을 지원하지 않는 store(rethinkdb)는 snapshot을 적용하는 중에 중단되면 되돌릴 수 없다.
+var errSnapshotNotAtomic = errors.New("the store can't apply a state snapshot in one transaction, bootstrap, resync and repair need sqlite or postgres")
+
+type restBlock struct {
+	Header struct {
+		BlockNum        string `json:"block_num"`
+		PreviousBlockId string `json:"previous_block_id"`
+	} `json:"header"`
+	HeaderSignature string `json:"header_signature"`
+}
+
+type restStateList struct {
+	Data []struct {
+		Address string `json:"address"`
+		Data    string `json:"data"`
+	} `json:"data"`
+	Head   string `json:"head"`
+	Paging struct {
+		NextPosition string `json:"next_position"`
+	} `json:"paging"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Title   string `json:"title"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func restGet(rest_addr string, path string, query url.Values, v interface{}) error {
+	get_url := restURL(rest_addr, path)
+	if len(query) > 0 {
+		get_url += "?" + query.Encode()
+	}
+	resp, err := restRequest(get_url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s : %s : %s", get_url, resp.Status, string(data))
+	}
+	return json.Unmarshal(data, v)
+}
+
+//REST API에서 chain head block의 정보를 읽는다.
+func getChainHeadBlock(rest_addr string) (*BlockInfo, error) {
+	var blocks struct {
+		Data []restBlock `json:"data"`
+	}
+	err := restGet(rest_addr, "/blocks", url.Values{"limit": {"1"}}, &blocks)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks.Data) == 0 {
+		return nil, errors.New("chain has no block")
+	}
+
+	block_num, err := strconv.ParseFloat(blocks.Data[0].Header.BlockNum, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockInfo{
+		BlockNum:        block_num,
+		BlockId:         blocks.Data[0].HeaderSignature,
+		PreviousBlockId: blocks.Data[0].Header.PreviousBlockId,
+	}, nil
+}
+
+//head block에서 ONS namespace의 state 전체를 page 단위로 읽는다.
+func getStateSnapshot(rest_addr string, head_block_id string, verbose bool) (map[string][]byte, error) {
+	snapshot := make(map[string][]byte)
+	start := ""
+	for {
+		query := url.Values{
+			"address": {namespace},
+			"head":    {head_block_id},
+			"limit":   {strconv.Itoa(statePageLimit)},
+		}
+		if len(start) > 0 {
+			query.Set("start", start)
+		}
+
+		var states restStateList
+		err := restGet(rest_addr, "/state", query, &states)
+		if err != nil {
+			return nil, err
+		}
+		if states.Error != nil {
+			return nil, fmt.Errorf("%s : %s (error code = %d)", states.Error.Title, states.Error.Message, states.Error.Code)
+		}
+
+		for _, state := range states.Data {
+			value, err := base64.StdEncoding.DecodeString(state.Data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid state data of %s : %v", state.Address, err)
+			}
+			snapshot[state.Address] = value
+		}
+		if verbose == true {
+			log.Printf("read %d states (total %d)\n", len(states.Data), len(snapshot))
+		}
+
+		if len(states.Paging.NextPosition) == 0 {
+			return snapshot, nil
+		}
+		start = states.Paging.NextPosition
+	}
+}
+
+//chain head의 state snapshot으로 database를 맞춘 후에 head block부터 event를 적용한다.
+//snapshot과 다른 address만 바꾸므로 비어 있는 database와 오래된 database 모두 사용할 수 있다.
+func Bootstrap(rest_addr string, verbose bool) error {
+	g_chain_mutex.Lock()
+	defer g_chain_mutex.Unlock()
+	return bootstrap(rest_addr, verbose)
+}
+
+func bootstrap(rest_addr string, verbose bool) error {
+	//snapshot을 읽기 전에 확인한다.
+	if DBAtomic() == false {
+		return errSnapshotNotAtomic
+	}
+	head, err := getChainHeadBlock(rest_addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("bootstrap from block %v(%s)\n", head.BlockNum, head.BlockId)
+
+	snapshot, err := getStateSnapshot(rest_addr, head.BlockId, verbose)
+	if err != nil {
+		return err
+	}
+
+	//snapshot 전체를 하나의 transaction으로 적용한다.
+	start := time.Now()
+	tx, err := DBBeginSnapshot()
+	if err != nil {
+		return err
+	}
+	changes, err := applySnapshot(tx, head, snapshot, verbose)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = commitCursor(tx, head, nil)
+	if err != nil {
+		return err
+	}
+	ObserveDBWrite(DB_WRITE_BOOTSTRAP, start)
+	g_pending_blocks = make(map[string][]*ONSEvent)
+
+	deleted := 0
+	for _, change := range changes {
+		if change.Value == nil {
+			deleted++
+		}
+	}
+	log.Printf("bootstrap is done at block %v : %d states, %d changed, %d deleted\n", head.BlockNum, len(snapshot), len(changes)-deleted, deleted)
+	ObserveSyncedBlock(head.BlockNum, head.BlockId)
+	//건너뛴 block의 change를 알 수 없으므로 snapshot으로 바뀐 address를 head block의 change로 알린다.
+	NotifyStateChanges(head, changes, false)
+	return nil
+}
+
+//snapshot과 다른 address를 바꾸고 snapshot에 없는 address를 삭제한다. 바꾸거나 삭제한 address의 change를 반환한다.
+func applySnapshot(tx StoreTx, head *BlockInfo, snapshot map[string][]byte, verbose bool) ([]*stateChange, error) {
+	addresses := make([]string, 0, len(snapshot))
+	for address := range snapshot {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	changes := []*stateChange{}
+	for _, address := range addresses {
+		prev, err := tx.GetStateValue(address)
+		if err != nil {
+			return nil, err
+		}
+		if prev.Value != nil && bytes.Equal(prev.Value, snapshot[address]) {
+			continue
+		}
+		err = applyStateValue(tx, address, snapshot[address], head.BlockNum, true, verbose)
+		if err == nil {
+			err = tx.SetStateValue(&StateValue{Address: address, Value: snapshot[address], BlockNum: head.BlockNum})
+		}
+		if err == nil {
+			err = addGS1CodeVersion(tx, address, prev.Value, snapshot[address], head, 0)
+		}
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &stateChange{Address: address, Previous: prev.Value, Value: snapshot[address]})
+	}
+
+	//snapshot에 없는 address는 삭제되었다.
+	stored, err := tx.StateAddresses()
+	if err != nil {
+		return nil, err
+	}
+	for _, address := range stored {
+		if _, ok := snapshot[address]; ok {
+			continue
+		}
+		prev, err := tx.GetStateValue(address)
+		if err != nil {
+			return nil, err
+		}
+		err = applyStateValue(tx, address, nil, head.BlockNum, true, verbose)
+		if err == nil {
+			err = tx.SetStateValue(&StateValue{Address: address})
+		}
+		if err == nil {
+			err = addGS1CodeVersion(tx, address, prev.Value, nil, head, 0)
+		}
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &stateChange{Address: address, Previous: prev.Value})
+	}
+
+	//snapshot 이전의 block은 chain이 이어지지 않으므로 모두 삭제한다.
+	//snapshot block은 undo data가 없으므로 이보다 깊은 fork는 다시 bootstrap 한다.
+	err = tx.PruneBlocks(math.Inf(1))
+	if err != nil {
+		return nil, err
+	}
+	err = tx.AddBlock(&BlockRecord{BlockInfo: *head, Undo: []*StateValue{}})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+//database가 비어 있거나 chain head보다 max_gap block 이상 뒤처져 있으면 bootstrap 한다.
+//resync이면 database를 모두 지우고 bootstrap 한다.
+//snapshot을 적용할 수 없는 store이면 resync는 실패하고, 그 외에는 bootstrap 하지 않고 block을 하나씩 적용한다.
+func BootstrapIfNeeded(rest_addr string, resync bool, max_gap float64, verbose bool) error {
+	if resync == true {
+		//database를 지우기 전에 확인한다.
+		if DBAtomic() == false {
+			return errSnapshotNotAtomic
+		}
+		log.Printf("resync : all synchronized data will be deleted\n")
+		err := DBClear()
+		if err != nil {
+			return err
+		}
+		_, err = DBInitLatestUpdatedBlockInfo(verbose)
+		if err != nil {
+			return err
+		}
+		return Bootstrap(rest_addr, verbose)
+	}
+
+	if DBAtomic() == false {
+		log.Printf("the store can't bootstrap, synchronize block by block from the last block of the database\n")
+		return nil
+	}
+
+	latest_block_num, latest_block_id := DBGetLatestUpdatedBlock()
+	if latest_block_id == "0000000000000000" {
+		log.Printf("database is empty\n")
+		return Bootstrap(rest_addr, verbose)
+	}
+
+	if max_gap < 0 {
+		return nil
+	}
+	head, err := getChainHeadBlock(rest_addr)
+	if err != nil {
+		return err
+	}
+	if head.BlockNum-latest_block_num > max_gap {
+		log.Printf("database is %v blocks behind the chain head\n", head.BlockNum-latest_block_num)
+		return Bootstrap(rest_addr, verbose)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBootstrap(t *testing.T) {
+	openTestStore(t)
+	_, head := DBGetLatestUpdatedBlock()
+	syncTestBlock(t, 1, "b1", head,
+		gs1CodeChange(t, newTestGS1Code("1", "owner-1")), gs1CodeChange(t, newTestGS1Code("2", "owner-1")))
+
+	//block 1 이후의 block을 건너뛰고 block 10의 snapshot으로 맞춘다.
+	rest := startTestRESTServer(t)
+	rest.SetHead(&BlockInfo{BlockNum: 10, BlockId: "b10", PreviousBlockId: "b9"}, map[string][]byte{
+		gs1CodeAddress("1"): gs1CodeState(t, newTestGS1Code("1", "owner-2")),
+		gs1CodeAddress("3"): gs1CodeState(t, newTestGS1Code("3", "owner-3")),
+	})
+	err := BootstrapIfNeeded(rest.Addr(), false, 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for gs1_code, expected := range map[string]string{"1": "owner-2", "2": "", "3": "owner-3"} {
+		if owner := testGS1CodeOwner(t, gs1_code); owner != expected {
+			t.Errorf("GS1 code %s owner is %q after bootstrap, want %q", gs1_code, owner, expected)
+		}
+	}
+	if block_num, block_id := DBGetLatestUpdatedBlock(); block_num != 10 || block_id != "b10" {
+		t.Errorf("cursor is %v(%s), want 10(b10)", block_num, block_id)
+	}
+	//snapshot 이전의 block은 되돌릴 수 없다.
+	if block, err := DBGetBlock("b1"); err != nil || block != nil {
+		t.Errorf("block 1 is %+v, %v after bootstrap", block, err)
+	}
+
+	//snapshot block의 child부터 이어서 적용한다.
+	syncTestBlock(t, 11, "b11", "b10", gs1CodeChange(t, newTestGS1Code("2", "owner-11")))
+	if owner := testGS1CodeOwner(t, "2"); owner != "owner-11" {
+		t.Errorf("GS1 code 2 owner is %q after block 11, want owner-11", owner)
+	}
+}
+
+//max_gap보다 가까우면 bootstrap 하지 않는다.
+func TestBootstrapIfNeededWithinGap(t *testing.T) {
+	openTestStore(t)
+	_, head := DBGetLatestUpdatedBlock()
+	syncTestBlock(t, 1, "b1", head, gs1CodeChange(t, newTestGS1Code("1", "owner-1")))
+
+	rest := startTestRESTServer(t)
+	rest.SetHead(&BlockInfo{BlockNum: 3, BlockId: "b3", PreviousBlockId: "b2"}, map[string][]byte{})
+	err := BootstrapIfNeeded(rest.Addr(), false, 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block_num, block_id := DBGetLatestUpdatedBlock(); block_num != 1 || block_id != "b1" {
+		t.Errorf("cursor is %v(%s), want 1(b1)", block_num, block_id)
+	}
+}
+
+func TestBootstrapNotifiesChanges(t *testing.T) {
+	openTestStore(t)
+	_, head := DBGetLatestUpdatedBlock()
+	syncTestBlock(t, 1, "b1", head,
+		gs1CodeChange(t, newTestGS1Code("1", "owner-1")), gs1CodeChange(t, newTestGS1Code("2", "owner-1")))
+	f := StartChangeFeed(100)
+	t.Cleanup(StopChangeFeed)
+
+	//block 1 이후의 block을 건너뛰고 block 10의 snapshot으로 맞춘다.
+	rest := startTestRESTServer(t)
+	rest.SetHead(&BlockInfo{BlockNum: 10, BlockId: "b10", PreviousBlockId: "b9"}, map[string][]byte{
+		gs1CodeAddress("1"): gs1CodeState(t, newTestGS1Code("1", "owner-2")),
+		gs1CodeAddress("3"): gs1CodeState(t, newTestGS1Code("3", "owner-3")),
+	})
+	err := Bootstrap(rest.Addr(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, replay, reset := f.subscribe("", true, 1)
+	if reset == true {
+		t.Fatalf("resume from block 1 is reset")
+	}
+	kinds := map[string]string{}
+	for _, event := range replay {
+		if event.BlockNum != 10 || event.Change.BlockId != "b10" {
+			t.Errorf("change %+v of block %v, want block 10", event.Change, event.BlockNum)
+		}
+		kinds[event.Change.Gs1Code] = event.Change.Kind
+	}
+	want := map[string]string{"1": WEBHOOK_GS1_CODE_UPDATED, "2": WEBHOOK_GS1_CODE_DELETED, "3": WEBHOOK_GS1_CODE_UPDATED}
+	if len(kinds) != len(want) {
+		t.Fatalf("changes %v, want %v", kinds, want)
+	}
+	for gs1_code, kind := range want {
+		if kinds[gs1_code] != kind {
+			t.Errorf("GS1 code %s change is %q, want %q", gs1_code, kinds[gs1_code], kind)
+		}
+	}
+}
+
+//rethinkdb와 같이 write를 바로 적용하는 store
+type nonAtomicStore struct {
+	Store
+}
+
+func (s *nonAtomicStore) Atomic() bool {
+	return false
+}
+
+//snapshot을 적용할 수 없는 store는 resync, repair를 거부하고 bootstrap 하지 않는다.
+func TestNonAtomicStoreRefusesSnapshot(t *testing.T) {
+	openTestStore(t)
+	_, head := DBGetLatestUpdatedBlock()
+	syncTestBlock(t, 1, "b1", head, gs1CodeChange(t, newTestGS1Code("1", "owner-1")))
+	g_store = &nonAtomicStore{Store: g_store}
+
+	rest := startTestRESTServer(t)
+	rest.SetHead(&BlockInfo{BlockNum: 10, BlockId: "b10", PreviousBlockId: "b9"}, map[string][]byte{
+		gs1CodeAddress("1"): gs1CodeState(t, newTestGS1Code("1", "owner-2")),
+	})
+	if err := BootstrapIfNeeded(rest.Addr(), true, 5, false); err != errSnapshotNotAtomic {
+		t.Errorf("resync : %v, want %v", err, errSnapshotNotAtomic)
+	}
+	if owner := testGS1CodeOwner(t, "1"); owner != "owner-1" {
+		t.Errorf("GS1 code 1 owner is %q after the refused resync, want owner-1", owner)
+	}
+
+	if err := BootstrapIfNeeded(rest.Addr(), false, 5, false); err != nil {
+		t.Fatal(err)
+	}
+	if block_num, block_id := DBGetLatestUpdatedBlock(); block_num != 1 || block_id != "b1" {
+		t.Errorf("cursor is %v(%s), want 1(b1)", block_num, block_id)
+	}
+
+	if err := Bootstrap(rest.Addr(), false); err != errSnapshotNotAtomic {
+		t.Errorf("bootstrap : %v, want %v", err, errSnapshotNotAtomic)
+	}
+	if _, err := Verify(rest.Addr(), true, false); err != errSnapshotNotAtomic {
+		t.Errorf("repair : %v, want %v", err, errSnapshotNotAtomic)
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"encoding/base64"
+	"log"
+	"protobuf/ons_pb2"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+//fork가 발생했을 때 되돌릴 수 있는 block의 개수. 이보다 깊은 fork는 resync가 필요하다.
+const MAX_UNDO_BLOCKS = 1000
+
+//address의 state value. Value가 nil이면 address가 없다는 의미이다.
+type StateValue struct {
+	Address  string
+	Value    []byte
+	BlockNum float64
+}
+
+//적용한 block과 block을 되돌리기 위한 undo data.
+//Undo는 block에서 바뀐 address의 이전 state value이며 적용한 순서로 저장한다.
+//Pending은 적용하거나 되돌리는 중인 block이다. transaction을 지원하지 않는 store(RethinkDB)에서
+//중단되면 recoverPendingBlocks가 block을 끝까지 적용했는지 cursor로 판단해서 정리한다.
+type BlockRecord struct {
+	BlockInfo
+	Undo    []*StateValue
+	Pending bool
+}
+
+var g_chain_mutex = &sync.Mutex{}
+
+//pending block을 정리해야 하는지. 시작할 때와 block을 적용하거나 되돌리다 실패했을 때 설정한다.
+var g_recover_pending = true
+
+//parent block을 기다리는 block. key는 previous block id이다.
+var g_pending_blocks = make(map[string][]*ONSEvent)
+
+//block id의 chain을 따라 block을 적용한다.
+//parent가 현재 head가 아니면 fork이므로 parent까지 rollback 한 후에 적용하고,
+//parent를 모르면 parent block의 delta를 요청하고 parent가 적용될 때까지 기다린다.
+func SyncBlock(h EventSource, onsEvent *ONSEvent, verbose bool) error {
+	g_chain_mutex.Lock()
+	defer g_chain_mutex.Unlock()
+	return syncBlock(h, onsEvent, verbose)
+}
+
+func syncBlock(h EventSource, onsEvent *ONSEvent, verbose bool) error {
+	if g_recover_pending == true {
+		err := recoverPendingBlocks(verbose)
+		if err != nil {
+			return err
+		}
+		g_recover_pending = false
+	}
+
+	known, err := DBGetBlock(onsEvent.BlockId)
+	if err != nil {
+		return err
+	}
+	if known != nil {
+		if verbose == true {
+			log.Printf("skip block %v(%s) because it is already applied\n", onsEvent.BlockNum, onsEvent.BlockId)
+		}
+		return nil
+	}
+
+	_, head_block_id := DBGetLatestUpdatedBlock()
+	if onsEvent.PreviousBlockId != head_block_id {
+		parent, err := DBGetBlock(onsEvent.PreviousBlockId)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			//parent가 가장 오래된 block보다 이전이면 undo data로 되돌릴 수 없다.
+			oldest_block_num, err := DBOldestBlockNum()
+			if err != nil {
+				return err
+			}
+			if oldest_block_num >= 0 && onsEvent.BlockNum-1 < oldest_block_num {
+				log.Printf("block %v(%s) is on a fork older than block %v\n", onsEvent.BlockNum, onsEvent.BlockId, oldest_block_num)
+				return errSnapshotRequired
+			}
+			_, requested := g_pending_blocks[onsEvent.PreviousBlockId]
+			g_pending_blocks[onsEvent.PreviousBlockId] = append(g_pending_blocks[onsEvent.PreviousBlockId], onsEvent)
+			log.Printf("Call previous block info\n - current block id : %s\n - previous block id : %s\n", onsEvent.BlockId, onsEvent.PreviousBlockId)
+			if requested == false && h != nil {
+				h.GetBlockDeltas(onsEvent.PreviousBlockId)
+			}
+			return nil
+		}
+
+		log.Printf("fork : block %v(%s) is not a child of head %s\n", onsEvent.BlockNum, onsEvent.BlockId, head_block_id)
+		err = rollbackTo(parent, verbose)
+		if err != nil {
+			g_recover_pending = true
+			return err
+		}
+	}
+
+	err = applyBlock(onsEvent, verbose)
+	if err != nil {
+		g_recover_pending = true
+		return err
+	}
+
+	children := g_pending_blocks[onsEvent.BlockId]
+	delete(g_pending_blocks, onsEvent.BlockId)
+	for _, child := range children {
+		err = syncBlock(h, child, verbose)
+		if err != nil {
+			return err
+		}
+	}
+
+	//버려진 fork의 block은 parent가 오지 않으므로 오래되면 삭제한다.
+	for previous_block_id, pending := range g_pending_blocks {
+		if pending[0].BlockNum+MAX_UNDO_BLOCKS < onsEvent.BlockNum {
+			delete(g_pending_blocks, previous_block_id)
+		}
+	}
+	return nil
+}
+
+//block의 state change를 적용하고 이전 state value를 undo data로 저장한다.
+//block 하나는 하나의 transaction으로 적용하고 commit 한 후에 cursor(head)를 바꾼다.
+//transaction을 지원하지 않는 store에서도 되돌릴 수 있도록 write 전에 undo data를 pending block으로 저장하고,
+//cursor를 바꾼 후에 pending을 지운다.
+func applyBlock(onsEvent *ONSEvent, verbose bool) error {
+	block := &BlockRecord{
+		BlockInfo: BlockInfo{
+			BlockNum:        onsEvent.BlockNum,
+			BlockId:         onsEvent.BlockId,
+			PreviousBlockId: onsEvent.PreviousBlockId,
+		},
+		Undo:    []*StateValue{},
+		Pending: true,
+	}
+	values := [][]byte{}
+
+	start := time.Now()
+	tx, err := DBBegin()
+	if err != nil {
+		return err
+	}
+
+	//block 안에서 같은 address가 다시 바뀌면 앞의 change가 이전 state value이다.
+	applied := map[string]*StateValue{}
+	for _, state := range onsEvent.StateChanges {
+		event_type, ok := state["type"]
+
+		if ok == false {
+			log.Printf("event: NONE, block num : %v, block id : %v\n", onsEvent.BlockNum, onsEvent.BlockId)
+			continue
+		}
+
+		var value []byte
+		if event_type == "DELETE" {
+			log.Printf("event: DELETE, block num : %v, block id : %v\n", onsEvent.BlockNum, onsEvent.BlockId)
+		} else {
+			log.Printf("event: SET, block num : %v, block id : %v\n", onsEvent.BlockNum, onsEvent.BlockId)
+			value, err = base64.StdEncoding.DecodeString(state["value"])
+			if err != nil {
+				log.Printf("Fail to base64 decoding in UpdateOnsEvent : %v\n", err)
+				ObserveDecodeFailure(state["address"])
+				continue
+			}
+		}
+
+		address := state["address"]
+		prev, ok := applied[address]
+		if ok == false {
+			prev, err = tx.GetStateValue(address)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		block.Undo = append(block.Undo, prev)
+		values = append(values, value)
+		applied[address] = &StateValue{Address: address, Value: value, BlockNum: onsEvent.BlockNum}
+	}
+
+	err = tx.AddBlock(block)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	changes := []*stateChange{}
+	for idx, prev := range block.Undo {
+		address, value := prev.Address, values[idx]
+		changes = append(changes, &stateChange{Address: address, Previous: prev.Value, Value: value})
+
+		err = applyStateValue(tx, address, value, onsEvent.BlockNum, false, verbose)
+		if err == nil {
+			err = tx.SetStateValue(&StateValue{Address: address, Value: value, BlockNum: onsEvent.BlockNum})
+		}
+		if err == nil {
+			err = addGS1CodeVersion(tx, address, prev.Value, value, &block.BlockInfo, time.Now().Unix())
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = tx.PruneBlocks(block.BlockNum - MAX_UNDO_BLOCKS)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	block.Pending = false
+	err = commitCursor(tx, &block.BlockInfo, block)
+	if err != nil {
+		return err
+	}
+	ObserveDBWrite(DB_WRITE_APPLY_BLOCK, start)
+	ObserveBlockApplied(changes)
+
+	ObserveSyncedBlock(onsEvent.BlockNum, onsEvent.BlockId)
+	NotifyStateChanges(&block.BlockInfo, changes, false)
+	return nil
+}
+
+//cursor(마지막으로 적용한 block)를 저장하고 transaction을 commit 한다.
+//commit에 실패하면 cursor가 바뀌지 않으므로 block을 다시 적용할 수 있다.
+//applied가 nil이 아니면 cursor를 바꾼 후에 applied block을 저장한다. (pending을 지운다)
+func commitCursor(tx StoreTx, block *BlockInfo, applied *BlockRecord) error {
+	err := tx.SetLastBlock(block)
+	if err == nil && applied != nil {
+		err = tx.AddBlock(applied)
+	}
+	if err != nil {
+		log.Printf("Failed to update latest updated block info : %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	setLatestUpdatedBlock(block.BlockNum, block.BlockId)
+	return nil
+}
+
+//head에서 fork_point까지 block을 역순으로 되돌린다. block 하나씩 transaction으로 되돌린다.
+//block을 pending으로 바꾸고 cursor를 parent로 옮긴 후에 되돌리므로 중단되면 recoverPendingBlocks가 마저 되돌린다.
+func rollbackTo(fork_point *BlockRecord, verbose bool) error {
+	_, head_block_id := DBGetLatestUpdatedBlock()
+	for head_block_id != fork_point.BlockId {
+		block, err := DBGetBlock(head_block_id)
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			log.Printf("block %s has no undo data, the fork is deeper than %d blocks\n", head_block_id, MAX_UNDO_BLOCKS)
+			return errSnapshotRequired
+		}
+
+		log.Printf("rollback block %v(%s)\n", block.BlockNum, block.BlockId)
+		start := time.Now()
+		tx, err := DBBegin()
+		if err != nil {
+			return err
+		}
+
+		parent, err := tx.GetBlock(block.PreviousBlockId)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		parent_info := &BlockInfo{BlockNum: block.BlockNum - 1, BlockId: block.PreviousBlockId}
+		if parent != nil {
+			parent_info = &parent.BlockInfo
+		}
+
+		block.Pending = true
+		err = tx.AddBlock(block)
+		if err == nil {
+			err = tx.SetLastBlock(parent_info)
+		}
+		var changes []*stateChange
+		if err == nil {
+			changes, err = undoBlock(tx, block, verbose)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		setLatestUpdatedBlock(parent_info.BlockNum, parent_info.BlockId)
+
+		ObserveDBWrite(DB_WRITE_ROLLBACK_BLOCK, start)
+		ObserveBlockRolledBack()
+		//last block gauge도 parent로 내린다.
+		ObserveSyncedBlock(parent_info.BlockNum, parent_info.BlockId)
+		NotifyStateChanges(&block.BlockInfo, changes, true)
+		head_block_id = block.PreviousBlockId
+	}
+	return nil
+}
+
+//block의 undo data를 역순으로 적용하고 block과 block의 GS1 code version을 삭제한다.
+//이미 되돌린 address를 다시 되돌려도 결과가 같다. 되돌린 state change를 반환한다.
+func undoBlock(tx StoreTx, block *BlockRecord, verbose bool) ([]*stateChange, error) {
+	changes := []*stateChange{}
+	for idx := len(block.Undo) - 1; idx >= 0; idx-- {
+		prev := block.Undo[idx]
+		current, err := tx.GetStateValue(prev.Address)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &stateChange{Address: prev.Address, Previous: current.Value, Value: prev.Value})
+
+		err = applyStateValue(tx, prev.Address, prev.Value, prev.BlockNum, true, verbose)
+		if err == nil {
+			err = tx.SetStateValue(prev)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := tx.DeleteGS1CodeVersions(block.BlockId)
+	if err == nil {
+		err = tx.DeleteBlock(block.BlockId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+//적용하거나 되돌리는 중에 중단된 block을 정리한다.
+//cursor가 block이면 적용을 마친 block이므로 pending만 지우고, 아니면 undo data로 되돌린다.
+func recoverPendingBlocks(verbose bool) error {
+	blocks, err := DBPendingBlocks()
+	if err != nil {
+		return err
+	}
+	_, head_block_id := DBGetLatestUpdatedBlock()
+	for _, block := range blocks {
+		tx, err := DBBegin()
+		if err != nil {
+			return err
+		}
+		if block.BlockId == head_block_id {
+			log.Printf("block %v(%s) was applied before the interruption\n", block.BlockNum, block.BlockId)
+			block.Pending = false
+			err = tx.AddBlock(block)
+		} else {
+			log.Printf("undo block %v(%s) interrupted while being applied or rolled back\n", block.BlockNum, block.BlockId)
+			_, err = undoBlock(tx, block, verbose)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//address의 state value를 table에 반영한다. value가 nil이면 삭제한다.
+//force이면 저장된 item의 block number와 관계없이 value로 바꾼다. (rollback)
+//value를 decode 할 수 없으면 log만 남기고 무시한다.
+func applyStateValue(store Store, address string, value []byte, block_num float64, force bool, verbose bool) error {
+	table_idx := GetTableIdxByAddress(address)
+
+	//managers는 권한을 받은 block을 유지해야 하므로 지우지 않고 UpdateManagers의 force로 바꾼다.
+	if value == nil || (force == true && table_idx != MANAGER_TABLE) {
+		var err error
+		if table_idx == MANAGER_TABLE {
+			err = store.DeleteManagers()
+		} else {
+			err = store.DeleteAddress(address)
+		}
+		if err != nil {
+			log.Printf("Fail to delete %s : %v\n", address, err)
+			return err
+		}
+		if value == nil {
+			return nil
+		}
+	}
+
+	if table_idx == GS1_CODE_TABLE {
+		log.Printf("Update gs1 code\n")
+		gs1_code_event := &ONSGS1CodeEvent{}
+		gs1_code_event.Address = address
+		gs1_code_event.BlockNum = block_num
+		err := proto.Unmarshal(value, &gs1_code_event.GS1CodeData)
+		if err != nil {
+			log.Printf("Fail to unmarshal proto buffer binary data in UpdateOnsEvent : %v\n", err)
+			ObserveDecodeFailure(address)
+			return nil
+		}
+		if verbose == true {
+			log.Printf("unmarshaled state value = %v\n", gs1_code_event)
+		}
+		return store.UpsertGS1Code(gs1_code_event)
+	} else if table_idx == SERVICE_TYPE_TABLE {
+		log.Printf("Update service type\n")
+		service_type_event := &ONSServiceTypeEvent{}
+		service_type_event.BlockNum = block_num
+		err := proto.Unmarshal(value, &service_type_event.ServiceType)
+		if err != nil {
+			log.Printf("Fail to unmarshal proto buffer binary data in UpdateOnsEvent : %v\n", err)
+			ObserveDecodeFailure(address)
+			return nil
+		}
+		//JSON field를 decode 하지 못해도 나머지 field와 함께 저장한다.
+		service_type_event.Document, err = NewServiceTypeDocument(&service_type_event.ServiceType)
+		if err != nil {
+			log.Printf("Fail to decode service type %s : %v\n", address, err)
+			ObserveDecodeFailure(address)
+		}
+		if verbose == true {
+			log.Printf("unmarshaled state value = %v\n", service_type_event)
+		}
+		return store.UpsertServiceType(service_type_event)
+	} else if table_idx == MANAGER_TABLE {
+		log.Printf("Update managers\n")
+		ons_manager := &ons_pb2.ONSManager{}
+		err := proto.Unmarshal(value, ons_manager)
+		if err != nil {
+			log.Printf("Fail to unmarshal proto buffer binary data in UpdateOnsEvent : %v\n", err)
+			ObserveDecodeFailure(address)
+			return nil
+		}
+		if verbose == true {
+			log.Printf("unmarshaled state value = %v\n", ons_manager)
+		}
+		return store.UpdateManagers(ons_manager, block_num, force)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//GET /changes의 Server-Sent Events. data는 webhook과 같은 ONSChange JSON이다.
+//SSE id는 block number이고 client가 Last-Event-ID로 다시 연결하면 그 block 이후의 change를 다시 보낸다.
+const (
+	CHANGE_FEED_BUFFER_SIZE     = 10000
+	CHANGE_FEED_SUBSCRIBER_SIZE = 256
+	CHANGE_FEED_KEEPALIVE       = 15 * time.Second
+	//client가 다시 연결할 때까지 기다리는 시간 (ms)
+	CHANGE_FEED_RETRY = 3000
+	//client가 요청한 block 이후의 change를 모두 다시 보낼 수 없을 때 보내는 event. client는 cache를 모두 지워야 한다.
+	CHANGE_FEED_RESET = "reset"
+)
+
+//BlockNum은 change를 적용한 후의 block number이다. rollback이면 되돌린 block의 이전 block number이다.
+type feedEvent struct {
+	BlockNum float64
+	Change   *ONSChange
+	//block의 마지막 change. SSE id는 block의 마지막 change에만 붙여서 block 중간에 끊기면 block을 처음부터 다시 보낸다.
+	Last bool
+}
+
+type feedSubscriber struct {
+	gs1_prefix string
+	events     chan *feedEvent
+}
+
+//최근 change를 buffer에 저장하고 연결된 client에 보낸다.
+type ChangeFeed struct {
+	mutex  sync.Mutex
+	size   int
+	events []*feedEvent
+	//buffer의 첫 change 이전의 block number. 시작할 때의 마지막 block 또는 buffer에서 버린 change의 block이다.
+	base        float64
+	subscribers map[*feedSubscriber]bool
+	//StopChangeFeed 이후에는 client를 추가하지 않는다.
+	stopped bool
+}
+
+//event handler(NotifyStateChanges)와 API handler가 읽으므로 getChangeFeed로 읽는다.
+var g_change_feed_mutex = &sync.RWMutex{}
+var g_change_feed *ChangeFeed = nil
+
+func getChangeFeed() *ChangeFeed {
+	g_change_feed_mutex.RLock()
+	defer g_change_feed_mutex.RUnlock()
+	return g_change_feed
+}
+
+//bootstrap 이후, query API를 시작하기 전에 호출한다.
+func StartChangeFeed(size int) *ChangeFeed {
+	if size <= 0 {
+		size = CHANGE_FEED_BUFFER_SIZE
+	}
+	block_num, _ := DBGetLatestUpdatedBlock()
+	f := &ChangeFeed{
+		size:        size,
+		base:        block_num,
+		subscribers: map[*feedSubscriber]bool{},
+	}
+	g_change_feed_mutex.Lock()
+	g_change_feed = f
+	g_change_feed_mutex.Unlock()
+	return f
+}
+
+//연결된 client를 모두 끊는다.
+func StopChangeFeed() {
+	g_change_feed_mutex.Lock()
+	f := g_change_feed
+	g_change_feed = nil
+	g_change_feed_mutex.Unlock()
+	if f == nil {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.stopped = true
+	for subscriber := range f.subscribers {
+		close(subscriber.events)
+	}
+	f.subscribers = map[*feedSubscriber]bool{}
+}
+
+//commit 한 block의 change를 buffer에 추가하고 client에 보낸다.
+func (f *ChangeFeed) publish(block_num float64, changes []*ONSChange) {
+	if len(changes) == 0 {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for idx, change := range changes {
+		event := &feedEvent{BlockNum: block_num, Change: change, Last: idx == len(changes)-1}
+		f.events = append(f.events, event)
+		for subscriber := range f.subscribers {
+			select {
+			case subscriber.events <- event:
+			default:
+				//느린 client는 끊는다. client는 Last-Event-ID로 다시 연결해서 buffer에서 받는다.
+				log.Printf("change feed client is too slow, disconnect\n")
+				close(subscriber.events)
+				delete(f.subscribers, subscriber)
+			}
+		}
+	}
+	if len(f.events) > f.size {
+		drop := len(f.events) - f.size
+		f.base = f.events[drop-1].BlockNum
+		f.events = append([]*feedEvent(nil), f.events[drop:]...)
+	}
+}
+
+//client를 추가하고 다시 보낼 change를 반환한다. feed를 멈췄으면 nil을 반환한다.
+//last_block_num 이후의 change가 buffer에 모두 있지 않으면 reset이 true이다.
+func (f *ChangeFeed) subscribe(gs1_prefix string, resume bool, last_block_num float64) (*feedSubscriber, []*feedEvent, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.stopped == true {
+		return nil, nil, false
+	}
+
+	subscriber := &feedSubscriber{gs1_prefix: gs1_prefix, events: make(chan *feedEvent, CHANGE_FEED_SUBSCRIBER_SIZE)}
+	f.subscribers[subscriber] = true
+	if resume == false {
+		return subscriber, nil, false
+	}
+
+	//fork 이후 같은 block number가 다시 있을 수 있으므로 처음 나오는 block부터 보낸다. 이미 받은 change를 다시 보낼 수 있다.
+	for idx, event := range f.events {
+		if event.Last == true && event.BlockNum == last_block_num {
+			return subscriber, append([]*feedEvent(nil), f.events[idx+1:]...), false
+		}
+	}
+	//buffer의 첫 change 이전 block이거나 database보다 앞선 block이면(다른 database, resync) 다시 보낼 수 없다.
+	head_block_num, _ := DBGetLatestUpdatedBlock()
+	if last_block_num < f.base || last_block_num > head_block_num {
+		return subscriber, nil, true
+	}
+	//ONS change가 없는 block이면 buffer에 없으므로 그 이후 block의 change부터 보낸다.
+	for idx, event := range f.events {
+		if event.BlockNum > last_block_num {
+			return subscriber, append([]*feedEvent(nil), f.events[idx:]...), false
+		}
+	}
+	return subscriber, nil, false
+}
+
+func (f *ChangeFeed) unsubscribe(subscriber *feedSubscriber) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.subscribers[subscriber] == true {
+		close(subscriber.events)
+		delete(f.subscribers, subscriber)
+	}
+}
+
+//gs1_prefix가 있으면 prefix가 맞는 GS1 code change만 보낸다.
+func (s *feedSubscriber) match(change *ONSChange) bool {
+	if len(s.gs1_prefix) == 0 {
+		return true
+	}
+	return len(change.Gs1Code) > 0 && strings.HasPrefix(change.Gs1Code, s.gs1_prefix)
+}
+
+func formatFeedId(block_num float64) string {
+	return strconv.FormatFloat(block_num, 'f', -1, 64)
+}
+
+//filter에 맞지 않는 block의 마지막 change는 id만 보내서 client의 Last-Event-ID를 바꾼다.
+func writeFeedEvent(w http.ResponseWriter, subscriber *feedSubscriber, event *feedEvent) error {
+	id := ""
+	if event.Last == true {
+		id = "id: " + formatFeedId(event.BlockNum) + "\n"
+	}
+	if subscriber.match(event.Change) == false {
+		if len(id) == 0 {
+			return nil
+		}
+		_, err := fmt.Fprint(w, id+"\n")
+		return err
+	}
+	data, err := json.Marshal(event.Change)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%sdata: %s\n\n", id, data)
+	return err
+}
+
+//GET /changes?gs1_prefix=...
+//Last-Event-ID header 또는 last_event_id parameter의 block 이후부터 보낸다. 없으면 연결한 후의 change만 보낸다.
+func handleChanges(w http.ResponseWriter, req *http.Request) {
+	f := getChangeFeed()
+	if f == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "change feed is not running")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if ok == false {
+		writeAPIError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	query := req.URL.Query()
+	last_event_id := req.Header.Get("Last-Event-ID")
+	if len(last_event_id) == 0 {
+		last_event_id = query.Get("last_event_id")
+	}
+	resume := len(last_event_id) > 0
+	last_block_num := float64(0)
+	if resume == true {
+		block_num, err := strconv.ParseUint(last_event_id, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid Last-Event-ID %q (block number)", last_event_id)
+			return
+		}
+		last_block_num = float64(block_num)
+	}
+
+	subscriber, replay, reset := f.subscribe(query.Get("gs1_prefix"), resume, last_block_num)
+	if subscriber == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "change feed is not running")
+		return
+	}
+	defer f.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", CHANGE_FEED_RETRY)
+
+	if reset == true {
+		block_num, block_id := DBGetLatestUpdatedBlock()
+		data, _ := json.Marshal(map[string]interface{}{"block_num": block_num, "block_id": block_id})
+		fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", formatFeedId(block_num), CHANGE_FEED_RESET, data)
+	}
+	for _, event := range replay {
+		if err := writeFeedEvent(w, subscriber, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(CHANGE_FEED_KEEPALIVE)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case event, ok := <-subscriber.events:
+			if ok == false {
+				return
+			}
+			if err := writeFeedEvent(w, subscriber, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestChangeFeedResume(t *testing.T) {
+	block_num, block_id := DBGetLatestUpdatedBlock()
+	t.Cleanup(func() { setLatestUpdatedBlock(block_num, block_id) })
+	setLatestUpdatedBlock(16, "b16")
+
+	f := &ChangeFeed{size: 10, base: 10, subscribers: map[*feedSubscriber]bool{}}
+	f.publish(12, []*ONSChange{&ONSChange{Id: "b12:0"}, &ONSChange{Id: "b12:1"}})
+	f.publish(15, []*ONSChange{&ONSChange{Id: "b15:0"}})
+
+	tests := []struct {
+		last_block_num float64
+		replay         int
+		reset          bool
+	}{
+		{10, 3, false},
+		//ONS change가 없는 block
+		{11, 3, false},
+		{12, 1, false},
+		{13, 1, false},
+		{15, 0, false},
+		{16, 0, false},
+		{9, 0, true},
+		{17, 0, true},
+	}
+	for _, test := range tests {
+		subscriber, replay, reset := f.subscribe("", true, test.last_block_num)
+		if len(replay) != test.replay || reset != test.reset {
+			t.Errorf("resume from %v: %d changes, reset %v, want %d changes, reset %v",
+				test.last_block_num, len(replay), reset, test.replay, test.reset)
+		}
+		f.unsubscribe(subscriber)
+	}
+}
+
+func TestChangeFeedResumeAfterFork(t *testing.T) {
+	block_num, block_id := DBGetLatestUpdatedBlock()
+	t.Cleanup(func() { setLatestUpdatedBlock(block_num, block_id) })
+	setLatestUpdatedBlock(6, "b6-fork")
+
+	//block 6을 되돌리고 fork의 block 6을 적용한다. rollback change의 block number는 5이다.
+	f := &ChangeFeed{size: 10, base: 4, subscribers: map[*feedSubscriber]bool{}}
+	f.publish(5, []*ONSChange{&ONSChange{Id: "b5:0"}})
+	f.publish(6, []*ONSChange{&ONSChange{Id: "b6:0"}})
+	f.publish(5, []*ONSChange{&ONSChange{Id: "b6:0:rollback"}})
+	f.publish(6, []*ONSChange{&ONSChange{Id: "b6-fork:0"}})
+
+	_, replay, reset := f.subscribe("", true, 5)
+	if reset == true || len(replay) != 3 || replay[1].Change.Id != "b6:0:rollback" {
+		t.Errorf("resume from 5: %d changes, reset %v, want the rollback and the fork", len(replay), reset)
+	}
+}
+
+func TestChangeFeedStopped(t *testing.T) {
+	f := StartChangeFeed(10)
+	subscriber, _, _ := f.subscribe("", false, 0)
+	StopChangeFeed()
+	if _, ok := <-subscriber.events; ok == true {
+		t.Errorf("subscriber is not closed")
+	}
+	if getChangeFeed() != nil {
+		t.Errorf("change feed is not cleared")
+	}
+	if subscriber, _, _ = f.subscribe("", false, 0); subscriber != nil {
+		t.Errorf("subscribed to a stopped change feed")
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gorilla/websocket"
+)
+
+//TLS client 설정. 인증서 file은 PEM 형식이다.
+type TLSConfig struct {
+	Enabled bool `toml:"enabled"`
+	//server 인증서를 확인할 CA. 비어 있으면 system CA를 사용한다.
+	CAFile string `toml:"ca_file"`
+	//client 인증서. 둘 다 지정해야 한다.
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+	//인증서를 확인할 server 이름. 비어 있으면 address의 host를 사용한다.
+	ServerName         string `toml:"server_name"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+}
+
+//Sawtooth REST API. websocket event source도 같은 address, TLS, header를 사용한다.
+type RESTConfig struct {
+	//host:port. https://host:port이면 TLS를 사용한다.
+	Address string    `toml:"address"`
+	TLS     TLSConfig `toml:"tls"`
+	//REST API, websocket 요청에 추가하는 header. (예: Authorization)
+	Headers map[string]string `toml:"headers"`
+}
+
+type DBConfig struct {
+	//rethinkdb, sqlite 또는 postgres
+	Store string `toml:"store"`
+	//비어 있으면 store의 기본 address를 사용한다.
+	Address string `toml:"address"`
+	//rethinkdb database name
+	Name string `toml:"name"`
+	//rethinkdb, postgres user. sqlite는 사용하지 않는다.
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	//password를 읽어 들일 file path. password가 지정되면 무시된다.
+	PasswordFile string    `toml:"password_file"`
+	TLS          TLSConfig `toml:"tls"`
+}
+
+//ONS DNS, GS1 Digital Link resolver. (ons_lib/ons_resolve) 동기화한 store의 GS1 code로 응답한다.
+type ResolverConfig struct {
+	//udp, tcp host:port. 비어 있으면 실행하지 않는다.
+	DNS string `toml:"dns"`
+	//GS1 Digital Link host:port. 비어 있으면 실행하지 않는다.
+	HTTP string `toml:"http"`
+	//ONS root domain
+	Root string `toml:"root"`
+	//NAPTR answer의 TTL
+	TTL uint32 `toml:"ttl"`
+}
+
+//event source 연결이 끊어졌을 때 다시 연결하는 설정. (ReconnectOptions) 시간은 "30s"와 같이 지정한다.
+type ReconnectConfig struct {
+	MinBackoff time.Duration `toml:"min_backoff"`
+	MaxBackoff time.Duration `toml:"max_backoff"`
+	//ping_period가 0이면 keepalive를 사용하지 않는다.
+	PingPeriod time.Duration `toml:"ping_period"`
+	PongWait   time.Duration `toml:"pong_wait"`
+	//0이면 alarm을 남기지 않는다.
+	OutageAlarm time.Duration `toml:"outage_alarm"`
+}
+
+//enabled이면 동기화하지 않고 database를 chain state와 비교한 후 종료한다.
+type VerifyConfig struct {
+	Enabled bool `toml:"enabled"`
+	//다른 state를 chain state로 다시 적용한다.
+	Repair bool `toml:"repair"`
+}
+
+//webhook notification 전송 설정 (WebhookOptions)
+type WebhookConfig struct {
+	Attempts int           `toml:"attempts"`
+	Timeout  time.Duration `toml:"timeout"`
+}
+
+type Config struct {
+	Rest RESTConfig `toml:"rest"`
+	DB   DBConfig   `toml:"db"`
+	//websocket 또는 zmq
+	Source string `toml:"source"`
+	//zmq event source가 연결하는 validator component endpoint
+	Validator string `toml:"validator"`
+	//ONS transaction family의 address prefix (hex 6자리)
+	Namespace string `toml:"namespace"`
+	Verbose   bool   `toml:"verbose"`
+	//query API, /healthz 와 /readyz listener. 비어 있으면 실행하지 않는다.
+	API    string `toml:"api"`
+	Health string `toml:"health"`
+	//Prometheus /metrics listener. 비어 있으면 실행하지 않는다.
+	Metrics string `toml:"metrics"`
+	//webhook 관리 API의 bearer token
+	WebhookToken string          `toml:"webhook_token"`
+	Resolver     ResolverConfig  `toml:"resolver"`
+	Reconnect    ReconnectConfig `toml:"reconnect"`
+	Verify       VerifyConfig    `toml:"verify"`
+	Webhook      WebhookConfig   `toml:"webhook"`
+}
+
+func DefaultConfig() *Config {
+	reconnect := DefaultReconnectOptions()
+	webhook := DefaultWebhookOptions()
+	return &Config{
+		Rest: RESTConfig{
+			Address: "localhost:8008",
+			Headers: map[string]string{},
+		},
+		DB: DBConfig{
+			Store: STORE_RETHINKDB,
+			Name:  "ons_ledger",
+		},
+		Source:    EVENT_SOURCE_WEBSOCKET,
+		Validator: "tcp://localhost:4004",
+		Namespace: Hexdigest(familyname)[:6],
+		Resolver: ResolverConfig{
+			Root: "onsepc.com",
+			TTL:  300,
+		},
+		Reconnect: ReconnectConfig{
+			MinBackoff:  reconnect.MinBackoff,
+			MaxBackoff:  reconnect.MaxBackoff,
+			PingPeriod:  reconnect.PingPeriod,
+			PongWait:    reconnect.PongWait,
+			OutageAlarm: reconnect.OutageAlarm,
+		},
+		Webhook: WebhookConfig{
+			Attempts: webhook.Attempts,
+			Timeout:  webhook.Timeout,
+		},
+	}
+}
+
+//path의 TOML file로 cfg를 덮어쓴다. file에 없는 항목은 기존 값을 유지한다.
+func (cfg *Config) LoadFile(path string) error {
+	md, err := toml.DecodeFile(path, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return fmt.Errorf("unknown keys in config file %s: %s", path, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+//ONS_SYNC_ prefix를 가진 environment variable로 cfg를 덮어쓴다.
+func (cfg *Config) ApplyEnv() error {
+	strings_env := map[string]*string{
+		"ONS_SYNC_REST_ADDRESS":         &cfg.Rest.Address,
+		"ONS_SYNC_REST_TLS_CA_FILE":     &cfg.Rest.TLS.CAFile,
+		"ONS_SYNC_REST_TLS_CERT_FILE":   &cfg.Rest.TLS.CertFile,
+		"ONS_SYNC_REST_TLS_KEY_FILE":    &cfg.Rest.TLS.KeyFile,
+		"ONS_SYNC_REST_TLS_SERVER_NAME": &cfg.Rest.TLS.ServerName,
+		"ONS_SYNC_DB_STORE":             &cfg.DB.Store,
+		"ONS_SYNC_DB_ADDRESS":           &cfg.DB.Address,
+		"ONS_SYNC_DB_NAME":              &cfg.DB.Name,
+		"ONS_SYNC_DB_USERNAME":          &cfg.DB.Username,
+		"ONS_SYNC_DB_PASSWORD":          &cfg.DB.Password,
+		"ONS_SYNC_DB_PASSWORD_FILE":     &cfg.DB.PasswordFile,
+		"ONS_SYNC_DB_TLS_CA_FILE":       &cfg.DB.TLS.CAFile,
+		"ONS_SYNC_DB_TLS_CERT_FILE":     &cfg.DB.TLS.CertFile,
+		"ONS_SYNC_DB_TLS_KEY_FILE":      &cfg.DB.TLS.KeyFile,
+		"ONS_SYNC_DB_TLS_SERVER_NAME":   &cfg.DB.TLS.ServerName,
+		"ONS_SYNC_SOURCE":               &cfg.Source,
+		"ONS_SYNC_VALIDATOR":            &cfg.Validator,
+		"ONS_SYNC_NAMESPACE":            &cfg.Namespace,
+		"ONS_SYNC_API":                  &cfg.API,
+		"ONS_SYNC_HEALTH":               &cfg.Health,
+		"ONS_SYNC_METRICS":              &cfg.Metrics,
+		"ONS_SYNC_WEBHOOK_TOKEN":        &cfg.WebhookToken,
+		"ONS_SYNC_RESOLVER_DNS":         &cfg.Resolver.DNS,
+		"ONS_SYNC_RESOLVER_HTTP":        &cfg.Resolver.HTTP,
+		"ONS_SYNC_RESOLVER_ROOT":        &cfg.Resolver.Root,
+	}
+	for name, field := range strings_env {
+		if v, ok := os.LookupEnv(name); ok {
+			*field = v
+		}
+	}
+
+	bools_env := map[string]*bool{
+		"ONS_SYNC_REST_TLS":                      &cfg.Rest.TLS.Enabled,
+		"ONS_SYNC_REST_TLS_INSECURE_SKIP_VERIFY": &cfg.Rest.TLS.InsecureSkipVerify,
+		"ONS_SYNC_DB_TLS":                        &cfg.DB.TLS.Enabled,
+		"ONS_SYNC_DB_TLS_INSECURE_SKIP_VERIFY":   &cfg.DB.TLS.InsecureSkipVerify,
+		"ONS_SYNC_VERBOSE":                       &cfg.Verbose,
+		"ONS_SYNC_VERIFY":                        &cfg.Verify.Enabled,
+		"ONS_SYNC_VERIFY_REPAIR":                 &cfg.Verify.Repair,
+	}
+	for name, field := range bools_env {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false, got %q", name, v)
+			}
+			*field = b
+		}
+	}
+
+	if v, ok := os.LookupEnv("ONS_SYNC_RESOLVER_TTL"); ok {
+		ttl, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("ONS_SYNC_RESOLVER_TTL must be a number of seconds, got %q", v)
+		}
+		cfg.Resolver.TTL = uint32(ttl)
+	}
+
+	durations_env := map[string]*time.Duration{
+		"ONS_SYNC_RECONNECT_MIN_BACKOFF":  &cfg.Reconnect.MinBackoff,
+		"ONS_SYNC_RECONNECT_MAX_BACKOFF":  &cfg.Reconnect.MaxBackoff,
+		"ONS_SYNC_RECONNECT_PING_PERIOD":  &cfg.Reconnect.PingPeriod,
+		"ONS_SYNC_RECONNECT_PONG_WAIT":    &cfg.Reconnect.PongWait,
+		"ONS_SYNC_RECONNECT_OUTAGE_ALARM": &cfg.Reconnect.OutageAlarm,
+		"ONS_SYNC_WEBHOOK_TIMEOUT":        &cfg.Webhook.Timeout,
+	}
+	for name, field := range durations_env {
+		if v, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s must be a duration (e.g. 30s), got %q", name, v)
+			}
+			*field = d
+		}
+	}
+
+	if v, ok := os.LookupEnv("ONS_SYNC_WEBHOOK_ATTEMPTS"); ok {
+		attempts, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("ONS_SYNC_WEBHOOK_ATTEMPTS must be a number, got %q", v)
+		}
+		cfg.Webhook.Attempts = attempts
+	}
+
+	//"Name: value"를 줄 단위로 지정한다.
+	if v, ok := os.LookupEnv("ONS_SYNC_REST_HEADERS"); ok {
+		for _, line := range strings.Split(v, "\n") {
+			if len(strings.TrimSpace(line)) == 0 {
+				continue
+			}
+			err := cfg.Rest.AddHeader(line)
+			if err != nil {
+				return fmt.Errorf("ONS_SYNC_REST_HEADERS : %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+//"Name: value" 형식의 header를 추가한다.
+func (rest *RESTConfig) AddHeader(header string) error {
+	idx := strings.Index(header, ":")
+	if idx <= 0 {
+		return fmt.Errorf("header must look like \"Name: value\", got %q", header)
+	}
+	if rest.Headers == nil {
+		rest.Headers = map[string]string{}
+	}
+	rest.Headers[strings.TrimSpace(header[:idx])] = strings.TrimSpace(header[idx+1:])
+	return nil
+}
+
+//address의 http://, https:// scheme을 없애고 https이면 TLS를 사용한다.
+func (cfg *Config) Validate() error {
+	if strings.HasPrefix(cfg.Rest.Address, "https://") == true {
+		cfg.Rest.TLS.Enabled = true
+	}
+	cfg.Rest.Address = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(cfg.Rest.Address, "https://"), "http://"), "/")
+	if _, _, err := net.SplitHostPort(cfg.Rest.Address); err != nil {
+		return fmt.Errorf("rest address must look like host:port, got %q", cfg.Rest.Address)
+	}
+	if err := cfg.Rest.TLS.validate("rest"); err != nil {
+		return err
+	}
+
+	if _, ok := g_default_store_addresses[cfg.DB.Store]; ok == false {
+		return fmt.Errorf("db store must be one of rethinkdb, sqlite or postgres, got %q", cfg.DB.Store)
+	}
+	if cfg.DB.Store == STORE_SQLITE && (len(cfg.DB.Username) > 0 || len(cfg.DB.Password) > 0 || len(cfg.DB.PasswordFile) > 0 || cfg.DB.TLS.Enabled == true) {
+		return fmt.Errorf("db username, password and tls are not supported by sqlite")
+	}
+	if cfg.DB.Store == STORE_POSTGRES && len(cfg.DB.TLS.ServerName) > 0 {
+		return fmt.Errorf("db tls server_name is not supported by postgres")
+	}
+	if err := cfg.DB.TLS.validate("db"); err != nil {
+		return err
+	}
+
+	if cfg.Source != EVENT_SOURCE_WEBSOCKET && cfg.Source != EVENT_SOURCE_ZMQ {
+		return fmt.Errorf("source must be websocket or zmq, got %q", cfg.Source)
+	}
+	u, err := url.Parse(cfg.Validator)
+	if err != nil || u.Scheme != "tcp" || len(u.Host) == 0 {
+		return fmt.Errorf("validator must look like tcp://host:port, got %q", cfg.Validator)
+	}
+
+	if _, err := hex.DecodeString(cfg.Namespace); err != nil || len(cfg.Namespace) != 6 || strings.ToLower(cfg.Namespace) != cfg.Namespace {
+		return fmt.Errorf("namespace must be 6 lowercase hex characters, got %q", cfg.Namespace)
+	}
+
+	if len(strings.Trim(cfg.Resolver.Root, ".")) == 0 && (len(cfg.Resolver.DNS) > 0 || len(cfg.Resolver.HTTP) > 0) {
+		return fmt.Errorf("resolver root must not be empty")
+	}
+
+	listeners := map[string]string{}
+	for _, listener := range [][2]string{{"api", cfg.API}, {"health", cfg.Health}, {"metrics", cfg.Metrics},
+		{"resolver dns", cfg.Resolver.DNS}, {"resolver http", cfg.Resolver.HTTP}} {
+		name, listen := listener[0], listener[1]
+		if len(listen) == 0 {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(listen); err != nil {
+			return fmt.Errorf("%s listen address must look like host:port, got %q", name, listen)
+		}
+		if other, ok := listeners[listen]; ok == true {
+			return fmt.Errorf("%s and %s listeners can't share the address %q", other, name, listen)
+		}
+		listeners[listen] = name
+	}
+
+	if cfg.Reconnect.MinBackoff <= 0 || cfg.Reconnect.MaxBackoff < cfg.Reconnect.MinBackoff {
+		return fmt.Errorf("reconnect min_backoff must be positive and not greater than max_backoff, got %v and %v", cfg.Reconnect.MinBackoff, cfg.Reconnect.MaxBackoff)
+	}
+	if cfg.Reconnect.PingPeriod < 0 || cfg.Reconnect.PongWait < 0 || cfg.Reconnect.OutageAlarm < 0 {
+		return fmt.Errorf("reconnect ping_period, pong_wait and outage_alarm must not be negative")
+	}
+	if cfg.Reconnect.PingPeriod > 0 && cfg.Reconnect.PongWait > 0 && cfg.Reconnect.PongWait <= cfg.Reconnect.PingPeriod {
+		return fmt.Errorf("reconnect pong_wait must be longer than ping_period, got %v and %v", cfg.Reconnect.PongWait, cfg.Reconnect.PingPeriod)
+	}
+
+	if cfg.Verify.Repair == true && cfg.Verify.Enabled == false {
+		return fmt.Errorf("verify repair is set but verify is not enabled")
+	}
+
+	if cfg.Webhook.Attempts < 1 || cfg.Webhook.Timeout <= 0 {
+		return fmt.Errorf("webhook attempts and timeout must be positive, got %d and %v", cfg.Webhook.Attempts, cfg.Webhook.Timeout)
+	}
+	return nil
+}
+
+func (t *TLSConfig) validate(name string) error {
+	if t.Enabled == false {
+		if len(t.CAFile) > 0 || len(t.CertFile) > 0 || len(t.KeyFile) > 0 || len(t.ServerName) > 0 || t.InsecureSkipVerify == true {
+			return fmt.Errorf("%s tls options are set but tls is not enabled", name)
+		}
+		return nil
+	}
+	if (len(t.CertFile) > 0) != (len(t.KeyFile) > 0) {
+		return fmt.Errorf("%s tls cert_file and key_file must be set together", name)
+	}
+	return nil
+}
+
+//TLS를 사용하지 않으면 nil을 반환한다.
+func (t *TLSConfig) ClientConfig() (*tls.Config, error) {
+	if t.Enabled == false {
+		return nil, nil
+	}
+	tls_config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if len(t.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(pem) == false {
+			return nil, fmt.Errorf("no certificate in CA file %s", t.CAFile)
+		}
+		tls_config.RootCAs = pool
+	}
+	if len(t.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tls_config.Certificates = []tls.Certificate{cert}
+	}
+	return tls_config, nil
+}
+
+//password > password_file 순서로 사용한다.
+func (db *DBConfig) StoreOptions() (*StoreOptions, error) {
+	password := db.Password
+	if len(password) == 0 && len(db.PasswordFile) > 0 {
+		password_bytes, err := ioutil.ReadFile(db.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read db password file: %v", err)
+		}
+		password = strings.TrimSpace(string(password_bytes))
+	}
+	return &StoreOptions{
+		Backend:  db.Store,
+		Address:  db.Address,
+		Name:     db.Name,
+		Username: db.Username,
+		Password: password,
+		TLS:      db.TLS,
+	}, nil
+}
+
+//bootstrap_gap은 -bootstrap-gap option이다.
+func (r *ReconnectConfig) ReconnectOptions(bootstrap_gap float64) *ReconnectOptions {
+	return &ReconnectOptions{
+		MinBackoff:   r.MinBackoff,
+		MaxBackoff:   r.MaxBackoff,
+		PingPeriod:   r.PingPeriod,
+		PongWait:     r.PongWait,
+		OutageAlarm:  r.OutageAlarm,
+		BootstrapGap: bootstrap_gap,
+	}
+}
+
+func (w *WebhookConfig) WebhookOptions() *WebhookOptions {
+	options := DefaultWebhookOptions()
+	options.Attempts = w.Attempts
+	options.Timeout = w.Timeout
+	return options
+}
+
+//REST API와 websocket 연결 설정. ConfigureREST로 바꾼다.
+var g_rest_tls *tls.Config = nil
+var g_rest_headers = http.Header{}
+var g_rest_client = &http.Client{}
+
+func ConfigureREST(rest *RESTConfig) error {
+	tls_config, err := rest.TLS.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("rest tls : %v", err)
+	}
+	headers := http.Header{}
+	for name, value := range rest.Headers {
+		headers.Set(name, value)
+	}
+	g_rest_tls = tls_config
+	g_rest_headers = headers
+	g_rest_client = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tls_config}}
+	return nil
+}
+
+func restURL(rest_addr string, path string) string {
+	if g_rest_tls != nil {
+		return "https://" + rest_addr + path
+	}
+	return "http://" + rest_addr + path
+}
+
+func websocketURL(rest_addr string, path string) string {
+	if g_rest_tls != nil {
+		return "wss://" + rest_addr + path
+	}
+	return "ws://" + rest_addr + path
+}
+
+//REST API의 header를 추가한 GET 요청
+func restRequest(get_url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, get_url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range g_rest_headers {
+		req.Header[name] = values
+	}
+	return g_rest_client.Do(req)
+}
+
+func dialWebsocket(ws_url string) (*websocket.Conn, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = g_rest_tls
+	conn, _, err := dialer.Dial(ws_url, g_rest_headers)
+	return conn, err
+}
+
+//password, token, header 값은 log에 남기지 않는다.
+func (cfg *Config) String() string {
+	masked := *cfg
+	mask := func(v string) string {
+		if len(v) == 0 {
+			return ""
+		}
+		return "****"
+	}
+	masked.DB.Password = mask(cfg.DB.Password)
+	masked.WebhookToken = mask(cfg.WebhookToken)
+	masked.Rest.Headers = map[string]string{}
+	for name, value := range cfg.Rest.Headers {
+		masked.Rest.Headers[name] = mask(value)
+	}
+	return fmt.Sprintf("%+v", masked)
+}
+
+//-rest-header를 여러 번 지정할 수 있다.
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerList) Set(header string) error {
+	err := (&RESTConfig{}).AddHeader(header)
+	if err != nil {
+		return err
+	}
+	*h = append(*h, header)
+	return nil
+}
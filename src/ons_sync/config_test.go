@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+//ons_sync.toml.example은 default와 같은 값이어야 한다.
+func TestExampleConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.LoadFile("ons_sync.toml.example"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultConfig()
+	if cfg.Reconnect != defaults.Reconnect || cfg.Verify != defaults.Verify || cfg.Webhook != defaults.Webhook {
+		t.Errorf("example config %+v %+v %+v differs from the default", cfg.Reconnect, cfg.Verify, cfg.Webhook)
+	}
+}
+
+func TestConfigEnv(t *testing.T) {
+	env := map[string]string{
+		"ONS_SYNC_RECONNECT_MAX_BACKOFF":  "30s",
+		"ONS_SYNC_RECONNECT_OUTAGE_ALARM": "0",
+		"ONS_SYNC_VERIFY":                 "true",
+		"ONS_SYNC_VERIFY_REPAIR":          "true",
+		"ONS_SYNC_WEBHOOK_ATTEMPTS":       "3",
+		"ONS_SYNC_WEBHOOK_TIMEOUT":        "2s",
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+	cfg := DefaultConfig()
+	if err := cfg.ApplyEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	reconnect := cfg.Reconnect.ReconnectOptions(10)
+	if reconnect.MaxBackoff != 30*time.Second || reconnect.OutageAlarm != 0 || reconnect.BootstrapGap != 10 {
+		t.Errorf("reconnect options %+v", reconnect)
+	}
+	if cfg.Verify.Enabled == false || cfg.Verify.Repair == false {
+		t.Errorf("verify %+v", cfg.Verify)
+	}
+	webhook := cfg.Webhook.WebhookOptions()
+	if webhook.Attempts != 3 || webhook.Timeout != 2*time.Second || webhook.QueueSize != DefaultWebhookOptions().QueueSize {
+		t.Errorf("webhook options %+v", webhook)
+	}
+
+	os.Setenv("ONS_SYNC_WEBHOOK_TIMEOUT", "10")
+	if err := DefaultConfig().ApplyEnv(); err == nil {
+		t.Errorf("duration without unit is accepted")
+	}
+}
+
+func TestConfigValidateOptions(t *testing.T) {
+	for name, modify := range map[string]func(cfg *Config){
+		"repair without verify": func(cfg *Config) { cfg.Verify.Repair = true },
+		"max below min backoff": func(cfg *Config) { cfg.Reconnect.MaxBackoff = cfg.Reconnect.MinBackoff / 2 },
+		"pong wait below ping":  func(cfg *Config) { cfg.Reconnect.PongWait = cfg.Reconnect.PingPeriod },
+		"no webhook attempts":   func(cfg *Config) { cfg.Webhook.Attempts = 0 },
+	} {
+		cfg := DefaultConfig()
+		modify(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s is accepted", name)
+		}
+	}
+}
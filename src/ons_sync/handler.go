@@ -1,16 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"sync"
+	"time"
 	"log"
 	"strings"
-	"net/url"
 	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
-	"encoding/base64"
 	"protobuf/ons_pb2"
-	"github.com/golang/protobuf/proto"
 	"github.com/gorilla/websocket"
 )
 
@@ -61,18 +60,27 @@ type ONSEventHandler struct {
 	block_id chan string
 	wg *sync.WaitGroup
 	conn *websocket.Conn
+	//conn을 바꾸거나 conn에 message를 쓸 때 사용한다.
+	conn_mutex *sync.Mutex
+	done chan struct{}
+	url string
+	rest_addr string
+	reconnect *ReconnectOptions
+	pipeline *EventPipeline
 }
 
-func NewONSEventHandler(addr string, path string, verbose bool) (*ONSEventHandler, error) {
+func NewONSEventHandler(addr string, path string, reconnect *ReconnectOptions, verbose bool) (*ONSEventHandler, error) {
 
-	u := url.URL{Scheme: "ws", Host: addr, Path: path}
-	log.Printf("connecting to %s", u.String())
+	ws_url := websocketURL(addr, path)
+	log.Printf("connecting to %s", ws_url)
 
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	conn, err := dialWebsocket(ws_url)
 	if err != nil {
 		log.Printf("Websocket dial error: %v", err)
+		SetSyncConnected(false, err)
 		return nil, err
 	}
+	SetSyncConnected(true, nil)
 
 	onsEvHandler := &ONSEventHandler{
 		subscirbed: false,
@@ -82,8 +90,14 @@ func NewONSEventHandler(addr string, path string, verbose bool) (*ONSEventHandle
 		rcv_exited: make(chan bool),
 		wg: &sync.WaitGroup{},
 		conn: conn,
+		conn_mutex: &sync.Mutex{},
+		done: make(chan struct{}),
+		url: ws_url,
+		rest_addr: addr,
+		reconnect: reconnect,
 	}
-	onsEvHandler.AddWaitGroup(1)
+	onsEvHandler.pipeline = NewEventPipeline(onsEvHandler, addr, decodeBlockDeltas)
+	onsEvHandler.AddWaitGroup(2)
 	onsEvHandler.initialized = true
 	g_verbose = verbose
 	return onsEvHandler, nil
@@ -95,21 +109,23 @@ func (h *ONSEventHandler) Run() bool {
 		return false
 	}
 	go h.runSubscriber()
-	go h.runReceiveEvents()
+	go h.runConnection()
 	return true
 }
 
 func (h *ONSEventHandler) Terminate(waiting bool) {
 
-	defer h.conn.Close()
-
-	if h.subscirbed == true {
+	if h.isSubscribed() == true {
 		h.Subscribe(false)
 	}
 	//waiting needed?
 
 	//h.exit_rcv <- true
 	h.exit_sub <- true
+	close(h.done)
+	h.conn_mutex.Lock()
+	h.conn.Close()
+	h.conn_mutex.Unlock()
 	log.Println("Terminate : called")
 	if waiting == true {
 		h.Wait()
@@ -130,7 +146,23 @@ func (h *ONSEventHandler) Subscribe(subscribing bool) {
 }
 
 func (h *ONSEventHandler) GetBlockDeltas(block_id string) {
-	h.block_id <- block_id
+	select {
+	case h.block_id <- block_id:
+	case <-h.done:
+	}
+}
+
+func (h *ONSEventHandler) isSubscribed() bool {
+	h.conn_mutex.Lock()
+	defer h.conn_mutex.Unlock()
+	return h.subscirbed
+}
+
+//현재 연결된 conn에 message를 쓴다. 재연결 중에도 하나의 writer만 사용한다.
+func (h *ONSEventHandler) writeMessage(data []byte) error {
+	h.conn_mutex.Lock()
+	defer h.conn_mutex.Unlock()
+	return h.conn.WriteMessage(websocket.TextMessage, data)
 }
 
 func (h *ONSEventHandler) subscribe(subscribing bool) error {
@@ -147,7 +179,7 @@ func (h *ONSEventHandler) subscribe(subscribing bool) error {
 		})
 	}
 
-	err := h.conn.WriteMessage(websocket.TextMessage, data)
+	err := h.writeMessage(data)
 
 	if err != nil {
 		log.Printf("Failed to sendSubscribeMessage : %v", err)
@@ -165,7 +197,7 @@ func (h *ONSEventHandler) getBlockDelteas(block_id string) error {
 			Address_prefixes: []string{namespace},
 		})
 
-	err := h.conn.WriteMessage(websocket.TextMessage, data)
+	err := h.writeMessage(data)
 
 	if err != nil {
 		log.Printf("Failed to sendSubscribeMessage : %v", err)
@@ -182,9 +214,13 @@ func (h *ONSEventHandler) runSubscriber() {
 	for {
 		select {
 		case subscribing := <- h.subscribing:
-			if h.subscirbed != subscribing {
+			if h.isSubscribed() != subscribing {
+				h.conn_mutex.Lock()
 				h.subscirbed = subscribing
-				h.subscribe(subscribing)
+				h.conn_mutex.Unlock()
+				if h.subscribe(subscribing) == nil {
+					SetSyncSubscribed(subscribing)
+				}
 			}
 			log.Printf("runSubscriber : called subscribing : %v", subscribing)
 		case block_id := <- h.block_id:
@@ -210,136 +246,59 @@ type ONSGS1CodeEvent struct {
 	BlockNum float64 `json:"block_num"`
 }
 
+//Document는 types에 따라 JSON field를 decode 한 fields이다. (servicetype.go)
 type ONSServiceTypeEvent struct {
 	ons_pb2.ServiceType
+	Document map[string]interface{} `json:"document"`
 	BlockNum float64 `json:"block_num"`
 }
 
-var g_current_latest_ons_event *ONSEvent = nil
-
-func getCurrentLatestONSEvent(onsEvent *ONSEvent) *ONSEvent {
-	if g_current_latest_ons_event == nil{
-		g_current_latest_ons_event = onsEvent
-		err := DBUpdateLatestUpdatedBlockInfo(onsEvent.BlockNum, onsEvent.BlockId, onsEvent.PreviousBlockId)
-		if err != nil {
-			log.Printf("getCurrentLatestONSEvent : Failed to update latest block info : ", err)
-		}
-		return g_current_latest_ons_event
-	}
-
-	if g_current_latest_ons_event.BlockNum < onsEvent.BlockNum {
-		g_current_latest_ons_event = onsEvent
-		err := DBUpdateLatestUpdatedBlockInfo(onsEvent.BlockNum, onsEvent.BlockId, onsEvent.PreviousBlockId)
-		if err != nil {
-			log.Printf("getCurrentLatestONSEvent : Failed to update latest block info : ", err)
-		}
-	}
-
-	return g_current_latest_ons_event
-}
-
-func UpdateOnsEvent(h *ONSEventHandler, onsEvent *ONSEvent, verbose bool) {
+//h는 parent block의 delta를 요청할 때, rest_addr은 다시 bootstrap 할 때 사용한다.
+func UpdateOnsEvent(h EventSource, rest_addr string, onsEvent *ONSEvent, verbose bool) {
 	if onsEvent == nil {
 		return
 	}
 
-	//currentLatestONSEvent := getCurrentLatestONSEvent(onsEvent)
-	_ = getCurrentLatestONSEvent(onsEvent)
+	err := SyncBlock(h, onsEvent, verbose)
+	if err == errSnapshotRequired && len(rest_addr) > 0 {
+		//undo data로 되돌릴 수 없는 fork이므로 chain head의 snapshot으로 다시 맞춘다.
+		err = Bootstrap(rest_addr, verbose)
+	}
+	if err != nil {
+		log.Printf("Failed to sync block %v(%s) : %v\n", onsEvent.BlockNum, onsEvent.BlockId, err)
+	}
 
-	latest_updated_block_num,  latest_updated_block_id := DBGetLatestUpdatedBlock()
 	if verbose == true {
-		log.Printf("latest block num : %v", latest_updated_block_num)
+		latest_updated_block_num, latest_updated_block_id := DBGetLatestUpdatedBlock()
+		log.Printf("latest block : %v(%s)", latest_updated_block_num, latest_updated_block_id)
 	}
+}
 
-	if onsEvent.PreviousBlockId != latest_updated_block_id {
-		log.Printf("Call previous block info\n - current block id : %s\n - previous block id : ", onsEvent.BlockId, onsEvent.PreviousBlockId)
-		h.GetBlockDeltas(onsEvent.PreviousBlockId)
-	}else{
-		//no more delta.
-		DBGetLatestUpdatedBlockInfo(verbose)
+//websocket message를 block delta로 decode 한다.
+func decodeBlockDeltas(message []byte) (*ONSEvent, error) {
+	onsEvent := &ONSEvent{}
+	err := json.Unmarshal(message, onsEvent)
+	if err != nil {
+		return nil, err
 	}
-
-	for _, state := range onsEvent.StateChanges {
-		event_type, ok := state["type"]
-
-		if ok == false {
-			log.Printf("event: NONE, block num : %v, block id : %v\n", onsEvent.BlockNum, onsEvent.BlockId)
-			continue
-		}
-
-		if event_type == "DELETE" {
-			log.Printf("event: DELETE, block num : %v, block id : %v\n", onsEvent.BlockNum, onsEvent.BlockId)
-			err := DBDeleteAddress(state["address"])
-			if err != nil {
-				log.Printf("Fail to DBDeleteGS1Code : %v\n", err)
-			}
-			continue
-		}
-
-		log.Printf("event: SET, block num : %v, block id : %v\n", onsEvent.BlockNum, onsEvent.BlockId)
-
-		state_value, err := base64.StdEncoding.DecodeString(state["value"])
-		if err != nil {
-			log.Printf("Fail to base64 decoding in UpdateOnsEvent : %v\n", err)
-		}else {
-
-			table_idx := GetTableIdxByAddress(state["address"])
-
-			if table_idx == GS1_CODE_TABLE {
-				log.Printf("Update gs1 code\n")
-				gs1_code_event := &ONSGS1CodeEvent{}
-				gs1_code_event.Address = state["address"]
-				gs1_code_event.BlockNum = onsEvent.BlockNum
-				err = proto.Unmarshal(state_value, gs1_code_event)
-				if verbose == true {
-					log.Printf("unmarshaled state value = %v\n", gs1_code_event)
-				}
-				DBUpdateOrInsert(GS1_CODE_TABLE, gs1_code_event.Gs1Code, gs1_code_event.BlockNum, gs1_code_event)
-			}else if table_idx == SERVICE_TYPE_TABLE {
-				log.Printf("Update service type\n")
-				service_type_event := &ONSServiceTypeEvent{}
-				service_type_event.BlockNum = onsEvent.BlockNum
-				err = proto.Unmarshal(state_value, service_type_event)
-				if err != nil {
-					log.Printf("Fail to unmarshal proto buffer binary data in UpdateOnsEvent : %v\n", err)
-					return
-				}
-				if verbose == true {
-					log.Printf("unmarshaled state value = %v\n", service_type_event)
-				}
-				DBUpdateOrInsert(SERVICE_TYPE_TABLE, service_type_event.Address, service_type_event.BlockNum, service_type_event)
-			}
-		}
+	if len(onsEvent.BlockId) == 0 {
+		return nil, fmt.Errorf("message is not a block delta : %s", string(message))
 	}
-
+	return onsEvent, nil
 }
 
-func (h *ONSEventHandler) runReceiveEvents() {
-	defer func() {
-		//h.conn.Close()
-		//h.wg.Done()
-		//h.rcv_exited <- true
-		log.Println("runReceiveEvents : Exit")
-	}()
+//conn이 끊어질 때까지 event를 읽어서 pipeline으로 보낸다.
+//pipeline이 가득 차면 읽지 않으므로 read timeout은 message를 읽기 시작할 때부터 계산한다.
+func (h *ONSEventHandler) receiveEvents(conn *websocket.Conn, read_timeout time.Duration) error {
 	for {
-		_, message, err := h.conn.ReadMessage()
+		if read_timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(read_timeout))
+		}
+		_, message, err := conn.ReadMessage()
 		if err != nil {
 			log.Printf("Failed to read from websocket: %v", err)
-			break
-		}else{
-			go func(h *ONSEventHandler) {
-				//log.Printf("message type : %v", msg_type)
-				//unmarshaling is needed...
-				message = append([]byte{'['}, append(message, []byte{']'}...)...)
-				//log.Printf("message : %v", string(message))
-				var onsEvent []ONSEvent
-				err = json.Unmarshal(message, &onsEvent)
-				if err != nil {
-					log.Printf("marshaling error : %#v", err)
-				}
-				//log.Printf("json : %#v", onsEvent[0])
-				UpdateOnsEvent(h, &onsEvent[0], g_verbose)
-			}(h)
+			return err
 		}
+		h.pipeline.Push(message)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const chainHeadPollInterval = 10 * time.Second
+
+//ons_sync의 연결 상태와 처리한 block 정보를 /healthz, /readyz로 보고한다.
+type syncStatus struct {
+	mutex         sync.Mutex
+	restAddr      string
+	maxLag        float64
+	connected     bool
+	subscribed    bool
+	lastError     string
+	lastBlockNum  float64
+	lastBlockId   string
+	lastBlockTime time.Time
+	headBlockNum  float64
+	headBlockId   string
+	headPollError string
+	//연결이 끊어진 시각. 연결되어 있으면 zero value이다.
+	disconnectedSince time.Time
+	reconnects        int
+	outageAlarm       bool
+}
+
+var g_sync_status = &syncStatus{}
+
+type SyncHealthReport struct {
+	Status            string  `json:"status"`
+	Connected         bool    `json:"connected"`
+	Subscribed        bool    `json:"subscribed"`
+	LastError         string  `json:"last_error,omitempty"`
+	LastBlockNum      float64 `json:"last_block_num"`
+	LastBlockId       string  `json:"last_block_id"`
+	LastBlockTime     string  `json:"last_block_time,omitempty"`
+	HeadBlockNum      float64 `json:"head_block_num"`
+	HeadBlockId       string  `json:"head_block_id"`
+	HeadPollError     string  `json:"head_poll_error,omitempty"`
+	BlocksBehind      float64 `json:"blocks_behind"`
+	MaxBlocksBehind   float64 `json:"max_blocks_behind"`
+	DisconnectedSince string  `json:"disconnected_since,omitempty"`
+	Reconnects        int     `json:"reconnects"`
+	OutageAlarm       bool    `json:"outage_alarm"`
+}
+
+func SetSyncConnected(connected bool, err error) {
+	g_sync_status.mutex.Lock()
+	defer g_sync_status.mutex.Unlock()
+	g_sync_status.connected = connected
+	if connected == false {
+		g_sync_status.subscribed = false
+		if g_sync_status.disconnectedSince.IsZero() {
+			g_sync_status.disconnectedSince = time.Now()
+		}
+	}
+	if err != nil {
+		g_sync_status.lastError = err.Error()
+	}
+}
+
+func SetSyncSubscribed(subscribed bool) {
+	g_sync_status.mutex.Lock()
+	defer g_sync_status.mutex.Unlock()
+	g_sync_status.subscribed = subscribed
+}
+
+//다시 연결되면 끊어진 시각과 alarm을 지운다.
+func ObserveSyncReconnect() {
+	g_sync_status.mutex.Lock()
+	defer g_sync_status.mutex.Unlock()
+	g_sync_status.reconnects++
+	reconnects.Inc()
+	g_sync_status.disconnectedSince = time.Time{}
+	g_sync_status.outageAlarm = false
+}
+
+func SetSyncOutageAlarm(alarm bool) {
+	g_sync_status.mutex.Lock()
+	defer g_sync_status.mutex.Unlock()
+	g_sync_status.outageAlarm = alarm
+}
+
+//시작할 때 database에 저장된 마지막 block으로 초기화한다. block을 처리한 시각은 모르므로 바꾸지 않는다.
+func SeedSyncedBlock(block_num float64, block_id string) {
+	g_sync_status.mutex.Lock()
+	defer g_sync_status.mutex.Unlock()
+	g_sync_status.lastBlockNum = block_num
+	g_sync_status.lastBlockId = block_id
+}
+
+//적용한 block. rollback, resync 후에는 이전보다 작은 block number일 수 있다.
+func ObserveSyncedBlock(block_num float64, block_id string) {
+	g_sync_status.mutex.Lock()
+	defer g_sync_status.mutex.Unlock()
+	g_sync_status.lastBlockNum = block_num
+	g_sync_status.lastBlockId = block_id
+	g_sync_status.lastBlockTime = time.Now()
+	if block_num > g_sync_status.headBlockNum {
+		g_sync_status.headBlockNum = block_num
+		g_sync_status.headBlockId = block_id
+	}
+}
+
+func GetSyncHealthReport() *SyncHealthReport {
+	g_sync_status.mutex.Lock()
+	defer g_sync_status.mutex.Unlock()
+
+	report := &SyncHealthReport{
+		Connected:       g_sync_status.connected,
+		Subscribed:      g_sync_status.subscribed,
+		LastError:       g_sync_status.lastError,
+		LastBlockNum:    g_sync_status.lastBlockNum,
+		LastBlockId:     g_sync_status.lastBlockId,
+		HeadBlockNum:    g_sync_status.headBlockNum,
+		HeadBlockId:     g_sync_status.headBlockId,
+		HeadPollError:   g_sync_status.headPollError,
+		MaxBlocksBehind: g_sync_status.maxLag,
+		Reconnects:      g_sync_status.reconnects,
+		OutageAlarm:     g_sync_status.outageAlarm,
+	}
+
+	if g_sync_status.lastBlockTime.IsZero() == false {
+		report.LastBlockTime = g_sync_status.lastBlockTime.Format(time.RFC3339)
+	}
+
+	if g_sync_status.disconnectedSince.IsZero() == false {
+		report.DisconnectedSince = g_sync_status.disconnectedSince.Format(time.RFC3339)
+	}
+
+	report.BlocksBehind = report.HeadBlockNum - report.LastBlockNum
+	if report.BlocksBehind < 0 {
+		report.BlocksBehind = 0
+	}
+
+	if report.Connected && report.Subscribed && report.BlocksBehind <= report.MaxBlocksBehind {
+		report.Status = "ok"
+	} else {
+		report.Status = "unavailable"
+	}
+	return report
+}
+
+type restBlockList struct {
+	Data []struct {
+		Header struct {
+			BlockNum string `json:"block_num"`
+		} `json:"header"`
+		HeaderSignature string `json:"header_signature"`
+	} `json:"data"`
+}
+
+//REST API에서 chain head block을 읽어 온다.
+func pollChainHead() {
+	g_sync_status.mutex.Lock()
+	rest_addr := g_sync_status.restAddr
+	g_sync_status.mutex.Unlock()
+
+	head_num, head_id, err := getChainHead(rest_addr)
+
+	g_sync_status.mutex.Lock()
+	defer g_sync_status.mutex.Unlock()
+	if err != nil {
+		g_sync_status.headPollError = err.Error()
+		return
+	}
+	g_sync_status.headPollError = ""
+	g_sync_status.headBlockNum = head_num
+	g_sync_status.headBlockId = head_id
+}
+
+func getChainHead(rest_addr string) (float64, string, error) {
+	resp, err := restRequest(restURL(rest_addr, "/blocks?limit=1"))
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var blocks restBlockList
+	err = json.Unmarshal(data, &blocks)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if len(blocks.Data) == 0 {
+		return 0, "", nil
+	}
+
+	head_num, err := strconv.ParseFloat(blocks.Data[0].Header.BlockNum, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	return head_num, blocks.Data[0].HeaderSignature, nil
+}
+
+func writeSyncHealthReport(w http.ResponseWriter, report *SyncHealthReport, healthy bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+var g_head_poller_once sync.Once
+
+//health, metrics listener가 같은 poller를 사용한다.
+func startChainHeadPoller(rest_addr string) {
+	g_head_poller_once.Do(func() {
+		g_sync_status.mutex.Lock()
+		g_sync_status.restAddr = rest_addr
+		g_sync_status.mutex.Unlock()
+
+		go func() {
+			for {
+				pollChainHead()
+				time.Sleep(chainHeadPollInterval)
+			}
+		}()
+	})
+}
+
+//healthz는 websocket 연결 상태를, readyz는 구독 중이며 chain head와의 차이가 max_lag 이하인지를 보고한다.
+func StartHealthListener(addr string, rest_addr string, max_lag float64) {
+	g_sync_status.mutex.Lock()
+	g_sync_status.maxLag = max_lag
+	g_sync_status.mutex.Unlock()
+
+	startChainHeadPoller(rest_addr)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		report := GetSyncHealthReport()
+		writeSyncHealthReport(w, report, report.Connected)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		report := GetSyncHealthReport()
+		writeSyncHealthReport(w, report, report.Status == "ok")
+	})
+
+	go func() {
+		log.Printf("Serving health checks on %s", addr)
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			log.Printf("Health listener stopped: %v", err)
+		}
+	}()
+}
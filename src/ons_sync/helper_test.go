@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"protobuf/ons_pb2"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+//임시 SQLite store를 g_store로 연결한다. test가 끝나면 닫고 지운다.
+func openTestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ons_sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	DBConnect(&StoreOptions{Backend: STORE_SQLITE, Address: filepath.Join(dir, "ons_ledger.db")}, false)
+	g_pending_blocks = make(map[string][]*ONSEvent)
+	g_recover_pending = true
+	t.Cleanup(func() {
+		DBDisconnect()
+		os.RemoveAll(dir)
+	})
+	_, err = DBGetLatestUpdatedBlockInfo(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gs1CodeAddress(gs1_code string) string {
+	return namespace + hexdigest("gs1")[:8] + hexdigest(gs1_code)[:56]
+}
+
+func newTestGS1Code(gs1_code string, owner_id string) *ons_pb2.GS1CodeData {
+	return &ons_pb2.GS1CodeData{Gs1Code: gs1_code, OwnerId: owner_id, State: ons_pb2.GS1CodeData_GS1CODE_ACTIVE}
+}
+
+//GS1 code를 저장하는 block delta의 state change.
+func gs1CodeChange(t *testing.T, gs1_code_data *ons_pb2.GS1CodeData) map[string]string {
+	data, err := proto.Marshal(gs1_code_data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{
+		"type":    "SET",
+		"address": gs1CodeAddress(gs1_code_data.Gs1Code),
+		"value":   base64.StdEncoding.EncodeToString(data),
+	}
+}
+
+//GS1 code의 owner. GS1 code가 없으면 비어 있다.
+func testGS1CodeOwner(t *testing.T, gs1_code string) string {
+	gs1_code_event, err := DBGetGS1Code(gs1_code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gs1_code_event == nil {
+		return ""
+	}
+	return gs1_code_event.OwnerId
+}
+
+//bootstrap, verify가 읽는 REST API의 /blocks, /state. state는 head block의 state이다.
+type testRESTServer struct {
+	*httptest.Server
+	mutex  sync.Mutex
+	head   *BlockInfo
+	blocks map[string]*BlockInfo
+	state  map[string][]byte
+	//설정하면 /state에 응답하기 전에 호출한다.
+	onState func()
+}
+
+func startTestRESTServer(t *testing.T) *testRESTServer {
+	s := &testRESTServer{blocks: map[string]*BlockInfo{}, state: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+//REST API address (host:port)
+func (s *testRESTServer) Addr() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+//head block과 head block의 state를 바꾼다.
+func (s *testRESTServer) SetHead(head *BlockInfo, state map[string][]byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.head = head
+	s.blocks[head.BlockId] = head
+	s.state = state
+}
+
+func (s *testRESTServer) serve(w http.ResponseWriter, req *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	restBlockOf := func(block *BlockInfo) restBlock {
+		rest_block := restBlock{HeaderSignature: block.BlockId}
+		rest_block.Header.BlockNum = strconv.FormatFloat(block.BlockNum, 'f', -1, 64)
+		rest_block.Header.PreviousBlockId = block.PreviousBlockId
+		return rest_block
+	}
+
+	var resp interface{}
+	switch {
+	case req.URL.Path == "/blocks" && s.head != nil:
+		resp = map[string]interface{}{"data": []restBlock{restBlockOf(s.head)}}
+	case strings.HasPrefix(req.URL.Path, "/blocks/") && s.blocks[strings.TrimPrefix(req.URL.Path, "/blocks/")] != nil:
+		resp = map[string]interface{}{"data": restBlockOf(s.blocks[strings.TrimPrefix(req.URL.Path, "/blocks/")])}
+	case req.URL.Path == "/state" && s.head != nil && req.URL.Query().Get("head") == s.head.BlockId:
+		if s.onState != nil {
+			s.onState()
+		}
+		states := restStateList{Head: s.head.BlockId}
+		for address, value := range s.state {
+			states.Data = append(states.Data, struct {
+				Address string `json:"address"`
+				Data    string `json:"data"`
+			}{address, base64.StdEncoding.EncodeToString(value)})
+		}
+		resp = states
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func gs1CodeState(t *testing.T, gs1_code_data *ons_pb2.GS1CodeData) []byte {
+	data, err := proto.Marshal(gs1_code_data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
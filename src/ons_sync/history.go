@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+//GS1 code가 block에서 바뀐 값. Value는 GS1 code의 state value이고 nil이면 삭제된 version(tombstone)이다.
+//SyncedAt은 ons_sync가 block을 적용한 시간(unix time)이다. 바뀐 시간을 모르는 version은 0이다.
+//(이전 database에서 가져온 version, bootstrap snapshot, repair는 건너뛴 block 중 언제 바뀌었는지 모른다)
+type GS1CodeVersion struct {
+	Id       string `gorethink:"id"`
+	Gs1Code  string
+	BlockNum float64
+	BlockId  string
+	SyncedAt int64
+	Address  string
+	Value    []byte
+}
+
+//as_of_block, as_of_time 조건. 둘 다 있으면 두 조건을 모두 만족하는 마지막 version을 찾는다.
+type AsOf struct {
+	BlockNum    float64
+	HasBlockNum bool
+	Time        int64
+	HasTime     bool
+}
+
+func gs1CodeVersionId(gs1_code string, block_num float64) string {
+	return gs1_code + ":" + strconv.FormatFloat(block_num, 'f', -1, 64)
+}
+
+//address가 GS1 code이면 block에서 바뀐 value를 version으로 저장한다.
+//value가 nil이면 prev_value의 GS1 code로 tombstone을 저장한다. synced_at이 0이면 바뀐 시간을 모르는 version이다.
+func addGS1CodeVersion(store Store, address string, prev_value []byte, value []byte, block *BlockInfo, synced_at int64) error {
+	if GetTableIdxByAddress(address) != GS1_CODE_TABLE {
+		return nil
+	}
+	//decode 할 수 없는 value는 적용하지 않으므로 version도 저장하지 않는다.
+	gs1_code_event := decodeGS1CodeValue(address, value, block.BlockNum)
+	if value == nil {
+		gs1_code_event = decodeGS1CodeValue(address, prev_value, block.BlockNum)
+	}
+	if gs1_code_event == nil {
+		return nil
+	}
+	return store.AddGS1CodeVersion(&GS1CodeVersion{
+		Id:       gs1CodeVersionId(gs1_code_event.Gs1Code, block.BlockNum),
+		Gs1Code:  gs1_code_event.Gs1Code,
+		BlockNum: block.BlockNum,
+		BlockId:  block.BlockId,
+		SyncedAt: synced_at,
+		Address:  address,
+		Value:    value,
+	})
+}
+
+//history를 저장하기 전의 database이면 현재 GS1 code를 마지막으로 바뀐 block의 version으로 저장한다.
+func BackfillGS1CodeHistory() error {
+	has_versions, err := DBHasGS1CodeVersions()
+	if err != nil || has_versions == true {
+		return err
+	}
+
+	count := 0
+	err = forEachPage(func(page Page) (int, int, error) {
+		gs1_codes, total, err := DBListGS1Codes(&GS1CodeFilter{}, page)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, gs1_code := range gs1_codes {
+			state_value, err := DBGetStateValue(gs1_code.Address)
+			if err != nil {
+				return 0, 0, err
+			}
+			if state_value.Value == nil {
+				continue
+			}
+			err = g_store.AddGS1CodeVersion(&GS1CodeVersion{
+				Id:       gs1CodeVersionId(gs1_code.Gs1Code, gs1_code.BlockNum),
+				Gs1Code:  gs1_code.Gs1Code,
+				BlockNum: gs1_code.BlockNum,
+				Address:  gs1_code.Address,
+				Value:    state_value.Value,
+			})
+			if err != nil {
+				return 0, 0, err
+			}
+			count++
+		}
+		return len(gs1_codes), total, nil
+	})
+	if count > 0 {
+		log.Printf("GS1 code history : %d versions are added from the current GS1 codes\n", count)
+	}
+	return err
+}
+
+//as_of_time 이전에 바뀌었는지 알 수 없는 version이 있어서 as_of_time 시점의 GS1 code를 정할 수 없다.
+type unknownVersionTimeError struct {
+	Gs1Code  string
+	BlockNum float64
+}
+
+func (e *unknownVersionTimeError) Error() string {
+	return fmt.Sprintf("the time GS1 code %s changed at block %v is unknown, use as_of_block", e.Gs1Code, e.BlockNum)
+}
+
+//as_of 시점의 GS1 code. 등록되지 않았거나 삭제되었으면 nil을 반환한다.
+//BlockNum은 GS1 code가 마지막으로 바뀐 block이다.
+//as_of_time이면 시간을 아는 version 중 마지막 version을 찾고, 다음 version의 시간을 모르면 unknownVersionTimeError를 반환한다.
+func DBGetGS1CodeAsOf(gs1_code string, as_of *AsOf) (*ONSGS1CodeEvent, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, err
+	}
+	version, err := g_store.GetGS1CodeVersion(gs1_code, as_of)
+	if err != nil {
+		return nil, err
+	}
+	if as_of.HasTime == true {
+		block_num := float64(-1)
+		if version != nil {
+			block_num = version.BlockNum
+		}
+		next, err := g_store.NextGS1CodeVersion(gs1_code, block_num)
+		if err != nil {
+			return nil, err
+		}
+		if next != nil && next.SyncedAt == 0 && (as_of.HasBlockNum == false || next.BlockNum <= as_of.BlockNum) {
+			return nil, &unknownVersionTimeError{Gs1Code: gs1_code, BlockNum: next.BlockNum}
+		}
+	}
+	if version == nil || version.Value == nil {
+		return nil, nil
+	}
+	gs1_code_event := decodeGS1CodeValue(version.Address, version.Value, version.BlockNum)
+	if gs1_code_event == nil {
+		return nil, fmt.Errorf("invalid version of GS1 code %s at block %v", gs1_code, version.BlockNum)
+	}
+	return gs1_code_event, nil
+}
+
+func DBHasGS1CodeVersions() (bool, error) {
+	if err := checkDBSession(); err != nil {
+		return false, err
+	}
+	return g_store.HasGS1CodeVersions()
+}
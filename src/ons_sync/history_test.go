@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGS1CodeAsOf(t *testing.T) {
+	openTestStore(t)
+	versions := []struct {
+		block_num float64
+		synced_at int64
+		owner_id  string
+	}{
+		{1, 100, "owner-1"},
+		{5, 200, "owner-2"},
+		//삭제된 version
+		{8, 300, ""},
+		{9, 400, "owner-3"},
+	}
+	for _, version := range versions {
+		gs1_code_version := &GS1CodeVersion{
+			Id:       gs1CodeVersionId("1", version.block_num),
+			Gs1Code:  "1",
+			BlockNum: version.block_num,
+			SyncedAt: version.synced_at,
+			Address:  gs1CodeAddress("1"),
+		}
+		if len(version.owner_id) > 0 {
+			gs1_code_version.Value = gs1CodeState(t, newTestGS1Code("1", version.owner_id))
+		}
+		err := g_store.AddGS1CodeVersion(gs1_code_version)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		as_of    *AsOf
+		owner_id string
+	}{
+		{&AsOf{Time: 50, HasTime: true}, ""},
+		{&AsOf{Time: 150, HasTime: true}, "owner-1"},
+		{&AsOf{Time: 250, HasTime: true, BlockNum: 3, HasBlockNum: true}, "owner-1"},
+		{&AsOf{BlockNum: 6, HasBlockNum: true}, "owner-2"},
+		{&AsOf{BlockNum: 8, HasBlockNum: true}, ""},
+		{&AsOf{Time: 450, HasTime: true}, "owner-3"},
+	}
+	for _, test := range tests {
+		gs1_code_event, err := DBGetGS1CodeAsOf("1", test.as_of)
+		if err != nil {
+			t.Fatal(err)
+		}
+		owner_id := ""
+		if gs1_code_event != nil {
+			owner_id = gs1_code_event.OwnerId
+		}
+		if owner_id != test.owner_id {
+			t.Errorf("as of %+v : owner %q, want %q", test.as_of, owner_id, test.owner_id)
+		}
+	}
+
+	has_versions, err := DBHasGS1CodeVersions()
+	if err != nil || has_versions == false {
+		t.Errorf("GS1 code history has no versions, %v", err)
+	}
+}
+
+func TestGS1CodeAsOfTime(t *testing.T) {
+	openTestStore(t)
+	//block 5는 bootstrap snapshot의 version이므로 바뀐 시간을 모른다.
+	versions := []struct {
+		block_num float64
+		synced_at int64
+		owner_id  string
+	}{
+		{1, 100, "owner-1"},
+		{5, 0, "owner-2"},
+		{8, 300, "owner-3"},
+	}
+	for _, version := range versions {
+		err := g_store.AddGS1CodeVersion(&GS1CodeVersion{
+			Id:       gs1CodeVersionId("1", version.block_num),
+			Gs1Code:  "1",
+			BlockNum: version.block_num,
+			SyncedAt: version.synced_at,
+			Address:  gs1CodeAddress("1"),
+			Value:    gs1CodeState(t, newTestGS1Code("1", version.owner_id)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		as_of    *AsOf
+		owner_id string
+		unknown  bool
+	}{
+		{&AsOf{Time: 50, HasTime: true}, "", false},
+		//block 5의 version이 150 이전일 수 있다.
+		{&AsOf{Time: 150, HasTime: true}, "", true},
+		{&AsOf{Time: 150, HasTime: true, BlockNum: 3, HasBlockNum: true}, "owner-1", false},
+		{&AsOf{Time: 350, HasTime: true}, "owner-3", false},
+		{&AsOf{BlockNum: 6, HasBlockNum: true}, "owner-2", false},
+	}
+	for _, test := range tests {
+		gs1_code_event, err := DBGetGS1CodeAsOf("1", test.as_of)
+		_, unknown := err.(*unknownVersionTimeError)
+		if err != nil && unknown == false {
+			t.Fatal(err)
+		}
+		owner_id := ""
+		if gs1_code_event != nil {
+			owner_id = gs1_code_event.OwnerId
+		}
+		if owner_id != test.owner_id || unknown != test.unknown {
+			t.Errorf("as of %+v : owner %q, unknown time %v, want %q, %v", test.as_of, owner_id, unknown, test.owner_id, test.unknown)
+		}
+	}
+}
@@ -8,17 +8,152 @@ import (
 )
 
 func main() {
-	addr := flag.String("addr", "198.13.60.39:8080", "REST API Server address")
+	defaults := DefaultConfig()
+	flags := DefaultConfig()
+	rest_headers := headerList{}
+	config_path := flag.String("config", os.Getenv("ONS_SYNC_CONFIG"), "TOML config file path, values are overridden by ONS_SYNC_* env and command line options (env : ONS_SYNC_CONFIG)")
+	flag.StringVar(&flags.Rest.Address, "addr", defaults.Rest.Address, "REST API Server address (host:port, https://host:port to use https and wss)")
+	flag.Var(&rest_headers, "rest-header", "Header added to REST API and websocket requests, e.g. \"Authorization: Bearer [token]\" (repeatable)")
+	flag.BoolVar(&flags.Rest.TLS.Enabled, "rest-tls", false, "Connect to the REST API with https and wss")
+	flag.StringVar(&flags.Rest.TLS.CAFile, "rest-ca", "", "CA certificate file (PEM) to verify the REST API server (default : system CA)")
+	flag.StringVar(&flags.Rest.TLS.CertFile, "rest-cert", "", "Client certificate file (PEM) for the REST API")
+	flag.StringVar(&flags.Rest.TLS.KeyFile, "rest-key", "", "Client key file (PEM) for the REST API")
+	flag.StringVar(&flags.Rest.TLS.ServerName, "rest-server-name", "", "Server name to verify the REST API certificate (default : host of -addr)")
+	flag.BoolVar(&flags.Rest.TLS.InsecureSkipVerify, "rest-insecure", false, "Do not verify the REST API certificate")
+	flag.StringVar(&flags.Health, "health", "", "Address to serve /healthz and /readyz on (e.g. :9201), disabled if empty")
+	flag.StringVar(&flags.Metrics, "metrics", "", "Address to serve Prometheus metrics on (e.g. :9203), disabled if empty")
+	health_max_lag := flag.Float64("health-max-lag", 10, "The number of blocks behind the chain head tolerated by /readyz")
+	flag.StringVar(&flags.API, "api", "", "Address to serve the JSON query API on (e.g. :9202), disabled if empty")
+	flag.StringVar(&flags.DB.Store, "store", defaults.DB.Store, "Storage backend : rethinkdb, sqlite or postgres")
+	flag.StringVar(&flags.DB.Address, "db", "", "RethinkDB address, SQLite file path or PostgreSQL connection string (default : localhost:28015, ons_ledger.db, dbname=ons_ledger sslmode=disable)")
+	flag.StringVar(&flags.DB.Name, "dbname", defaults.DB.Name, "RethinkDB database name")
+	flag.StringVar(&flags.DB.Username, "db-user", "", "RethinkDB or PostgreSQL user")
+	flag.StringVar(&flags.DB.Password, "db-password", "", "RethinkDB or PostgreSQL password, prefer -db-password-file or ONS_SYNC_DB_PASSWORD")
+	flag.StringVar(&flags.DB.PasswordFile, "db-password-file", "", "File to read the RethinkDB or PostgreSQL password from")
+	flag.BoolVar(&flags.DB.TLS.Enabled, "db-tls", false, "Connect to RethinkDB or PostgreSQL with TLS")
+	flag.StringVar(&flags.DB.TLS.CAFile, "db-ca", "", "CA certificate file (PEM) to verify the database server (default : system CA)")
+	flag.StringVar(&flags.DB.TLS.CertFile, "db-cert", "", "Client certificate file (PEM) for the database")
+	flag.StringVar(&flags.DB.TLS.KeyFile, "db-key", "", "Client key file (PEM) for the database")
+	flag.StringVar(&flags.DB.TLS.ServerName, "db-server-name", "", "Server name to verify the RethinkDB certificate (default : host of -db)")
+	flag.BoolVar(&flags.DB.TLS.InsecureSkipVerify, "db-insecure", false, "Do not verify the database certificate")
+	resync := flag.Bool("resync", false, "Delete all synchronized data and rebuild the database from the state of the chain head")
+	bootstrap_gap := flag.Float64("bootstrap-gap", 100, "Bootstrap from the state of the chain head if the database is more blocks behind than this, disabled if negative")
+	flag.StringVar(&flags.Source, "source", defaults.Source, "Event source : websocket (REST API /subscriptions) or zmq (validator component endpoint)")
+	flag.StringVar(&flags.Validator, "validator", defaults.Validator, "Validator component endpoint used by the zmq event source")
+	flag.StringVar(&flags.Namespace, "namespace", defaults.Namespace, "Address prefix of the ONS transaction family (6 hex characters)")
+	flag.BoolVar(&flags.Verbose, "v", false, "Verbose log")
+	flag.DurationVar(&flags.Reconnect.MinBackoff, "reconnect-min-backoff", defaults.Reconnect.MinBackoff, "Delay before the first reconnect attempt when the websocket connection is lost")
+	flag.DurationVar(&flags.Reconnect.MaxBackoff, "reconnect-max-backoff", defaults.Reconnect.MaxBackoff, "Maximum delay between reconnect attempts")
+	flag.DurationVar(&flags.Reconnect.PingPeriod, "ping-period", defaults.Reconnect.PingPeriod, "Interval of websocket pings, keepalive is disabled if 0")
+	flag.DurationVar(&flags.Reconnect.PongWait, "pong-wait", defaults.Reconnect.PongWait, "The connection is considered lost if no pong is received within this time")
+	flag.DurationVar(&flags.Reconnect.OutageAlarm, "outage-alarm", defaults.Reconnect.OutageAlarm, "Log an alarm if the connection is lost for longer than this, disabled if 0")
+	flag.BoolVar(&flags.Verify.Enabled, "verify", false, "Compare the database with the chain state at the last block of the database, report missing, extra and divergent rows and exit")
+	flag.BoolVar(&flags.Verify.Repair, "repair", false, "With -verify, apply the chain state to the rows that differ")
+	flag.StringVar(&flags.WebhookToken, "webhook-token", "", "Bearer token of the /webhooks management API on the query API listener, disabled if empty")
+	flag.IntVar(&flags.Webhook.Attempts, "webhook-attempts", defaults.Webhook.Attempts, "Maximum number of attempts to deliver a webhook notification before it is moved to the dead letter log")
+	flag.DurationVar(&flags.Webhook.Timeout, "webhook-timeout", defaults.Webhook.Timeout, "Timeout of a webhook request")
+	flag.StringVar(&flags.Resolver.DNS, "resolver-dns", "", "Address to serve ONS DNS queries from the database on (udp and tcp, e.g. :5353), disabled if empty")
+	flag.StringVar(&flags.Resolver.HTTP, "resolver-http", "", "Address to serve the GS1 Digital Link resolver from the database on (e.g. :8090), disabled if empty")
+	flag.StringVar(&flags.Resolver.Root, "resolver-root", defaults.Resolver.Root, "ONS root domain of the DNS resolver")
+	resolver_ttl := flag.Uint("resolver-ttl", uint(defaults.Resolver.TTL), "TTL of NAPTR answers")
+	changes_buffer := flag.Int("changes-buffer", CHANGE_FEED_BUFFER_SIZE, "Number of recent changes kept for /changes clients resuming with Last-Event-ID")
 	flag.Parse()
+	flags.Resolver.TTL = uint32(*resolver_ttl)
 	log.SetFlags(0)
 
-	DBConnect("198.13.60.39:28016", "ons_ledger", false)
+	cfg, err := loadConfig(*config_path, flags, rest_headers)
+	if err != nil {
+		log.Printf("Invalid configuration : %v\n", err)
+		os.Exit(2)
+	}
+	namespace = cfg.Namespace
+	g_verbose = cfg.Verbose
+	g_webhook_token = cfg.WebhookToken
+	reconnect := cfg.Reconnect.ReconnectOptions(*bootstrap_gap)
+	webhook_options := cfg.Webhook.WebhookOptions()
+	if cfg.Verbose == true {
+		log.Printf("configuration : %s\n", cfg)
+	}
+
+	err = ConfigureREST(&cfg.Rest)
+	if err != nil {
+		log.Printf("Invalid configuration : %v\n", err)
+		os.Exit(2)
+	}
+	store_options, err := cfg.DB.StoreOptions()
+	if err != nil {
+		log.Printf("Invalid configuration : %v\n", err)
+		os.Exit(2)
+	}
+
+	DBConnect(store_options, cfg.Verbose)
 	DBGetLatestUpdatedBlockInfo(true)
+	SeedSyncedBlock(DBGetLatestUpdatedBlock())
+
+	if cfg.Verify.Enabled == true {
+		//repair 한 change를 webhook으로 보낸 후에 종료한다.
+		if cfg.Verify.Repair == true {
+			StartWebhookDispatcher(webhook_options)
+		}
+		report, err := Verify(cfg.Rest.Address, cfg.Verify.Repair, cfg.Verbose)
+		if report != nil {
+			PrintVerifyReport(report)
+		}
+		StopWebhookDispatcher()
+		DBDisconnect()
+		if err != nil {
+			log.Printf("Failed to verify : %v\n", err)
+			os.Exit(2)
+		}
+		if (cfg.Verify.Repair == true && len(report.Remaining) > 0) || (cfg.Verify.Repair == false && len(report.Issues) > 0) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *resync == false {
+		err := BackfillGS1CodeHistory()
+		if err != nil {
+			log.Printf("Failed to backfill GS1 code history : %v\n", err)
+		}
+		err = BackfillServiceTypeDocuments()
+		if err != nil {
+			log.Printf("Failed to backfill service type documents : %v\n", err)
+		}
+	}
+
+	//bootstrap으로 바뀐 state도 webhook으로 알린다.
+	StartWebhookDispatcher(webhook_options)
+
+	err = BootstrapIfNeeded(cfg.Rest.Address, *resync, *bootstrap_gap, cfg.Verbose)
+	if err != nil {
+		log.Printf("Failed to bootstrap : %v\n", err)
+		os.Exit(2)
+	}
+
+	if len(cfg.Health) > 0 {
+		StartHealthListener(cfg.Health, cfg.Rest.Address, *health_max_lag)
+	}
+
+	if len(cfg.Metrics) > 0 {
+		StartMetricsListener(cfg.Metrics, cfg.Rest.Address)
+	}
+
+	if len(cfg.API) > 0 {
+		StartChangeFeed(*changes_buffer)
+		StartAPIListener(cfg.API)
+	}
+
+	resolver, err := StartResolver(&cfg.Resolver, cfg.Verbose)
+	if err != nil {
+		log.Printf("Failed to start resolver : %v\n", err)
+		os.Exit(2)
+	}
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 
-	onsEvtHandler, err := NewONSEventHandler(*addr, "/subscriptions", false)
+	onsEvtHandler, err := NewEventSource(cfg.Source, cfg.Rest.Address, cfg.Validator, reconnect, cfg.Verbose)
 
 	if err != nil {
 		log.Printf("Failed to create ons event handler : ", err)
@@ -37,5 +172,121 @@ func main() {
 	//interrupt가 발생하면.. (ctrl-c와 같은..)
 	onsEvtHandler.Subscribe(false)
 	onsEvtHandler.Terminate(true)
+	if resolver != nil {
+		resolver.Stop()
+	}
+	StopWebhookDispatcher()
+	StopChangeFeed()
 	DBDisconnect()
-}
\ No newline at end of file
+}
+//default < config file < ONS_SYNC_* env < command line option 순서로 설정을 덮어쓴다.
+func loadConfig(path string, flags *Config, rest_headers headerList) (*Config, error) {
+	cfg := DefaultConfig()
+
+	if len(path) > 0 {
+		err := cfg.LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := cfg.ApplyEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Rest.Address = flags.Rest.Address
+		case "rest-header":
+			for _, header := range rest_headers {
+				cfg.Rest.AddHeader(header)
+			}
+		case "rest-tls":
+			cfg.Rest.TLS.Enabled = flags.Rest.TLS.Enabled
+		case "rest-ca":
+			cfg.Rest.TLS.CAFile = flags.Rest.TLS.CAFile
+		case "rest-cert":
+			cfg.Rest.TLS.CertFile = flags.Rest.TLS.CertFile
+		case "rest-key":
+			cfg.Rest.TLS.KeyFile = flags.Rest.TLS.KeyFile
+		case "rest-server-name":
+			cfg.Rest.TLS.ServerName = flags.Rest.TLS.ServerName
+		case "rest-insecure":
+			cfg.Rest.TLS.InsecureSkipVerify = flags.Rest.TLS.InsecureSkipVerify
+		case "health":
+			cfg.Health = flags.Health
+		case "api":
+			cfg.API = flags.API
+		case "metrics":
+			cfg.Metrics = flags.Metrics
+		case "store":
+			cfg.DB.Store = flags.DB.Store
+		case "db":
+			cfg.DB.Address = flags.DB.Address
+		case "dbname":
+			cfg.DB.Name = flags.DB.Name
+		case "db-user":
+			cfg.DB.Username = flags.DB.Username
+		case "db-password":
+			cfg.DB.Password = flags.DB.Password
+		case "db-password-file":
+			cfg.DB.PasswordFile = flags.DB.PasswordFile
+		case "db-tls":
+			cfg.DB.TLS.Enabled = flags.DB.TLS.Enabled
+		case "db-ca":
+			cfg.DB.TLS.CAFile = flags.DB.TLS.CAFile
+		case "db-cert":
+			cfg.DB.TLS.CertFile = flags.DB.TLS.CertFile
+		case "db-key":
+			cfg.DB.TLS.KeyFile = flags.DB.TLS.KeyFile
+		case "db-server-name":
+			cfg.DB.TLS.ServerName = flags.DB.TLS.ServerName
+		case "db-insecure":
+			cfg.DB.TLS.InsecureSkipVerify = flags.DB.TLS.InsecureSkipVerify
+		case "source":
+			cfg.Source = flags.Source
+		case "validator":
+			cfg.Validator = flags.Validator
+		case "namespace":
+			cfg.Namespace = flags.Namespace
+		case "v":
+			cfg.Verbose = flags.Verbose
+		case "webhook-token":
+			cfg.WebhookToken = flags.WebhookToken
+		case "resolver-dns":
+			cfg.Resolver.DNS = flags.Resolver.DNS
+		case "resolver-http":
+			cfg.Resolver.HTTP = flags.Resolver.HTTP
+		case "resolver-root":
+			cfg.Resolver.Root = flags.Resolver.Root
+		case "resolver-ttl":
+			cfg.Resolver.TTL = flags.Resolver.TTL
+		case "reconnect-min-backoff":
+			cfg.Reconnect.MinBackoff = flags.Reconnect.MinBackoff
+		case "reconnect-max-backoff":
+			cfg.Reconnect.MaxBackoff = flags.Reconnect.MaxBackoff
+		case "ping-period":
+			cfg.Reconnect.PingPeriod = flags.Reconnect.PingPeriod
+		case "pong-wait":
+			cfg.Reconnect.PongWait = flags.Reconnect.PongWait
+		case "outage-alarm":
+			cfg.Reconnect.OutageAlarm = flags.Reconnect.OutageAlarm
+		case "verify":
+			cfg.Verify.Enabled = flags.Verify.Enabled
+		case "repair":
+			cfg.Verify.Repair = flags.Verify.Repair
+		case "webhook-attempts":
+			cfg.Webhook.Attempts = flags.Webhook.Attempts
+		case "webhook-timeout":
+			cfg.Webhook.Timeout = flags.Webhook.Timeout
+		}
+	})
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"protobuf/ons_pb2"
+)
+
+const (
+	MANAGER_KIND_SU  = "su"
+	MANAGER_KIND_GS1 = "gs1"
+)
+
+//ONSManager state의 manager 한 명. su manager는 Gs1Code가 비어 있다.
+//BlockNum은 마지막으로 ONSManager state가 바뀐 block이고 GrantedBlockNum은 권한을 받은 block이다.
+type ONSManagerRow struct {
+	Id              string `gorethink:"id"`
+	Kind            string
+	Gs1Code         string
+	Address         string
+	BlockNum        float64
+	GrantedBlockNum float64
+}
+
+//manager list의 조건. 비어 있는 조건은 사용하지 않는다.
+type ManagerFilter struct {
+	//MANAGER_KIND_SU 또는 MANAGER_KIND_GS1
+	Kind    string
+	Gs1Code string
+}
+
+func managerRowId(kind string, gs1_code string, address string) string {
+	if kind == MANAGER_KIND_SU {
+		return kind + ":" + address
+	}
+	//GS1 code의 manager는 한 명이다.
+	return kind + ":" + gs1_code
+}
+
+func newManagerRows(ons_manager *ons_pb2.ONSManager, block_num float64) []*ONSManagerRow {
+	rows := []*ONSManagerRow{}
+	for _, su_manager := range ons_manager.GetSuAddresses() {
+		rows = append(rows, &ONSManagerRow{
+			Id:              managerRowId(MANAGER_KIND_SU, "", su_manager.GetAddress()),
+			Kind:            MANAGER_KIND_SU,
+			Address:         su_manager.GetAddress(),
+			BlockNum:        block_num,
+			GrantedBlockNum: block_num,
+		})
+	}
+	for _, manager := range ons_manager.GetManagerAddresses() {
+		rows = append(rows, &ONSManagerRow{
+			Id:              managerRowId(MANAGER_KIND_GS1, manager.GetGs1Code(), manager.GetAddress()),
+			Kind:            MANAGER_KIND_GS1,
+			Gs1Code:         manager.GetGs1Code(),
+			Address:         manager.GetAddress(),
+			BlockNum:        block_num,
+			GrantedBlockNum: block_num,
+		})
+	}
+	return rows
+}
+
+//이전에도 같은 권한이 있던 manager는 권한을 받은 block을 유지한다.
+//GS1 code의 manager가 바뀌었으면 새로 권한을 받은 것이다.
+//rollback이나 bootstrap으로 다시 생긴 권한은 그 state가 저장된 block을 권한을 받은 block으로 사용한다.
+func keepGrantedBlockNums(rows []*ONSManagerRow, stored []*ONSManagerRow) {
+	stored_map := make(map[string]*ONSManagerRow)
+	for _, row := range stored {
+		stored_map[row.Id] = row
+	}
+	for _, row := range rows {
+		prev, ok := stored_map[row.Id]
+		if ok == false || prev.Address != row.Address {
+			continue
+		}
+		if prev.GrantedBlockNum > 0 {
+			row.GrantedBlockNum = prev.GrantedBlockNum
+		} else if prev.BlockNum < row.GrantedBlockNum {
+			//GrantedBlockNum이 없던 row는 BlockNum을 사용한다.
+			row.GrantedBlockNum = prev.BlockNum
+		}
+	}
+}
+
+//ONSManager state 전체를 managers table에 반영한다. state에 없는 manager는 삭제한다.
+func DBUpdateManagers(ons_manager *ons_pb2.ONSManager, block_num float64) error {
+	if err := checkDBSession(); err != nil {
+		return err
+	}
+	return g_store.UpdateManagers(ons_manager, block_num, false)
+}
+
+//su manager, GS1 code manager를 id(kind:GS1 code 또는 kind:address) 순서로 읽는다.
+func DBListManagers(filter *ManagerFilter, page Page) ([]*ONSManagerRow, int, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, 0, err
+	}
+	return g_store.ListManagers(filter, page)
+}
+
+func DBDeleteManagers() error {
+	if err := checkDBSession(); err != nil {
+		return err
+	}
+	return g_store.DeleteManagers()
+}
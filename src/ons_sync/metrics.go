@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+//DB write의 종류
+const (
+	DB_WRITE_APPLY_BLOCK    = "apply_block"
+	DB_WRITE_ROLLBACK_BLOCK = "rollback_block"
+	DB_WRITE_BOOTSTRAP      = "bootstrap"
+)
+
+//ONS state가 아닌 address와 address를 알 수 없는 event의 table label
+const (
+	METRIC_TABLE_OTHER = "other"
+	METRIC_TABLE_EVENT = "event"
+)
+
+//block number, lag은 /readyz와 같은 값(g_sync_status)을 보고한다.
+var (
+	lastBlockNum = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "ons_sync",
+		Name:      "last_block_number",
+		Help:      "Number of the last block applied to the database.",
+	}, func() float64 {
+		return GetSyncHealthReport().LastBlockNum
+	})
+
+	lastBlockTime = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "ons_sync",
+		Name:      "last_block_timestamp_seconds",
+		Help:      "Unix time when the last block was applied, 0 if no block was applied since start.",
+	}, func() float64 {
+		g_sync_status.mutex.Lock()
+		defer g_sync_status.mutex.Unlock()
+		if g_sync_status.lastBlockTime.IsZero() == true {
+			return 0
+		}
+		return float64(g_sync_status.lastBlockTime.Unix())
+	})
+
+	headBlockNum = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "ons_sync",
+		Name:      "head_block_number",
+		Help:      "Number of the chain head block seen through the REST API.",
+	}, func() float64 {
+		return GetSyncHealthReport().HeadBlockNum
+	})
+
+	blocksBehind = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "ons_sync",
+		Name:      "blocks_behind",
+		Help:      "Number of blocks between the chain head and the last applied block.",
+	}, func() float64 {
+		return GetSyncHealthReport().BlocksBehind
+	})
+
+	connected = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "ons_sync",
+		Name:      "connected",
+		Help:      "1 if the event source is connected, 0 otherwise.",
+	}, func() float64 {
+		if GetSyncHealthReport().Connected == true {
+			return 1
+		}
+		return 0
+	})
+
+	blocksApplied = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "ons_sync",
+		Name:      "blocks_applied_total",
+		Help:      "Number of blocks applied to the database.",
+	})
+
+	blocksRolledBack = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "ons_sync",
+		Name:      "blocks_rolled_back_total",
+		Help:      "Number of blocks rolled back because of forks.",
+	})
+
+	deltasApplied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ons_sync",
+		Name:      "deltas_applied_total",
+		Help:      "Number of state deltas applied from blocks, by table.",
+	}, []string{"table"})
+
+	decodeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ons_sync",
+		Name:      "decode_failures_total",
+		Help:      "Number of events and state values that could not be decoded, by table.",
+	}, []string{"table"})
+
+	dbWriteLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ons_sync",
+		Name:      "db_write_duration_seconds",
+		Help:      "Latency of database transactions, from begin to commit.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"op"})
+
+	reconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "ons_sync",
+		Name:      "reconnects_total",
+		Help:      "Number of times the event source reconnected.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(lastBlockNum, lastBlockTime, headBlockNum, blocksBehind, connected,
+		blocksApplied, blocksRolledBack, deltasApplied, decodeFailures, dbWriteLatency, reconnects)
+}
+
+//address의 table 이름. ONS state가 아니면 other이다.
+func metricTable(address string) string {
+	if len(address) < 14 {
+		return METRIC_TABLE_OTHER
+	}
+	switch table_idx := GetTableIdxByAddress(address); table_idx {
+	case GS1_CODE_TABLE, SERVICE_TYPE_TABLE, MANAGER_TABLE:
+		return g_table_names[table_idx]
+	}
+	return METRIC_TABLE_OTHER
+}
+
+//commit 한 block의 state change를 table별로 센다.
+func ObserveBlockApplied(changes []*stateChange) {
+	blocksApplied.Inc()
+	for _, change := range changes {
+		deltasApplied.WithLabelValues(metricTable(change.Address)).Inc()
+	}
+}
+
+func ObserveBlockRolledBack() {
+	blocksRolledBack.Inc()
+}
+
+//address가 비어 있으면 event 전체를 decode 하지 못한 것이다.
+func ObserveDecodeFailure(address string) {
+	if len(address) == 0 {
+		decodeFailures.WithLabelValues(METRIC_TABLE_EVENT).Inc()
+		return
+	}
+	decodeFailures.WithLabelValues(metricTable(address)).Inc()
+}
+
+func ObserveDBWrite(op string, start time.Time) {
+	dbWriteLatency.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+//addr로 /metrics를 제공하는 http listener를 background로 실행한다.
+//head_block_number, blocks_behind를 위해 chain head를 주기적으로 읽는다.
+func StartMetricsListener(addr string, rest_addr string) {
+	startChainHeadPoller(rest_addr)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		log.Printf("Serving metrics on %s/metrics", addr)
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			log.Printf("Metrics listener stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,476 @@
+package main
+
+//query API의 OpenAPI 3.0 spec. GET /openapi.yaml로 제공한다.
+const openAPISpec = `openapi: 3.0.3
+info:
+  title: ONS sync query API
+  description: JSON query API over the ONS data synchronized by ons_sync.
+  version: 1.0.0
+paths:
+  /gs1codes:
+    get:
+      summary: List GS1 codes
+      parameters:
+        - name: owner
+          in: query
+          description: Public key of the GS1 code owner
+          schema: {type: string}
+        - name: provider
+          in: query
+          description: Provider of any record
+          schema: {type: string}
+        - name: state
+          in: query
+          description: GS1 code state (GS1CODE_ACTIVE, ACTIVE, active or 2)
+          schema: {type: string}
+        - name: service_type
+          in: query
+          description: Service of any record
+          schema: {type: string}
+        - $ref: '#/components/parameters/offset'
+        - $ref: '#/components/parameters/limit'
+      responses:
+        '200':
+          description: A page of GS1 codes ordered by GS1 code
+          content:
+            application/json:
+              schema:
+                allOf:
+                  - $ref: '#/components/schemas/List'
+                  - type: object
+                    properties:
+                      items:
+                        type: array
+                        items: {$ref: '#/components/schemas/GS1Code'}
+        '400': {$ref: '#/components/responses/Error'}
+  /gs1codes/{code}:
+    get:
+      summary: Get a GS1 code
+      parameters:
+        - name: code
+          in: path
+          required: true
+          schema: {type: string}
+        - name: as_of_block
+          in: query
+          description: Return the GS1 code as it was at this block number
+          schema: {type: integer, minimum: 0}
+        - name: as_of_time
+          in: query
+          description: >-
+            Return the GS1 code as it was synced at this time (RFC 3339 or unix time).
+            Fails with 422 if the GS1 code changed at an unknown time (bootstrap, repair or a database without history) that may be before it.
+          schema: {type: string}
+      responses:
+        '200':
+          description: The GS1 code. With as_of_block or as_of_time, block_num is the block of the version.
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/GS1Code'}
+        '400': {$ref: '#/components/responses/Error'}
+        '404': {$ref: '#/components/responses/Error'}
+        '422': {$ref: '#/components/responses/Error'}
+  /servicetypes:
+    get:
+      summary: List service types
+      parameters:
+        - name: provider
+          in: query
+          description: Public key of the service type provider
+          schema: {type: string}
+        - name: fields.{path}
+          in: query
+          description: >-
+            Value of a nested field of the service type document, e.g. fields.protocol.version=2.0.
+            The path is a list of object keys separated by dots. Strings, numbers and booleans
+            are compared with their JSON text (strings without quotes).
+            Repeat the parameter with other paths to match all of them.
+          schema: {type: string}
+        - $ref: '#/components/parameters/offset'
+        - $ref: '#/components/parameters/limit'
+      responses:
+        '200':
+          description: A page of service types ordered by address
+          content:
+            application/json:
+              schema:
+                allOf:
+                  - $ref: '#/components/schemas/List'
+                  - type: object
+                    properties:
+                      items:
+                        type: array
+                        items: {$ref: '#/components/schemas/ServiceType'}
+        '400': {$ref: '#/components/responses/Error'}
+  /servicetypes/{address}:
+    get:
+      summary: Get a service type
+      parameters:
+        - name: address
+          in: path
+          required: true
+          schema: {type: string}
+      responses:
+        '200':
+          description: The service type
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/ServiceType'}
+        '404': {$ref: '#/components/responses/Error'}
+  /owners/{key}/gs1codes:
+    get:
+      summary: List GS1 codes owned by a public key
+      parameters:
+        - $ref: '#/components/parameters/key'
+        - $ref: '#/components/parameters/offset'
+        - $ref: '#/components/parameters/limit'
+      responses:
+        '200':
+          description: A page of GS1 codes ordered by GS1 code
+          content:
+            application/json:
+              schema:
+                allOf:
+                  - $ref: '#/components/schemas/List'
+                  - type: object
+                    properties:
+                      items:
+                        type: array
+                        items: {$ref: '#/components/schemas/GS1Code'}
+        '400': {$ref: '#/components/responses/Error'}
+  /providers/{key}/records:
+    get:
+      summary: List records provided by a public key
+      parameters:
+        - $ref: '#/components/parameters/key'
+        - $ref: '#/components/parameters/offset'
+        - $ref: '#/components/parameters/limit'
+      responses:
+        '200':
+          description: A page of records ordered by GS1 code and record index
+          content:
+            application/json:
+              schema:
+                allOf:
+                  - $ref: '#/components/schemas/List'
+                  - type: object
+                    properties:
+                      items:
+                        type: array
+                        items: {$ref: '#/components/schemas/ProviderRecord'}
+        '400': {$ref: '#/components/responses/Error'}
+  /managers:
+    get:
+      summary: List super managers and GS1 code managers
+      parameters:
+        - name: kind
+          in: query
+          description: su for super managers, gs1 for GS1 code managers
+          schema: {type: string, enum: [su, gs1]}
+        - name: gs1_code
+          in: query
+          description: Manager of a GS1 code
+          schema: {type: string}
+        - $ref: '#/components/parameters/offset'
+        - $ref: '#/components/parameters/limit'
+      responses:
+        '200':
+          description: A page of managers ordered by kind and GS1 code or address
+          content:
+            application/json:
+              schema:
+                allOf:
+                  - $ref: '#/components/schemas/List'
+                  - type: object
+                    properties:
+                      items:
+                        type: array
+                        items: {$ref: '#/components/schemas/Manager'}
+        '400': {$ref: '#/components/responses/Error'}
+  /managers/{key}/gs1codes:
+    get:
+      summary: List GS1 codes managed by a public key
+      parameters:
+        - $ref: '#/components/parameters/key'
+        - $ref: '#/components/parameters/offset'
+        - $ref: '#/components/parameters/limit'
+      responses:
+        '200':
+          description: A page of managed GS1 codes ordered by GS1 code
+          content:
+            application/json:
+              schema:
+                allOf:
+                  - $ref: '#/components/schemas/List'
+                  - type: object
+                    properties:
+                      items:
+                        type: array
+                        items: {$ref: '#/components/schemas/ManagedGS1Code'}
+        '400': {$ref: '#/components/responses/Error'}
+  /changes:
+    get:
+      summary: Stream ONS changes as Server-Sent Events
+      description: >
+        Each event carries the JSON change notification that is also posted to webhooks.
+        The event id is the block number, set on the last change of each block.
+        A client reconnecting with Last-Event-ID receives the changes after that block,
+        or an event named reset if they are no longer buffered.
+      parameters:
+        - name: gs1_prefix
+          in: query
+          description: Only changes of GS1 codes starting with this prefix
+          schema: {type: string}
+        - name: Last-Event-ID
+          in: header
+          description: Block number of the last received event
+          schema: {type: integer, minimum: 0}
+        - name: last_event_id
+          in: query
+          description: Same as Last-Event-ID for clients that cannot set headers
+          schema: {type: integer, minimum: 0}
+      responses:
+        '200':
+          description: An event stream that is kept open
+          content:
+            text/event-stream:
+              schema: {type: string}
+        '400': {$ref: '#/components/responses/Error'}
+        '503': {$ref: '#/components/responses/Error'}
+  /webhooks:
+    get:
+      summary: List webhooks, available if ons_sync is started with -webhook-token
+      security: [{webhookToken: []}]
+      responses:
+        '200':
+          description: Registered webhooks without secrets
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  items:
+                    type: array
+                    items: {$ref: '#/components/schemas/Webhook'}
+        '401': {$ref: '#/components/responses/Error'}
+    post:
+      summary: Register a webhook
+      security: [{webhookToken: []}]
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/WebhookRequest'}
+      responses:
+        '201':
+          description: The registered webhook including the secret
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/Webhook'}
+        '400': {$ref: '#/components/responses/Error'}
+        '401': {$ref: '#/components/responses/Error'}
+  /webhooks/{id}:
+    delete:
+      summary: Delete a webhook
+      security: [{webhookToken: []}]
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema: {type: string}
+      responses:
+        '204': {description: Deleted}
+        '401': {$ref: '#/components/responses/Error'}
+        '404': {$ref: '#/components/responses/Error'}
+  /webhooks/{id}/ping:
+    post:
+      summary: Send a test notification once
+      security: [{webhookToken: []}]
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema: {type: string}
+      responses:
+        '200':
+          description: The webhook responded with 2xx
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/WebhookPing'}
+        '502':
+          description: The webhook failed
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/WebhookPing'}
+        '401': {$ref: '#/components/responses/Error'}
+        '404': {$ref: '#/components/responses/Error'}
+  /webhooks/dead-letters:
+    get:
+      summary: List notifications that could not be delivered
+      security: [{webhookToken: []}]
+      parameters:
+        - $ref: '#/components/parameters/offset'
+        - $ref: '#/components/parameters/limit'
+      responses:
+        '200':
+          description: A page of dead letters ordered by the time of failure
+          content:
+            application/json:
+              schema:
+                allOf:
+                  - $ref: '#/components/schemas/List'
+                  - type: object
+                    properties:
+                      items:
+                        type: array
+                        items: {$ref: '#/components/schemas/WebhookDeadLetter'}
+        '400': {$ref: '#/components/responses/Error'}
+        '401': {$ref: '#/components/responses/Error'}
+components:
+  securitySchemes:
+    webhookToken:
+      type: http
+      scheme: bearer
+      description: The -webhook-token of ons_sync
+  parameters:
+    key:
+      name: key
+      in: path
+      required: true
+      description: Public key
+      schema: {type: string}
+    offset:
+      name: offset
+      in: query
+      schema: {type: integer, minimum: 0, default: 0}
+    limit:
+      name: limit
+      in: query
+      schema: {type: integer, minimum: 1, maximum: 1000, default: 100}
+  responses:
+    Error:
+      description: Error
+      content:
+        application/json:
+          schema: {$ref: '#/components/schemas/Error'}
+  schemas:
+    Record:
+      type: object
+      properties:
+        index: {type: integer}
+        flags: {type: string, example: u}
+        service: {type: string}
+        regexp: {type: string, example: '!^.*$!http://example.com/cgibin/epcis!'}
+        state: {type: string, enum: [RECORD_INACTIVE, RECORD_ACTIVE]}
+        provider: {type: string}
+    GS1Code:
+      type: object
+      properties:
+        gs1_code: {type: string}
+        owner_id: {type: string}
+        state: {type: string, enum: [GS1CODE_NONE, GS1CODE_INACTIVE, GS1CODE_ACTIVE]}
+        records:
+          type: array
+          items: {$ref: '#/components/schemas/Record'}
+        address: {type: string}
+        block_num: {type: number}
+    KeyValue:
+      type: object
+      properties:
+        key: {type: string}
+        value: {type: string}
+    ServiceType:
+      type: object
+      properties:
+        address: {type: string}
+        provider: {type: string}
+        fields:
+          type: array
+          items: {$ref: '#/components/schemas/KeyValue'}
+        types:
+          type: array
+          items: {$ref: '#/components/schemas/KeyValue'}
+        document:
+          type: object
+          description: Fields by key. Values of fields whose type is json are decoded, other values are strings.
+          additionalProperties: true
+        block_num: {type: number}
+    ProviderRecord:
+      allOf:
+        - $ref: '#/components/schemas/Record'
+        - type: object
+          properties:
+            gs1_code: {type: string}
+            gs1_code_state: {type: string, enum: [GS1CODE_NONE, GS1CODE_INACTIVE, GS1CODE_ACTIVE]}
+            block_num: {type: number}
+    ManagedGS1Code:
+      type: object
+      properties:
+        gs1_code: {type: string}
+        manager: {type: string}
+        registered: {type: boolean, description: False if the GS1 code is not registered yet}
+        state: {type: string, enum: [GS1CODE_NONE, GS1CODE_INACTIVE, GS1CODE_ACTIVE]}
+        owner_id: {type: string}
+        block_num: {type: number, description: Block at which the manager list was synchronized}
+        granted_block_num: {type: number, description: Block at which the manager was granted the GS1 code}
+    Manager:
+      type: object
+      properties:
+        kind: {type: string, enum: [su, gs1]}
+        address: {type: string, description: Public key of the manager}
+        gs1_code: {type: string, description: Managed GS1 code, omitted for super managers}
+        granted_block_num: {type: number, description: Block at which the right was granted}
+        block_num: {type: number, description: Block at which the manager list was synchronized}
+    Webhook:
+      type: object
+      properties:
+        id: {type: string}
+        url: {type: string}
+        gs1_prefix: {type: string}
+        owner: {type: string}
+        kinds:
+          type: array
+          items: {type: string, enum: [gs1_code.updated, gs1_code.deleted, service_type.updated, service_type.deleted, managers.updated, managers.deleted]}
+        secret: {type: string, description: Returned only when the webhook is registered}
+        created_at: {type: string, format: date-time}
+    WebhookRequest:
+      type: object
+      required: [url]
+      properties:
+        url: {type: string, description: http or https URL}
+        gs1_prefix: {type: string, description: Only GS1 codes starting with this prefix}
+        owner: {type: string, description: Only GS1 codes owned by this key before or after the change}
+        kinds:
+          type: array
+          description: Only these kinds of change, all kinds if empty
+          items: {type: string}
+        secret: {type: string, description: HMAC-SHA256 key, generated if empty}
+    WebhookPing:
+      type: object
+      properties:
+        delivered: {type: boolean}
+        error: {type: string}
+    WebhookDeadLetter:
+      type: object
+      properties:
+        id: {type: string}
+        webhook_id: {type: string, description: Omitted if the notification was dropped before it was matched}
+        url: {type: string}
+        kind: {type: string}
+        payload: {type: object, description: The notification body}
+        error: {type: string}
+        attempts: {type: integer}
+        failed_at: {type: string, format: date-time}
+    List:
+      type: object
+      properties:
+        total: {type: integer}
+        offset: {type: integer}
+        limit: {type: integer}
+        next: {type: string, description: URL of the next page, omitted on the last page}
+    Error:
+      type: object
+      properties:
+        code: {type: integer}
+        message: {type: string}
+`
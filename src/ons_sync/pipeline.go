@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+)
+
+//decode를 기다리는 message의 최대 개수. 가득 차면 event source가 message를 더 읽지 않는다.
+const EVENT_PIPELINE_SIZE = 100
+
+//event source가 받은 message를 하나의 goroutine에서 받은 순서대로 처리한다.
+//decode → block linkage 확인 (SyncBlock) → 하나의 transaction으로 적용 → cursor commit
+type EventPipeline struct {
+	items     chan *pipelineItem
+	done      chan struct{}
+	decode    func(message []byte) (*ONSEvent, error)
+	source    EventSource
+	rest_addr string
+}
+
+//pipeline이 순서대로 처리하는 항목. snapshot이 nil이 아니면 message 대신 chain head의 snapshot으로 다시 맞추고 결과를 알린다.
+type pipelineItem struct {
+	message  []byte
+	snapshot func(err error)
+}
+
+//source는 parent block의 delta를 요청할 때, rest_addr은 다시 bootstrap 할 때 사용한다.
+func NewEventPipeline(source EventSource, rest_addr string, decode func(message []byte) (*ONSEvent, error)) *EventPipeline {
+	pipeline := &EventPipeline{
+		items:     make(chan *pipelineItem, EVENT_PIPELINE_SIZE),
+		done:      make(chan struct{}),
+		decode:    decode,
+		source:    source,
+		rest_addr: rest_addr,
+	}
+	go pipeline.run()
+	return pipeline
+}
+
+//buffer가 가득 차면 앞의 message가 적용될 때까지 기다린다. (backpressure)
+//Close 이후에는 호출하면 안 된다.
+func (p *EventPipeline) Push(message []byte) {
+	p.push(&pipelineItem{message: message})
+}
+
+func (p *EventPipeline) push(item *pipelineItem) {
+	p.items <- item
+}
+
+//Push와 같지만 buffer가 가득 차면 기다리지 않고 false를 반환한다.
+//socket을 읽는 goroutine이 ping에 응답해야 하는 event source(zmq)에서 사용한다.
+func (p *EventPipeline) TryPush(item *pipelineItem) bool {
+	select {
+	case p.items <- item:
+		return true
+	default:
+		return false
+	}
+}
+
+//buffer에 남은 message를 모두 처리할 때까지 기다린다.
+func (p *EventPipeline) Close() {
+	close(p.items)
+	<-p.done
+}
+
+func (p *EventPipeline) run() {
+	defer close(p.done)
+	for item := range p.items {
+		if item.snapshot != nil {
+			item.snapshot(p.resyncSnapshot())
+			continue
+		}
+		onsEvent, err := p.decode(item.message)
+		if err != nil {
+			log.Printf("Failed to decode event : %v", err)
+			ObserveDecodeFailure("")
+			continue
+		}
+		UpdateOnsEvent(p.source, p.rest_addr, onsEvent, g_verbose)
+	}
+}
+
+//앞의 message를 모두 적용한 후에 chain head의 snapshot으로 다시 맞춘다. (errSnapshotRequired)
+func (p *EventPipeline) resyncSnapshot() error {
+	if len(p.rest_addr) == 0 {
+		return errSnapshotRequired
+	}
+	err := Bootstrap(p.rest_addr, g_verbose)
+	if err != nil {
+		log.Printf("Failed to bootstrap : %v", err)
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//REST API의 /subscriptions websocket. get_block_deltas를 받으면 chain의 block delta를 보낸다.
+type testWebsocketServer struct {
+	*httptest.Server
+	chain map[string]*ONSEvent
+	send  chan *ONSEvent
+}
+
+func startTestWebsocketServer(t *testing.T, blocks ...*ONSEvent) *testWebsocketServer {
+	s := &testWebsocketServer{chain: map[string]*ONSEvent{}, send: make(chan *ONSEvent, 100)}
+	for _, block := range blocks {
+		s.chain[block.BlockId] = block
+	}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/subscriptions" {
+			http.NotFound(w, req)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				var message getBlockDeltasMessage
+				if err := conn.ReadJSON(&message); err != nil {
+					return
+				}
+				if message.Action == "get_block_deltas" && s.chain[message.BlockId] != nil {
+					s.send <- s.chain[message.BlockId]
+				}
+			}
+		}()
+		for {
+			select {
+			case <-closed:
+				return
+			case block := <-s.send:
+				data, _ := json.Marshal(map[string]interface{}{
+					"block_num":         strconv.FormatFloat(block.BlockNum, 'f', -1, 64),
+					"block_id":          block.BlockId,
+					"previous_block_id": block.PreviousBlockId,
+					"state_changes":     block.StateChanges,
+				})
+				if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestEventPipelineOrder(t *testing.T) {
+	openTestStore(t)
+
+	_, genesis := DBGetLatestUpdatedBlock()
+	block := func(block_num float64, block_id string, previous_block_id string, gs1_code string, owner_id string) *ONSEvent {
+		return &ONSEvent{BlockNum: block_num, BlockId: block_id, PreviousBlockId: previous_block_id,
+			StateChanges: []map[string]string{gs1CodeChange(t, newTestGS1Code(gs1_code, owner_id))}}
+	}
+	b1 := block(1, "b1", genesis, "1", "owner-1")
+	b2 := block(2, "b2", "b1", "1", "owner-2")
+	b3 := block(3, "b3", "b2", "2", "owner-3")
+	b3_fork := block(3, "b3-fork", "b2", "2", "owner-4")
+	b4_fork := block(4, "b4-fork", "b3-fork", "1", "owner-5")
+	server := startTestWebsocketServer(t, b1, b2, b3, b3_fork, b4_fork)
+
+	h, err := NewONSEventHandler(strings.TrimPrefix(server.URL, "http://"), "/subscriptions",
+		&ReconnectOptions{MinBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Run()
+	h.Subscribe(true)
+
+	//b3은 parent b2보다 먼저 오고, b1, b2는 다시 온다. b4-fork의 parent b3-fork는 요청해서 받는다.
+	for _, event := range []*ONSEvent{b1, b3, b1, b2, b4_fork} {
+		server.send <- event
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		last_block, err := g_store.GetLastBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last_block != nil && last_block.BlockId == "b4-fork" {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("stored cursor is %+v, want 4(b4-fork)", last_block)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	//pipeline에 남은 message를 모두 처리하고 끝난다.
+	h.Terminate(true)
+
+	if block_num, block_id := DBGetLatestUpdatedBlock(); block_num != 4 || block_id != "b4-fork" {
+		t.Errorf("cursor is %v(%s), want 4(b4-fork)", block_num, block_id)
+	}
+	if owner := testGS1CodeOwner(t, "1"); owner != "owner-5" {
+		t.Errorf("GS1 code 1 owner is %q, want owner-5", owner)
+	}
+	if owner := testGS1CodeOwner(t, "2"); owner != "owner-4" {
+		t.Errorf("GS1 code 2 owner is %q, want owner-4 of the fork", owner)
+	}
+	if block, err := DBGetBlock("b3"); err != nil || block != nil {
+		t.Errorf("rolled back block b3 is %+v, %v", block, err)
+	}
+
+	//undo data는 block을 적용하기 전의 값이므로 적용한 순서를 나타낸다.
+	//b3-fork는 b3을 되돌린 후에 적용했으므로 GS1 code 2가 없을 때 적용되었다.
+	for _, item := range []struct {
+		block_id string
+		prev     []byte
+	}{
+		{"b1", nil},
+		{"b2", gs1CodeState(t, newTestGS1Code("1", "owner-1"))},
+		{"b3-fork", nil},
+		{"b4-fork", gs1CodeState(t, newTestGS1Code("1", "owner-2"))},
+	} {
+		block, err := DBGetBlock(item.block_id)
+		if err != nil || block == nil || len(block.Undo) != 1 {
+			t.Errorf("block %s is %+v, %v", item.block_id, block, err)
+			continue
+		}
+		if bytes.Equal(block.Undo[0].Value, item.prev) == false {
+			t.Errorf("block %s is applied on %q, want %q", item.block_id, block.Undo[0].Value, item.prev)
+		}
+	}
+}
+
+//pipeline이 가득 차도 zmq socket goroutine은 기다리지 않는다.
+func TestZMQBacklogDoesNotBlock(t *testing.T) {
+	openTestStore(t)
+	release := make(chan struct{})
+	decoded := make(chan struct{}, EVENT_PIPELINE_SIZE*3)
+	h := &ONSZMQEventHandler{snapshot_mutex: &sync.Mutex{}}
+	h.pipeline = NewEventPipeline(h, "", func(message []byte) (*ONSEvent, error) {
+		<-release
+		decoded <- struct{}{}
+		return nil, nil
+	})
+
+	pushed := make(chan struct{})
+	go func() {
+		for i := 0; i < EVENT_PIPELINE_SIZE+ZMQ_BACKLOG_SIZE+10; i++ {
+			h.pushEvent(&pipelineItem{message: []byte{}})
+		}
+		//bootstrap 할 수 없으면 snapshot 결과로 errSnapshotRequired를 받는다.
+		h.pushEvent(&pipelineItem{snapshot: h.onSnapshot})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("pushEvent is blocked by the pipeline")
+	}
+	if h.resubscribe == false || len(h.backlog) != ZMQ_BACKLOG_SIZE+1 {
+		t.Errorf("backlog has %d events, resubscribe %v", len(h.backlog), h.resubscribe)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.backlog) > 0 && time.Now().Before(deadline) {
+		h.flushBacklog()
+		time.Sleep(time.Millisecond)
+	}
+	h.pipeline.Close()
+	if len(h.backlog) > 0 || h.resubscribe == true {
+		t.Errorf("backlog has %d events after the pipeline is drained, resubscribe %v", len(h.backlog), h.resubscribe)
+	}
+	//decode 중인 event가 있으면 하나 더 받는다. 나머지는 버리고 다시 구독해서 받는다.
+	if len(decoded) < EVENT_PIPELINE_SIZE+ZMQ_BACKLOG_SIZE || len(decoded) > EVENT_PIPELINE_SIZE+ZMQ_BACKLOG_SIZE+1 {
+		t.Errorf("%d events are decoded", len(decoded))
+	}
+	if results := h.takeSnapshotResults(); len(results) != 1 || results[0] != errSnapshotRequired {
+		t.Errorf("snapshot results are %v", results)
+	}
+}
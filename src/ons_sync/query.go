@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"protobuf/ons_pb2"
+)
+
+//query API에서 사용하는 list filter. 비어 있는 조건은 사용하지 않는다.
+type GS1CodeFilter struct {
+	OwnerId     string
+	Provider    string
+	State       int32
+	HasState    bool
+	ServiceType string
+}
+
+//Fields는 모두 맞아야 한다. (servicetype.go)
+type ServiceTypeFilter struct {
+	Provider string
+	Fields   []*FieldFilter
+}
+
+type Page struct {
+	Offset int
+	Limit  int
+}
+
+//provider가 등록한 record와 그 record의 GS1 code.
+type ProviderRecord struct {
+	Gs1Code     string
+	Gs1State    ons_pb2.GS1CodeData_GS1CodeState
+	RecordIndex int
+	Record      *ons_pb2.Record
+	BlockNum    float64
+}
+
+//manager가 관리하는 GS1 code. GS1 code가 아직 등록되지 않았으면 Gs1CodeData는 nil이다.
+type ManagedGS1Code struct {
+	Manager     *ONSManagerRow
+	Gs1CodeData *ONSGS1CodeEvent
+}
+
+func checkDBSession() error {
+	if g_store == nil {
+		log.Printf("Not connected.\n")
+		return errors.New("Not connected.")
+	}
+	return nil
+}
+
+func DBGetGS1Code(gs1_code string) (*ONSGS1CodeEvent, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, err
+	}
+	return g_store.GetGS1Code(gs1_code)
+}
+
+func DBListGS1Codes(filter *GS1CodeFilter, page Page) ([]*ONSGS1CodeEvent, int, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, 0, err
+	}
+	return g_store.ListGS1Codes(filter, page)
+}
+
+//provider의 record를 GS1 code, record index 순서로 읽는다.
+func DBListRecordsByProvider(provider string, page Page) ([]*ProviderRecord, int, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, 0, err
+	}
+	return g_store.ListRecordsByProvider(provider, page)
+}
+
+func DBListManagedGS1Codes(address string, page Page) ([]*ManagedGS1Code, int, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, 0, err
+	}
+	return g_store.ListManagedGS1Codes(address, page)
+}
+
+func DBGetServiceType(address string) (*ONSServiceTypeEvent, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, err
+	}
+	return g_store.GetServiceType(address)
+}
+
+func DBListServiceTypes(filter *ServiceTypeFilter, page Page) ([]*ONSServiceTypeEvent, int, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, 0, err
+	}
+	return g_store.ListServiceTypes(filter, page)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const pingWriteWait = 10 * time.Second
+
+//websocket 연결이 끊어졌을 때 다시 연결하는 방법.
+type ReconnectOptions struct {
+	//다시 연결할 때 기다리는 시간. 실패할 때마다 MaxBackoff까지 두 배로 늘린다.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	//PingPeriod마다 ping을 보내고 PongWait 동안 아무것도 받지 못하면 연결이 끊어진 것으로 본다.
+	//0이면 keepalive를 사용하지 않는다.
+	PingPeriod time.Duration
+	PongWait   time.Duration
+	//연결이 OutageAlarm 이상 끊어져 있으면 alarm을 남긴다. 0이면 사용하지 않는다.
+	OutageAlarm time.Duration
+	//다시 연결한 후에 database가 이보다 많이 뒤처져 있으면 bootstrap 한다.
+	BootstrapGap float64
+}
+
+func DefaultReconnectOptions() *ReconnectOptions {
+	return &ReconnectOptions{
+		MinBackoff:   time.Second,
+		MaxBackoff:   time.Minute,
+		PingPeriod:   30 * time.Second,
+		PongWait:     60 * time.Second,
+		OutageAlarm:  5 * time.Minute,
+		BootstrapGap: 100,
+	}
+}
+
+//연결이 끊어지면 다시 연결하고 마지막으로 저장한 block부터 다시 동기화한다.
+//Terminate가 호출될 때까지 반환하지 않는다.
+func (h *ONSEventHandler) runConnection() {
+	defer func() {
+		//남은 event를 모두 적용한 후에 종료한다.
+		h.pipeline.Close()
+		h.wg.Done()
+		log.Println("runConnection : Exit")
+	}()
+
+	if h.reconnect == nil {
+		h.reconnect = DefaultReconnectOptions()
+	}
+
+	h.conn_mutex.Lock()
+	conn := h.conn
+	h.conn_mutex.Unlock()
+
+	for {
+		err := h.serveConnection(conn)
+		select {
+		case <-h.done:
+			return
+		default:
+		}
+
+		log.Printf("websocket connection is lost : %v", err)
+		SetSyncConnected(false, err)
+		conn.Close()
+
+		conn = h.redial()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+//keepalive를 사용하면서 연결이 끊어질 때까지 event를 받는다.
+func (h *ONSEventHandler) serveConnection(conn *websocket.Conn) error {
+	pong_wait := time.Duration(0)
+	if h.reconnect.PingPeriod > 0 && h.reconnect.PongWait > 0 {
+		pong_wait = h.reconnect.PongWait
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pong_wait))
+		})
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go h.runKeepalive(conn, stop)
+	}
+	return h.receiveEvents(conn, pong_wait)
+}
+
+func (h *ONSEventHandler) runKeepalive(conn *websocket.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(h.reconnect.PingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			//WriteControl은 다른 writer와 동시에 호출할 수 있다.
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingWriteWait))
+			if err != nil {
+				log.Printf("Failed to send ping : %v", err)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+//exponential backoff로 다시 연결한다. Terminate가 호출되면 nil을 반환한다.
+func (h *ONSEventHandler) redial() *websocket.Conn {
+	disconnected_at := time.Now()
+	alarmed := false
+	backoff := h.reconnect.MinBackoff
+
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-h.done:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		log.Printf("reconnecting to %s (attempt %d)", h.url, attempt)
+		conn, err := dialWebsocket(h.url)
+		if err == nil {
+			err = h.resume(conn)
+			if err == nil {
+				log.Printf("reconnected to %s after %v", h.url, time.Since(disconnected_at).Round(time.Second))
+				ObserveSyncReconnect()
+				return conn
+			}
+			conn.Close()
+		}
+		log.Printf("Failed to reconnect : %v", err)
+		SetSyncConnected(false, err)
+
+		outage := time.Since(disconnected_at)
+		if alarmed == false && h.reconnect.OutageAlarm > 0 && outage >= h.reconnect.OutageAlarm {
+			log.Printf("ALARM : disconnected from %s for %v, the database is not synchronized", h.url, outage.Round(time.Second))
+			SetSyncOutageAlarm(true)
+			alarmed = true
+		}
+
+		backoff *= 2
+		if backoff > h.reconnect.MaxBackoff {
+			backoff = h.reconnect.MaxBackoff
+		}
+	}
+}
+
+//새 연결로 바꾸고 구독과 동기화를 이어서 한다.
+func (h *ONSEventHandler) resume(conn *websocket.Conn) error {
+	//오래 끊어져 있었으면 block을 하나씩 받는 대신 snapshot으로 맞춘다.
+	err := BootstrapIfNeeded(h.rest_addr, false, h.reconnect.BootstrapGap, g_verbose)
+	if err != nil {
+		return err
+	}
+
+	h.conn_mutex.Lock()
+	select {
+	case <-h.done:
+		h.conn_mutex.Unlock()
+		conn.Close()
+		return nil
+	default:
+	}
+	h.conn = conn
+	subscribed := h.subscirbed
+	h.conn_mutex.Unlock()
+	SetSyncConnected(true, nil)
+
+	if subscribed == true {
+		err = h.subscribe(true)
+		if err != nil {
+			return err
+		}
+		SetSyncSubscribed(true)
+	}
+
+	//끊어진 동안 적용하지 못한 block은 chain head부터 parent를 따라 요청한다. (SyncBlock의 pending 처리)
+	head, err := getChainHeadBlock(h.rest_addr)
+	if err != nil {
+		log.Printf("Failed to get the chain head : %v", err)
+		return nil
+	}
+	_, latest_block_id := DBGetLatestUpdatedBlock()
+	if head.BlockId != latest_block_id {
+		log.Printf("catch up from block %s to the chain head %v(%s)", latest_block_id, head.BlockNum, head.BlockId)
+		return h.getBlockDelteas(head.BlockId)
+	}
+	return nil
+}
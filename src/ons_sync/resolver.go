@@ -0,0 +1,31 @@
+package main
+
+import (
+	"ons_lib/ons_resolve"
+	"protobuf/ons_pb2"
+)
+
+//동기화한 store에서 GS1 code를 읽는 resolver source. store 종류와 관계없이 사용할 수 있다.
+type storeGS1CodeSource struct{}
+
+func (storeGS1CodeSource) GetGS1Code(gs1_code string) (*ons_pb2.GS1CodeData, error) {
+	gs1_code_event, err := DBGetGS1Code(gs1_code)
+	if err != nil || gs1_code_event == nil {
+		return nil, err
+	}
+	return &gs1_code_event.GS1CodeData, nil
+}
+
+//DNS, Digital Link address가 모두 비어 있으면 실행하지 않고 nil을 반환한다.
+func StartResolver(resolver *ResolverConfig, verbose bool) (*ons_resolve.Server, error) {
+	if len(resolver.DNS) == 0 && len(resolver.HTTP) == 0 {
+		return nil, nil
+	}
+	return ons_resolve.Start(storeGS1CodeSource{}, &ons_resolve.Options{
+		DNS:     resolver.DNS,
+		HTTP:    resolver.HTTP,
+		Root:    resolver.Root,
+		TTL:     resolver.TTL,
+		Verbose: verbose,
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"protobuf/ons_pb2"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolverServesStore(t *testing.T) {
+	openTestStore(t)
+
+	gs1_code_data := &ons_pb2.GS1CodeData{
+		Gs1Code: "09506000134352",
+		State:   ons_pb2.GS1CodeData_GS1CODE_ACTIVE,
+		Records: []*ons_pb2.Record{{
+			Flags:   117,
+			Service: "http://www.gs1.org/ons/epcis",
+			Regexp:  "!^.*$!http://example.com/epcis!",
+			State:   ons_pb2.Record_RECORD_ACTIVE,
+		}},
+	}
+	_, head := DBGetLatestUpdatedBlock()
+	err := SyncBlock(nil, &ONSEvent{BlockNum: 1, BlockId: "b1", PreviousBlockId: head,
+		StateChanges: []map[string]string{gs1CodeChange(t, gs1_code_data)}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := StartResolver(&ResolverConfig{DNS: "127.0.0.1:0", Root: "onsepc.com", TTL: 300}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resolver.Stop()
+
+	query := func() *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("5.3.4.3.1.0.0.0.6.0.5.9.0.gtin.gs1.id.onsepc.com.", dns.TypeNAPTR)
+		resp, _, err := (&dns.Client{}).Exchange(req, resolver.DNSAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := query()
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("rcode %s with %d answers, want 1 NAPTR", dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+	if naptr := resp.Answer[0].(*dns.NAPTR); naptr.Service != "http://www.gs1.org/ons/epcis" {
+		t.Errorf("unexpected answer %s", naptr.String())
+	}
+
+	//다음 block에서 INACTIVE가 되면 존재하지 않는 이름으로 응답한다.
+	gs1_code_data.State = ons_pb2.GS1CodeData_GS1CODE_INACTIVE
+	err = SyncBlock(nil, &ONSEvent{BlockNum: 2, BlockId: "b2", PreviousBlockId: "b1",
+		StateChanges: []map[string]string{gs1CodeChange(t, gs1_code_data)}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp = query(); resp.Rcode != dns.RcodeNameError {
+		t.Errorf("rcode %s after the GS1 code is inactivated, want NXDOMAIN", dns.RcodeToString[resp.Rcode])
+	}
+}
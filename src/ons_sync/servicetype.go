@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"protobuf/ons_pb2"
+	"strings"
+)
+
+//service type의 types에서 field value가 JSON임을 나타내는 type name
+const SERVICE_TYPE_JSON = "json"
+
+//GET /servicetypes에서 field path 조건을 나타내는 query parameter prefix
+const SERVICE_TYPE_FIELD_PARAM = "fields."
+
+//service type field의 nested path 조건. Path는 document의 key 순서이다.
+//값이 string, number, boolean이고 JSON text가 Value와 같으면 맞는다. (string은 따옴표 없이 비교한다)
+type FieldFilter struct {
+	Path  []string
+	Value string
+}
+
+//service type의 fields를 key : value document로 만든다.
+//types에서 type이 json인 field는 value를 decode 한다. decode 하지 못하면 string으로 두고 마지막 error를 반환한다.
+//같은 key가 여러 번 있으면 마지막 field를 사용한다.
+func NewServiceTypeDocument(service_type *ons_pb2.ServiceType) (map[string]interface{}, error) {
+	types := map[string]string{}
+	for _, field_type := range service_type.Types {
+		types[field_type.Key] = strings.ToLower(field_type.Value)
+	}
+
+	var decode_err error
+	document := map[string]interface{}{}
+	for _, field := range service_type.Fields {
+		if types[field.Key] != SERVICE_TYPE_JSON {
+			document[field.Key] = field.Value
+			continue
+		}
+		var value interface{}
+		err := json.Unmarshal([]byte(field.Value), &value)
+		if err != nil {
+			decode_err = fmt.Errorf("field %s is not JSON : %v", field.Key, err)
+			document[field.Key] = field.Value
+			continue
+		}
+		document[field.Key] = value
+	}
+	return document, decode_err
+}
+
+//protocol.version 같은 path를 key list로 나눈다.
+//key는 비어 있으면 안 되고 SQLite JSON path에서 사용하므로 따옴표와 backslash를 쓸 수 없다.
+func ParseFieldPath(path string) ([]string, error) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys {
+		if len(key) == 0 || strings.ContainsAny(key, "\"\\") == true {
+			return nil, fmt.Errorf("invalid field path %q", path)
+		}
+	}
+	return keys, nil
+}
+
+//document가 없는 service type(이전 version에서 저장한 service type)의 document를 만든다.
+func BackfillServiceTypeDocuments() error {
+	count := 0
+	err := forEachPage(func(page Page) (int, int, error) {
+		service_types, total, err := DBListServiceTypes(&ServiceTypeFilter{}, page)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, service_type := range service_types {
+			if service_type.Document != nil {
+				continue
+			}
+			document, err := NewServiceTypeDocument(&service_type.ServiceType)
+			if err != nil {
+				log.Printf("service type %s : %v\n", service_type.Address, err)
+			}
+			err = g_store.SetServiceTypeDocument(service_type.Address, document)
+			if err != nil {
+				return 0, 0, err
+			}
+			count++
+		}
+		return len(service_types), total, nil
+	})
+	if count > 0 {
+		log.Printf("service type : %d documents are added from the stored fields\n", count)
+	}
+	return err
+}
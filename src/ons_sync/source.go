@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	//REST API의 /subscriptions websocket
+	EVENT_SOURCE_WEBSOCKET = "websocket"
+	//validator의 component endpoint (ZMQ)
+	EVENT_SOURCE_ZMQ = "zmq"
+)
+
+//block event를 받아서 UpdateOnsEvent로 database에 반영한다.
+type EventSource interface {
+	Run() bool
+	Subscribe(subscribing bool)
+	//parent를 모르는 block이 있을 때 SyncBlock이 parent block의 delta를 요청한다.
+	GetBlockDeltas(block_id string)
+	Terminate(waiting bool)
+}
+
+//rest_addr은 websocket source의 address이면서 bootstrap에 사용하고, validator_url은 zmq source에서 사용한다.
+func NewEventSource(source string, rest_addr string, validator_url string, reconnect *ReconnectOptions, verbose bool) (EventSource, error) {
+	switch source {
+	case EVENT_SOURCE_WEBSOCKET:
+		h, err := NewONSEventHandler(rest_addr, "/subscriptions", reconnect, verbose)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	case EVENT_SOURCE_ZMQ:
+		h, err := NewONSZMQEventHandler(validator_url, rest_addr, verbose)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+	return nil, fmt.Errorf("unknown event source : %s", source)
+}
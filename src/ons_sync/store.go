@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"protobuf/ons_pb2"
+)
+
+const (
+	STORE_RETHINKDB = "rethinkdb"
+	STORE_SQLITE    = "sqlite"
+	STORE_POSTGRES  = "postgres"
+)
+
+//store를 지정하지 않았을 때 사용하는 database address.
+var g_default_store_addresses = map[string]string{
+	STORE_RETHINKDB: "localhost:28015",
+	STORE_SQLITE:    "ons_ledger.db",
+	STORE_POSTGRES:  "dbname=ons_ledger sslmode=disable",
+}
+
+//마지막으로 처리한 block.
+type BlockInfo struct {
+	BlockNum        float64
+	BlockId         string
+	PreviousBlockId string
+}
+
+//적용한 block, 동기화 cursor와 ONS namespace의 address별 state value. (chain.go, bootstrap.go)
+type BlockStore interface {
+	//저장된 block이 없으면 nil을 반환한다.
+	GetLastBlock() (*BlockInfo, error)
+	SetLastBlock(block *BlockInfo) error
+
+	//fork 처리를 위해 적용한 block의 undo data를 저장한다. block이 없으면 nil을 반환한다.
+	GetBlock(block_id string) (*BlockRecord, error)
+	//같은 block id가 있으면 바꾼다.
+	AddBlock(block *BlockRecord) error
+	DeleteBlock(block_id string) error
+	//block number가 block_num보다 작은 block을 삭제한다.
+	PruneBlocks(block_num float64) error
+	//저장된 가장 오래된 block의 block number. block이 없으면 -1을 반환한다.
+	OldestBlockNum() (float64, error)
+	//Pending인 block. (적용하거나 되돌리는 중에 중단된 block)
+	PendingBlocks() ([]*BlockRecord, error)
+
+	//address의 마지막 state value. address가 없으면 Value가 nil이다.
+	GetStateValue(address string) (*StateValue, error)
+	//Value가 nil이면 삭제한다.
+	SetStateValue(state_value *StateValue) error
+	StateAddresses() ([]string, error)
+}
+
+//state를 decode 한 GS1 code, service type, manager table.
+//GS1 code, service type은 저장된 data보다 block number가 작거나 같으면 무시한다.
+type ONSTableStore interface {
+	UpsertGS1Code(gs1_code *ONSGS1CodeEvent) error
+	UpsertServiceType(service_type *ONSServiceTypeEvent) error
+	//이전 version에서 저장한 service type의 document를 저장한다. (servicetype.go)
+	SetServiceTypeDocument(address string, document map[string]interface{}) error
+	//address의 GS1 code 또는 service type을 삭제한다.
+	DeleteAddress(address string) error
+	//address와 관계없이 GS1 code와 record를 삭제한다. (verify의 repair)
+	DeleteGS1Code(gs1_code string) error
+	//force이면 저장된 block number와 관계없이 바꾼다. (rollback, bootstrap)
+	UpdateManagers(ons_manager *ons_pb2.ONSManager, block_num float64, force bool) error
+	DeleteManagers() error
+
+	//query API에서 사용한다. item이 없으면 nil을 반환한다.
+	GetGS1Code(gs1_code string) (*ONSGS1CodeEvent, error)
+	ListGS1Codes(filter *GS1CodeFilter, page Page) ([]*ONSGS1CodeEvent, int, error)
+	GetServiceType(address string) (*ONSServiceTypeEvent, error)
+	ListServiceTypes(filter *ServiceTypeFilter, page Page) ([]*ONSServiceTypeEvent, int, error)
+	ListRecordsByProvider(provider string, page Page) ([]*ProviderRecord, int, error)
+	ListManagedGS1Codes(address string, page Page) ([]*ManagedGS1Code, int, error)
+	ListManagers(filter *ManagerFilter, page Page) ([]*ONSManagerRow, int, error)
+}
+
+//GS1 code의 block별 version. (history.go)
+type HistoryStore interface {
+	//같은 GS1 code, block number의 version은 바꾼다.
+	AddGS1CodeVersion(version *GS1CodeVersion) error
+	//rollback 한 block의 version을 삭제한다.
+	DeleteGS1CodeVersions(block_id string) error
+	//as_of 이전의 마지막 version. as_of.HasTime이면 SyncedAt을 모르는 version은 제외한다. version이 없으면 nil을 반환한다.
+	GetGS1CodeVersion(gs1_code string, as_of *AsOf) (*GS1CodeVersion, error)
+	//block_num 이후의 첫 version. version이 없으면 nil을 반환한다.
+	NextGS1CodeVersion(gs1_code string, block_num float64) (*GS1CodeVersion, error)
+	HasGS1CodeVersions() (bool, error)
+}
+
+//webhook registry와 전달하지 못한 notification. (webhook.go)
+type WebhookStore interface {
+	AddWebhook(webhook *Webhook) error
+	//webhook이 없으면 false를 반환한다.
+	DeleteWebhook(id string) (bool, error)
+	ListWebhooks() ([]*Webhook, error)
+	AddWebhookDeadLetter(dead_letter *WebhookDeadLetter) error
+	//오래된 순서로 읽는다.
+	ListWebhookDeadLetters(page Page) ([]*WebhookDeadLetter, int, error)
+}
+
+//동기화한 ONS state를 저장하는 storage.
+type Store interface {
+	BlockStore
+	ONSTableStore
+	HistoryStore
+	WebhookStore
+
+	//동기화한 data를 모두 삭제한다. (resync)
+	Clear() error
+
+	//block 하나를 하나의 transaction으로 적용할 때 사용한다. transaction 안에서 다시 Begin 하면 안 된다.
+	Begin() (StoreTx, error)
+	//Begin의 write를 Commit 할 때 한 번에 반영하면 true이다.
+	//false이면 write를 바로 적용하므로 중단되면 pending block의 undo data로만 되돌릴 수 있다.
+	//block 단위로 되돌릴 수 없는 snapshot(bootstrap, resync, repair)은 적용하지 않는다.
+	Atomic() bool
+
+	Close() error
+}
+
+//Begin으로 시작한 transaction. 반환된 store의 write는 Commit 할 때 한 번에 반영된다.
+type StoreTx interface {
+	Store
+	Commit() error
+	Rollback() error
+}
+
+//database 연결 설정.
+type StoreOptions struct {
+	//rethinkdb, sqlite, postgres 중 하나이다.
+	Backend string
+	//rethinkdb이면 host:port, sqlite이면 file path, postgres이면 connection string이다. 비어 있으면 기본 address를 사용한다.
+	Address string
+	//rethinkdb에서만 사용한다.
+	Name string
+	//rethinkdb, postgres에서만 사용한다. postgres는 connection string에 추가한다.
+	Username string
+	Password string
+	TLS      TLSConfig
+}
+
+func OpenStore(options *StoreOptions, verbose bool) (Store, error) {
+	address := options.Address
+	if len(address) == 0 {
+		address = g_default_store_addresses[options.Backend]
+	}
+
+	switch options.Backend {
+	case STORE_RETHINKDB:
+		tls_config, err := options.TLS.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		return NewRethinkStore(address, options.Name, options.Username, options.Password, tls_config, verbose)
+	case STORE_SQLITE:
+		return NewSQLStore(sqliteDialect, address, verbose)
+	case STORE_POSTGRES:
+		dsn, err := postgresDSN(address, options)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLStore(postgresDialect, dsn, verbose)
+	}
+	return nil, fmt.Errorf("unknown store %q (rethinkdb, sqlite or postgres)", options.Backend)
+}
@@ -0,0 +1,864 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"protobuf/ons_pb2"
+	"sync"
+
+	r "gopkg.in/gorethink/gorethink.v4"
+)
+
+//RethinkDB store. table마다 document 하나에 item 하나를 저장한다.
+type rethinkStore struct {
+	session *r.Session
+	db_name string
+	mutex   *sync.Mutex
+}
+
+//secondary index 정의. function이 nil이면 같은 이름의 field를 index로 사용한다.
+type secondaryIndex struct {
+	table_idx int
+	name      string
+	function  func(row r.Term) interface{}
+	multi     bool
+}
+
+var g_secondary_indexes = []secondaryIndex{
+	{GS1_CODE_TABLE, "OwnerId", nil, false},
+	//record의 provider. record가 여러 개이므로 multi index를 사용한다.
+	{GS1_CODE_TABLE, "Providers", func(row r.Term) interface{} {
+		return row.Field("Records").Default([]interface{}{}).Map(func(record r.Term) r.Term {
+			return record.Field("Provider").Default("")
+		}).Distinct()
+	}, true},
+	{MANAGER_TABLE, "Address", nil, false},
+	//GS1 code의 block number 순서로 version을 읽는다.
+	{GS1_CODE_HISTORY_TABLE, "Gs1CodeBlockNum", func(row r.Term) interface{} {
+		return []interface{}{row.Field("Gs1Code"), row.Field("BlockNum")}
+	}, false},
+	{GS1_CODE_HISTORY_TABLE, "BlockId", nil, false},
+}
+
+//username이 비어 있으면 admin user로 연결한다. tls_config가 nil이면 TLS를 사용하지 않는다.
+func NewRethinkStore(url string, db_name string, username string, password string, tls_config *tls.Config, verbose bool) (Store, error) {
+	log.Printf("Connect %s\n", url)
+	session, err := r.Connect(r.ConnectOpts{
+		Address:   url,
+		Username:  username,
+		Password:  password,
+		TLSConfig: tls_config,
+	})
+
+	if err != nil {
+		log.Printf("Failed to connect %s\n", url)
+		return nil, err
+	}
+
+	resp, err := r.DBList().Contains(db_name).Run(session)
+	if err != nil {
+		log.Printf("Failed to check %s database exist\n", db_name)
+		session.Close()
+		return nil, err
+	}
+
+	var contained bool
+	err = resp.One(&contained)
+	if err != nil {
+		log.Printf("Failed to retrieves the first document from the result set to check %s database exist\n", db_name)
+		session.Close()
+		return nil, err
+	}
+
+	if verbose == true {
+		log.Printf("%s database is exist : %#v\n", db_name, contained)
+	}
+
+	if contained == false {
+		resp, err = r.DBCreate(db_name).Run(session)
+		if err != nil {
+			session.Close()
+			return nil, err
+		}
+		var rows []map[string]interface{}
+		err = resp.All(&rows)
+		if err != nil {
+			session.Close()
+			return nil, err
+		}
+
+		if int(rows[0]["dbs_created"].(float64)) == 1 {
+			log.Printf("%s database is created\n", db_name)
+		}
+
+		if verbose == true {
+			prettyPrint(rows)
+		}
+	}
+
+	for table_name, pk_name := range g_table_map {
+		resp, err = r.DB(db_name).TableList().Contains(table_name).Run(session)
+		if err != nil {
+			session.Close()
+			return nil, err
+		}
+		var table_contained bool
+		err = resp.One(&table_contained)
+		if err != nil {
+			log.Printf("Failed to retrieves the first document from the result set to check %s database exist\n", db_name)
+			session.Close()
+			return nil, err
+		}
+
+		if verbose == true {
+			log.Printf("is %s table exist in %s database : %#v\n", table_name, db_name, table_contained)
+		}
+
+		if table_contained == false {
+			resp, err = r.DB(db_name).TableCreate(table_name, r.TableCreateOpts{PrimaryKey: pk_name}).Run(session)
+			if err != nil {
+				session.Close()
+				return nil, err
+			}
+			var creation_reslut map[string]interface{}
+			err = resp.One(&creation_reslut)
+
+			if verbose == true {
+				log.Printf("result to create table : %#v", creation_reslut["tables_created"])
+			}
+
+			if creation_reslut["tables_created"].(float64) == 1 {
+				log.Printf("Is table creation succeeded : %#v", creation_reslut["tables_created"].(float64))
+			}
+
+		} else {
+			log.Printf("%s table is exist in %s\n", table_name, db_name)
+		}
+	}
+	//reverse lookup(owner, provider, manager)을 위한 secondary index.
+	for _, index := range g_secondary_indexes {
+		err = dbEnsureIndex(session, db_name, index, verbose)
+		if err != nil {
+			log.Printf("Failed to create %s index on %s\n", index.name, g_table_names[index.table_idx])
+			session.Close()
+			return nil, err
+		}
+	}
+
+	return &rethinkStore{session: session, db_name: db_name, mutex: &sync.Mutex{}}, nil
+}
+
+//index가 없으면 만들고 사용할 수 있을 때까지 기다린다.
+func dbEnsureIndex(session *r.Session, db_name string, index secondaryIndex, verbose bool) error {
+	table := r.DB(db_name).Table(g_table_names[index.table_idx])
+
+	cur, err := table.IndexList().Contains(index.name).Run(session)
+	if err != nil {
+		return err
+	}
+	var contained bool
+	err = cur.One(&contained)
+	cur.Close()
+	if err != nil {
+		return err
+	}
+
+	if contained == false {
+		opts := r.IndexCreateOpts{Multi: index.multi}
+		var create r.Term
+		if index.function == nil {
+			create = table.IndexCreate(index.name, opts)
+		} else {
+			create = table.IndexCreateFunc(index.name, index.function, opts)
+		}
+		_, err = create.RunWrite(session)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s index is created on %s\n", index.name, g_table_names[index.table_idx])
+	} else if verbose == true {
+		log.Printf("%s index is exist on %s\n", index.name, g_table_names[index.table_idx])
+	}
+
+	_, err = table.IndexWait(index.name).Run(session)
+	return err
+}
+
+func (s *rethinkStore) table(table_idx int) r.Term {
+	return r.DB(s.db_name).Table(g_table_names[table_idx])
+}
+
+//RethinkDB는 여러 document의 transaction을 지원하지 않으므로 바로 적용한다.
+//적용 중에 중단된 block은 write 전에 저장한 pending block의 undo data로 되돌린다. (chain.go recoverPendingBlocks)
+type rethinkStoreTx struct {
+	*rethinkStore
+}
+
+func (s *rethinkStore) Begin() (StoreTx, error) {
+	return &rethinkStoreTx{rethinkStore: s}, nil
+}
+
+func (s *rethinkStore) Atomic() bool {
+	return false
+}
+
+func (t *rethinkStoreTx) Commit() error {
+	return nil
+}
+
+func (t *rethinkStoreTx) Rollback() error {
+	return nil
+}
+
+func (s *rethinkStore) Close() error {
+	log.Printf("database session will be closed\n")
+	return s.session.Close()
+}
+
+func checkNormalOPResult(cur *r.Cursor, op string, func_name string) bool {
+	//for test
+	var results map[string]float64
+	err := cur.One(&results)
+	if err != nil {
+		log.Printf("Failed to checkNormalOPResult(func : %s, op : %s) : %v\n", func_name, op, err)
+	}
+
+	log.Printf("%s : %s result: %v", func_name, op, results)
+
+	if results[op] == 1 {
+		return true
+	}
+
+	return false
+}
+
+func (s *rethinkStore) GetLastBlock() (*BlockInfo, error) {
+	//lastest updated block info table은 항상 index 0인 record만 사용한다.
+	cur, err := s.table(LATEST_BLOCK_INFO).Get(0).Run(s.session)
+	if err != nil {
+		log.Printf("maybe doesn't exist field : %s\n", g_table_map[g_table_names[LATEST_BLOCK_INFO]])
+		return nil, err
+	}
+	defer cur.Close()
+
+	var record map[string]interface{}
+	err = cur.One(&record)
+	if err == r.ErrEmptyResult {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockInfo{
+		BlockNum:        record["block_num"].(float64),
+		BlockId:         record["block_id"].(string),
+		PreviousBlockId: record["previous_block_id"].(string),
+	}, nil
+}
+
+func (s *rethinkStore) SetLastBlock(block *BlockInfo) error {
+	cur, err := s.table(LATEST_BLOCK_INFO).Insert(map[string]interface{}{
+		g_table_map[g_table_names[LATEST_BLOCK_INFO]]: 0,
+		"block_num":         block.BlockNum,
+		"block_id":          block.BlockId,
+		"previous_block_id": block.PreviousBlockId,
+	}, r.InsertOpts{Conflict: "replace"}).Run(s.session)
+	if err != nil {
+		log.Printf("Failed to update latest updated block info table : %#v\n", err)
+		return err
+	}
+	defer cur.Close()
+	checkNormalOPResult(cur, "replaced", "SetLastBlock")
+	return nil
+}
+
+func (s *rethinkStore) updateOrInsert(table_idx int, pk_v string, block_num float64, v interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	table_name := g_table_names[table_idx]
+
+	cur, err := s.table(table_idx).Get(pk_v).Run(s.session)
+	if err != nil {
+		log.Printf("maybe %s doesn't exist field : %v\n", g_table_map[table_name], err)
+		return err
+	}
+	defer cur.Close()
+
+	var record map[string]interface{}
+	err = cur.One(&record)
+	if err != nil {
+		log.Printf("updateOrInsert : insert item because %s item doesn't exist field (2): %v\n", g_table_map[table_name], err)
+		cur, err := s.table(table_idx).Insert(v).Run(s.session)
+		if err != nil {
+			log.Printf("Failed to item : %#v\n", err)
+			return err
+		}
+		defer cur.Close()
+		checkNormalOPResult(cur, "inserted", "updateOrInsert")
+	} else {
+		//if old data, skip..
+		if block_num <= record["BlockNum"].(float64) {
+			log.Printf("skip item because of old block data : %s, %s, %v\n", g_table_map[table_name], pk_v, block_num)
+			return nil
+		}
+
+		log.Printf("updateOrInsert : update item because %s item exist(%s)", g_table_map[table_name], pk_v)
+		cur, err := s.table(table_idx).Get(pk_v).Replace(v).Run(s.session)
+		if err != nil {
+			log.Printf("Failed to update item : %v\n", err)
+			return err
+		}
+		defer cur.Close()
+		checkNormalOPResult(cur, "replaced", "updateOrInsert")
+	}
+
+	return nil
+}
+
+func (s *rethinkStore) UpsertGS1Code(gs1_code *ONSGS1CodeEvent) error {
+	return s.updateOrInsert(GS1_CODE_TABLE, gs1_code.Gs1Code, gs1_code.BlockNum, gs1_code)
+}
+
+func (s *rethinkStore) UpsertServiceType(service_type *ONSServiceTypeEvent) error {
+	return s.updateOrInsert(SERVICE_TYPE_TABLE, service_type.Address, service_type.BlockNum, service_type)
+}
+
+func (s *rethinkStore) SetServiceTypeDocument(address string, document map[string]interface{}) error {
+	cur, err := s.table(SERVICE_TYPE_TABLE).Get(address).Update(map[string]interface{}{
+		"Document": document,
+	}).Run(s.session)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	checkNormalOPResult(cur, "replaced", "SetServiceTypeDocument")
+	return nil
+}
+
+func (s *rethinkStore) DeleteAddress(address string) error {
+	cur, err := s.table(GS1_CODE_TABLE).Filter(map[string]string{
+		"Address": address,
+	}).Delete().Run(s.session)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+
+	if checkNormalOPResult(cur, "deleted", "DeleteAddress in "+g_table_names[GS1_CODE_TABLE]) == false {
+		cur, err := s.table(SERVICE_TYPE_TABLE).Get(address).Delete().Run(s.session)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		if checkNormalOPResult(cur, "deleted", "DeleteAddress in "+g_table_names[SERVICE_TYPE_TABLE]) == false {
+			log.Printf("Nothing has been deleted.")
+		}
+	}
+	return nil
+}
+
+func (s *rethinkStore) DeleteGS1Code(gs1_code string) error {
+	_, err := s.table(GS1_CODE_TABLE).Get(gs1_code).Delete().RunWrite(s.session)
+	return err
+}
+
+func (s *rethinkStore) UpdateManagers(ons_manager *ons_pb2.ONSManager, block_num float64, force bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	table := s.table(MANAGER_TABLE)
+
+	cur, err := table.Run(s.session)
+	if err != nil {
+		return err
+	}
+	stored := []*ONSManagerRow{}
+	err = cur.All(&stored)
+	cur.Close()
+	if err != nil {
+		return err
+	}
+
+	//if old data, skip..
+	if force == false {
+		for _, row := range stored {
+			if block_num < row.BlockNum {
+				log.Printf("skip managers because of old block data : %v\n", block_num)
+				return nil
+			}
+		}
+	}
+
+	rows := newManagerRows(ons_manager, block_num)
+	keepGrantedBlockNums(rows, stored)
+	ids := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.Id)
+	}
+
+	_, err = table.Filter(func(row r.Term) r.Term {
+		return r.Expr(ids).Contains(row.Field("id")).Not()
+	}).Delete().RunWrite(s.session)
+	if err != nil {
+		log.Printf("Failed to delete removed managers : %v\n", err)
+		return err
+	}
+
+	if len(rows) > 0 {
+		_, err = table.Insert(rows, r.InsertOpts{Conflict: "replace"}).RunWrite(s.session)
+		if err != nil {
+			log.Printf("Failed to update managers : %v\n", err)
+			return err
+		}
+	}
+	log.Printf("UpdateManagers : %d managers at block %v\n", len(rows), block_num)
+	return nil
+}
+
+func (s *rethinkStore) DeleteManagers() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := s.table(MANAGER_TABLE).Delete().RunWrite(s.session)
+	if err != nil {
+		log.Printf("Failed to delete managers : %v\n", err)
+	}
+	return err
+}
+
+func (s *rethinkStore) GetBlock(block_id string) (*BlockRecord, error) {
+	block := &BlockRecord{}
+	found, err := s.getOne(BLOCK_TABLE, block_id, block)
+	if err != nil || found == false {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (s *rethinkStore) AddBlock(block *BlockRecord) error {
+	_, err := s.table(BLOCK_TABLE).Insert(block, r.InsertOpts{Conflict: "replace"}).RunWrite(s.session)
+	return err
+}
+
+func (s *rethinkStore) PendingBlocks() ([]*BlockRecord, error) {
+	cur, err := s.table(BLOCK_TABLE).Filter(r.Row.Field("Pending").Default(false).Eq(true)).OrderBy("BlockNum").Run(s.session)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	blocks := []*BlockRecord{}
+	err = cur.All(&blocks)
+	return blocks, err
+}
+
+func (s *rethinkStore) DeleteBlock(block_id string) error {
+	_, err := s.table(BLOCK_TABLE).Get(block_id).Delete().RunWrite(s.session)
+	return err
+}
+
+func (s *rethinkStore) PruneBlocks(block_num float64) error {
+	_, err := s.table(BLOCK_TABLE).Filter(r.Row.Field("BlockNum").Lt(block_num)).Delete().RunWrite(s.session)
+	return err
+}
+
+func (s *rethinkStore) OldestBlockNum() (float64, error) {
+	cur, err := s.table(BLOCK_TABLE).Min("BlockNum").Field("BlockNum").Default(-1).Run(s.session)
+	if err != nil {
+		return -1, err
+	}
+	defer cur.Close()
+
+	var block_num float64
+	err = cur.One(&block_num)
+	return block_num, err
+}
+
+func (s *rethinkStore) GetStateValue(address string) (*StateValue, error) {
+	state_value := &StateValue{}
+	found, err := s.getOne(STATE_VALUE_TABLE, address, state_value)
+	if err != nil {
+		return nil, err
+	}
+	if found == false {
+		return &StateValue{Address: address}, nil
+	}
+	return state_value, nil
+}
+
+func (s *rethinkStore) SetStateValue(state_value *StateValue) error {
+	var err error
+	if state_value.Value == nil {
+		_, err = s.table(STATE_VALUE_TABLE).Get(state_value.Address).Delete().RunWrite(s.session)
+	} else {
+		_, err = s.table(STATE_VALUE_TABLE).Insert(state_value, r.InsertOpts{Conflict: "replace"}).RunWrite(s.session)
+	}
+	return err
+}
+
+func (s *rethinkStore) StateAddresses() ([]string, error) {
+	cur, err := s.table(STATE_VALUE_TABLE).Field("Address").Run(s.session)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	addresses := []string{}
+	err = cur.All(&addresses)
+	return addresses, err
+}
+
+func (s *rethinkStore) Clear() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for table_idx := range g_table_names {
+		if g_registry_tables[table_idx] == true {
+			continue
+		}
+		_, err := s.table(table_idx).Delete().RunWrite(s.session)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//primary key로 한 item을 읽는다. item이 없으면 false를 반환한다.
+func (s *rethinkStore) getOne(table_idx int, pk_v string, v interface{}) (bool, error) {
+	cur, err := s.table(table_idx).Get(pk_v).Run(s.session)
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close()
+
+	if cur.IsNil() {
+		return false, nil
+	}
+	err = cur.One(v)
+	if err == r.ErrEmptyResult {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//primary key 순서로 filter에 맞는 item의 page와 전체 개수를 읽는다.
+//index가 주어지면 secondary index로 index_key에 해당하는 item만 읽는다.
+func (s *rethinkStore) list(table_idx int, index string, index_key interface{}, filter r.Term, has_filter bool, page Page, v interface{}) (int, error) {
+	pk_name := g_table_map[g_table_names[table_idx]]
+	var query r.Term
+	if len(index) > 0 {
+		query = s.table(table_idx).GetAllByIndex(index, index_key).OrderBy(pk_name)
+	} else {
+		query = s.table(table_idx).OrderBy(r.OrderByOpts{Index: r.Asc(pk_name)})
+	}
+	if has_filter {
+		query = query.Filter(filter)
+	}
+
+	cur, err := query.Count().Run(s.session)
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	err = cur.One(&total)
+	cur.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	cur, err = query.Skip(page.Offset).Limit(page.Limit).Run(s.session)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close()
+
+	err = cur.All(v)
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+func (s *rethinkStore) GetGS1Code(gs1_code string) (*ONSGS1CodeEvent, error) {
+	gs1_code_event := &ONSGS1CodeEvent{}
+	found, err := s.getOne(GS1_CODE_TABLE, gs1_code, gs1_code_event)
+	if err != nil || found == false {
+		return nil, err
+	}
+	return gs1_code_event, nil
+}
+
+func (s *rethinkStore) ListGS1Codes(filter *GS1CodeFilter, page Page) ([]*ONSGS1CodeEvent, int, error) {
+	//owner, provider 조건은 secondary index를 사용한다.
+	index, index_key := "", ""
+	conditions := []r.Term{}
+	if len(filter.OwnerId) > 0 {
+		index, index_key = "OwnerId", filter.OwnerId
+	}
+	if filter.HasState {
+		conditions = append(conditions, r.Row.Field("State").Default(0).Eq(filter.State))
+	}
+	//provider, service type은 record 중 하나라도 일치하면 된다.
+	if len(filter.Provider) > 0 {
+		provider := filter.Provider
+		if len(index) == 0 {
+			index, index_key = "Providers", provider
+		} else {
+			conditions = append(conditions, r.Row.Field("Records").Default([]interface{}{}).Contains(func(record r.Term) r.Term {
+				return record.Field("Provider").Default("").Eq(provider)
+			}))
+		}
+	}
+	if len(filter.ServiceType) > 0 {
+		service_type := filter.ServiceType
+		conditions = append(conditions, r.Row.Field("Records").Default([]interface{}{}).Contains(func(record r.Term) r.Term {
+			return record.Field("Service").Default("").Eq(service_type)
+		}))
+	}
+
+	gs1_codes := []*ONSGS1CodeEvent{}
+	total, err := s.list(GS1_CODE_TABLE, index, index_key, r.And(conditionArgs(conditions)...), len(conditions) > 0, page, &gs1_codes)
+	if err != nil {
+		return nil, 0, err
+	}
+	return gs1_codes, total, nil
+}
+
+func (s *rethinkStore) GetServiceType(address string) (*ONSServiceTypeEvent, error) {
+	service_type_event := &ONSServiceTypeEvent{}
+	found, err := s.getOne(SERVICE_TYPE_TABLE, address, service_type_event)
+	if err != nil || found == false {
+		return nil, err
+	}
+	return service_type_event, nil
+}
+
+func (s *rethinkStore) ListServiceTypes(filter *ServiceTypeFilter, page Page) ([]*ONSServiceTypeEvent, int, error) {
+	conditions := []r.Term{}
+	if len(filter.Provider) > 0 {
+		conditions = append(conditions, r.Row.Field("Provider").Eq(filter.Provider))
+	}
+	//path가 없으면 filter의 error가 되어 item을 제외한다.
+	for _, field := range filter.Fields {
+		value := r.Row.Field("Document")
+		for _, key := range field.Path {
+			value = value.Field(key)
+		}
+		conditions = append(conditions, r.Expr([]string{"STRING", "NUMBER", "BOOL"}).Contains(value.TypeOf()).And(
+			value.CoerceTo("string").Eq(field.Value)))
+	}
+
+	service_types := []*ONSServiceTypeEvent{}
+	total, err := s.list(SERVICE_TYPE_TABLE, "", nil, r.And(conditionArgs(conditions)...), len(conditions) > 0, page, &service_types)
+	if err != nil {
+		return nil, 0, err
+	}
+	return service_types, total, nil
+}
+
+//provider의 record를 GS1 code, record index 순서로 읽는다.
+func (s *rethinkStore) ListRecordsByProvider(provider string, page Page) ([]*ProviderRecord, int, error) {
+	cur, err := s.table(GS1_CODE_TABLE).GetAllByIndex("Providers", provider).OrderBy("Gs1Code").Run(s.session)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close()
+
+	gs1_codes := []*ONSGS1CodeEvent{}
+	err = cur.All(&gs1_codes)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records := []*ProviderRecord{}
+	for _, gs1_code := range gs1_codes {
+		for idx, record := range gs1_code.Records {
+			if record.Provider != provider {
+				continue
+			}
+			records = append(records, &ProviderRecord{
+				Gs1Code:     gs1_code.Gs1Code,
+				Gs1State:    gs1_code.State,
+				RecordIndex: idx,
+				Record:      record,
+				BlockNum:    gs1_code.BlockNum,
+			})
+		}
+	}
+
+	total := len(records)
+	if page.Offset >= total {
+		return []*ProviderRecord{}, total, nil
+	}
+	end := page.Offset + page.Limit
+	if end > total {
+		end = total
+	}
+	return records[page.Offset:end], total, nil
+}
+
+func (s *rethinkStore) ListManagedGS1Codes(address string, page Page) ([]*ManagedGS1Code, int, error) {
+	rows := []*ONSManagerRow{}
+	total, err := s.list(MANAGER_TABLE, "Address", address, r.Row.Field("Kind").Eq(MANAGER_KIND_GS1), true, page, &rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	managed := []*ManagedGS1Code{}
+	if len(rows) == 0 {
+		return managed, total, nil
+	}
+
+	keys := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, row.Gs1Code)
+	}
+	cur, err := s.table(GS1_CODE_TABLE).GetAll(keys...).Run(s.session)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close()
+
+	gs1_codes := []*ONSGS1CodeEvent{}
+	err = cur.All(&gs1_codes)
+	if err != nil {
+		return nil, 0, err
+	}
+	gs1_code_map := make(map[string]*ONSGS1CodeEvent)
+	for _, gs1_code := range gs1_codes {
+		gs1_code_map[gs1_code.Gs1Code] = gs1_code
+	}
+
+	for _, row := range rows {
+		managed = append(managed, &ManagedGS1Code{Manager: row, Gs1CodeData: gs1_code_map[row.Gs1Code]})
+	}
+	return managed, total, nil
+}
+
+func (s *rethinkStore) ListManagers(filter *ManagerFilter, page Page) ([]*ONSManagerRow, int, error) {
+	conditions := []r.Term{}
+	if len(filter.Kind) > 0 {
+		conditions = append(conditions, r.Row.Field("Kind").Eq(filter.Kind))
+	}
+	if len(filter.Gs1Code) > 0 {
+		conditions = append(conditions, r.Row.Field("Gs1Code").Eq(filter.Gs1Code))
+	}
+
+	managers := []*ONSManagerRow{}
+	total, err := s.list(MANAGER_TABLE, "", nil, r.And(conditionArgs(conditions)...), len(conditions) > 0, page, &managers)
+	if err != nil {
+		return nil, 0, err
+	}
+	return managers, total, nil
+}
+
+func conditionArgs(conditions []r.Term) []interface{} {
+	args := make([]interface{}, len(conditions))
+	for idx, condition := range conditions {
+		args[idx] = condition
+	}
+	return args
+}
+
+func (s *rethinkStore) AddWebhook(webhook *Webhook) error {
+	_, err := s.table(WEBHOOK_TABLE).Insert(webhook).RunWrite(s.session)
+	return err
+}
+
+func (s *rethinkStore) DeleteWebhook(id string) (bool, error) {
+	resp, err := s.table(WEBHOOK_TABLE).Get(id).Delete().RunWrite(s.session)
+	if err != nil {
+		return false, err
+	}
+	return resp.Deleted > 0, nil
+}
+
+func (s *rethinkStore) ListWebhooks() ([]*Webhook, error) {
+	cur, err := s.table(WEBHOOK_TABLE).OrderBy("CreatedAt", "id").Run(s.session)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	webhooks := []*Webhook{}
+	err = cur.All(&webhooks)
+	return webhooks, err
+}
+
+func (s *rethinkStore) AddWebhookDeadLetter(dead_letter *WebhookDeadLetter) error {
+	_, err := s.table(WEBHOOK_DEAD_LETTER_TABLE).Insert(dead_letter).RunWrite(s.session)
+	return err
+}
+
+func (s *rethinkStore) ListWebhookDeadLetters(page Page) ([]*WebhookDeadLetter, int, error) {
+	dead_letters := []*WebhookDeadLetter{}
+	total, err := s.list(WEBHOOK_DEAD_LETTER_TABLE, "", nil, r.Term{}, false, page, &dead_letters)
+	if err != nil {
+		return nil, 0, err
+	}
+	return dead_letters, total, nil
+}
+
+func (s *rethinkStore) AddGS1CodeVersion(version *GS1CodeVersion) error {
+	_, err := s.table(GS1_CODE_HISTORY_TABLE).Insert(version, r.InsertOpts{Conflict: "replace"}).RunWrite(s.session)
+	return err
+}
+
+func (s *rethinkStore) DeleteGS1CodeVersions(block_id string) error {
+	_, err := s.table(GS1_CODE_HISTORY_TABLE).GetAllByIndex("BlockId", block_id).Delete().RunWrite(s.session)
+	return err
+}
+
+func (s *rethinkStore) GetGS1CodeVersion(gs1_code string, as_of *AsOf) (*GS1CodeVersion, error) {
+	var upper interface{} = r.MaxVal
+	if as_of.HasBlockNum {
+		upper = as_of.BlockNum
+	}
+	query := s.table(GS1_CODE_HISTORY_TABLE).Between([]interface{}{gs1_code, r.MinVal}, []interface{}{gs1_code, upper},
+		r.BetweenOpts{Index: "Gs1CodeBlockNum", RightBound: "closed"}).OrderBy(r.OrderByOpts{Index: r.Desc("Gs1CodeBlockNum")})
+	if as_of.HasTime {
+		query = query.Filter(r.Row.Field("SyncedAt").Gt(0).And(r.Row.Field("SyncedAt").Le(as_of.Time)))
+	}
+	return s.getGS1CodeVersion(query)
+}
+
+func (s *rethinkStore) NextGS1CodeVersion(gs1_code string, block_num float64) (*GS1CodeVersion, error) {
+	return s.getGS1CodeVersion(s.table(GS1_CODE_HISTORY_TABLE).Between([]interface{}{gs1_code, block_num}, []interface{}{gs1_code, r.MaxVal},
+		r.BetweenOpts{Index: "Gs1CodeBlockNum", LeftBound: "open"}).OrderBy(r.OrderByOpts{Index: "Gs1CodeBlockNum"}))
+}
+
+func (s *rethinkStore) getGS1CodeVersion(query r.Term) (*GS1CodeVersion, error) {
+	cur, err := query.Limit(1).Run(s.session)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	version := &GS1CodeVersion{}
+	err = cur.One(version)
+	if err == r.ErrEmptyResult {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+func (s *rethinkStore) HasGS1CodeVersions() (bool, error) {
+	cur, err := s.table(GS1_CODE_HISTORY_TABLE).IsEmpty().Run(s.session)
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close()
+
+	var empty bool
+	err = cur.One(&empty)
+	return empty == false, err
+}
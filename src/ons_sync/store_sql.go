@@ -0,0 +1,1274 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/url"
+	"protobuf/ons_pb2"
+	"strconv"
+	"strings"
+	"sync"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//SQLite, PostgreSQL의 차이. query는 ? placeholder로 작성하고 실행할 때 바꾼다.
+type sqlDialect struct {
+	name   string
+	driver string
+	//$1, $2 형식의 placeholder를 사용한다.
+	numbered_placeholder bool
+}
+
+var sqliteDialect = &sqlDialect{name: STORE_SQLITE, driver: "sqlite3", numbered_placeholder: false}
+var postgresDialect = &sqlDialect{name: STORE_POSTGRES, driver: "postgres", numbered_placeholder: true}
+
+//service type document의 path 값이 value와 같은 조건.
+//string, number, boolean만 비교하고 boolean은 true, false로 비교한다.
+func (d *sqlDialect) fieldCondition(field *FieldFilter) (string, []interface{}) {
+	if d == postgresDialect {
+		placeholders := make([]string, len(field.Path))
+		args := []interface{}{}
+		for idx, key := range field.Path {
+			placeholders[idx] = "?"
+			args = append(args, key)
+		}
+		path := `CAST(ARRAY[` + strings.Join(placeholders, ", ") + `] AS text[])`
+		condition := `jsonb_typeof(CAST(document AS jsonb) #> ` + path + `) IN ('string', 'number', 'boolean') AND ` +
+			`CAST(document AS jsonb) #>> ` + path + ` = ?`
+		return condition, append(append(args, args...), field.Value)
+	}
+	path := "$"
+	for _, key := range field.Path {
+		path += `."` + key + `"`
+	}
+	condition := `(CASE json_type(document, ?) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ` +
+		`WHEN 'text' THEN json_extract(document, ?) WHEN 'integer' THEN CAST(json_extract(document, ?) AS TEXT) ` +
+		`WHEN 'real' THEN CAST(json_extract(document, ?) AS TEXT) END) = ?`
+	return condition, []interface{}{path, path, path, path, field.Value}
+}
+
+func (d *sqlDialect) rebind(query string) string {
+	if d.numbered_placeholder == false {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+//GS1 code의 record는 gs1_records table에 (gs1_code, record_index)로 저장한다.
+const SQL_RECORD_TABLE = "gs1_records"
+
+var g_sql_schema = []string{
+	`CREATE TABLE IF NOT EXISTS gs1_codes (
+		gs1_code TEXT PRIMARY KEY,
+		owner_id TEXT NOT NULL,
+		state INTEGER NOT NULL,
+		address TEXT NOT NULL,
+		block_num DOUBLE PRECISION NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS gs1_codes_owner_id ON gs1_codes (owner_id)`,
+	`CREATE INDEX IF NOT EXISTS gs1_codes_address ON gs1_codes (address)`,
+	`CREATE TABLE IF NOT EXISTS gs1_records (
+		gs1_code TEXT NOT NULL,
+		record_index INTEGER NOT NULL,
+		flags INTEGER NOT NULL,
+		service TEXT NOT NULL,
+		naptr_regexp TEXT NOT NULL,
+		state INTEGER NOT NULL,
+		provider TEXT NOT NULL,
+		PRIMARY KEY (gs1_code, record_index)
+	)`,
+	`CREATE INDEX IF NOT EXISTS gs1_records_provider ON gs1_records (provider)`,
+	`CREATE TABLE IF NOT EXISTS service_types (
+		address TEXT PRIMARY KEY,
+		provider TEXT NOT NULL,
+		fields TEXT NOT NULL,
+		types TEXT NOT NULL,
+		block_num DOUBLE PRECISION NOT NULL,
+		document TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS service_types_provider ON service_types (provider)`,
+	`CREATE TABLE IF NOT EXISTS managers (
+		id TEXT PRIMARY KEY,
+		kind TEXT NOT NULL,
+		gs1_code TEXT NOT NULL,
+		address TEXT NOT NULL,
+		block_num DOUBLE PRECISION NOT NULL,
+		granted_block_num DOUBLE PRECISION NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS managers_address ON managers (address)`,
+	`CREATE TABLE IF NOT EXISTS latest_updated_block_info (
+		id INTEGER PRIMARY KEY,
+		block_num DOUBLE PRECISION NOT NULL,
+		block_id TEXT NOT NULL,
+		previous_block_id TEXT NOT NULL
+	)`,
+	//undo는 StateValue list의 JSON이다. pending은 적용하거나 되돌리는 중인 block이면 1이다.
+	`CREATE TABLE IF NOT EXISTS blocks (
+		block_id TEXT PRIMARY KEY,
+		block_num DOUBLE PRECISION NOT NULL,
+		previous_block_id TEXT NOT NULL,
+		undo TEXT NOT NULL,
+		pending INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS blocks_block_num ON blocks (block_num)`,
+	//value는 base64로 저장한다.
+	`CREATE TABLE IF NOT EXISTS state_values (
+		address TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		block_num DOUBLE PRECISION NOT NULL
+	)`,
+	//value는 base64로 저장하고 삭제된 version(tombstone)은 NULL이다.
+	`CREATE TABLE IF NOT EXISTS gs1_code_history (
+		gs1_code TEXT NOT NULL,
+		block_num DOUBLE PRECISION NOT NULL,
+		block_id TEXT NOT NULL,
+		synced_at BIGINT NOT NULL,
+		address TEXT NOT NULL,
+		value TEXT,
+		PRIMARY KEY (gs1_code, block_num)
+	)`,
+	`CREATE INDEX IF NOT EXISTS gs1_code_history_block_id ON gs1_code_history (block_id)`,
+	//kinds는 event kind list의 JSON이다.
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		gs1_prefix TEXT NOT NULL,
+		owner TEXT NOT NULL,
+		kinds TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+		id TEXT PRIMARY KEY,
+		webhook_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		error TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		failed_at TEXT NOT NULL
+	)`,
+}
+
+//SQLite 또는 PostgreSQL store.
+type sqlStore struct {
+	db *sql.DB
+	//transaction 밖의 read에 사용한다. SQLite file이면 write connection을 기다리지 않도록 db와 다른 pool이다.
+	read_db *sql.DB
+	dialect *sqlDialect
+	mutex   *sync.Mutex
+	//Begin으로 만든 store이면 모든 query를 tx에서 실행한다.
+	tx *sql.Tx
+}
+
+//Begin으로 시작한 transaction. Commit 또는 Rollback 할 때까지 다른 write는 기다린다.
+type sqlStoreTx struct {
+	*sqlStore
+	finished bool
+}
+
+//query를 실행할 수 있는 *sql.DB, *sql.Tx
+type sqlQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+//username, password, TLS 설정을 connection string(key=value 또는 postgres:// URL)에 추가한다.
+//TLS를 사용하면 sslmode는 verify-full이고 insecure_skip_verify이면 require이다.
+func postgresDSN(dsn string, options *StoreOptions) (string, error) {
+	params := [][2]string{}
+	if len(options.Username) > 0 {
+		params = append(params, [2]string{"user", options.Username})
+	}
+	if len(options.Password) > 0 {
+		params = append(params, [2]string{"password", options.Password})
+	}
+	if options.TLS.Enabled == true {
+		sslmode := "verify-full"
+		if options.TLS.InsecureSkipVerify == true {
+			sslmode = "require"
+		}
+		params = append(params, [2]string{"sslmode", sslmode})
+		if len(options.TLS.CAFile) > 0 {
+			params = append(params, [2]string{"sslrootcert", options.TLS.CAFile})
+		}
+		if len(options.TLS.CertFile) > 0 {
+			params = append(params, [2]string{"sslcert", options.TLS.CertFile}, [2]string{"sslkey", options.TLS.KeyFile})
+		}
+	}
+	if len(params) == 0 {
+		return dsn, nil
+	}
+
+	if strings.HasPrefix(dsn, "postgres://") == true || strings.HasPrefix(dsn, "postgresql://") == true {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		query := u.Query()
+		for _, param := range params {
+			if param[0] == "user" || param[0] == "password" {
+				continue
+			}
+			query.Set(param[0], param[1])
+		}
+		if len(options.Username) > 0 {
+			u.User = url.UserPassword(options.Username, options.Password)
+		} else if len(options.Password) > 0 && u.User != nil {
+			u.User = url.UserPassword(u.User.Username(), options.Password)
+		} else if len(options.Password) > 0 {
+			query.Set("password", options.Password)
+		}
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+
+	//key=value 형식은 뒤의 값이 앞의 값을 덮어쓴다.
+	for _, param := range params {
+		value := strings.Replace(strings.Replace(param[1], `\`, `\\`, -1), `'`, `\'`, -1)
+		dsn += " " + param[0] + "='" + value + "'"
+	}
+	return strings.TrimSpace(dsn), nil
+}
+
+//SQLite가 memory database이면 connection마다 다른 database이므로 read pool을 따로 만들 수 없다.
+func sqliteInMemory(dsn string) bool {
+	return strings.HasPrefix(dsn, ":memory:") == true || strings.Contains(dsn, "mode=memory") == true
+}
+
+//lock을 기다리는 시간을 지정하지 않았으면 추가한다.
+func sqliteDSN(dsn string) string {
+	if strings.Contains(dsn, "_timeout") == true {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") == true {
+		return dsn + "&_busy_timeout=5000"
+	}
+	return dsn + "?_busy_timeout=5000"
+}
+
+func NewSQLStore(dialect *sqlDialect, dsn string, verbose bool) (Store, error) {
+	log.Printf("Open %s database\n", dialect.name)
+	if dialect == sqliteDialect {
+		dsn = sqliteDSN(dsn)
+	}
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == sqliteDialect {
+		//SQLite는 동시에 하나의 writer만 허용한다.
+		db.SetMaxOpenConns(1)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		log.Printf("Failed to connect %s database\n", dialect.name)
+		db.Close()
+		return nil, err
+	}
+
+	for _, statement := range g_sql_schema {
+		if verbose == true {
+			log.Printf("%s\n", statement)
+		}
+		_, err = db.Exec(statement)
+		if err != nil {
+			log.Printf("Failed to create %s schema\n", dialect.name)
+			db.Close()
+			return nil, err
+		}
+	}
+
+	//이전 version의 database에 없는 column을 추가한다.
+	for _, column := range g_sql_added_columns {
+		err = addColumnIfMissing(db, column)
+		if err != nil {
+			log.Printf("Failed to add %s.%s column\n", column.table, column.name)
+			db.Close()
+			return nil, err
+		}
+	}
+
+	//WAL mode에서는 write transaction 중에도 다른 connection에서 commit 된 data를 읽을 수 있다.
+	//bootstrap처럼 긴 transaction 중에도 query API가 기다리지 않도록 read는 다른 pool에서 실행한다.
+	read_db := db
+	if dialect == sqliteDialect && sqliteInMemory(dsn) == false {
+		_, err = db.Exec(`PRAGMA journal_mode=WAL`)
+		if err == nil {
+			read_db, err = sql.Open(dialect.driver, dsn)
+		}
+		if err != nil {
+			log.Printf("Failed to open %s read connections\n", dialect.name)
+			db.Close()
+			return nil, err
+		}
+	}
+	return &sqlStore{db: db, read_db: read_db, dialect: dialect, mutex: &sync.Mutex{}}, nil
+}
+
+//schema에 나중에 추가한 column. backfill은 column을 추가한 후에 실행한다.
+type sqlAddedColumn struct {
+	table      string
+	name       string
+	definition string
+	backfill   string
+}
+
+var g_sql_added_columns = []sqlAddedColumn{
+	{"managers", "granted_block_num", "DOUBLE PRECISION NOT NULL DEFAULT 0", `UPDATE managers SET granted_block_num = block_num`},
+	//document는 시작할 때 BackfillServiceTypeDocuments가 만든다.
+	{"service_types", "document", "TEXT", ""},
+	{"blocks", "pending", "INTEGER NOT NULL DEFAULT 0", ""},
+}
+
+func addColumnIfMissing(db *sql.DB, column sqlAddedColumn) error {
+	rows, err := db.Query(`SELECT ` + column.name + ` FROM ` + column.table + ` LIMIT 1`)
+	if err == nil {
+		rows.Close()
+		return nil
+	}
+
+	log.Printf("add %s.%s column\n", column.table, column.name)
+	_, err = db.Exec(`ALTER TABLE ` + column.table + ` ADD COLUMN ` + column.name + ` ` + column.definition)
+	if err != nil || len(column.backfill) == 0 {
+		return err
+	}
+	_, err = db.Exec(column.backfill)
+	return err
+}
+
+func (s *sqlStore) Close() error {
+	log.Printf("database will be closed\n")
+	if s.read_db != s.db {
+		s.read_db.Close()
+	}
+	return s.db.Close()
+}
+
+func (s *sqlStore) exec(q sqlQueryer, query string, args ...interface{}) (sql.Result, error) {
+	return q.Exec(s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStore) query(q sqlQueryer, query string, args ...interface{}) (*sql.Rows, error) {
+	return q.Query(s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStore) queryRow(q sqlQueryer, query string, args ...interface{}) *sql.Row {
+	return q.QueryRow(s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStore) queryer() sqlQueryer {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.read_db
+}
+
+//write는 하나의 transaction에서 실행한다. f가 error를 반환하면 rollback 한다.
+//Begin으로 만든 store이면 Begin의 transaction에서 실행한다.
+func (s *sqlStore) transaction(f func(tx *sql.Tx) error) error {
+	if s.tx != nil {
+		return f(s.tx)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Begin() (StoreTx, error) {
+	s.mutex.Lock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	return &sqlStoreTx{sqlStore: &sqlStore{db: s.db, read_db: s.read_db, dialect: s.dialect, mutex: s.mutex, tx: tx}}, nil
+}
+
+func (s *sqlStore) Atomic() bool {
+	return true
+}
+
+func (t *sqlStoreTx) Commit() error {
+	if t.finished == true {
+		return sql.ErrTxDone
+	}
+	t.finished = true
+	defer t.mutex.Unlock()
+	return t.tx.Commit()
+}
+
+func (t *sqlStoreTx) Rollback() error {
+	if t.finished == true {
+		return sql.ErrTxDone
+	}
+	t.finished = true
+	defer t.mutex.Unlock()
+	return t.tx.Rollback()
+}
+
+func (s *sqlStore) GetLastBlock() (*BlockInfo, error) {
+	block := &BlockInfo{}
+	err := s.queryRow(s.queryer(), `SELECT block_num, block_id, previous_block_id FROM latest_updated_block_info WHERE id = 0`).Scan(
+		&block.BlockNum, &block.BlockId, &block.PreviousBlockId)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (s *sqlStore) SetLastBlock(block *BlockInfo) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		result, err := s.exec(tx, `UPDATE latest_updated_block_info SET block_num = ?, block_id = ?, previous_block_id = ? WHERE id = 0`,
+			block.BlockNum, block.BlockId, block.PreviousBlockId)
+		if err != nil {
+			return err
+		}
+		if updated, _ := result.RowsAffected(); updated > 0 {
+			return nil
+		}
+		_, err = s.exec(tx, `INSERT INTO latest_updated_block_info (id, block_num, block_id, previous_block_id) VALUES (0, ?, ?, ?)`,
+			block.BlockNum, block.BlockId, block.PreviousBlockId)
+		return err
+	})
+}
+
+const SQL_BLOCK_COLUMNS = `block_id, block_num, previous_block_id, undo, pending`
+
+func scanBlock(scanner interface{ Scan(...interface{}) error }) (*BlockRecord, error) {
+	block := &BlockRecord{}
+	var undo string
+	var pending int
+	err := scanner.Scan(&block.BlockId, &block.BlockNum, &block.PreviousBlockId, &undo, &pending)
+	if err != nil {
+		return nil, err
+	}
+	block.Pending = pending != 0
+	err = json.Unmarshal([]byte(undo), &block.Undo)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (s *sqlStore) GetBlock(block_id string) (*BlockRecord, error) {
+	block, err := scanBlock(s.queryRow(s.queryer(), `SELECT `+SQL_BLOCK_COLUMNS+` FROM blocks WHERE block_id = ?`, block_id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return block, err
+}
+
+func (s *sqlStore) PendingBlocks() ([]*BlockRecord, error) {
+	rows, err := s.query(s.queryer(), `SELECT `+SQL_BLOCK_COLUMNS+` FROM blocks WHERE pending <> 0 ORDER BY block_num`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := []*BlockRecord{}
+	for rows.Next() {
+		block, err := scanBlock(rows)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, rows.Err()
+}
+
+func (s *sqlStore) AddBlock(block *BlockRecord) error {
+	undo, err := json.Marshal(block.Undo)
+	if err != nil {
+		return err
+	}
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `DELETE FROM blocks WHERE block_id = ?`, block.BlockId)
+		if err != nil {
+			return err
+		}
+		pending := 0
+		if block.Pending == true {
+			pending = 1
+		}
+		_, err = s.exec(tx, `INSERT INTO blocks (`+SQL_BLOCK_COLUMNS+`) VALUES (?, ?, ?, ?, ?)`,
+			block.BlockId, block.BlockNum, block.PreviousBlockId, string(undo), pending)
+		return err
+	})
+}
+
+func (s *sqlStore) DeleteBlock(block_id string) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `DELETE FROM blocks WHERE block_id = ?`, block_id)
+		return err
+	})
+}
+
+func (s *sqlStore) PruneBlocks(block_num float64) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `DELETE FROM blocks WHERE block_num < ?`, block_num)
+		return err
+	})
+}
+
+func (s *sqlStore) OldestBlockNum() (float64, error) {
+	var block_num float64
+	err := s.queryRow(s.queryer(), `SELECT COALESCE(MIN(block_num), -1) FROM blocks`).Scan(&block_num)
+	return block_num, err
+}
+
+func (s *sqlStore) GetStateValue(address string) (*StateValue, error) {
+	state_value := &StateValue{Address: address}
+	var value string
+	err := s.queryRow(s.queryer(), `SELECT value, block_num FROM state_values WHERE address = ?`, address).Scan(&value, &state_value.BlockNum)
+	if err == sql.ErrNoRows {
+		return state_value, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state_value.Value, err = base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return state_value, nil
+}
+
+func (s *sqlStore) SetStateValue(state_value *StateValue) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `DELETE FROM state_values WHERE address = ?`, state_value.Address)
+		if err != nil || state_value.Value == nil {
+			return err
+		}
+		_, err = s.exec(tx, `INSERT INTO state_values (address, value, block_num) VALUES (?, ?, ?)`,
+			state_value.Address, base64.StdEncoding.EncodeToString(state_value.Value), state_value.BlockNum)
+		return err
+	})
+}
+
+func (s *sqlStore) StateAddresses() ([]string, error) {
+	rows, err := s.query(s.queryer(), `SELECT address FROM state_values`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []string{}
+	for rows.Next() {
+		var address string
+		err = rows.Scan(&address)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, rows.Err()
+}
+
+func (s *sqlStore) Clear() error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `DELETE FROM `+SQL_RECORD_TABLE)
+		if err != nil {
+			return err
+		}
+		for table_idx, table_name := range g_table_names {
+			if g_registry_tables[table_idx] == true {
+				continue
+			}
+			_, err = s.exec(tx, `DELETE FROM `+table_name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//저장된 item의 block number. item이 없으면 false를 반환한다.
+func (s *sqlStore) storedBlockNum(tx *sql.Tx, query string, pk_v string) (float64, bool, error) {
+	var block_num float64
+	err := s.queryRow(tx, query, pk_v).Scan(&block_num)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return block_num, true, nil
+}
+
+func (s *sqlStore) UpsertGS1Code(gs1_code *ONSGS1CodeEvent) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		block_num, exist, err := s.storedBlockNum(tx, `SELECT block_num FROM gs1_codes WHERE gs1_code = ?`, gs1_code.Gs1Code)
+		if err != nil {
+			return err
+		}
+		//if old data, skip..
+		if exist == true && gs1_code.BlockNum <= block_num {
+			log.Printf("skip item because of old block data : %s, %s, %v\n", "gs1_code", gs1_code.Gs1Code, gs1_code.BlockNum)
+			return nil
+		}
+
+		if exist == true {
+			_, err = s.exec(tx, `UPDATE gs1_codes SET owner_id = ?, state = ?, address = ?, block_num = ? WHERE gs1_code = ?`,
+				gs1_code.OwnerId, int32(gs1_code.State), gs1_code.Address, gs1_code.BlockNum, gs1_code.Gs1Code)
+			if err == nil {
+				_, err = s.exec(tx, `DELETE FROM gs1_records WHERE gs1_code = ?`, gs1_code.Gs1Code)
+			}
+		} else {
+			_, err = s.exec(tx, `INSERT INTO gs1_codes (gs1_code, owner_id, state, address, block_num) VALUES (?, ?, ?, ?, ?)`,
+				gs1_code.Gs1Code, gs1_code.OwnerId, int32(gs1_code.State), gs1_code.Address, gs1_code.BlockNum)
+		}
+		if err != nil {
+			return err
+		}
+
+		for idx, record := range gs1_code.Records {
+			_, err = s.exec(tx, `INSERT INTO gs1_records (gs1_code, record_index, flags, service, naptr_regexp, state, provider) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				gs1_code.Gs1Code, idx, record.Flags, record.Service, record.Regexp, int32(record.State), record.Provider)
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("UpsertGS1Code : %s at block %v\n", gs1_code.Gs1Code, gs1_code.BlockNum)
+		return nil
+	})
+}
+
+func (s *sqlStore) UpsertServiceType(service_type *ONSServiceTypeEvent) error {
+	fields, err := json.Marshal(service_type.Fields)
+	if err != nil {
+		return err
+	}
+	types, err := json.Marshal(service_type.Types)
+	if err != nil {
+		return err
+	}
+	document, err := json.Marshal(service_type.Document)
+	if err != nil {
+		return err
+	}
+
+	return s.transaction(func(tx *sql.Tx) error {
+		block_num, exist, err := s.storedBlockNum(tx, `SELECT block_num FROM service_types WHERE address = ?`, service_type.Address)
+		if err != nil {
+			return err
+		}
+		//if old data, skip..
+		if exist == true && service_type.BlockNum <= block_num {
+			log.Printf("skip item because of old block data : %s, %s, %v\n", "service_type", service_type.Address, service_type.BlockNum)
+			return nil
+		}
+
+		if exist == true {
+			_, err = s.exec(tx, `UPDATE service_types SET provider = ?, fields = ?, types = ?, document = ?, block_num = ? WHERE address = ?`,
+				service_type.Provider, string(fields), string(types), string(document), service_type.BlockNum, service_type.Address)
+		} else {
+			_, err = s.exec(tx, `INSERT INTO service_types (address, provider, fields, types, document, block_num) VALUES (?, ?, ?, ?, ?, ?)`,
+				service_type.Address, service_type.Provider, string(fields), string(types), string(document), service_type.BlockNum)
+		}
+		if err == nil {
+			log.Printf("UpsertServiceType : %s at block %v\n", service_type.Address, service_type.BlockNum)
+		}
+		return err
+	})
+}
+
+func (s *sqlStore) SetServiceTypeDocument(address string, document map[string]interface{}) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `UPDATE service_types SET document = ? WHERE address = ?`, string(data), address)
+		return err
+	})
+}
+
+func (s *sqlStore) DeleteAddress(address string) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `DELETE FROM gs1_records WHERE gs1_code IN (SELECT gs1_code FROM gs1_codes WHERE address = ?)`, address)
+		if err != nil {
+			return err
+		}
+		var deleted int64
+		for _, query := range []string{
+			`DELETE FROM gs1_codes WHERE address = ?`,
+			`DELETE FROM service_types WHERE address = ?`,
+		} {
+			result, err := s.exec(tx, query, address)
+			if err != nil {
+				return err
+			}
+			n, _ := result.RowsAffected()
+			deleted += n
+		}
+		if deleted == 0 {
+			log.Printf("Nothing has been deleted.")
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) DeleteGS1Code(gs1_code string) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `DELETE FROM gs1_records WHERE gs1_code = ?`, gs1_code)
+		if err == nil {
+			_, err = s.exec(tx, `DELETE FROM gs1_codes WHERE gs1_code = ?`, gs1_code)
+		}
+		return err
+	})
+}
+
+func (s *sqlStore) UpdateManagers(ons_manager *ons_pb2.ONSManager, block_num float64, force bool) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		stored, err := s.listManagerRows(tx, "", nil, Page{Limit: -1})
+		if err != nil {
+			return err
+		}
+
+		//if old data, skip..
+		if force == false {
+			for _, row := range stored {
+				if block_num < row.BlockNum {
+					log.Printf("skip managers because of old block data : %v\n", block_num)
+					return nil
+				}
+			}
+		}
+
+		_, err = s.exec(tx, `DELETE FROM managers`)
+		if err != nil {
+			return err
+		}
+		rows := newManagerRows(ons_manager, block_num)
+		keepGrantedBlockNums(rows, stored)
+		for _, row := range rows {
+			_, err = s.exec(tx, `INSERT INTO managers (id, kind, gs1_code, address, block_num, granted_block_num) VALUES (?, ?, ?, ?, ?, ?)`,
+				row.Id, row.Kind, row.Gs1Code, row.Address, row.BlockNum, row.GrantedBlockNum)
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("UpdateManagers : %d managers at block %v\n", len(rows), block_num)
+		return nil
+	})
+}
+
+//page.Limit이 음수이면 모두 읽는다.
+func (s *sqlStore) listManagerRows(q sqlQueryer, where string, args []interface{}, page Page) ([]*ONSManagerRow, error) {
+	query := `SELECT id, kind, gs1_code, address, block_num, granted_block_num FROM managers` + where + ` ORDER BY id`
+	if page.Limit >= 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, page.Limit, page.Offset)
+	}
+	rows, err := s.query(q, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	managers := []*ONSManagerRow{}
+	for rows.Next() {
+		row := &ONSManagerRow{}
+		err = rows.Scan(&row.Id, &row.Kind, &row.Gs1Code, &row.Address, &row.BlockNum, &row.GrantedBlockNum)
+		if err != nil {
+			return nil, err
+		}
+		managers = append(managers, row)
+	}
+	return managers, rows.Err()
+}
+
+func (s *sqlStore) ListManagers(filter *ManagerFilter, page Page) ([]*ONSManagerRow, int, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	if len(filter.Kind) > 0 {
+		conditions = append(conditions, `kind = ?`)
+		args = append(args, filter.Kind)
+	}
+	if len(filter.Gs1Code) > 0 {
+		conditions = append(conditions, `gs1_code = ?`)
+		args = append(args, filter.Gs1Code)
+	}
+	where := whereClause(conditions)
+
+	total, err := s.count(`managers`, where, args)
+	if err != nil {
+		return nil, 0, err
+	}
+	managers, err := s.listManagerRows(s.queryer(), where, args, page)
+	if err != nil {
+		return nil, 0, err
+	}
+	return managers, total, nil
+}
+
+func (s *sqlStore) DeleteManagers() error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `DELETE FROM managers`)
+		return err
+	})
+}
+
+//GS1 code들의 record를 읽어서 Records에 채운다.
+func (s *sqlStore) loadRecords(gs1_codes []*ONSGS1CodeEvent) error {
+	if len(gs1_codes) == 0 {
+		return nil
+	}
+	gs1_code_map := make(map[string]*ONSGS1CodeEvent)
+	args := make([]interface{}, 0, len(gs1_codes))
+	for _, gs1_code := range gs1_codes {
+		gs1_code.Records = []*ons_pb2.Record{}
+		gs1_code_map[gs1_code.Gs1Code] = gs1_code
+		args = append(args, gs1_code.Gs1Code)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	rows, err := s.query(s.queryer(), `SELECT gs1_code, flags, service, naptr_regexp, state, provider FROM gs1_records
+		WHERE gs1_code IN (`+placeholders+`) ORDER BY gs1_code, record_index`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var gs1_code string
+		var state int32
+		record := &ons_pb2.Record{}
+		err = rows.Scan(&gs1_code, &record.Flags, &record.Service, &record.Regexp, &state, &record.Provider)
+		if err != nil {
+			return err
+		}
+		record.State = ons_pb2.Record_RecordState(state)
+		gs1_code_map[gs1_code].Records = append(gs1_code_map[gs1_code].Records, record)
+	}
+	return rows.Err()
+}
+
+func scanGS1Code(scanner interface{ Scan(...interface{}) error }) (*ONSGS1CodeEvent, error) {
+	gs1_code := &ONSGS1CodeEvent{}
+	var state int32
+	err := scanner.Scan(&gs1_code.Gs1Code, &gs1_code.OwnerId, &state, &gs1_code.Address, &gs1_code.BlockNum)
+	if err != nil {
+		return nil, err
+	}
+	gs1_code.State = ons_pb2.GS1CodeData_GS1CodeState(state)
+	return gs1_code, nil
+}
+
+func (s *sqlStore) GetGS1Code(gs1_code string) (*ONSGS1CodeEvent, error) {
+	gs1_code_event, err := scanGS1Code(s.queryRow(s.queryer(), `SELECT gs1_code, owner_id, state, address, block_num FROM gs1_codes WHERE gs1_code = ?`, gs1_code))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = s.loadRecords([]*ONSGS1CodeEvent{gs1_code_event})
+	if err != nil {
+		return nil, err
+	}
+	return gs1_code_event, nil
+}
+
+//where 조건에 맞는 item의 전체 개수를 읽는다.
+func (s *sqlStore) count(from string, where string, args []interface{}) (int, error) {
+	var total int
+	err := s.queryRow(s.queryer(), `SELECT COUNT(*) FROM `+from+where, args...).Scan(&total)
+	return total, err
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+func (s *sqlStore) ListGS1Codes(filter *GS1CodeFilter, page Page) ([]*ONSGS1CodeEvent, int, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	if len(filter.OwnerId) > 0 {
+		conditions = append(conditions, `g.owner_id = ?`)
+		args = append(args, filter.OwnerId)
+	}
+	if filter.HasState {
+		conditions = append(conditions, `g.state = ?`)
+		args = append(args, filter.State)
+	}
+	//provider, service type은 record 중 하나라도 일치하면 된다.
+	if len(filter.Provider) > 0 {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM gs1_records r WHERE r.gs1_code = g.gs1_code AND r.provider = ?)`)
+		args = append(args, filter.Provider)
+	}
+	if len(filter.ServiceType) > 0 {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM gs1_records r WHERE r.gs1_code = g.gs1_code AND r.service = ?)`)
+		args = append(args, filter.ServiceType)
+	}
+	where := whereClause(conditions)
+
+	total, err := s.count(`gs1_codes g`, where, args)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.query(s.queryer(), `SELECT g.gs1_code, g.owner_id, g.state, g.address, g.block_num FROM gs1_codes g`+where+
+		` ORDER BY g.gs1_code LIMIT ? OFFSET ?`, append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	gs1_codes := []*ONSGS1CodeEvent{}
+	for rows.Next() {
+		gs1_code, err := scanGS1Code(rows)
+		if err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		gs1_codes = append(gs1_codes, gs1_code)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	err = s.loadRecords(gs1_codes)
+	if err != nil {
+		return nil, 0, err
+	}
+	return gs1_codes, total, nil
+}
+
+func scanServiceType(scanner interface{ Scan(...interface{}) error }) (*ONSServiceTypeEvent, error) {
+	service_type := &ONSServiceTypeEvent{}
+	var fields, types string
+	var document sql.NullString
+	err := scanner.Scan(&service_type.Address, &service_type.Provider, &fields, &types, &document, &service_type.BlockNum)
+	if err != nil {
+		return nil, err
+	}
+	//document column을 추가하기 전에 저장한 service type은 NULL이다.
+	if document.Valid == true {
+		err = json.Unmarshal([]byte(document.String), &service_type.Document)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = json.Unmarshal([]byte(fields), &service_type.Fields)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(types), &service_type.Types)
+	if err != nil {
+		return nil, err
+	}
+	return service_type, nil
+}
+
+func (s *sqlStore) GetServiceType(address string) (*ONSServiceTypeEvent, error) {
+	service_type, err := scanServiceType(s.queryRow(s.queryer(), `SELECT address, provider, fields, types, document, block_num FROM service_types WHERE address = ?`, address))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return service_type, nil
+}
+
+func (s *sqlStore) ListServiceTypes(filter *ServiceTypeFilter, page Page) ([]*ONSServiceTypeEvent, int, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	if len(filter.Provider) > 0 {
+		conditions = append(conditions, `provider = ?`)
+		args = append(args, filter.Provider)
+	}
+	for _, field := range filter.Fields {
+		condition, field_args := s.dialect.fieldCondition(field)
+		conditions = append(conditions, condition)
+		args = append(args, field_args...)
+	}
+	where := whereClause(conditions)
+
+	total, err := s.count(`service_types`, where, args)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.query(s.queryer(), `SELECT address, provider, fields, types, document, block_num FROM service_types`+where+
+		` ORDER BY address LIMIT ? OFFSET ?`, append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	service_types := []*ONSServiceTypeEvent{}
+	for rows.Next() {
+		service_type, err := scanServiceType(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		service_types = append(service_types, service_type)
+	}
+	return service_types, total, rows.Err()
+}
+
+//provider의 record를 GS1 code, record index 순서로 읽는다.
+func (s *sqlStore) ListRecordsByProvider(provider string, page Page) ([]*ProviderRecord, int, error) {
+	total, err := s.count(`gs1_records`, ` WHERE provider = ?`, []interface{}{provider})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.query(s.queryer(), `SELECT r.gs1_code, g.state, r.record_index, r.flags, r.service, r.naptr_regexp, r.state, r.provider, g.block_num
+		FROM gs1_records r JOIN gs1_codes g ON g.gs1_code = r.gs1_code
+		WHERE r.provider = ? ORDER BY r.gs1_code, r.record_index LIMIT ? OFFSET ?`, provider, page.Limit, page.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	records := []*ProviderRecord{}
+	for rows.Next() {
+		var gs1_state, record_state int32
+		record := &ProviderRecord{Record: &ons_pb2.Record{}}
+		err = rows.Scan(&record.Gs1Code, &gs1_state, &record.RecordIndex, &record.Record.Flags, &record.Record.Service,
+			&record.Record.Regexp, &record_state, &record.Record.Provider, &record.BlockNum)
+		if err != nil {
+			return nil, 0, err
+		}
+		record.Gs1State = ons_pb2.GS1CodeData_GS1CodeState(gs1_state)
+		record.Record.State = ons_pb2.Record_RecordState(record_state)
+		records = append(records, record)
+	}
+	return records, total, rows.Err()
+}
+
+func (s *sqlStore) ListManagedGS1Codes(address string, page Page) ([]*ManagedGS1Code, int, error) {
+	total, err := s.count(`managers`, ` WHERE address = ? AND kind = ?`, []interface{}{address, MANAGER_KIND_GS1})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.query(s.queryer(), `SELECT m.id, m.kind, m.gs1_code, m.address, m.block_num, m.granted_block_num,
+		g.gs1_code, g.owner_id, g.state, g.address, g.block_num
+		FROM managers m LEFT JOIN gs1_codes g ON g.gs1_code = m.gs1_code
+		WHERE m.address = ? AND m.kind = ? ORDER BY m.gs1_code LIMIT ? OFFSET ?`, address, MANAGER_KIND_GS1, page.Limit, page.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	managed := []*ManagedGS1Code{}
+	gs1_codes := []*ONSGS1CodeEvent{}
+	for rows.Next() {
+		row := &ONSManagerRow{}
+		var gs1_code, owner_id, gs1_address sql.NullString
+		var state sql.NullInt64
+		var block_num sql.NullFloat64
+		err = rows.Scan(&row.Id, &row.Kind, &row.Gs1Code, &row.Address, &row.BlockNum, &row.GrantedBlockNum,
+			&gs1_code, &owner_id, &state, &gs1_address, &block_num)
+		if err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		m := &ManagedGS1Code{Manager: row}
+		if gs1_code.Valid {
+			m.Gs1CodeData = &ONSGS1CodeEvent{Address: gs1_address.String, BlockNum: block_num.Float64}
+			m.Gs1CodeData.Gs1Code = gs1_code.String
+			m.Gs1CodeData.OwnerId = owner_id.String
+			m.Gs1CodeData.State = ons_pb2.GS1CodeData_GS1CodeState(state.Int64)
+			gs1_codes = append(gs1_codes, m.Gs1CodeData)
+		}
+		managed = append(managed, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	err = s.loadRecords(gs1_codes)
+	if err != nil {
+		return nil, 0, err
+	}
+	return managed, total, nil
+}
+
+func (s *sqlStore) AddWebhook(webhook *Webhook) error {
+	kinds, err := json.Marshal(webhook.Kinds)
+	if err != nil {
+		return err
+	}
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `INSERT INTO webhooks (id, url, gs1_prefix, owner, kinds, secret, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			webhook.Id, webhook.Url, webhook.Gs1Prefix, webhook.Owner, string(kinds), webhook.Secret, webhook.CreatedAt)
+		return err
+	})
+}
+
+func (s *sqlStore) DeleteWebhook(id string) (bool, error) {
+	deleted := false
+	err := s.transaction(func(tx *sql.Tx) error {
+		result, err := s.exec(tx, `DELETE FROM webhooks WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		deleted = n > 0
+		return err
+	})
+	return deleted, err
+}
+
+func (s *sqlStore) ListWebhooks() ([]*Webhook, error) {
+	rows, err := s.query(s.queryer(), `SELECT id, url, gs1_prefix, owner, kinds, secret, created_at FROM webhooks ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		webhook := &Webhook{}
+		var kinds string
+		err = rows.Scan(&webhook.Id, &webhook.Url, &webhook.Gs1Prefix, &webhook.Owner, &kinds, &webhook.Secret, &webhook.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(kinds), &webhook.Kinds)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *sqlStore) AddWebhookDeadLetter(dead_letter *WebhookDeadLetter) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `INSERT INTO webhook_dead_letters (id, webhook_id, url, kind, payload, error, attempts, failed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			dead_letter.Id, dead_letter.WebhookId, dead_letter.Url, dead_letter.Kind, dead_letter.Payload, dead_letter.Error, dead_letter.Attempts, dead_letter.FailedAt)
+		return err
+	})
+}
+
+func (s *sqlStore) ListWebhookDeadLetters(page Page) ([]*WebhookDeadLetter, int, error) {
+	total, err := s.count(`webhook_dead_letters`, "", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.query(s.queryer(), `SELECT id, webhook_id, url, kind, payload, error, attempts, failed_at FROM webhook_dead_letters
+		ORDER BY id LIMIT ? OFFSET ?`, page.Limit, page.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	dead_letters := []*WebhookDeadLetter{}
+	for rows.Next() {
+		dead_letter := &WebhookDeadLetter{}
+		err = rows.Scan(&dead_letter.Id, &dead_letter.WebhookId, &dead_letter.Url, &dead_letter.Kind, &dead_letter.Payload,
+			&dead_letter.Error, &dead_letter.Attempts, &dead_letter.FailedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		dead_letters = append(dead_letters, dead_letter)
+	}
+	return dead_letters, total, rows.Err()
+}
+
+func (s *sqlStore) AddGS1CodeVersion(version *GS1CodeVersion) error {
+	var value interface{}
+	if version.Value != nil {
+		value = base64.StdEncoding.EncodeToString(version.Value)
+	}
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `DELETE FROM gs1_code_history WHERE gs1_code = ? AND block_num = ?`, version.Gs1Code, version.BlockNum)
+		if err != nil {
+			return err
+		}
+		_, err = s.exec(tx, `INSERT INTO gs1_code_history (gs1_code, block_num, block_id, synced_at, address, value) VALUES (?, ?, ?, ?, ?, ?)`,
+			version.Gs1Code, version.BlockNum, version.BlockId, version.SyncedAt, version.Address, value)
+		return err
+	})
+}
+
+func (s *sqlStore) DeleteGS1CodeVersions(block_id string) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `DELETE FROM gs1_code_history WHERE block_id = ?`, block_id)
+		return err
+	})
+}
+
+func (s *sqlStore) GetGS1CodeVersion(gs1_code string, as_of *AsOf) (*GS1CodeVersion, error) {
+	conditions := []string{`gs1_code = ?`}
+	args := []interface{}{gs1_code}
+	if as_of.HasBlockNum {
+		conditions = append(conditions, `block_num <= ?`)
+		args = append(args, as_of.BlockNum)
+	}
+	if as_of.HasTime {
+		conditions = append(conditions, `synced_at > 0`, `synced_at <= ?`)
+		args = append(args, as_of.Time)
+	}
+	return s.getGS1CodeVersion(conditions, args, `block_num DESC`)
+}
+
+func (s *sqlStore) NextGS1CodeVersion(gs1_code string, block_num float64) (*GS1CodeVersion, error) {
+	return s.getGS1CodeVersion([]string{`gs1_code = ?`, `block_num > ?`}, []interface{}{gs1_code, block_num}, `block_num`)
+}
+
+func (s *sqlStore) getGS1CodeVersion(conditions []string, args []interface{}, order string) (*GS1CodeVersion, error) {
+	version := &GS1CodeVersion{}
+	var value sql.NullString
+	err := s.queryRow(s.queryer(), `SELECT gs1_code, block_num, block_id, synced_at, address, value FROM gs1_code_history`+
+		whereClause(conditions)+` ORDER BY `+order+` LIMIT 1`, args...).Scan(
+		&version.Gs1Code, &version.BlockNum, &version.BlockId, &version.SyncedAt, &version.Address, &value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value.Valid {
+		version.Value, err = base64.StdEncoding.DecodeString(value.String)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return version, nil
+}
+
+func (s *sqlStore) HasGS1CodeVersions() (bool, error) {
+	total, err := s.count(`gs1_code_history`, "", nil)
+	return total > 0, err
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestSQLStoreSkipsOlderBlocks(t *testing.T) {
+	openTestStore(t)
+
+	for _, item := range []struct {
+		owner     string
+		block_num float64
+	}{{"owner-5", 5}, {"owner-3", 3}, {"owner-5-again", 5}} {
+		gs1_code_event := &ONSGS1CodeEvent{Address: gs1CodeAddress("1"), BlockNum: item.block_num}
+		gs1_code_event.GS1CodeData = *newTestGS1Code("1", item.owner)
+		err := g_store.UpsertGS1Code(gs1_code_event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if owner := testGS1CodeOwner(t, "1"); owner != "owner-5" {
+		t.Errorf("owner is %q, want the first item of block 5", owner)
+	}
+}
+
+func TestSQLStoreTransaction(t *testing.T) {
+	openTestStore(t)
+
+	tx, err := DBBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.SetStateValue(&StateValue{Address: "a", Value: []byte("v"), BlockNum: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state_value, err := DBGetStateValue("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state_value.Value != nil {
+		t.Errorf("rolled back value %q is stored", state_value.Value)
+	}
+}
+
+//write transaction 중에도 read는 기다리지 않고 commit 된 data를 읽는다.
+func TestSQLStoreReadDuringTransaction(t *testing.T) {
+	openTestStore(t)
+
+	tx, err := DBBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs1_code_event := &ONSGS1CodeEvent{Address: gs1CodeAddress("1"), BlockNum: 1}
+	gs1_code_event.GS1CodeData = *newTestGS1Code("1", "owner")
+	err = tx.UpsertGS1Code(gs1_code_event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan string, 1)
+	go func() {
+		gs1_code_event, err := DBGetGS1Code("1")
+		if err != nil || gs1_code_event != nil {
+			read <- "uncommitted"
+			return
+		}
+		read <- ""
+	}()
+	select {
+	case result := <-read:
+		if len(result) > 0 {
+			t.Errorf("read %s data", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("read is blocked by the write transaction")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner := testGS1CodeOwner(t, "1"); owner != "owner" {
+		t.Errorf("owner is %q after commit", owner)
+	}
+}
+
+func TestSQLStoreBlocks(t *testing.T) {
+	openTestStore(t)
+
+	undo := []*StateValue{{Address: "a", Value: []byte("v"), BlockNum: 1}, {Address: "b"}}
+	for idx, block_id := range []string{"b1", "b2", "b3"} {
+		err := g_store.AddBlock(&BlockRecord{
+			BlockInfo: BlockInfo{BlockNum: float64(idx + 1), BlockId: block_id, PreviousBlockId: "prev"},
+			Undo:      undo,
+			Pending:   block_id != "b1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	//같은 block id는 바꾼다.
+	err := g_store.AddBlock(&BlockRecord{BlockInfo: BlockInfo{BlockNum: 3, BlockId: "b3", PreviousBlockId: "prev"}, Undo: undo})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := g_store.PendingBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].BlockId != "b2" || len(pending[0].Undo) != 2 || string(pending[0].Undo[0].Value) != "v" {
+		t.Errorf("unexpected pending blocks %+v", pending)
+	}
+
+	err = g_store.PruneBlocks(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldest, err := g_store.OldestBlockNum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := g_store.GetBlock("b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldest != 2 || block != nil {
+		t.Errorf("oldest block is %v and b1 is %v after prune", oldest, block)
+	}
+}
+
+//transaction을 지원하지 않는 store에서 block 2를 적용하다 중단된 것처럼 만든다.
+//undo data는 write 전의 값이어야 하고 cursor는 block 1이다.
+func interruptBlock(t *testing.T, cursor_moved bool) {
+	_, head := DBGetLatestUpdatedBlock()
+	block := &BlockRecord{
+		BlockInfo: BlockInfo{BlockNum: 2, BlockId: "b2", PreviousBlockId: head},
+		Undo:      []*StateValue{},
+		Pending:   true,
+	}
+	for _, gs1_code := range []string{"1", "2"} {
+		prev, err := DBGetStateValue(gs1CodeAddress(gs1_code))
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.Undo = append(block.Undo, prev)
+	}
+	err := DBAddBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//GS1 code 1만 바꾸고 중단한다. cursor_moved이면 모두 바꾸고 cursor까지 바꾼 후에 중단한다.
+	changes := []map[string]string{gs1CodeChange(t, newTestGS1Code("1", "owner-2")), gs1CodeChange(t, newTestGS1Code("2", "owner-2"))}
+	if cursor_moved == false {
+		changes = changes[:1]
+	}
+	for _, change := range changes {
+		value, _ := base64.StdEncoding.DecodeString(change["value"])
+		err = applyStateValue(g_store, change["address"], value, 2, false, false)
+		if err == nil {
+			err = g_store.SetStateValue(&StateValue{Address: change["address"], Value: value, BlockNum: 2})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cursor_moved == true {
+		err = DBUpdateLatestUpdatedBlockInfo(2, "b2", head)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	//다시 시작한 것처럼 pending block을 정리하게 한다.
+	g_recover_pending = true
+}
+
+func syncTestBlock(t *testing.T, block_num float64, block_id string, previous_block_id string, changes ...map[string]string) {
+	err := SyncBlock(nil, &ONSEvent{BlockNum: block_num, BlockId: block_id, PreviousBlockId: previous_block_id, StateChanges: changes}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverInterruptedBlock(t *testing.T) {
+	openTestStore(t)
+	_, head := DBGetLatestUpdatedBlock()
+	syncTestBlock(t, 1, "b1", head, gs1CodeChange(t, newTestGS1Code("1", "owner-1")))
+
+	interruptBlock(t, false)
+
+	//같은 block을 다시 적용한다. 중단된 write는 되돌린 후에 적용하므로 undo data는 block 1의 값이다.
+	syncTestBlock(t, 2, "b2", "b1",
+		gs1CodeChange(t, newTestGS1Code("1", "owner-2")), gs1CodeChange(t, newTestGS1Code("2", "owner-2")))
+	if owner := testGS1CodeOwner(t, "2"); owner != "owner-2" {
+		t.Fatalf("GS1 code 2 owner is %q after the block is applied again", owner)
+	}
+
+	//fork로 block 2를 되돌리면 block 1의 state가 된다.
+	syncTestBlock(t, 2, "b2-fork", "b1")
+	if owner := testGS1CodeOwner(t, "1"); owner != "owner-1" {
+		t.Errorf("GS1 code 1 owner is %q after the fork, want owner-1", owner)
+	}
+	if owner := testGS1CodeOwner(t, "2"); owner != "" {
+		t.Errorf("GS1 code 2 owner is %q after the fork, want deleted", owner)
+	}
+	pending, err := DBPendingBlocks()
+	if err != nil || len(pending) != 0 {
+		t.Errorf("pending blocks %v, %v", pending, err)
+	}
+}
+
+func TestRecoverBlockInterruptedAfterCursor(t *testing.T) {
+	openTestStore(t)
+	_, head := DBGetLatestUpdatedBlock()
+	syncTestBlock(t, 1, "b1", head, gs1CodeChange(t, newTestGS1Code("1", "owner-1")))
+
+	interruptBlock(t, true)
+
+	//cursor가 block 2이면 block 2는 적용을 마쳤으므로 되돌리지 않는다.
+	syncTestBlock(t, 3, "b3", "b2")
+	if owner := testGS1CodeOwner(t, "2"); owner != "owner-2" {
+		t.Errorf("GS1 code 2 owner is %q, want owner-2", owner)
+	}
+	block, err := DBGetBlock("b2")
+	if err != nil || block == nil || block.Pending == true {
+		t.Errorf("block 2 is %+v, %v, want applied", block, err)
+	}
+	if block_num, block_id := DBGetLatestUpdatedBlock(); block_num != 3 || block_id != "b3" {
+		t.Errorf("cursor is %v(%s), want 3(b3)", block_num, block_id)
+	}
+}
+
+func TestSQLStoreDeleteAddress(t *testing.T) {
+	openTestStore(t)
+
+	gs1_code_event := &ONSGS1CodeEvent{Address: gs1CodeAddress("1"), BlockNum: 1}
+	gs1_code_event.GS1CodeData = *newTestGS1Code("1", "owner")
+	err := DBUpsertGS1Code(gs1_code_event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DBDeleteAddress(gs1CodeAddress("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner := testGS1CodeOwner(t, "1"); owner != "" {
+		t.Errorf("owner is %q after the address is deleted", owner)
+	}
+}
+
+func TestSQLStoreLastBlock(t *testing.T) {
+	openTestStore(t)
+
+	err := DBUpdateLatestUpdatedBlockInfo(3, "b3", "b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := g_store.GetLastBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block == nil || block.BlockNum != 3 || block.BlockId != "b3" || block.PreviousBlockId != "b2" {
+		t.Errorf("last block is %+v, want 3(b3)", block)
+	}
+}
+
+func TestSyncBlockFork(t *testing.T) {
+	openTestStore(t)
+	_, head := DBGetLatestUpdatedBlock()
+	syncTestBlock(t, 1, "b1", head, gs1CodeChange(t, newTestGS1Code("1", "owner-1")))
+	syncTestBlock(t, 2, "b2", "b1",
+		gs1CodeChange(t, newTestGS1Code("1", "owner-2")), gs1CodeChange(t, newTestGS1Code("2", "owner-2")))
+
+	//b2-fork는 b1의 child이므로 b2를 되돌린 후에 적용한다.
+	syncTestBlock(t, 2, "b2-fork", "b1", gs1CodeChange(t, newTestGS1Code("3", "owner-3")))
+	for gs1_code, expected := range map[string]string{"1": "owner-1", "2": "", "3": "owner-3"} {
+		if owner := testGS1CodeOwner(t, gs1_code); owner != expected {
+			t.Errorf("GS1 code %s owner is %q after the fork, want %q", gs1_code, owner, expected)
+		}
+	}
+	if block, err := DBGetBlock("b2"); err != nil || block != nil {
+		t.Errorf("rolled back block is %+v, %v", block, err)
+	}
+	if block_num, block_id := DBGetLatestUpdatedBlock(); block_num != 2 || block_id != "b2-fork" {
+		t.Errorf("cursor is %v(%s), want 2(b2-fork)", block_num, block_id)
+	}
+}
+
+//parent를 모르는 block은 parent가 적용될 때까지 기다린다.
+func TestSyncBlockWaitsForParent(t *testing.T) {
+	openTestStore(t)
+	_, head := DBGetLatestUpdatedBlock()
+	syncTestBlock(t, 2, "b2", "b1", gs1CodeChange(t, newTestGS1Code("1", "owner-2")))
+	if owner := testGS1CodeOwner(t, "1"); owner != "" {
+		t.Fatalf("block 2 is applied before block 1")
+	}
+
+	syncTestBlock(t, 1, "b1", head, gs1CodeChange(t, newTestGS1Code("1", "owner-1")))
+	if owner := testGS1CodeOwner(t, "1"); owner != "owner-2" {
+		t.Errorf("GS1 code 1 owner is %q, want owner-2", owner)
+	}
+	if block_num, block_id := DBGetLatestUpdatedBlock(); block_num != 2 || block_id != "b2" {
+		t.Errorf("cursor is %v(%s), want 2(b2)", block_num, block_id)
+	}
+}
+
+func TestRollbackLowersSyncedBlock(t *testing.T) {
+	openTestStore(t)
+	_, head := DBGetLatestUpdatedBlock()
+	syncTestBlock(t, 1, "b1", head, gs1CodeChange(t, newTestGS1Code("1", "owner-1")))
+	syncTestBlock(t, 2, "b2", "b1", gs1CodeChange(t, newTestGS1Code("1", "owner-2")))
+
+	fork_point, err := DBGetBlock("b1")
+	if err != nil || fork_point == nil {
+		t.Fatalf("block 1 is %v, %v", fork_point, err)
+	}
+	err = rollbackTo(fork_point, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//last_block_number gauge는 /readyz와 같은 값을 보고한다.
+	report := GetSyncHealthReport()
+	if report.LastBlockNum != 1 || report.LastBlockId != "b1" {
+		t.Errorf("last block is %v(%s) after rollback, want 1(b1)", report.LastBlockNum, report.LastBlockId)
+	}
+}
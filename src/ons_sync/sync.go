@@ -2,30 +2,38 @@ package main
 
 import (
 	"log"
-	"errors"
 	"strings"
 	"sync"
 	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
 	//"protobuf/ons_pb2"
-	r "gopkg.in/gorethink/gorethink.v4"
 )
 
 const (
 	GS1_CODE_TABLE = iota
 	SERVICE_TYPE_TABLE
 	LATEST_BLOCK_INFO
+	MANAGER_TABLE
+	BLOCK_TABLE
+	STATE_VALUE_TABLE
+	GS1_CODE_HISTORY_TABLE
+	WEBHOOK_TABLE
+	WEBHOOK_DEAD_LETTER_TABLE
 	NONE
 )
 
-var g_db_name string
-var g_table_names = []string {"gs1_codes", "service_types", "latest_updated_block_info"}
-var g_table_map = map[string] string {g_table_names[GS1_CODE_TABLE]:"Gs1Code", g_table_names[SERVICE_TYPE_TABLE]:"Address", g_table_names[LATEST_BLOCK_INFO]:"index"}
-var g_db_session *r.Session = nil
+var g_table_names = []string {"gs1_codes", "service_types", "latest_updated_block_info", "managers", "blocks", "state_values", "gs1_code_history", "webhooks", "webhook_dead_letters"}
+var g_table_map = map[string] string {g_table_names[GS1_CODE_TABLE]:"Gs1Code", g_table_names[SERVICE_TYPE_TABLE]:"Address", g_table_names[LATEST_BLOCK_INFO]:"index", g_table_names[MANAGER_TABLE]:"id",
+	g_table_names[BLOCK_TABLE]:"BlockId", g_table_names[STATE_VALUE_TABLE]:"Address",
+	g_table_names[GS1_CODE_HISTORY_TABLE]:"id", g_table_names[WEBHOOK_TABLE]:"id", g_table_names[WEBHOOK_DEAD_LETTER_TABLE]:"id"}
+//chain에서 동기화한 data가 아니므로 resync(Clear)에서 지우지 않는 table.
+var g_registry_tables = map[int]bool {WEBHOOK_TABLE: true, WEBHOOK_DEAD_LETTER_TABLE: true}
+var g_store Store = nil
+//cursor는 event handler가 바꾸고 API, webhook, reconnect에서 읽으므로 setLatestUpdatedBlock, DBGetLatestUpdatedBlock으로만 접근한다.
+var g_latest_block_mutex = &sync.RWMutex{}
 var g_latest_block_id string = "0000000000000000"
 var g_latest_block_num float64 = 0
-var g_mutex *sync.Mutex = nil
 
 func prettyPrint(v interface{}) {
 	buf, err := json.MarshalIndent(v, "", "  ")
@@ -38,97 +46,25 @@ func prettyPrint(v interface{}) {
 	log.Printf(string(buf))
 }
 
-func DBConnect(url string, db_name string, verbose bool) {
-	log.Printf("Connect %s\n", url)
-	session, err := r.Connect(r.ConnectOpts{
-		Address: url,
-	})
-
-	if err != nil {
-		log.Printf("Failed to connect %s\n", url)
-		log.Fatalln(err)
-	}
-
-	resp, err := r.DBList().Contains(db_name).Run(session)
-	if err != nil {
-		log.Printf("Failed to check %s database exist\n", db_name)
-		log.Fatalln(err)
-	}
-
-	var contained bool
-	err = resp.One(&contained)
+func DBConnect(options *StoreOptions, verbose bool) {
+	store, err := OpenStore(options, verbose)
 	if err != nil {
-		log.Printf("Failed to retrieves the first document from the result set to check %s database exist\n", db_name)
+		log.Printf("Failed to open %s store\n", options.Backend)
 		log.Fatalln(err)
 	}
+	log.Printf("%s store is opened\n", options.Backend)
 
-	if verbose == true {
-		log.Printf("%s database is exist : %#v\n", db_name, contained)
-	}
-
-	if contained == false {
-		resp, err = r.DBCreate(db_name).Run(session)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		var rows []map[string]interface{}
-		err = resp.All(&rows)
-		if err != nil {
-			log.Fatalln(err)
-		}
-
-		if int(rows[0]["dbs_created"].(float64)) == 1 {
-			log.Printf("%s database is created\n", db_name)
-		}
-
-		if verbose == true {
-			prettyPrint(rows)
-		}
-	}
-
-	for table_name, pk_name := range g_table_map {
-		resp, err = r.DB(db_name).TableList().Contains(table_name).Run(session)
-		var table_contained bool
-		err = resp.One(&table_contained)
-		if err != nil {
-			log.Printf("Failed to retrieves the first document from the result set to check %s database exist\n", db_name)
-			log.Fatalln(err)
-		}
-
-		if verbose == true {
-			log.Printf("is %s table exist in %s database : %#v\n", table_name, db_name, table_contained)
-		}
-
-		if table_contained == false {
-			resp, err = r.DB(db_name).TableCreate(table_name, r.TableCreateOpts{PrimaryKey:pk_name,}).Run(session)
-			var creation_reslut map[string] interface{}
-			err = resp.One(&creation_reslut)
-
-			if verbose == true {
-				log.Printf("result to create table : %#v", creation_reslut["tables_created"])
-			}
-
-			if creation_reslut["tables_created"].(float64) == 1 {
-				log.Printf("Is table creation succeeded : %#v", creation_reslut["tables_created"].(float64))
-			}
-
-		}else{
-			log.Printf("%s table is exist in %s\n", table_name, db_name)
-		}
-	}
-	g_db_name = db_name
-	g_db_session = session
-	g_mutex = &sync.Mutex{}
+	g_store = store
 	return
 }
 
 func DBDisconnect() {
-	if g_db_session != nil {
-		log.Printf("database session will be closed\n")
-		g_db_session.Close()
-		g_db_session = nil
-		g_db_name = ""
-		g_mutex = nil
+	if g_store != nil {
+		err := g_store.Close()
+		if err != nil {
+			log.Printf("Failed to close store : %v\n", err)
+		}
+		g_store = nil
 	}
 }
 
@@ -136,189 +72,200 @@ func UpdateLatestUpdateBlockInfo() {
 
 }
 
-func checkNormalOPResult(cur *r.Cursor, op string, func_name string) bool {
-		//for test
-	var results map[string]float64
-	err := cur.One(&results)
-	if err != nil {
-		log.Printf("Failed to checkNormalOPResult(func : %s, op : %s) : %v\n", func_name, op, err)
-	}
-
-	log.Printf("%s : %s result: %v", func_name, op, results)
-
-	if results[op] == 1 {
-		return true
-	}
-
-	return false
-}
-
 func DBInitLatestUpdatedBlockInfo(verbose bool) (float64, error) {
-	if g_db_session == nil {
-		log.Printf("Not connected.\n")
-		return -1, errors.New("Not connected.")
+	if err := checkDBSession(); err != nil {
+		return -1, err
 	}
 
-	cur, err := r.DB(g_db_name).Table(g_table_names[LATEST_BLOCK_INFO]).Insert(map[string]interface{}{
-		g_table_map[g_table_names[LATEST_BLOCK_INFO]]:0,
-		"block_num": 0,
-		"block_id": "0000000000000000",
-		"previous_block_id": "0000000000000000", //the number of zero is important.
-		}).Run(g_db_session)
+	err := g_store.SetLastBlock(&BlockInfo{
+		BlockNum: 0,
+		BlockId: "0000000000000000",
+		PreviousBlockId: "0000000000000000", //the number of zero is important.
+	})
 	if err != nil {
 		log.Printf("Failed to initialize latest updated block info table : %#v\n", err)
 		return -1, err
 	}
-	defer cur.Close()
-	checkNormalOPResult(cur, "inserted", "DBInitLatestUpdatedBlockInfo")
 
-	g_latest_block_num = 0
-	return g_latest_block_num, nil
+	setLatestUpdatedBlock(0, "0000000000000000")
+	return 0, nil
 }
 
 func DBUpdateLatestUpdatedBlockInfo(block_num float64, block_id string, prev_block_id string) error {
-	if g_db_session == nil {
-		log.Printf("Not connected.\n")
-		return errors.New("Not connected.")
+	if err := checkDBSession(); err != nil {
+		return err
 	}
 
-	cur, err := r.DB(g_db_name).Table(g_table_names[LATEST_BLOCK_INFO]).Update(map[string]interface{}{
-		g_table_map[g_table_names[LATEST_BLOCK_INFO]]:0,
-		"block_num": block_num,
-		"block_id": block_id,
-		"previous_block_id": prev_block_id, //the number of zero is important.
-		}).Run(g_db_session)
+	err := g_store.SetLastBlock(&BlockInfo{
+		BlockNum: block_num,
+		BlockId: block_id,
+		PreviousBlockId: prev_block_id,
+	})
 	if err != nil {
-		log.Printf("Failed to initialize latest updated block info table : %#v\n", err)
+		log.Printf("Failed to update latest updated block info : %v\n", err)
 		return err
 	}
-	defer cur.Close()
-	checkNormalOPResult(cur, "replaced", "DBUpdateLatestUpdatedBlockInfo")
 
+	setLatestUpdatedBlock(block_num, block_id)
 	return nil
 }
 
 func DBGetLatestUpdatedBlockInfo(verbose bool) (float64, error) {
-	if g_db_session == nil {
-		log.Printf("Not connected.\n")
-		return -1, errors.New("Not connected.")
+	if err := checkDBSession(); err != nil {
+		return -1, err
 	}
 
-	//lastest updated block info table은 항상 index 0인 record만 사용한다.
-	cur, err := r.DB(g_db_name).Table(g_table_names[LATEST_BLOCK_INFO]).Get(0).Run(g_db_session)
+	block, err := g_store.GetLastBlock()
 	if err != nil {
-		log.Printf("maybe doesn't exist field : %s\n", g_table_map[g_table_names[LATEST_BLOCK_INFO]])
+		log.Printf("Failed to get latest updated block info : %v\n", err)
 		return -1, err
 	}
-	defer cur.Close()
-
-	var record map[string]interface{}
-	err = cur.One(&record)
-	if err != nil {
-		log.Printf("maybe doesn't exist field : %s, error : %#v\n", g_table_map[g_table_names[LATEST_BLOCK_INFO]], err)
+	if block == nil {
 		return DBInitLatestUpdatedBlockInfo(verbose)
 	}
 
 	if verbose == true {
-		log.Printf("DBGetLatestUpdatedBlockInfo\n - latest block num : %#v", record["block_num"].(float64))
-		log.Printf(" - latest block id : %#v", record["block_id"].(string))
+		log.Printf("DBGetLatestUpdatedBlockInfo\n - latest block num : %#v", block.BlockNum)
+		log.Printf(" - latest block id : %#v", block.BlockId)
 	}
 
-	g_latest_block_num = record["block_num"].(float64)
-	g_latest_block_id = record["previous_block_id"].(string)
+	//새 block의 previous block id가 head의 block id와 같아야 한다.
+	setLatestUpdatedBlock(block.BlockNum, block.BlockId)
 
-	return g_latest_block_num, nil
+	return block.BlockNum, nil
 }
 
 func DBGetLatestUpdatedBlock() (float64, string) {
+	g_latest_block_mutex.RLock()
+	defer g_latest_block_mutex.RUnlock()
 	return g_latest_block_num, g_latest_block_id
 }
 
-func DBUpdateOrInsert(table_idx int, pk_v string, block_num float64, v interface{}) error {
+//store에 cursor를 commit한 후에 호출한다.
+func setLatestUpdatedBlock(block_num float64, block_id string) {
+	g_latest_block_mutex.Lock()
+	defer g_latest_block_mutex.Unlock()
+	g_latest_block_num = block_num
+	g_latest_block_id = block_id
+}
+
+//block 하나를 하나의 transaction으로 적용할 때 사용한다. (chain.go)
+func DBBegin() (StoreTx, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, err
+	}
+	return g_store.Begin()
+}
+
+//snapshot을 적용할 수 있는 store인지. (Store.Atomic)
+func DBAtomic() bool {
+	if err := checkDBSession(); err != nil {
+		return false
+	}
+	return g_store.Atomic()
+}
 
-	if g_db_session == nil {
-		log.Printf("Not connected.\n")
-		return errors.New("Not connected.")
+//chain state snapshot을 하나의 transaction으로 적용할 때 사용한다. (bootstrap, repair)
+func DBBeginSnapshot() (StoreTx, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, err
 	}
+	if g_store.Atomic() == false {
+		return nil, errSnapshotNotAtomic
+	}
+	return g_store.Begin()
+}
 
-	if len(g_table_names) < table_idx {
-		log.Printf("Invalid table index : %d.\n", table_idx)
-		return errors.New("Invalid table index")
+func DBGetBlock(block_id string) (*BlockRecord, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, err
 	}
+	return g_store.GetBlock(block_id)
+}
 
-	g_mutex.Lock()
-	defer g_mutex.Unlock()
+func DBAddBlock(block *BlockRecord) error {
+	if err := checkDBSession(); err != nil {
+		return err
+	}
+	return g_store.AddBlock(block)
+}
 
-	table_name := g_table_names[table_idx]
+//적용하거나 되돌리는 중에 중단된 block. (chain.go recoverPendingBlocks)
+func DBPendingBlocks() ([]*BlockRecord, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, err
+	}
+	return g_store.PendingBlocks()
+}
 
-	//lastest updated block info table은 항상 index 0인 record만 사용한다.
-	cur, err := r.DB(g_db_name).Table(table_name).Get(pk_v).Run(g_db_session)
-	if err != nil {
-		log.Printf("maybe %s doesn't exist field : %v\n", g_table_map[g_table_names[GS1_CODE_TABLE]], err)
+func DBDeleteBlock(block_id string) error {
+	if err := checkDBSession(); err != nil {
 		return err
 	}
-	defer cur.Close()
+	return g_store.DeleteBlock(block_id)
+}
 
-	var record map[string]interface{}
-	//var record ons_pb2.GS1CodeData
-	err = cur.One(&record)
-	if err != nil {
-		log.Printf("DBUpdateOrInsert : insert item because %s item doesn't exist field (2): %v\n", g_table_map[g_table_names[GS1_CODE_TABLE]], err)
-		cur, err := r.DB(g_db_name).Table(table_name).Insert(v).Run(g_db_session)
-		if err != nil {
-			log.Printf("Failed to item : %#v\n", err)
-			return err
-		}
-		defer cur.Close()
-		checkNormalOPResult(cur, "inserted", "DBUpdateOrInsert")
-	}else{
-		//if old data, skip..
-		if block_num <= record["BlockNum"].(float64) {
-			log.Printf("skip item because of old block data : %s, %s, %v\n", g_table_map[g_table_names[GS1_CODE_TABLE]], pk_v, block_num)
-			return nil
-		}
+func DBPruneBlocks(block_num float64) error {
+	if err := checkDBSession(); err != nil {
+		return err
+	}
+	return g_store.PruneBlocks(block_num)
+}
 
-		log.Printf("DBUpdateOrInsert : update item because %s item exist(%s)", g_table_map[g_table_names[GS1_CODE_TABLE]], pk_v)
-		cur, err := r.DB(g_db_name).Table(table_name).Update(v).Run(g_db_session)
-		if err != nil {
-			log.Printf("Failed to update item : %v\n", err)
-			return err
-		}
-		defer cur.Close()
-		checkNormalOPResult(cur, "replaced", "DBUpdateOrInsert")
+func DBOldestBlockNum() (float64, error) {
+	if err := checkDBSession(); err != nil {
+		return -1, err
 	}
+	return g_store.OldestBlockNum()
+}
 
-	return nil
+func DBStateAddresses() ([]string, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, err
+	}
+	return g_store.StateAddresses()
 }
 
-func DBDeleteAddress(address string) error {
-	if g_db_session == nil {
-		log.Printf("Not connected.\n")
-		return errors.New("Not connected.")
+func DBClear() error {
+	if err := checkDBSession(); err != nil {
+		return err
 	}
+	return g_store.Clear()
+}
 
-	//lastest updated block info table은 항상 index 0인 record만 사용한다.
-	cur, err := r.DB(g_db_name).Table(g_table_names[GS1_CODE_TABLE]).Filter(map[string]string{
-		"Address":address,
-	}).Run(g_db_session)
-	if err != nil {
+func DBGetStateValue(address string) (*StateValue, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, err
+	}
+	return g_store.GetStateValue(address)
+}
+
+func DBSetStateValue(state_value *StateValue) error {
+	if err := checkDBSession(); err != nil {
 		return err
 	}
-	defer cur.Close()
+	return g_store.SetStateValue(state_value)
+}
 
-	if checkNormalOPResult(cur, "deleted", "DBDeleteAddress in "+g_table_names[GS1_CODE_TABLE]) == false {
-		cur, err := r.DB(g_db_name).Table(g_table_names[SERVICE_TYPE_TABLE]).Filter(map[string]string{
-			"Address":address,
-		}).Run(g_db_session)
-		defer cur.Close()
-		if err != nil {
-			return err
-		}
-		if checkNormalOPResult(cur, "deleted", "DBDeleteAddress in "+g_table_names[SERVICE_TYPE_TABLE]) == false {
-			log.Printf("Nothing has been deleted.")
-		}
+func DBUpsertGS1Code(gs1_code *ONSGS1CodeEvent) error {
+	if err := checkDBSession(); err != nil {
+		return err
 	}
-	return nil
+	return g_store.UpsertGS1Code(gs1_code)
+}
+
+func DBUpsertServiceType(service_type *ONSServiceTypeEvent) error {
+	if err := checkDBSession(); err != nil {
+		return err
+	}
+	return g_store.UpsertServiceType(service_type)
+}
+
+func DBDeleteAddress(address string) error {
+	if err := checkDBSession(); err != nil {
+		return err
+	}
+	return g_store.DeleteAddress(address)
 }
 
 func hexdigest(str string) string {
@@ -329,19 +276,25 @@ func hexdigest(str string) string {
 }
 
 func GetTableIdxByAddress(address string) int{
-	namespace := hexdigest("ons")[:6]
-
 	target_address := namespace + hexdigest("gs1")[:8]
-	log.Printf("GetTableIdxByAddress : %s : %s\n", address, target_address)
+	if g_verbose == true {
+		log.Printf("GetTableIdxByAddress : %s : %s\n", address, target_address)
+	}
 	if address[:14] == target_address {
 		return GS1_CODE_TABLE
 	}
 
 	target_address = namespace + hexdigest("service-type")[:8]
-	log.Printf("GetTableIdxByAddress : %s : %s\n", address, target_address)
+	if g_verbose == true {
+		log.Printf("GetTableIdxByAddress : %s : %s\n", address, target_address)
+	}
 	if address[:14] == target_address {
 		return SERVICE_TYPE_TABLE
 	}
 
+	if address == namespace + hexdigest("ons_manager")[:64] {
+		return MANAGER_TABLE
+	}
+
 	return NONE
 }
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"protobuf/ons_pb2"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	//chain에 있지만 database에 없다.
+	VERIFY_MISSING = "missing"
+	//database에 있지만 chain에 없다.
+	VERIFY_EXTRA = "extra"
+	//chain과 database의 field가 다르다.
+	VERIFY_DIVERGENT = "divergent"
+)
+
+//chain state와 다른 database row.
+type VerifyIssue struct {
+	Kind  string
+	Table string
+	//GS1 code, service type address, manager row id 또는 state address
+	Key string
+	//row를 만든 state address. repair 할 때 이 address의 state를 다시 적용한다.
+	Address string
+	//divergent이면 다른 field
+	Fields []*VerifyField
+}
+
+//Path는 query API의 JSON field 이름을 사용한다. (예: records[0].provider)
+type VerifyField struct {
+	Path  string
+	Chain interface{}
+	DB    interface{}
+}
+
+type VerifyReport struct {
+	Block *BlockInfo
+	//chain의 ONS namespace state 개수
+	States int
+	//decode 할 수 없어서 비교하지 않은 state 개수
+	Undecodable int
+	Issues      []*VerifyIssue
+	//repair 한 address 개수와 repair 후에 남은 issue
+	Repaired  int
+	Remaining []*VerifyIssue
+}
+
+func (report *VerifyReport) Count(kind string) int {
+	count := 0
+	for _, issue := range report.Issues {
+		if issue.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+//database의 마지막 block의 ONS namespace state를 REST API로 읽어서 database와 비교한다.
+//database는 마지막 block의 state만 저장하므로 이전 block과는 비교할 수 없다.
+//repair이면 다른 address의 state를 chain state로 다시 적용하고 다시 비교한다.
+func Verify(rest_addr string, repair bool, verbose bool) (*VerifyReport, error) {
+	latest_block_num, latest_block_id := DBGetLatestUpdatedBlock()
+	if latest_block_id == "0000000000000000" {
+		return nil, errors.New("database is empty")
+	}
+	//snapshot을 읽기 전에 확인한다.
+	if repair == true && DBAtomic() == false {
+		return nil, errSnapshotNotAtomic
+	}
+
+	block := &BlockInfo{BlockNum: latest_block_num, BlockId: latest_block_id}
+	log.Printf("verify the database against the state at block %v(%s)\n", block.BlockNum, block.BlockId)
+
+	snapshot, err := getStateSnapshot(rest_addr, block.BlockId, verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	//비교하고 repair 하는 동안 block을 적용하지 않는다.
+	g_chain_mutex.Lock()
+	defer g_chain_mutex.Unlock()
+	//snapshot을 읽는 동안 block이 적용되었으면 다른 block의 state와 비교하게 된다. 다른 ons_sync가 적용했을 수 있으므로 database에서 다시 읽는다.
+	_, err = DBGetLatestUpdatedBlockInfo(false)
+	if err != nil {
+		return nil, err
+	}
+	if _, head_block_id := DBGetLatestUpdatedBlock(); head_block_id != block.BlockId {
+		return nil, fmt.Errorf("the last block of the database changed from %s to %s during verify", block.BlockId, head_block_id)
+	}
+
+	report := &VerifyReport{Block: block, States: len(snapshot)}
+	report.Issues, report.Undecodable, err = compareSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if repair == false || len(report.Issues) == 0 {
+		return report, nil
+	}
+
+	report.Repaired, err = repairSnapshot(block, snapshot, report.Issues, verbose)
+	if err != nil {
+		return report, err
+	}
+	report.Remaining, _, err = compareSnapshot(snapshot)
+	return report, err
+}
+
+//snapshot과 database의 GS1 code, service type, manager, state value를 비교한다.
+func compareSnapshot(snapshot map[string][]byte) ([]*VerifyIssue, int, error) {
+	addresses := make([]string, 0, len(snapshot))
+	for address := range snapshot {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	issues := []*VerifyIssue{}
+	undecodable := 0
+	for _, compare := range []func([]string, map[string][]byte) ([]*VerifyIssue, int, error){
+		compareGS1Codes, compareServiceTypes, compareManagers, compareStateValues,
+	} {
+		table_issues, table_undecodable, err := compare(addresses, snapshot)
+		if err != nil {
+			return nil, 0, err
+		}
+		issues = append(issues, table_issues...)
+		undecodable += table_undecodable
+	}
+	return issues, undecodable, nil
+}
+
+//GetTableIdxByAddress는 짧은 address를 처리하지 못한다.
+func verifyTableIdx(address string) int {
+	if len(address) < 14 {
+		return NONE
+	}
+	return GetTableIdxByAddress(address)
+}
+
+func compareGS1Codes(addresses []string, snapshot map[string][]byte) ([]*VerifyIssue, int, error) {
+	issues := []*VerifyIssue{}
+	undecodable := 0
+	chain_addresses := make(map[string]string)
+	for _, address := range addresses {
+		if verifyTableIdx(address) != GS1_CODE_TABLE {
+			continue
+		}
+		chain := decodeGS1CodeValue(address, snapshot[address], 0)
+		if chain == nil {
+			log.Printf("skip GS1 code state %s because it cannot be decoded\n", address)
+			undecodable++
+			continue
+		}
+		chain_addresses[chain.Gs1Code] = address
+
+		stored, err := DBGetGS1Code(chain.Gs1Code)
+		if err != nil {
+			return nil, 0, err
+		}
+		if stored == nil {
+			issues = append(issues, &VerifyIssue{Kind: VERIFY_MISSING, Table: g_table_names[GS1_CODE_TABLE], Key: chain.Gs1Code, Address: address})
+			continue
+		}
+		fields := diffFields("", jsonValue(NewAPIGS1Code(chain)), jsonValue(NewAPIGS1Code(stored)), nil)
+		if len(fields) > 0 {
+			issues = append(issues, &VerifyIssue{Kind: VERIFY_DIVERGENT, Table: g_table_names[GS1_CODE_TABLE], Key: chain.Gs1Code, Address: address, Fields: fields})
+		}
+	}
+
+	err := forEachPage(func(page Page) (int, int, error) {
+		stored, total, err := DBListGS1Codes(&GS1CodeFilter{}, page)
+		for _, gs1_code := range stored {
+			if _, ok := chain_addresses[gs1_code.Gs1Code]; ok == false {
+				issues = append(issues, &VerifyIssue{Kind: VERIFY_EXTRA, Table: g_table_names[GS1_CODE_TABLE], Key: gs1_code.Gs1Code, Address: gs1_code.Address})
+			}
+		}
+		return len(stored), total, err
+	})
+	return issues, undecodable, err
+}
+
+func compareServiceTypes(addresses []string, snapshot map[string][]byte) ([]*VerifyIssue, int, error) {
+	issues := []*VerifyIssue{}
+	undecodable := 0
+	chain_addresses := make(map[string]bool)
+	for _, address := range addresses {
+		if verifyTableIdx(address) != SERVICE_TYPE_TABLE {
+			continue
+		}
+		chain := &ONSServiceTypeEvent{}
+		err := proto.Unmarshal(snapshot[address], &chain.ServiceType)
+		if err != nil {
+			log.Printf("skip service type state %s because it cannot be decoded : %v\n", address, err)
+			undecodable++
+			continue
+		}
+		chain_addresses[address] = true
+
+		stored, err := DBGetServiceType(address)
+		if err != nil {
+			return nil, 0, err
+		}
+		if stored == nil {
+			issues = append(issues, &VerifyIssue{Kind: VERIFY_MISSING, Table: g_table_names[SERVICE_TYPE_TABLE], Key: address, Address: address})
+			continue
+		}
+		fields := diffFields("", jsonValue(NewAPIServiceType(chain)), jsonValue(NewAPIServiceType(stored)), nil)
+		if len(fields) > 0 {
+			issues = append(issues, &VerifyIssue{Kind: VERIFY_DIVERGENT, Table: g_table_names[SERVICE_TYPE_TABLE], Key: address, Address: address, Fields: fields})
+		}
+	}
+
+	err := forEachPage(func(page Page) (int, int, error) {
+		stored, total, err := DBListServiceTypes(&ServiceTypeFilter{}, page)
+		for _, service_type := range stored {
+			if chain_addresses[service_type.Address] == false {
+				issues = append(issues, &VerifyIssue{Kind: VERIFY_EXTRA, Table: g_table_names[SERVICE_TYPE_TABLE], Key: service_type.Address, Address: service_type.Address})
+			}
+		}
+		return len(stored), total, err
+	})
+	return issues, undecodable, err
+}
+
+//manager는 row id(kind, GS1 code)로 비교한다.
+func compareManagers(addresses []string, snapshot map[string][]byte) ([]*VerifyIssue, int, error) {
+	address := namespace + hexdigest("ons_manager")[:64]
+	chain_rows := make(map[string]*ONSManagerRow)
+	if value, ok := snapshot[address]; ok {
+		ons_manager := &ons_pb2.ONSManager{}
+		err := proto.Unmarshal(value, ons_manager)
+		if err != nil {
+			log.Printf("skip managers state %s because it cannot be decoded : %v\n", address, err)
+			return []*VerifyIssue{}, 1, nil
+		}
+		for _, row := range newManagerRows(ons_manager, 0) {
+			chain_rows[row.Id] = row
+		}
+	}
+
+	issues := []*VerifyIssue{}
+	stored_rows := make(map[string]bool)
+	err := forEachPage(func(page Page) (int, int, error) {
+		stored, total, err := DBListManagers(&ManagerFilter{}, page)
+		for _, row := range stored {
+			stored_rows[row.Id] = true
+			chain, ok := chain_rows[row.Id]
+			if ok == false {
+				issues = append(issues, &VerifyIssue{Kind: VERIFY_EXTRA, Table: g_table_names[MANAGER_TABLE], Key: row.Id, Address: address})
+				continue
+			}
+			fields := diffFields("", jsonValue(newVerifyManager(chain)), jsonValue(newVerifyManager(row)), nil)
+			if len(fields) > 0 {
+				issues = append(issues, &VerifyIssue{Kind: VERIFY_DIVERGENT, Table: g_table_names[MANAGER_TABLE], Key: row.Id, Address: address, Fields: fields})
+			}
+		}
+		return len(stored), total, err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids := []string{}
+	for id := range chain_rows {
+		if stored_rows[id] == false {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		issues = append(issues, &VerifyIssue{Kind: VERIFY_MISSING, Table: g_table_names[MANAGER_TABLE], Key: id, Address: address})
+	}
+	return issues, 0, nil
+}
+
+//권한을 받은 block은 chain state에 없으므로 비교하지 않는다.
+func newVerifyManager(row *ONSManagerRow) *APIManager {
+	return &APIManager{Kind: row.Kind, Address: row.Address, Gs1Code: row.Gs1Code}
+}
+
+//fork 처리에 사용하는 state value도 chain state와 같아야 한다.
+func compareStateValues(addresses []string, snapshot map[string][]byte) ([]*VerifyIssue, int, error) {
+	issues := []*VerifyIssue{}
+	for _, address := range addresses {
+		stored, err := DBGetStateValue(address)
+		if err != nil {
+			return nil, 0, err
+		}
+		if stored.Value == nil {
+			issues = append(issues, &VerifyIssue{Kind: VERIFY_MISSING, Table: g_table_names[STATE_VALUE_TABLE], Key: address, Address: address})
+		} else if bytes.Equal(stored.Value, snapshot[address]) == false {
+			issues = append(issues, &VerifyIssue{Kind: VERIFY_DIVERGENT, Table: g_table_names[STATE_VALUE_TABLE], Key: address, Address: address,
+				Fields: []*VerifyField{{
+					Path:  "value",
+					Chain: base64.StdEncoding.EncodeToString(snapshot[address]),
+					DB:    base64.StdEncoding.EncodeToString(stored.Value),
+				}}})
+		}
+	}
+
+	stored, err := DBStateAddresses()
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Strings(stored)
+	for _, address := range stored {
+		if _, ok := snapshot[address]; ok == false {
+			issues = append(issues, &VerifyIssue{Kind: VERIFY_EXTRA, Table: g_table_names[STATE_VALUE_TABLE], Key: address, Address: address})
+		}
+	}
+	return issues, 0, nil
+}
+
+//list의 page를 차례로 읽는다. list는 읽은 개수와 전체 개수를 반환한다.
+func forEachPage(list func(page Page) (int, int, error)) error {
+	page := Page{Offset: 0, Limit: maxPageLimit}
+	for {
+		count, total, err := list(page)
+		if err != nil {
+			return err
+		}
+		if count == 0 || page.Offset+count >= total {
+			return nil
+		}
+		page.Offset += count
+	}
+}
+
+//field 비교를 위해 JSON의 map, slice, string, number, bool로 바꾼다.
+func jsonValue(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var value interface{}
+	json.Unmarshal(data, &value)
+	return value
+}
+
+//chain과 db의 다른 field를 fields에 추가한다. 동기화한 block number는 비교하지 않는다.
+func diffFields(path string, chain interface{}, db interface{}, fields []*VerifyField) []*VerifyField {
+	switch chain_value := chain.(type) {
+	case map[string]interface{}:
+		db_value, ok := db.(map[string]interface{})
+		if ok == false {
+			break
+		}
+		keys := []string{}
+		for key := range chain_value {
+			keys = append(keys, key)
+		}
+		for key := range db_value {
+			if _, ok := chain_value[key]; ok == false {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == "block_num" || key == "granted_block_num" {
+				continue
+			}
+			field_path := key
+			if len(path) > 0 {
+				field_path = path + "." + key
+			}
+			fields = diffFields(field_path, chain_value[key], db_value[key], fields)
+		}
+		return fields
+	case []interface{}:
+		db_value, ok := db.([]interface{})
+		if ok == false {
+			break
+		}
+		for idx := 0; idx < len(chain_value) || idx < len(db_value); idx++ {
+			var chain_item, db_item interface{}
+			if idx < len(chain_value) {
+				chain_item = chain_value[idx]
+			}
+			if idx < len(db_value) {
+				db_item = db_value[idx]
+			}
+			fields = diffFields(fmt.Sprintf("%s[%d]", path, idx), chain_item, db_item, fields)
+		}
+		return fields
+	}
+
+	if reflect.DeepEqual(chain, db) == false {
+		fields = append(fields, &VerifyField{Path: path, Chain: chain, DB: db})
+	}
+	return fields
+}
+
+//issue가 있는 address의 state를 chain state로 다시 적용한다. 모든 address를 하나의 transaction으로 바꾸고 webhook으로 알린다.
+//chain에 없는 address는 삭제한다. chain에 없는 GS1 code는 address가 달라도 삭제되도록 GS1 code로 삭제한다.
+//g_chain_mutex를 잡고 block이 database의 마지막 block인지 확인한 후에 호출한다.
+func repairSnapshot(block *BlockInfo, snapshot map[string][]byte, issues []*VerifyIssue, verbose bool) (int, error) {
+	tx, err := DBBeginSnapshot()
+	if err != nil {
+		return 0, err
+	}
+
+	repair_addresses := make(map[string]bool)
+	for _, issue := range issues {
+		if issue.Kind == VERIFY_EXTRA && issue.Table == g_table_names[GS1_CODE_TABLE] {
+			err = tx.DeleteGS1Code(issue.Key)
+			if err == nil {
+				err = tx.AddGS1CodeVersion(&GS1CodeVersion{
+					Id:       gs1CodeVersionId(issue.Key, block.BlockNum),
+					Gs1Code:  issue.Key,
+					BlockNum: block.BlockNum,
+					BlockId:  block.BlockId,
+					//repair 한 GS1 code는 언제 삭제되었는지 모른다.
+					SyncedAt: 0,
+					Address:  issue.Address,
+				})
+			}
+			if err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+			log.Printf("repair : delete GS1 code %s\n", issue.Key)
+		}
+		if verifyTableIdx(issue.Address) != NONE || issue.Table == g_table_names[STATE_VALUE_TABLE] {
+			repair_addresses[issue.Address] = true
+		}
+	}
+	addresses := []string{}
+	for address := range repair_addresses {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	changes := []*stateChange{}
+	for _, address := range addresses {
+		value := snapshot[address]
+		state_value := &StateValue{Address: address}
+		if value != nil {
+			state_value = &StateValue{Address: address, Value: value, BlockNum: block.BlockNum}
+		}
+		prev, err := tx.GetStateValue(address)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		err = applyStateValue(tx, address, value, block.BlockNum, true, verbose)
+		if err == nil {
+			err = tx.SetStateValue(state_value)
+		}
+		if err == nil {
+			err = addGS1CodeVersion(tx, address, prev.Value, value, block, 0)
+		}
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		changes = append(changes, &stateChange{Address: address, Previous: prev.Value, Value: value})
+		log.Printf("repair : apply %s at block %v\n", address, block.BlockNum)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	NotifyStateChanges(block, changes, false)
+	return len(repair_addresses), nil
+}
+
+func formatVerifyIssue(issue *VerifyIssue) string {
+	line := fmt.Sprintf("%s %s %s", issue.Kind, issue.Table, issue.Key)
+	if issue.Address != issue.Key {
+		line += " (address " + issue.Address + ")"
+	}
+	fields := []string{}
+	for _, field := range issue.Fields {
+		chain, _ := json.Marshal(field.Chain)
+		db, _ := json.Marshal(field.DB)
+		fields = append(fields, fmt.Sprintf("%s chain=%s db=%s", field.Path, chain, db))
+	}
+	if len(fields) > 0 {
+		line += " : " + strings.Join(fields, ", ")
+	}
+	return line
+}
+
+func PrintVerifyReport(report *VerifyReport) {
+	for _, issue := range report.Issues {
+		log.Println(formatVerifyIssue(issue))
+	}
+	log.Printf("verify block %v(%s) : %d states, %d missing, %d extra, %d divergent, %d undecodable\n",
+		report.Block.BlockNum, report.Block.BlockId, report.States,
+		report.Count(VERIFY_MISSING), report.Count(VERIFY_EXTRA), report.Count(VERIFY_DIVERGENT), report.Undecodable)
+	if report.Repaired > 0 {
+		for _, issue := range report.Remaining {
+			log.Println("remaining " + formatVerifyIssue(issue))
+		}
+		log.Printf("repaired %d addresses, %d issues remain\n", report.Repaired, len(report.Remaining))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVerifyRepair(t *testing.T) {
+	openTestStore(t)
+	_, head := DBGetLatestUpdatedBlock()
+	syncTestBlock(t, 1, "b1", head,
+		gs1CodeChange(t, newTestGS1Code("1", "owner-1")), gs1CodeChange(t, newTestGS1Code("2", "owner-1")))
+
+	rest := startTestRESTServer(t)
+	rest.SetHead(&BlockInfo{BlockNum: 1, BlockId: "b1", PreviousBlockId: head}, map[string][]byte{
+		gs1CodeAddress("1"): gs1CodeState(t, newTestGS1Code("1", "owner-2")),
+		gs1CodeAddress("2"): gs1CodeState(t, newTestGS1Code("2", "owner-1")),
+	})
+	report, err := Verify(rest.Addr(), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(VERIFY_DIVERGENT) == 0 || report.Repaired != 1 || len(report.Remaining) != 0 {
+		t.Errorf("%d divergent, %d repaired, %d remaining", report.Count(VERIFY_DIVERGENT), report.Repaired, len(report.Remaining))
+	}
+	for _, issue := range report.Issues {
+		if issue.Address != gs1CodeAddress("1") {
+			t.Errorf("unexpected issue %+v", issue)
+		}
+	}
+	if owner := testGS1CodeOwner(t, "1"); owner != "owner-2" {
+		t.Errorf("GS1 code 1 owner is %q after repair, want owner-2", owner)
+	}
+
+	report, err = Verify(rest.Addr(), false, false)
+	if err != nil || len(report.Issues) != 0 {
+		t.Errorf("verify after repair : %+v, %v", report, err)
+	}
+}
+
+func TestVerifyFailsWhenBlockIsApplied(t *testing.T) {
+	openTestStore(t)
+	_, head := DBGetLatestUpdatedBlock()
+	syncTestBlock(t, 1, "b1", head, gs1CodeChange(t, newTestGS1Code("1", "owner-1")))
+
+	rest := startTestRESTServer(t)
+	rest.SetHead(&BlockInfo{BlockNum: 1, BlockId: "b1", PreviousBlockId: head}, map[string][]byte{
+		gs1CodeAddress("1"): gs1CodeState(t, newTestGS1Code("1", "owner-1")),
+	})
+	//snapshot을 읽는 동안 block 2를 적용한다. repair 하면 block 2의 change를 block 1의 state로 되돌리게 된다.
+	rest.onState = func() {
+		syncTestBlock(t, 2, "b2", "b1", gs1CodeChange(t, newTestGS1Code("1", "owner-2")))
+	}
+	if _, err := Verify(rest.Addr(), true, false); err == nil {
+		t.Errorf("verify succeeded while block 2 was applied")
+	}
+	if owner := testGS1CodeOwner(t, "1"); owner != "owner-2" {
+		t.Errorf("GS1 code 1 owner is %q, want owner-2 of block 2", owner)
+	}
+}
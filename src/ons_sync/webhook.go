@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"protobuf/ons_pb2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+//webhook으로 보내는 change notification의 kind.
+const (
+	WEBHOOK_GS1_CODE_UPDATED     = "gs1_code.updated"
+	WEBHOOK_GS1_CODE_DELETED     = "gs1_code.deleted"
+	WEBHOOK_SERVICE_TYPE_UPDATED = "service_type.updated"
+	WEBHOOK_SERVICE_TYPE_DELETED = "service_type.deleted"
+	WEBHOOK_MANAGERS_UPDATED     = "managers.updated"
+	WEBHOOK_MANAGERS_DELETED     = "managers.deleted"
+	//POST /webhooks/{id}/ping 으로 보내는 test notification. filter와 관계없이 보낸다.
+	WEBHOOK_PING = "ping"
+)
+
+var g_webhook_kinds = []string{
+	WEBHOOK_GS1_CODE_UPDATED, WEBHOOK_GS1_CODE_DELETED,
+	WEBHOOK_SERVICE_TYPE_UPDATED, WEBHOOK_SERVICE_TYPE_DELETED,
+	WEBHOOK_MANAGERS_UPDATED, WEBHOOK_MANAGERS_DELETED,
+}
+
+//notification을 받을 URL과 filter. 비어 있는 filter는 사용하지 않는다.
+//Gs1Prefix, Owner filter는 GS1 code notification에만 맞을 수 있다.
+type Webhook struct {
+	Id        string `gorethink:"id"`
+	Url       string
+	Gs1Prefix string
+	//GS1 code의 owner. owner가 바뀌면 이전 owner도 맞는 것으로 본다.
+	Owner string
+	Kinds []string
+	//payload의 HMAC-SHA256 signature key
+	Secret    string
+	CreatedAt string
+}
+
+//재시도 후에도 전달하지 못한 notification. Id는 실패한 시간 순서로 정렬된다.
+type WebhookDeadLetter struct {
+	Id        string `gorethink:"id"`
+	WebhookId string
+	Url       string
+	Kind      string
+	//보내려고 했던 JSON body
+	Payload  string
+	Error    string
+	Attempts int
+	FailedAt string
+}
+
+//webhook으로 보내는 JSON body.
+//Data는 바뀐 후의 GS1 code(APIGS1Code), service type(APIServiceType) 또는 manager list이고 삭제되었으면 null이다.
+type ONSChange struct {
+	Id              string      `json:"id"`
+	Kind            string      `json:"kind"`
+	Time            string      `json:"time"`
+	BlockNum        float64     `json:"block_num"`
+	BlockId         string      `json:"block_id"`
+	Rollback        bool        `json:"rollback,omitempty"`
+	Address         string      `json:"address,omitempty"`
+	Gs1Code         string      `json:"gs1_code,omitempty"`
+	OwnerId         string      `json:"owner_id,omitempty"`
+	PreviousOwnerId string      `json:"previous_owner_id,omitempty"`
+	Data            interface{} `json:"data"`
+}
+
+//block에서 바뀐 address의 이전 value와 새 value. nil이면 address가 없다.
+type stateChange struct {
+	Address  string
+	Previous []byte
+	Value    []byte
+}
+
+type WebhookOptions struct {
+	//한 notification을 보내는 최대 횟수
+	Attempts int
+	//요청 하나의 timeout
+	Timeout time.Duration
+	//다시 보낼 때 기다리는 시간. 실패할 때마다 두 배로 늘린다.
+	MinBackoff time.Duration
+	//보내기를 기다리는 notification의 최대 개수. 가득 차면 dead letter에 남긴다.
+	QueueSize int
+}
+
+func DefaultWebhookOptions() *WebhookOptions {
+	return &WebhookOptions{
+		Attempts:   5,
+		Timeout:    10 * time.Second,
+		MinBackoff: time.Second,
+		QueueSize:  1000,
+	}
+}
+
+//적용한 block의 change notification을 등록된 webhook으로 보낸다.
+//notification은 하나의 goroutine에서 순서대로 보내므로 응답하지 않는 webhook은 다음 notification을 늦춘다.
+type WebhookDispatcher struct {
+	options *WebhookOptions
+	client  *http.Client
+	changes chan *ONSChange
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+//NotifyStateChanges는 read lock을 잡은 채로 enqueue 하므로 StopWebhookDispatcher가 닫은 channel에 보내지 않는다.
+var g_webhook_dispatcher_mutex = &sync.RWMutex{}
+var g_webhook_dispatcher *WebhookDispatcher = nil
+
+func getWebhookDispatcher() *WebhookDispatcher {
+	g_webhook_dispatcher_mutex.RLock()
+	defer g_webhook_dispatcher_mutex.RUnlock()
+	return g_webhook_dispatcher
+}
+
+func StartWebhookDispatcher(options *WebhookOptions) *WebhookDispatcher {
+	if options == nil {
+		options = DefaultWebhookOptions()
+	}
+	d := &WebhookDispatcher{
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		changes: make(chan *ONSChange, options.QueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	g_webhook_dispatcher_mutex.Lock()
+	g_webhook_dispatcher = d
+	g_webhook_dispatcher_mutex.Unlock()
+	go d.run()
+	return d
+}
+
+//남은 notification은 한 번씩만 보내고 실패하면 dead letter에 남긴다.
+//event source를 종료한 후에 호출해야 한다.
+func StopWebhookDispatcher() {
+	g_webhook_dispatcher_mutex.Lock()
+	d := g_webhook_dispatcher
+	g_webhook_dispatcher = nil
+	g_webhook_dispatcher_mutex.Unlock()
+	if d == nil {
+		return
+	}
+	close(d.stop)
+	close(d.changes)
+	<-d.done
+}
+
+//commit 한 block의 change를 webhook과 change feed(/changes)로 보낸다. 둘 다 없으면 무시한다.
+func NotifyStateChanges(block *BlockInfo, changes []*stateChange, rollback bool) {
+	g_webhook_dispatcher_mutex.RLock()
+	defer g_webhook_dispatcher_mutex.RUnlock()
+	d := g_webhook_dispatcher
+	f := getChangeFeed()
+	if d == nil && f == nil {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	ons_changes := []*ONSChange{}
+	for idx, change := range changes {
+		ons_change := newONSChange(change, block, rollback)
+		if ons_change == nil {
+			continue
+		}
+		ons_change.Id = fmt.Sprintf("%s:%d", block.BlockId, idx)
+		if rollback == true {
+			ons_change.Id += ":rollback"
+		}
+		ons_change.Time = now
+		ons_changes = append(ons_changes, ons_change)
+		if d != nil {
+			d.enqueue(ons_change)
+		}
+	}
+	if f != nil {
+		//rollback 한 후의 state는 이전 block의 state이다.
+		block_num := block.BlockNum
+		if rollback == true {
+			block_num--
+		}
+		f.publish(block_num, ons_changes)
+	}
+}
+
+//ONS state가 아닌 address는 nil을 반환한다.
+func newONSChange(change *stateChange, block *BlockInfo, rollback bool) *ONSChange {
+	ons_change := &ONSChange{
+		BlockNum: block.BlockNum,
+		BlockId:  block.BlockId,
+		Rollback: rollback,
+		Address:  change.Address,
+	}
+
+	switch GetTableIdxByAddress(change.Address) {
+	case GS1_CODE_TABLE:
+		previous := decodeGS1CodeValue(change.Address, change.Previous, block.BlockNum)
+		current := decodeGS1CodeValue(change.Address, change.Value, block.BlockNum)
+		if current != nil {
+			ons_change.Kind = WEBHOOK_GS1_CODE_UPDATED
+			ons_change.Gs1Code = current.Gs1Code
+			ons_change.OwnerId = current.OwnerId
+			ons_change.Data = NewAPIGS1Code(current)
+		} else {
+			ons_change.Kind = WEBHOOK_GS1_CODE_DELETED
+		}
+		if previous != nil {
+			if current == nil {
+				ons_change.Gs1Code = previous.Gs1Code
+				ons_change.OwnerId = previous.OwnerId
+			} else if previous.OwnerId != current.OwnerId {
+				ons_change.PreviousOwnerId = previous.OwnerId
+			}
+		}
+		if len(ons_change.Gs1Code) == 0 {
+			return nil
+		}
+	case SERVICE_TYPE_TABLE:
+		ons_change.Kind = WEBHOOK_SERVICE_TYPE_DELETED
+		if change.Value != nil {
+			service_type_event := &ONSServiceTypeEvent{}
+			service_type_event.BlockNum = block.BlockNum
+			err := proto.Unmarshal(change.Value, &service_type_event.ServiceType)
+			if err != nil {
+				return nil
+			}
+			ons_change.Kind = WEBHOOK_SERVICE_TYPE_UPDATED
+			ons_change.Data = NewAPIServiceType(service_type_event)
+		}
+	case MANAGER_TABLE:
+		ons_change.Kind = WEBHOOK_MANAGERS_DELETED
+		if change.Value != nil {
+			ons_manager := &ons_pb2.ONSManager{}
+			err := proto.Unmarshal(change.Value, ons_manager)
+			if err != nil {
+				return nil
+			}
+			managers := []*APIManager{}
+			for _, row := range newManagerRows(ons_manager, block.BlockNum) {
+				managers = append(managers, &APIManager{
+					Kind:     row.Kind,
+					Address:  row.Address,
+					Gs1Code:  row.Gs1Code,
+					BlockNum: row.BlockNum,
+				})
+			}
+			ons_change.Kind = WEBHOOK_MANAGERS_UPDATED
+			ons_change.Data = map[string]interface{}{"managers": managers}
+		}
+	default:
+		return nil
+	}
+	return ons_change
+}
+
+//value가 nil이거나 decode 할 수 없으면 nil을 반환한다.
+func decodeGS1CodeValue(address string, value []byte, block_num float64) *ONSGS1CodeEvent {
+	if value == nil {
+		return nil
+	}
+	gs1_code_event := &ONSGS1CodeEvent{}
+	gs1_code_event.Address = address
+	gs1_code_event.BlockNum = block_num
+	err := proto.Unmarshal(value, &gs1_code_event.GS1CodeData)
+	if err != nil {
+		return nil
+	}
+	return gs1_code_event
+}
+
+//queue가 가득 차면 sync를 멈추지 않고 dead letter에 남긴다.
+func (d *WebhookDispatcher) enqueue(change *ONSChange) {
+	select {
+	case d.changes <- change:
+	default:
+		log.Printf("webhook queue is full, drop notification %s\n", change.Id)
+		d.addDeadLetter(nil, change, nil, 0, fmt.Errorf("notification queue is full"))
+	}
+}
+
+func (d *WebhookDispatcher) run() {
+	defer close(d.done)
+	for change := range d.changes {
+		webhooks, err := DBListWebhooks()
+		if err != nil {
+			log.Printf("Failed to list webhooks : %v\n", err)
+			d.addDeadLetter(nil, change, nil, 0, err)
+			continue
+		}
+		for _, webhook := range webhooks {
+			if webhook.Match(change) == true {
+				d.deliver(webhook, change)
+			}
+		}
+	}
+}
+
+func (w *Webhook) Match(change *ONSChange) bool {
+	if change.Kind == WEBHOOK_PING {
+		return true
+	}
+	if len(w.Kinds) > 0 && containsString(w.Kinds, change.Kind) == false {
+		return false
+	}
+	if len(w.Gs1Prefix) > 0 && strings.HasPrefix(change.Gs1Code, w.Gs1Prefix) == false {
+		return false
+	}
+	if len(w.Owner) > 0 && change.OwnerId != w.Owner && change.PreviousOwnerId != w.Owner {
+		return false
+	}
+	return true
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+//Attempts 번까지 exponential backoff로 다시 보낸다. 모두 실패하면 dead letter에 남긴다.
+func (d *WebhookDispatcher) deliver(webhook *Webhook, change *ONSChange) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	backoff := d.options.MinBackoff
+	attempt := 1
+	for ; ; attempt++ {
+		err = d.post(webhook, change, body)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Failed to send notification %s to webhook %s (attempt %d) : %v\n", change.Id, webhook.Id, attempt, err)
+		if attempt >= d.options.Attempts || d.wait(backoff) == false {
+			break
+		}
+		backoff *= 2
+	}
+
+	log.Printf("notification %s to webhook %s is moved to the dead letter log\n", change.Id, webhook.Id)
+	d.addDeadLetter(webhook, change, body, attempt, err)
+	return err
+}
+
+//StopWebhookDispatcher가 호출되면 false를 반환한다.
+func (d *WebhookDispatcher) wait(backoff time.Duration) bool {
+	select {
+	case <-d.stop:
+		return false
+	case <-time.After(backoff):
+		return true
+	}
+}
+
+//2xx 응답이 아니면 error를 반환한다.
+func (d *WebhookDispatcher) post(webhook *Webhook, change *ONSChange, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ons_sync")
+	req.Header.Set("X-ONS-Event", change.Kind)
+	//같은 notification을 다시 보내면 같은 delivery id를 사용한다.
+	req.Header.Set("X-ONS-Delivery", change.Id+":"+webhook.Id)
+	req.Header.Set("X-ONS-Timestamp", timestamp)
+	req.Header.Set("X-ONS-Signature", "sha256="+SignWebhookPayload(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+//timestamp + "." + body의 HMAC-SHA256. 받는 쪽은 같은 방법으로 계산해서 X-ONS-Signature와 비교한다.
+func SignWebhookPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//webhook이 nil이면 webhook을 정하기 전에 실패한 notification이다.
+func (d *WebhookDispatcher) addDeadLetter(webhook *Webhook, change *ONSChange, body []byte, attempts int, cause error) {
+	if body == nil {
+		body, _ = json.Marshal(change)
+	}
+	now := time.Now()
+	dead_letter := &WebhookDeadLetter{
+		Id:       fmt.Sprintf("%019d-%s", now.UnixNano(), randomHex(4)),
+		Kind:     change.Kind,
+		Payload:  string(body),
+		Error:    cause.Error(),
+		Attempts: attempts,
+		FailedAt: now.UTC().Format(time.RFC3339),
+	}
+	if webhook != nil {
+		dead_letter.WebhookId = webhook.Id
+		dead_letter.Url = webhook.Url
+	}
+	err := DBAddWebhookDeadLetter(dead_letter)
+	if err != nil {
+		log.Printf("Failed to add dead letter of notification %s : %v\n", change.Id, err)
+	}
+}
+
+//test notification을 한 번 보낸다. 실패해도 dead letter에 남기지 않는다.
+func (d *WebhookDispatcher) Ping(webhook *Webhook) error {
+	change := &ONSChange{
+		Id:   "ping:" + randomHex(8),
+		Kind: WEBHOOK_PING,
+		Time: time.Now().UTC().Format(time.RFC3339),
+	}
+	change.BlockNum, change.BlockId = DBGetLatestUpdatedBlock()
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return d.post(webhook, change, body)
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	_, err := rand.Read(buf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+//Id, CreatedAt을 채우고 Secret이 비어 있으면 만든다.
+func NewWebhook(webhook_url string, gs1_prefix string, owner string, kinds []string, secret string) (*Webhook, error) {
+	u, err := url.Parse(webhook_url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid webhook url %q", webhook_url)
+	}
+	if kinds == nil {
+		kinds = []string{}
+	}
+	for _, kind := range kinds {
+		if containsString(g_webhook_kinds, kind) == false {
+			return nil, fmt.Errorf("invalid kind %q (%s)", kind, strings.Join(g_webhook_kinds, ", "))
+		}
+	}
+	if len(secret) == 0 {
+		secret = randomHex(32)
+	}
+	return &Webhook{
+		Id:        randomHex(16),
+		Url:       webhook_url,
+		Gs1Prefix: gs1_prefix,
+		Owner:     owner,
+		Kinds:     kinds,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	}, nil
+}
+
+func DBAddWebhook(webhook *Webhook) error {
+	if err := checkDBSession(); err != nil {
+		return err
+	}
+	return g_store.AddWebhook(webhook)
+}
+
+func DBDeleteWebhook(id string) (bool, error) {
+	if err := checkDBSession(); err != nil {
+		return false, err
+	}
+	return g_store.DeleteWebhook(id)
+}
+
+func DBListWebhooks() ([]*Webhook, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, err
+	}
+	return g_store.ListWebhooks()
+}
+
+func DBAddWebhookDeadLetter(dead_letter *WebhookDeadLetter) error {
+	if err := checkDBSession(); err != nil {
+		return err
+	}
+	return g_store.AddWebhookDeadLetter(dead_letter)
+}
+
+func DBListWebhookDeadLetters(page Page) ([]*WebhookDeadLetter, int, error) {
+	if err := checkDBSession(); err != nil {
+		return nil, 0, err
+	}
+	return g_store.ListWebhookDeadLetters(page)
+}
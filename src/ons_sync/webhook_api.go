@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+//webhook 관리 API의 bearer token. 비어 있으면 관리 API를 제공하지 않는다.
+var g_webhook_token string = ""
+
+//secret은 등록할 때의 응답에만 포함한다.
+type APIWebhook struct {
+	Id        string   `json:"id"`
+	Url       string   `json:"url"`
+	Gs1Prefix string   `json:"gs1_prefix,omitempty"`
+	Owner     string   `json:"owner,omitempty"`
+	Kinds     []string `json:"kinds"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"created_at"`
+}
+
+//POST /webhooks의 body. secret이 비어 있으면 만들어서 응답으로 알려준다.
+type APIWebhookRequest struct {
+	Url       string   `json:"url"`
+	Gs1Prefix string   `json:"gs1_prefix"`
+	Owner     string   `json:"owner"`
+	Kinds     []string `json:"kinds"`
+	Secret    string   `json:"secret"`
+}
+
+type APIWebhookDeadLetter struct {
+	Id        string          `json:"id"`
+	WebhookId string          `json:"webhook_id,omitempty"`
+	Url       string          `json:"url,omitempty"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	Error     string          `json:"error"`
+	Attempts  int             `json:"attempts"`
+	FailedAt  string          `json:"failed_at"`
+}
+
+type APIWebhookPing struct {
+	Delivered bool   `json:"delivered"`
+	Error     string `json:"error,omitempty"`
+}
+
+func newAPIWebhook(webhook *Webhook) *APIWebhook {
+	return &APIWebhook{
+		Id:        webhook.Id,
+		Url:       webhook.Url,
+		Gs1Prefix: webhook.Gs1Prefix,
+		Owner:     webhook.Owner,
+		Kinds:     webhook.Kinds,
+		CreatedAt: webhook.CreatedAt,
+	}
+}
+
+//Authorization: Bearer <token>
+func requireWebhookToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(g_webhook_token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, "invalid webhook token")
+			return
+		}
+		handler(w, req)
+	}
+}
+
+//GET, POST /webhooks
+//GET /webhooks/dead-letters
+//DELETE /webhooks/{id}
+//POST /webhooks/{id}/ping
+func handleWebhooks(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/webhooks"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case len(path) == 0 && req.Method == http.MethodGet:
+		handleWebhookList(w, req)
+	case len(path) == 0 && req.Method == http.MethodPost:
+		handleWebhookCreate(w, req)
+	case path == "dead-letters" && req.Method == http.MethodGet:
+		handleWebhookDeadLetters(w, req)
+	case len(parts) == 1 && path != "dead-letters" && req.Method == http.MethodDelete:
+		handleWebhookDelete(w, req, parts[0])
+	case len(parts) == 2 && parts[1] == "ping" && req.Method == http.MethodPost:
+		handleWebhookPing(w, req, parts[0])
+	default:
+		writeAPIError(w, http.StatusNotFound, "%s %s is not found", req.Method, req.URL.Path)
+	}
+}
+
+func handleWebhookList(w http.ResponseWriter, req *http.Request) {
+	webhooks, err := DBListWebhooks()
+	if err != nil {
+		log.Printf("Failed to list webhooks : %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to list webhooks")
+		return
+	}
+	items := []*APIWebhook{}
+	for _, webhook := range webhooks {
+		items = append(items, newAPIWebhook(webhook))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+}
+
+func handleWebhookCreate(w http.ResponseWriter, req *http.Request) {
+	request := &APIWebhookRequest{}
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 64*1024)).Decode(request)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body : %v", err)
+		return
+	}
+	webhook, err := NewWebhook(request.Url, request.Gs1Prefix, request.Owner, request.Kinds, request.Secret)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	err = DBAddWebhook(webhook)
+	if err != nil {
+		log.Printf("Failed to add webhook : %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to add webhook")
+		return
+	}
+	log.Printf("webhook %s is registered for %s\n", webhook.Id, webhook.Url)
+
+	item := newAPIWebhook(webhook)
+	item.Secret = webhook.Secret
+	writeJSON(w, http.StatusCreated, item)
+}
+
+func handleWebhookDelete(w http.ResponseWriter, req *http.Request, id string) {
+	deleted, err := DBDeleteWebhook(id)
+	if err != nil {
+		log.Printf("Failed to delete webhook %s : %v\n", id, err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to delete webhook")
+		return
+	}
+	if deleted == false {
+		writeAPIError(w, http.StatusNotFound, "webhook %s is not found", id)
+		return
+	}
+	log.Printf("webhook %s is deleted\n", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//test notification을 한 번 보내고 결과를 반환한다.
+func handleWebhookPing(w http.ResponseWriter, req *http.Request, id string) {
+	d := getWebhookDispatcher()
+	if d == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "webhook dispatcher is not running")
+		return
+	}
+	webhooks, err := DBListWebhooks()
+	if err != nil {
+		log.Printf("Failed to list webhooks : %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to list webhooks")
+		return
+	}
+	for _, webhook := range webhooks {
+		if webhook.Id != id {
+			continue
+		}
+		err = d.Ping(webhook)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, &APIWebhookPing{Delivered: false, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, &APIWebhookPing{Delivered: true})
+		return
+	}
+	writeAPIError(w, http.StatusNotFound, "webhook %s is not found", id)
+}
+
+func handleWebhookDeadLetters(w http.ResponseWriter, req *http.Request) {
+	page, err := parsePage(req.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	dead_letters, total, err := DBListWebhookDeadLetters(page)
+	if err != nil {
+		log.Printf("Failed to list dead letters : %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to list dead letters")
+		return
+	}
+	items := []*APIWebhookDeadLetter{}
+	for _, dead_letter := range dead_letters {
+		items = append(items, &APIWebhookDeadLetter{
+			Id:        dead_letter.Id,
+			WebhookId: dead_letter.WebhookId,
+			Url:       dead_letter.Url,
+			Kind:      dead_letter.Kind,
+			Payload:   json.RawMessage(dead_letter.Payload),
+			Error:     dead_letter.Error,
+			Attempts:  dead_letter.Attempts,
+			FailedAt:  dead_letter.FailedAt,
+		})
+	}
+	writeAPIList(w, req, page, items, len(items), total)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+//받은 notification의 header와 body. status가 비어 있으면 200으로 응답한다.
+type testWebhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	status   []int
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func startTestWebhookServer(t *testing.T, status ...int) *testWebhookServer {
+	s := &testWebhookServer{status: status, received: make(chan struct{}, 100)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		s.mutex.Lock()
+		s.requests = append(s.requests, req)
+		s.bodies = append(s.bodies, body)
+		code := http.StatusOK
+		if len(s.status) > 0 {
+			code, s.status = s.status[0], s.status[1:]
+		}
+		s.mutex.Unlock()
+		w.WriteHeader(code)
+		s.received <- struct{}{}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testWebhookServer) wait(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("webhook received %d of %d requests", i, n)
+		}
+	}
+}
+
+//webhook을 등록하고 GS1 code change를 보낸다.
+func notifyTestWebhook(t *testing.T, url string, attempts int) *Webhook {
+	webhook, err := NewWebhook(url, "", "", nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DBAddWebhook(webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	StartWebhookDispatcher(&WebhookOptions{Attempts: attempts, Timeout: time.Second, MinBackoff: 10 * time.Millisecond, QueueSize: 10})
+	t.Cleanup(StopWebhookDispatcher)
+
+	value, err := proto.Marshal(newTestGS1Code("1", "owner-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &BlockInfo{BlockNum: 1, BlockId: "b1"}
+	NotifyStateChanges(block, []*stateChange{&stateChange{Address: gs1CodeAddress("1"), Value: value}}, false)
+	return webhook
+}
+
+func TestWebhookSignatureAndRetry(t *testing.T) {
+	openTestStore(t)
+	server := startTestWebhookServer(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+	webhook := notifyTestWebhook(t, server.URL, 3)
+	server.wait(t, 3)
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for idx, req := range server.requests {
+		timestamp := req.Header.Get("X-ONS-Timestamp")
+		signature := "sha256=" + SignWebhookPayload(webhook.Secret, timestamp, server.bodies[idx])
+		if req.Header.Get("X-ONS-Signature") != signature {
+			t.Errorf("request %d: signature %q, want %q", idx, req.Header.Get("X-ONS-Signature"), signature)
+		}
+		//다시 보내도 같은 delivery id와 body이다.
+		if req.Header.Get("X-ONS-Delivery") != "b1:0:"+webhook.Id || string(server.bodies[idx]) != string(server.bodies[0]) {
+			t.Errorf("request %d: delivery %q, body %s", idx, req.Header.Get("X-ONS-Delivery"), server.bodies[idx])
+		}
+	}
+	change := &ONSChange{}
+	err := json.Unmarshal(server.bodies[0], change)
+	if err != nil || change.Kind != WEBHOOK_GS1_CODE_UPDATED || change.Gs1Code != "1" || change.OwnerId != "owner-1" {
+		t.Errorf("notification %s, %v", server.bodies[0], err)
+	}
+
+	dead_letters, _, err := DBListWebhookDeadLetters(Page{Limit: 10})
+	if err != nil || len(dead_letters) != 0 {
+		t.Errorf("dead letters %v, %v after a successful retry", dead_letters, err)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	openTestStore(t)
+	server := startTestWebhookServer(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	webhook := notifyTestWebhook(t, server.URL, 2)
+	server.wait(t, 2)
+
+	//마지막 시도가 실패한 후에 dead letter를 저장한다.
+	var dead_letters []*WebhookDeadLetter
+	deadline := time.Now().Add(5 * time.Second)
+	for len(dead_letters) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		dead_letters, _, err = DBListWebhookDeadLetters(Page{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dead_letters) != 1 {
+		t.Fatalf("%d dead letters, want 1", len(dead_letters))
+	}
+	dead_letter := dead_letters[0]
+	server.mutex.Lock()
+	body := string(server.bodies[0])
+	requests := len(server.requests)
+	server.mutex.Unlock()
+	if dead_letter.WebhookId != webhook.Id || dead_letter.Url != server.URL || dead_letter.Attempts != 2 ||
+		dead_letter.Kind != WEBHOOK_GS1_CODE_UPDATED || dead_letter.Payload != body {
+		t.Errorf("dead letter %+v", dead_letter)
+	}
+	if requests != 2 {
+		t.Errorf("webhook received %d requests, want 2", requests)
+	}
+}
+
+//dispatcher를 멈추는 동안 change를 보내도 닫은 queue에 보내지 않는다.
+func TestStopWebhookDispatcherWhileNotifying(t *testing.T) {
+	openTestStore(t)
+	value, err := proto.Marshal(newTestGS1Code("1", "owner-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := []*stateChange{&stateChange{Address: gs1CodeAddress("1"), Value: value}}
+	for i := 0; i < 20; i++ {
+		StartWebhookDispatcher(&WebhookOptions{Attempts: 1, Timeout: time.Second, MinBackoff: time.Millisecond, QueueSize: 1000})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for j := 0; j < 100; j++ {
+				NotifyStateChanges(&BlockInfo{BlockNum: 1, BlockId: "b1"}, changes, false)
+			}
+		}()
+		StopWebhookDispatcher()
+		<-done
+	}
+}
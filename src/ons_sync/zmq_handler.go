@@ -0,0 +1,531 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sawtooth_sdk/protobuf/client_event_pb2"
+	"sawtooth_sdk/protobuf/events_pb2"
+	"sawtooth_sdk/protobuf/transaction_receipt_pb2"
+	"sawtooth_sdk/protobuf/validator_pb2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	zmq "github.com/pebbe/zmq4"
+)
+
+const (
+	BLOCK_COMMIT_EVENT = "sawtooth/block-commit"
+	STATE_DELTA_EVENT  = "sawtooth/state-delta"
+	//subscribe 할 때 last_known_block_ids로 보내는 block의 개수. fork가 있어도 공통 block을 찾을 수 있게 여러 개를 보낸다.
+	LAST_KNOWN_BLOCK_COUNT = 20
+	//socket에서 message를 기다리는 시간. 이 간격으로 subscribe, terminate 요청을 확인한다.
+	ZMQ_POLL_TIMEOUT = 100 * time.Millisecond
+	//pipeline에 넣지 못하고 기다리는 event의 최대 개수. 넘으면 event를 버리고 pipeline이 비면 다시 구독한다.
+	ZMQ_BACKLOG_SIZE = EVENT_PIPELINE_SIZE
+)
+
+//응답을 기다리는 request
+type zmqRequest struct {
+	msg_type validator_pb2.Message_MessageType
+	//UNKNOWN_BLOCK으로 bootstrap 한 후에 다시 보낸 subscribe request
+	retried bool
+}
+
+//validator의 component endpoint에 ClientEventsSubscribeRequest로 구독한다.
+//REST API의 websocket과 달리 validator가 last_known_block_ids 이후의 block event를 모두 보내므로
+//ons_sync나 REST API가 재시작해도 event를 잃지 않는다.
+type ONSZMQEventHandler struct {
+	initialized bool
+	subscribed  bool
+	connected   bool
+	subscribing chan bool
+	exit        chan bool
+	pipeline    *EventPipeline
+	wg          *sync.WaitGroup
+	socket      *zmq.Socket
+	monitor     *zmq.Socket
+	url         string
+	rest_addr   string
+
+	correlation_id uint64
+	requests       map[string]*zmqRequest
+
+	//GetBlockDeltas로 요청한 block id. SyncBlock에서 호출하므로 channel 대신 mutex를 사용한다.
+	block_ids_mutex *sync.Mutex
+	block_ids       []string
+
+	//pipeline이 가득 차서 넣지 못한 event. socket goroutine은 pipeline을 기다리지 않고 ping에 응답한다.
+	backlog []*pipelineItem
+	//backlog가 넘쳐서 event를 버렸으면 backlog가 빈 후에 database의 마지막 block부터 다시 구독한다.
+	resubscribe bool
+
+	//UNKNOWN_BLOCK 응답으로 pipeline이 snapshot으로 다시 맞춘 결과. 다시 구독은 socket goroutine에서 한다.
+	snapshot_mutex   *sync.Mutex
+	snapshot_results []error
+}
+
+func NewONSZMQEventHandler(validator_url string, rest_addr string, verbose bool) (*ONSZMQEventHandler, error) {
+	log.Printf("connecting to %s", validator_url)
+
+	socket, err := zmq.NewSocket(zmq.DEALER)
+	if err != nil {
+		return nil, err
+	}
+	socket.SetLinger(0)
+
+	//validator와의 연결이 끊어지거나 다시 연결되는 것을 monitor socket으로 확인한다.
+	monitor_addr := fmt.Sprintf("inproc://ons-sync-monitor-%p", socket)
+	err = socket.Monitor(monitor_addr, zmq.EVENT_CONNECTED|zmq.EVENT_DISCONNECTED)
+	if err != nil {
+		socket.Close()
+		return nil, err
+	}
+	monitor, err := zmq.NewSocket(zmq.PAIR)
+	if err != nil {
+		socket.Close()
+		return nil, err
+	}
+	err = monitor.Connect(monitor_addr)
+	if err != nil {
+		monitor.Close()
+		socket.Close()
+		return nil, err
+	}
+
+	err = socket.Connect(validator_url)
+	if err != nil {
+		log.Printf("ZMQ connect error: %v", err)
+		monitor.Close()
+		socket.Close()
+		SetSyncConnected(false, err)
+		return nil, err
+	}
+
+	onsEvHandler := &ONSZMQEventHandler{
+		subscribing:     make(chan bool),
+		exit:            make(chan bool),
+		wg:              &sync.WaitGroup{},
+		socket:          socket,
+		monitor:         monitor,
+		url:             validator_url,
+		rest_addr:       rest_addr,
+		requests:        make(map[string]*zmqRequest),
+		block_ids_mutex: &sync.Mutex{},
+		snapshot_mutex:  &sync.Mutex{},
+	}
+	onsEvHandler.pipeline = NewEventPipeline(onsEvHandler, rest_addr, decodeEventList)
+	onsEvHandler.wg.Add(1)
+	onsEvHandler.initialized = true
+	g_verbose = verbose
+	return onsEvHandler, nil
+}
+
+func (h *ONSZMQEventHandler) Run() bool {
+	if h.initialized != true {
+		log.Printf("ONSZMQEventHandler isn't intialized")
+		return false
+	}
+	go h.runSocket()
+	return true
+}
+
+func (h *ONSZMQEventHandler) Subscribe(subscribing bool) {
+	h.subscribing <- subscribing
+}
+
+func (h *ONSZMQEventHandler) GetBlockDeltas(block_id string) {
+	h.block_ids_mutex.Lock()
+	defer h.block_ids_mutex.Unlock()
+	h.block_ids = append(h.block_ids, block_id)
+}
+
+func (h *ONSZMQEventHandler) Terminate(waiting bool) {
+	h.exit <- true
+	log.Println("Terminate : called")
+	if waiting == true {
+		h.wg.Wait()
+	}
+}
+
+//zmq socket은 thread safe하지 않으므로 socket은 이 goroutine에서만 사용한다.
+func (h *ONSZMQEventHandler) runSocket() {
+	defer func() {
+		//남은 event를 모두 적용한 후에 종료한다.
+		for _, item := range h.backlog {
+			h.pipeline.push(item)
+		}
+		h.pipeline.Close()
+		h.monitor.Close()
+		h.socket.Close()
+		h.wg.Done()
+		log.Println("runSocket : Exit")
+	}()
+
+	poller := zmq.NewPoller()
+	poller.Add(h.socket, zmq.POLLIN)
+	poller.Add(h.monitor, zmq.POLLIN)
+
+	for {
+		select {
+		case subscribing := <-h.subscribing:
+			if h.subscribed != subscribing {
+				h.subscribed = subscribing
+				h.subscribe(subscribing, false)
+			}
+			log.Printf("runSocket : called subscribing : %v", subscribing)
+		case _ = <-h.exit:
+			log.Println("runSocket : called exit")
+			return
+		default:
+		}
+
+		for _, block_id := range h.takeBlockIds() {
+			h.getBlockDeltas(block_id)
+		}
+		for _, err := range h.takeSnapshotResults() {
+			if err != nil {
+				log.Printf("Failed to subscribe from the snapshot : %v", err)
+			} else if h.subscribed == true {
+				h.subscribe(true, true)
+			}
+		}
+		h.flushBacklog()
+
+		polled, err := poller.Poll(ZMQ_POLL_TIMEOUT)
+		if err != nil {
+			log.Printf("Failed to poll zmq socket : %v", err)
+			continue
+		}
+		for _, p := range polled {
+			switch p.Socket {
+			case h.socket:
+				h.receiveMessage()
+			case h.monitor:
+				h.receiveMonitorEvent()
+			}
+		}
+	}
+}
+
+func (h *ONSZMQEventHandler) takeBlockIds() []string {
+	h.block_ids_mutex.Lock()
+	defer h.block_ids_mutex.Unlock()
+	block_ids := h.block_ids
+	h.block_ids = nil
+	return block_ids
+}
+
+func (h *ONSZMQEventHandler) takeSnapshotResults() []error {
+	h.snapshot_mutex.Lock()
+	defer h.snapshot_mutex.Unlock()
+	results := h.snapshot_results
+	h.snapshot_results = nil
+	return results
+}
+
+//pipeline이 적용한 후에 socket goroutine이 결과를 받는다.
+func (h *ONSZMQEventHandler) onSnapshot(err error) {
+	h.snapshot_mutex.Lock()
+	defer h.snapshot_mutex.Unlock()
+	h.snapshot_results = append(h.snapshot_results, err)
+}
+
+//받은 순서대로 pipeline에 넣는다. pipeline이 가득 차면 기다리지 않고 backlog에 둔다.
+func (h *ONSZMQEventHandler) pushEvent(item *pipelineItem) {
+	if item.snapshot == nil && len(h.backlog) >= ZMQ_BACKLOG_SIZE {
+		//validator는 다시 구독할 때 last_known_block_ids 이후의 block을 다시 보낸다.
+		if h.resubscribe == false {
+			log.Printf("event pipeline is full, drop events until it is drained")
+		}
+		h.resubscribe = true
+		return
+	}
+	h.backlog = append(h.backlog, item)
+	h.flushBacklog()
+}
+
+func (h *ONSZMQEventHandler) flushBacklog() {
+	for len(h.backlog) > 0 && h.pipeline.TryPush(h.backlog[0]) == true {
+		h.backlog[0] = nil
+		h.backlog = h.backlog[1:]
+	}
+	if len(h.backlog) == 0 && h.resubscribe == true {
+		h.resubscribe = false
+		if h.subscribed == true {
+			h.subscribe(true, false)
+		}
+	}
+}
+
+func (h *ONSZMQEventHandler) sendMessage(msg_type validator_pb2.Message_MessageType, correlation_id string, content proto.Message) error {
+	var data []byte
+	if content != nil {
+		var err error
+		data, err = proto.Marshal(content)
+		if err != nil {
+			return err
+		}
+	}
+	msg, err := proto.Marshal(&validator_pb2.Message{
+		MessageType:   msg_type,
+		CorrelationId: correlation_id,
+		Content:       data,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = h.socket.SendMessage(msg)
+	return err
+}
+
+//response를 받을 수 있도록 correlation id를 기록하고 request를 보낸다.
+func (h *ONSZMQEventHandler) sendRequest(msg_type validator_pb2.Message_MessageType, content proto.Message, retried bool) error {
+	h.correlation_id++
+	correlation_id := strconv.FormatUint(h.correlation_id, 10)
+	err := h.sendMessage(msg_type, correlation_id, content)
+	if err != nil {
+		return err
+	}
+	h.requests[correlation_id] = &zmqRequest{msg_type: msg_type, retried: retried}
+	return nil
+}
+
+//ONS namespace의 state delta와 모든 block commit event를 구독한다.
+func onsEventSubscriptions() []*events_pb2.EventSubscription {
+	return []*events_pb2.EventSubscription{
+		&events_pb2.EventSubscription{
+			EventType: BLOCK_COMMIT_EVENT,
+		},
+		&events_pb2.EventSubscription{
+			EventType: STATE_DELTA_EVENT,
+			Filters: []*events_pb2.EventFilter{
+				&events_pb2.EventFilter{
+					Key:         "address",
+					MatchString: "^" + namespace + ".*",
+					FilterType:  events_pb2.EventFilter_REGEX_ANY,
+				},
+			},
+		},
+	}
+}
+
+//database의 head부터 parent를 따라 최대 LAST_KNOWN_BLOCK_COUNT개의 block id를 구한다.
+func lastKnownBlockIds() []string {
+	block_ids := []string{}
+	_, block_id := DBGetLatestUpdatedBlock()
+	for len(block_ids) < LAST_KNOWN_BLOCK_COUNT {
+		block, err := DBGetBlock(block_id)
+		if err != nil || block == nil {
+			break
+		}
+		block_ids = append(block_ids, block.BlockId)
+		block_id = block.PreviousBlockId
+	}
+	return block_ids
+}
+
+//retried는 UNKNOWN_BLOCK 응답으로 bootstrap 한 후에 다시 구독하는 경우이다.
+func (h *ONSZMQEventHandler) subscribe(subscribing bool, retried bool) error {
+	var err error
+	if subscribing == true {
+		request := &client_event_pb2.ClientEventsSubscribeRequest{
+			Subscriptions:     onsEventSubscriptions(),
+			LastKnownBlockIds: lastKnownBlockIds(),
+		}
+		log.Printf("subscribe with last known blocks : %v", request.LastKnownBlockIds)
+		err = h.sendRequest(validator_pb2.Message_CLIENT_EVENTS_SUBSCRIBE_REQUEST, request, retried)
+	} else {
+		err = h.sendRequest(validator_pb2.Message_CLIENT_EVENTS_UNSUBSCRIBE_REQUEST, &client_event_pb2.ClientEventsUnsubscribeRequest{}, false)
+	}
+
+	if err != nil {
+		log.Printf("Failed to send subscribe request : %v", err)
+	}
+	log.Printf("Called subscribe : %v", subscribing)
+	return err
+}
+
+func (h *ONSZMQEventHandler) getBlockDeltas(block_id string) error {
+	err := h.sendRequest(validator_pb2.Message_CLIENT_EVENTS_GET_REQUEST, &client_event_pb2.ClientEventsGetRequest{
+		Subscriptions: onsEventSubscriptions(),
+		BlockIds:      []string{block_id},
+	}, false)
+	if err != nil {
+		log.Printf("Failed to send events get request : %v", err)
+	}
+	return err
+}
+
+func (h *ONSZMQEventHandler) receiveMonitorEvent() {
+	event, addr, _, err := h.monitor.RecvEvent(0)
+	if err != nil {
+		log.Printf("Failed to receive monitor event : %v", err)
+		return
+	}
+
+	switch event {
+	case zmq.EVENT_CONNECTED:
+		log.Printf("connected to %s", addr)
+		reconnected := h.connected
+		SetSyncConnected(true, nil)
+		if reconnected == true {
+			ObserveSyncReconnect()
+		}
+		//validator가 재시작하면 구독 정보가 없어지므로 database의 마지막 block부터 다시 구독한다.
+		if reconnected == true && h.subscribed == true {
+			h.subscribe(true, false)
+		}
+		h.connected = true
+	case zmq.EVENT_DISCONNECTED:
+		log.Printf("disconnected from %s", addr)
+		SetSyncConnected(false, errors.New("disconnected from "+h.url))
+	}
+}
+
+func (h *ONSZMQEventHandler) receiveMessage() {
+	frames, err := h.socket.RecvMessageBytes(0)
+	if err != nil {
+		log.Printf("Failed to receive message : %v", err)
+		return
+	}
+	if len(frames) == 0 {
+		return
+	}
+
+	msg := &validator_pb2.Message{}
+	err = proto.Unmarshal(frames[len(frames)-1], msg)
+	if err != nil {
+		log.Printf("Failed to unmarshal message : %v", err)
+		return
+	}
+
+	switch msg.MessageType {
+	case validator_pb2.Message_PING_REQUEST:
+		h.sendMessage(validator_pb2.Message_PING_RESPONSE, msg.CorrelationId, nil)
+	case validator_pb2.Message_CLIENT_EVENTS:
+		h.pushEvent(&pipelineItem{message: msg.Content})
+	default:
+		request, ok := h.requests[msg.CorrelationId]
+		if ok == false {
+			log.Printf("unexpected message : %v (correlation id = %s)", msg.MessageType, msg.CorrelationId)
+			return
+		}
+		delete(h.requests, msg.CorrelationId)
+		h.receiveResponse(request, msg)
+	}
+}
+
+func (h *ONSZMQEventHandler) receiveResponse(request *zmqRequest, msg *validator_pb2.Message) {
+	switch msg.MessageType {
+	case validator_pb2.Message_CLIENT_EVENTS_SUBSCRIBE_RESPONSE:
+		response := &client_event_pb2.ClientEventsSubscribeResponse{}
+		err := proto.Unmarshal(msg.Content, response)
+		if err != nil {
+			log.Printf("Failed to unmarshal subscribe response : %v", err)
+			return
+		}
+		switch response.Status {
+		case client_event_pb2.ClientEventsSubscribeResponse_OK:
+			SetSyncSubscribed(true)
+		case client_event_pb2.ClientEventsSubscribeResponse_UNKNOWN_BLOCK:
+			//validator가 database의 block을 모르면 (다른 network이거나 너무 오래된 fork) snapshot으로 다시 맞춘다.
+			log.Printf("validator doesn't know the last known blocks : %s", response.Response)
+			if request.retried == true || len(h.rest_addr) == 0 {
+				log.Printf("Failed to subscribe from the last known blocks")
+				return
+			}
+			//앞의 event를 적용한 후에 pipeline에서 bootstrap 하고, 끝나면 다시 구독한다. (errSnapshotRequired)
+			h.pushEvent(&pipelineItem{snapshot: h.onSnapshot})
+		default:
+			log.Printf("Failed to subscribe : %v %s", response.Status, response.Response)
+		}
+	case validator_pb2.Message_CLIENT_EVENTS_UNSUBSCRIBE_RESPONSE:
+		SetSyncSubscribed(false)
+	case validator_pb2.Message_CLIENT_EVENTS_GET_RESPONSE:
+		response := &client_event_pb2.ClientEventsGetResponse{}
+		err := proto.Unmarshal(msg.Content, response)
+		if err != nil {
+			log.Printf("Failed to unmarshal events get response : %v", err)
+			return
+		}
+		if response.Status != client_event_pb2.ClientEventsGetResponse_OK {
+			log.Printf("Failed to get block events : %v", response.Status)
+			return
+		}
+		//CLIENT_EVENTS와 같은 EventList로 pipeline에 보낸다.
+		content, err := proto.Marshal(&events_pb2.EventList{Events: response.Events})
+		if err != nil {
+			log.Printf("Failed to marshal event list : %v", err)
+			return
+		}
+		h.pushEvent(&pipelineItem{message: content})
+	default:
+		log.Printf("unexpected response : %v", msg.MessageType)
+	}
+}
+
+//CLIENT_EVENTS message의 EventList를 decode 한다.
+func decodeEventList(message []byte) (*ONSEvent, error) {
+	event_list := &events_pb2.EventList{}
+	err := proto.Unmarshal(message, event_list)
+	if err != nil {
+		return nil, err
+	}
+	return newONSEventFromEvents(event_list.Events)
+}
+
+//한 block의 block-commit, state-delta event를 websocket의 block delta와 같은 ONSEvent로 바꾼다.
+func newONSEventFromEvents(events []*events_pb2.Event) (*ONSEvent, error) {
+	onsEvent := &ONSEvent{StateChanges: []map[string]string{}}
+	committed := false
+
+	for _, event := range events {
+		switch event.EventType {
+		case BLOCK_COMMIT_EVENT:
+			for _, attr := range event.Attributes {
+				switch attr.Key {
+				case "block_id":
+					onsEvent.BlockId = attr.Value
+				case "previous_block_id":
+					onsEvent.PreviousBlockId = attr.Value
+				case "block_num":
+					block_num, err := strconv.ParseFloat(attr.Value, 64)
+					if err != nil {
+						return nil, fmt.Errorf("invalid block_num %s : %v", attr.Value, err)
+					}
+					onsEvent.BlockNum = block_num
+				}
+			}
+			committed = true
+		case STATE_DELTA_EVENT:
+			state_changes := &transaction_receipt_pb2.StateChangeList{}
+			err := proto.Unmarshal(event.Data, state_changes)
+			if err != nil {
+				return nil, err
+			}
+			for _, change := range state_changes.StateChanges {
+				if strings.HasPrefix(change.Address, namespace) == false {
+					continue
+				}
+				state := map[string]string{"address": change.Address}
+				if change.Type == transaction_receipt_pb2.StateChange_DELETE {
+					state["type"] = "DELETE"
+				} else {
+					state["type"] = "SET"
+					state["value"] = base64.StdEncoding.EncodeToString(change.Value)
+				}
+				onsEvent.StateChanges = append(onsEvent.StateChanges, state)
+			}
+		}
+	}
+
+	if committed == false {
+		return nil, errors.New("block-commit event is missing")
+	}
+	return onsEvent, nil
+}
@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"encoding/json"
 	"encoding/base64"
 	"protobuf/ons_pb2"
+	"ons_lib/ons_gs1"
+	"ons_lib/ons_naptr"
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/jsonpb"
 )
@@ -106,7 +110,7 @@ func PrintPrettyJson(pb proto.Message, verbose bool) error {
 	return nil
 }
 
-func QueryGS1CodeData(gs1_code_address string, url string, verbose bool) (*ons_pb2.GS1CodeData, error) {
+func GetGS1CodeData(gs1_code_address string, url string, verbose bool) (*ons_pb2.GS1CodeData, error) {
 	pb2_data, err := GetRawData(gs1_code_address, url, verbose)
 	if err != nil {
 		return nil, err
@@ -123,11 +127,53 @@ func QueryGS1CodeData(gs1_code_address string, url string, verbose bool) (*ons_p
 		fmt.Printf("protobuf unmarshaled data : %v\n", gs1_code_data)
 	}
 
+	return gs1_code_data, nil
+}
+
+func QueryGS1CodeData(gs1_code_address string, url string, verbose bool) (*ons_pb2.GS1CodeData, error) {
+	gs1_code_data, err := GetGS1CodeData(gs1_code_address, url, verbose)
+	if err != nil {
+		return nil, err
+	}
+
 	_ = PrintPrettyJson(gs1_code_data, verbose)
 
 	return gs1_code_data, nil
 }
 
+//GS1 code의 ACTIVE record에 NAPTR regexp를 적용해서 order, preference 순서로 service URI를 출력한다.
+//aus가 비어 있으면 GS1 key의 Digital Link path(예: /01/09506000134352)를 사용한다.
+func ResolveGS1Code(gs1_code string, gs1_code_address string, url string, aus string, verbose bool) ([]*ons_naptr.Result, error) {
+	if len(aus) == 0 {
+		key_type, err := ons_gs1.KeyTypeOf(gs1_code)
+		if err != nil {
+			fmt.Printf("Fail to make application unique string : %v\n", err)
+			return nil, err
+		}
+		aus = ons_naptr.ApplicationUniqueString(key_type, gs1_code)
+	}
+
+	gs1_code_data, err := GetGS1CodeData(gs1_code_address, url, verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	if verbose == true {
+		fmt.Printf("application unique string : %s\n", aus)
+	}
+
+	results := ons_naptr.Resolve(aus, ons_naptr.FromGS1CodeData(gs1_code_data))
+
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		fmt.Printf("ResolveGS1Code : json.MarshalIndent : error %v\n", err);
+		return nil, err
+	}
+	fmt.Println(string(b))
+
+	return results, nil
+}
+
 func QueryServicTypeData(service_type_address string, url string, verbose bool) (*ons_pb2.ServiceType, error) {
 	pb2_data, err := GetRawData(service_type_address, url, verbose)
 	if err != nil {
@@ -171,4 +217,63 @@ func QueryONSManager(ons_manager_address string, url string, verbose bool) (*ons
 	_ = PrintPrettyJson(ons_manager, verbose)
 
 	return ons_manager, nil
-}
\ No newline at end of file
+}
+
+//ons_sync의 query API를 조회해서 JSON 응답을 그대로 출력한다.
+func QueryAPI(api_url string, path string, offset int, limit int, verbose bool) ([]byte, error) {
+	query := url.Values{}
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+	return queryAPI(api_url, path, query, verbose)
+}
+
+//ons_sync에서 as_of_block 또는 as_of_time 시점의 GS1 code data를 조회한다.
+func QueryGS1CodeAsOf(api_url string, gs1_code string, as_of_block string, as_of_time string, verbose bool) ([]byte, error) {
+	query := url.Values{}
+	if len(as_of_block) > 0 {
+		query.Set("as_of_block", as_of_block)
+	}
+	if len(as_of_time) > 0 {
+		query.Set("as_of_time", as_of_time)
+	}
+	return queryAPI(api_url, "/gs1codes/" + url.PathEscape(gs1_code), query, verbose)
+}
+
+func queryAPI(api_url string, path string, query url.Values, verbose bool) ([]byte, error) {
+	get_url := api_url + path + "?" + query.Encode()
+
+	if verbose == true {
+		fmt.Println("query : " + get_url)
+	}
+
+	resp, err := http.Get(get_url)
+	if err != nil {
+		fmt.Printf("Fail to query : %v\n", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("Fail to read body : %v\n", err)
+		return nil, err
+	}
+
+	var dat interface{}
+	if err := json.Unmarshal(data, &dat); err != nil {
+		fmt.Printf("Fail to json unmarshal : %v\n", err)
+		return nil, err
+	}
+
+	b, err := json.MarshalIndent(dat, "", "  ")
+	if err != nil {
+		fmt.Printf("QueryAPI : json.MarshalIndent : error %v\n", err);
+		return nil, err
+	}
+	fmt.Println(string(b))
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("query API error (status code = %d)", resp.StatusCode)
+	}
+	return data, nil
+}
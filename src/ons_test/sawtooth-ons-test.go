@@ -24,6 +24,9 @@ import (
 	"sawtooth_sdk/protobuf/batch_pb2"
 	"sawtooth_sdk/signing"
 	"ons_test/ons_query"
+	"ons_lib/ons_ai"
+	"ons_lib/ons_epc"
+	"ons_lib/ons_gs1"
 )
 
 var namespace = hexdigestbyString("ons")[:6]
@@ -44,6 +47,17 @@ var opts struct {
 	State int32 `short:"t" long:"state" description:"The state of GS1 code or record" default:"1"`
 	ManagerAddress string `short:"m" long:"manager" description:"The public key to be gs1 code manager or su manager"`
 	Op uint32 `short:"o" long:"operation" description:"The operation type for manager data, (1 = caching)" default:"1"`
+	CompanyPrefixLength int `long:"cpl" description:"GS1 company prefix length to convert GS1 code to EPC (epc action)" default:"7"`
+	Serial string `long:"serial" description:"Serial number of SGTIN or extension of SGLN to convert GS1 code to EPC (epc action)"`
+	Filter int `long:"filter" description:"Filter value of EPC tag URI and binary encoding (epc action)" default:"0"`
+	Scan string `long:"scan" description:"Scanned GS1 element string to use instead of --gs1code, e.g. (01)09506000134352(21)ABC123 or raw data with FNC1(<GS>)"`
+	AUS string `long:"aus" description:"Application unique string to apply NAPTR regexp for resolve (default : Digital Link path of GS1 code, e.g. /01/[gtin])"`
+	Key string `short:"k" long:"key" description:"The public key to look up for get_owned, get_provided and get_managed (default : public key of the signer)"`
+	API string `long:"api" description:"The ons_sync query API endpoint for get_owned, get_provided, get_managed and get with --as-of-block or --as-of-time" default:"http://localhost:9202"`
+	Offset int `long:"offset" description:"Offset of the first item for get_owned, get_provided and get_managed" default:"0"`
+	Limit int `long:"limit" description:"Maximum number of items for get_owned, get_provided and get_managed" default:"100"`
+	AsOfBlock string `long:"as-of-block" description:"Get the GS1 code data at the block number from ons_sync (get action)"`
+	AsOfTime string `long:"as-of-time" description:"Get the GS1 code data at the time (RFC 3339 or unix time) from ons_sync (get action)"`
 }
 
 const action_register = "register"
@@ -62,6 +76,11 @@ const action_remove_mngr = "remove_mngr"
 const action_add_sumngr = "add_sumngr"
 const action_remove_sumngr = "remove_sumngr"
 const action_op_sumngr = "op_mngr"
+const action_resolve = "resolve"
+const action_epc = "epc"
+const action_get_owned = "get_owned"
+const action_get_provided = "get_provided"
+const action_get_managed = "get_managed"
 
 const (
 	REGISTER_GS1CODE = iota+1
@@ -82,6 +101,11 @@ const (
 	GET_GS1CODE_DATA
 	GET_SVC_DATA
 	GET_MNGR
+	RESOLVE_GS1CODE
+	CONVERT_EPC
+	GET_OWNED
+	GET_PROVIDED
+	GET_MANAGED
 )
 
 func IfThenElse(condition bool, a interface{}, b interface{}) interface{} {
@@ -140,6 +164,38 @@ func main() {
 
 
 	input_gs1_code := opts.GS1Code
+	//barcode에서 읽은 element string과 RFID reader가 읽은 EPC(URI 또는 96 bit hex)는 GS1 key로 변환해서 사용한다.
+	//Digital Link path는 resolve action의 application unique string으로 사용한다.
+	var input_epc *ons_epc.EPC
+	var input_dl_path string
+	if len(opts.Scan) > 0 || ons_ai.IsElementString(input_gs1_code) {
+		scanned := IfThenElse(len(opts.Scan) > 0, opts.Scan, input_gs1_code).(string)
+		element_string, err := ons_ai.Parse(scanned)
+		if err != nil {
+			fmt.Printf("Invalid element string : %v\n", err)
+			os.Exit(2)
+		}
+		_, input_gs1_code, err = element_string.PrimaryKey()
+		if err != nil {
+			fmt.Printf("Invalid element string : %v\n", err)
+			os.Exit(2)
+		}
+		input_dl_path, _ = element_string.DigitalLinkPath()
+		if is_verbose == true {
+			fmt.Printf("element string %q -> GS1 code %s\n", scanned, input_gs1_code)
+		}
+	}else if ons_epc.IsEPC(input_gs1_code) {
+		input_epc, err = ons_epc.Parse(input_gs1_code)
+		if err != nil {
+			fmt.Printf("Invalid EPC : %v\n", err)
+			os.Exit(2)
+		}
+		_, input_gs1_code, _ = input_epc.GS1Key()
+		input_dl_path, _ = input_epc.DigitalLinkPath()
+		if is_verbose == true {
+			fmt.Printf("EPC %s -> GS1 code %s\n", opts.GS1Code, input_gs1_code)
+		}
+	}
 	var local_private_key []byte
 	var local_public_key []byte
 	user, err := user.Current()
@@ -210,6 +266,16 @@ func main() {
 		transaction_type = OP_MANAGER
 	}else if args[0] == action_get_mngr {
 		transaction_type = GET_MNGR
+	}else if args[0] == action_resolve {
+		transaction_type = RESOLVE_GS1CODE
+	}else if args[0] == action_epc {
+		transaction_type = CONVERT_EPC
+	}else if args[0] == action_get_owned {
+		transaction_type = GET_OWNED
+	}else if args[0] == action_get_provided {
+		transaction_type = GET_PROVIDED
+	}else if args[0] == action_get_managed {
+		transaction_type = GET_MANAGED
 	}else{
 		fmt.Printf("Need vaild command(your command = %v)\n", args[0])
 		os.Exit(2)
@@ -257,6 +323,10 @@ func main() {
 		payload, tr_err = MakeRemoveRecordPayload(input_gs1_code, opts.RecordIdx)
 		address = MakeAddressByGS1Code(input_gs1_code)
 	case GET_GS1CODE_DATA:
+		if len(opts.AsOfBlock) > 0 || len(opts.AsOfTime) > 0 {
+			ons_query.QueryGS1CodeAsOf(opts.API, input_gs1_code, opts.AsOfBlock, opts.AsOfTime, is_verbose)
+			return
+		}
 		address = MakeAddressByGS1Code(input_gs1_code)
 		ons_query.QueryGS1CodeData(address, opts.Connect, is_verbose)
 		return
@@ -292,6 +362,34 @@ func main() {
 	case GET_MNGR:
 		ons_query.QueryONSManager(GetONSManagerAddress(), opts.Connect, is_verbose)
 		return
+	case RESOLVE_GS1CODE:
+		address = MakeAddressByGS1Code(input_gs1_code)
+		aus := opts.AUS
+		if len(aus) == 0 {
+			//element string이나 EPC의 serial 등도 application unique string에 포함한다.
+			aus = input_dl_path
+		}
+		ons_query.ResolveGS1Code(input_gs1_code, address, opts.Connect, aus, is_verbose)
+		return
+	case CONVERT_EPC:
+		PrintEPCConversion(input_epc, input_gs1_code)
+		return
+	case GET_OWNED, GET_PROVIDED, GET_MANAGED:
+		key := opts.Key
+		if len(key) == 0 {
+			key = signer.GetPublicKey().AsHex()
+		}
+		var path string
+		switch transaction_type {
+		case GET_OWNED:
+			path = "/owners/" + key + "/gs1codes"
+		case GET_PROVIDED:
+			path = "/providers/" + key + "/records"
+		default:
+			path = "/managers/" + key + "/gs1codes"
+		}
+		ons_query.QueryAPI(opts.API, path, opts.Offset, opts.Limit, is_verbose)
+		return
 	default:
 		payload, tr_err = MakeRegisterGS1CodePayload(input_gs1_code, signer.GetPublicKey().AsHex())
 		address = MakeAddressByGS1Code(input_gs1_code)
@@ -598,6 +696,43 @@ func hexdigestbyByte(data []byte) string {
 	return strings.ToLower(hex.EncodeToString(hashBytes))
 }
 
+//EPC 또는 GS1 code를 GS1 key, EPC pure identity URI, tag URI, 96 bit hex, Digital Link path로 변환해서 출력한다.
+//GS1 code를 변환할 때는 --cpl, --serial, --filter option을 사용한다.
+func PrintEPCConversion(epc *ons_epc.EPC, gs1_code string) {
+	if epc == nil {
+		key_type, err := ons_gs1.KeyTypeOf(gs1_code)
+		if err != nil {
+			fmt.Printf("Fail to convert GS1 code : %v\n", err)
+			os.Exit(2)
+		}
+		epc, err = ons_epc.FromGS1Key(key_type, gs1_code, opts.CompanyPrefixLength, opts.Serial, opts.Filter)
+		if err != nil {
+			fmt.Printf("Fail to convert GS1 code : %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	key_type, key, _ := epc.GS1Key()
+	conversion := map[string]string{
+		"gs1_key_type": key_type,
+		"gs1_code": key,
+		"pure_identity_uri": epc.PureIdentityURI(),
+	}
+	if path, err := epc.DigitalLinkPath(); err == nil {
+		conversion["digital_link_path"] = path
+	}
+	//serial이 96 bit encoding에 맞지 않으면 tag URI와 hex는 만들 수 없다.
+	if tag_uri, err := epc.TagURI(); err == nil {
+		conversion["tag_uri"] = tag_uri
+		conversion["hex"], _ = epc.Hex()
+	} else {
+		conversion["binary_error"] = err.Error()
+	}
+
+	b, _ := json.MarshalIndent(conversion, "", "  ")
+	fmt.Println(string(b))
+}
+
 func MakeAddressByGS1Code(gs1_code string) string{
 	return namespace + hexdigestbyString("gs1")[:8] + hexdigestbyString(gs1_code)[:56]
 }